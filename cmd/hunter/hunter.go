@@ -1,11 +1,22 @@
 package main
 
 import (
+	"flag"
+
 	"orion/src/hunter" // Import the hunter package
 )
 
 func main() {
-	// Call the hunter.Connect function directly
-	//no connect options
-	hunter.Connect()
+	var opts hunter.Options
+	flag.IntVar(&opts.Port, "port", 0, "port dev mode connects to (default 6379)")
+	flag.BoolVar(&opts.TLS, "tls", false, "connect over TLS, to the server's tls-port")
+	flag.StringVar(&opts.CACertFile, "cacert", "", "CA certificate file to verify the server with")
+	flag.StringVar(&opts.CertFile, "cert", "", "client certificate file")
+	flag.StringVar(&opts.KeyFile, "key", "", "client private key file")
+	flag.BoolVar(&opts.InsecureSkipVerify, "insecure", false, "skip server certificate verification (development only)")
+	flag.StringVar(&opts.User, "user", "", "ACL user to authenticate as")
+	flag.StringVar(&opts.Password, "pass", "", "password to authenticate with")
+	flag.Parse()
+
+	hunter.Connect(opts)
 }
@@ -0,0 +1,6 @@
+//go:build ratewindow
+
+package main
+
+// Compile the sliding-window rate counter module into the server
+import _ "orion/src/modules/ratewindow"
@@ -1,21 +1,45 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
+	"os"
+	"strings"
 
 	"orion/src/server"
 )
 
+// Usage: orion [orion.conf] [--config orion.conf] [--name value ...]
 func main() {
-	mode := flag.String("mode", "server", "start in `server` mode")
-	port := flag.String("port", "6379", "port to run the server on")
-	flag.Parse()
+	args := os.Args[1:]
 
-	if *mode == "server" {
-		fmt.Println("Starting Orion server...")
-		server.StartServer(*port)
-	} else {
-		fmt.Println("Unknown mode. Use `server`.")
+	// --mode is still accepted, server is the only mode
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if name != "mode" || !strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		end := i + 1
+		if !hasValue && i+1 < len(args) {
+			value, end = args[i+1], i+2
+		}
+		if value != "server" {
+			fmt.Println("Unknown mode. Use `server`.")
+			os.Exit(1)
+		}
+		args = append(args[:i:i], args[end:]...)
+		i--
+	}
+
+	cfg, err := server.ConfigFromArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading configuration:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Starting Orion server...")
+	if err := server.StartServer(context.Background(), cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
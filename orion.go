@@ -0,0 +1,61 @@
+// Package orion embeds an Orion engine inside a Go program.
+//
+//	engine, err := orion.New(orion.DefaultConfig())
+//	if err != nil {
+//		return err
+//	}
+//	defer engine.Close()
+//
+//	reply, err := engine.Do(ctx, "SET", "greeting", "hello")
+package orion
+
+import "orion/src/server"
+
+// Config configures an embedded engine
+type Config = server.Config
+
+// Engine is an independent Orion instance with its own store, AOF, expiry
+// loop and command table. Engines are safe for concurrent use and several
+// may coexist in one process as long as they use different directories.
+type Engine = server.Server
+
+// OutputBufferLimit bounds the replies queued for a slow client, see
+// Config.ClientOutputBufferLimit
+type OutputBufferLimit = server.OutputBufferLimit
+
+// RateLimit is a token-bucket budget for the clients it matches, see
+// Config.RateLimits
+type RateLimit = server.RateLimit
+
+// ShutdownMode selects whether Engine.Shutdown saves a final snapshot
+type ShutdownMode = server.ShutdownMode
+
+const (
+	ShutdownDefault = server.ShutdownDefault
+	ShutdownSave    = server.ShutdownSave
+	ShutdownNoSave  = server.ShutdownNoSave
+)
+
+// ErrClosed is returned by Engine.Do after Close or Shutdown
+var ErrClosed = server.ErrServerClosed
+
+// DefaultConfig returns the configuration used by the standalone server
+func DefaultConfig() Config {
+	return server.DefaultConfig()
+}
+
+// LoadConfig reads an orion.conf file on top of DefaultConfig
+func LoadConfig(path string) (Config, error) {
+	return server.LoadConfigFile(path)
+}
+
+// New creates an engine from cfg, replaying its AOF if persistence is enabled
+func New(cfg Config) (*Engine, error) {
+	return server.New(cfg)
+}
+
+// Hook intercepts commands before and after they run, see Engine.AddHook
+type Hook = server.Hook
+
+// Call is the parsed command handed to hooks
+type Call = server.Call
@@ -4,58 +4,138 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"log/slog"
+	"orion/src/logging"
 	"orion/src/protocol"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
-var (
-	aofFile           *os.File
-	appendedCommands  map[string]struct{}
-	appendedCommandsM sync.Mutex
-)
+// AOF is an append-only file recording the commands applied to a data store.
+// Each engine owns its own AOF so several instances can coexist in one process.
+type AOF struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	buf  []byte // encoding buffer reused across appends
+
+	fsyncs    int64         // fsyncs done so far
+	fsyncTime time.Duration // time spent in them
+
+	latencyHook func(event string, d time.Duration) // told how long each fsync takes
+	logger      *slog.Logger
+}
+
+// Open opens (creating it if needed) the append-only file at path
+func Open(path string) (*AOF, error) {
+	a := &AOF{path: path, logger: logging.For("aof")}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
 
-// InitAOF initializes the AOF system should be called on server start
-func InitAOF() error {
-	var err error
-	aofFile, err = os.OpenFile("appendonly.orion", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+// open (re)opens the underlying file, the caller must hold a.mu or own a exclusively
+func (a *AOF) open() error {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return fmt.Errorf("error opening AOF file: %w", err)
 	}
-	appendedCommands = make(map[string]struct{})
+	a.file = file
 	return nil
 }
 
-func AppendCommand(command protocol.ArrayValue) error {
-	appendedCommandsM.Lock()
-	defer appendedCommandsM.Unlock()
+// Path returns the location of the append-only file
+func (a *AOF) Path() string {
+	return a.path
+}
 
-	if aofFile == nil {
-		return fmt.Errorf("AOF file not initialized")
+// AppendCommand writes command to the AOF. A nil AOF means persistence is
+// disabled and the call is a no-op.
+func (a *AOF) AppendCommand(command protocol.ArrayValue) error {
+	if a == nil {
+		return nil
 	}
 
-	commandStr := command.Marshal()
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	// Check if command has already been appended
-	if _, exists := appendedCommands[commandStr]; exists {
-		// Command already appended, skip it
-		return nil
+	if a.file == nil {
+		return fmt.Errorf("AOF file not initialized")
 	}
 
-	// Append the command only if it's not already present
-	_, err := aofFile.WriteString(commandStr)
+	a.buf = protocol.AppendValue(a.buf[:0], command)
+	_, err := a.file.Write(a.buf)
 	if err != nil {
 		return fmt.Errorf("error writing to AOF file: %w", err)
 	}
 
-	// Mark command as appended
-	appendedCommands[commandStr] = struct{}{}
+	return a.sync()
+}
+
+// sync fsyncs the file and times it, the caller must hold a.mu
+func (a *AOF) sync() error {
+	start := time.Now()
+	err := a.file.Sync()
+	took := time.Since(start)
+	a.fsyncs++
+	a.fsyncTime += took
+	if a.latencyHook != nil {
+		a.latencyHook("aof-fsync", took)
+	}
+	return err
+}
+
+// SetLogger makes the AOF log to logger, it must be called before Load
+func (a *AOF) SetLogger(logger *slog.Logger) {
+	if a == nil {
+		return
+	}
+	a.logger = logger
+}
+
+// SetLatencyHook makes the AOF report how long each fsync takes
+// ("aof-fsync"), a nil fn stops the reports
+func (a *AOF) SetLatencyHook(fn func(event string, d time.Duration)) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latencyHook = fn
+}
+
+// FsyncStats returns how many fsyncs the AOF has done and the time they took
+func (a *AOF) FsyncStats() (count int64, total time.Duration) {
+	if a == nil {
+		return 0, 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.fsyncs, a.fsyncTime
+}
+
+// Size returns the size of the append-only file in bytes
+func (a *AOF) Size() (int64, error) {
+	if a == nil {
+		return 0, nil
+	}
 
-	return aofFile.Sync()
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
 }
 
-func LoadAOF(handleCommand func(command protocol.ArrayValue) error) error {
-	file, err := os.Open("appendonly.orion")
+// Load replays every command stored in the AOF through handleCommand
+func (a *AOF) Load(handleCommand func(command protocol.ArrayValue) error) error {
+	file, err := os.Open(a.path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// AOF file doesn't exist, which is fine for a new instance
@@ -75,7 +155,7 @@ func LoadAOF(handleCommand func(command protocol.ArrayValue) error) error {
 			if err != nil {
 				if err == io.EOF {
 					// End of file reached, we're done
-					fmt.Printf("Total commands replayed: %d\n", commandCount)
+					a.logger.Info("AOF replayed", "commands", commandCount)
 					return nil
 				}
 				return fmt.Errorf("error reading AOF file: %w", err)
@@ -90,7 +170,7 @@ func LoadAOF(handleCommand func(command protocol.ArrayValue) error) error {
 		if err != nil {
 			if err == io.EOF {
 				// End of file reached while trying to unmarshal, we're done
-				fmt.Printf("Total commands replayed: %d\n", commandCount)
+				a.logger.Info("AOF replayed", "commands", commandCount)
 				return nil
 			}
 			// Print the content of the file at the point of error
@@ -98,11 +178,9 @@ func LoadAOF(handleCommand func(command protocol.ArrayValue) error) error {
 			errorContext := make([]byte, 100)
 			_, readErr := file.ReadAt(errorContext, currentPosition-50)
 			if readErr != nil && readErr != io.EOF {
-				fmt.Printf("Error reading error context: %v\n", readErr)
+				a.logger.Warn("Error reading error context", "err", readErr)
 			}
-			fmt.Printf("Error context: %s\n", string(errorContext))
-
-			fmt.Printf("Error unmarshaling command at position %d: %v\n", currentPosition, err)
+			a.logger.Warn("Skipping corrupted AOF entry", "position", currentPosition, "err", err, "context", string(errorContext))
 
 			// Try to skip to the next command
 			for {
@@ -123,23 +201,13 @@ func LoadAOF(handleCommand func(command protocol.ArrayValue) error) error {
 
 		arrayCommand, ok := command.(protocol.ArrayValue)
 		if !ok {
-			fmt.Printf("Warning: Invalid command format in AOF file: expected ArrayValue, got %T\n", command)
+			a.logger.Warn("Invalid command format in AOF file, expected an array", "type", fmt.Sprintf("%T", command))
 			continue // Skip this command and continue with the next one
 		}
 
-		// fmt.Printf("Executing command from AOF: %v\n", arrayCommand)
-		_ = handleCommand(arrayCommand)
-
-		// if err := handleCommand(arrayCommand); err != nil {
-		// 	// fmt.Printf("Warning: Error handling command %v: %v\n", arrayCommand, err)
-		// 	fmt.Printf("error handling command")
-		// 	// Continue loading instead of returning an error
-		// }
-
-		// Execute the command without printing
+		// Execute the command without printing, each entry is applied exactly once
 		if err := handleCommand(arrayCommand); err != nil {
-			// Optionally, you can log errors here if needed
-			fmt.Printf("Error handling command: %v", err)
+			a.logger.Warn("Error replaying AOF command", "err", err)
 		}
 
 		commandCount++
@@ -150,24 +218,57 @@ func isWhitespace(b byte) bool {
 	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
 }
 
-// CloseAOF closes the AOF file
-func CloseAOF() error {
-	if aofFile != nil {
-		return aofFile.Close()
+// Sync flushes the AOF to stable storage
+func (a *AOF) Sync() error {
+	if a == nil {
+		return nil
 	}
-	return nil
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	return a.sync()
 }
 
-// RewriteAOF rewrites the AOF file to optimize storage
-func RewriteAOF(getCurrentState func() ([]protocol.ArrayValue, error)) error {
+// Close syncs and closes the AOF file
+func (a *AOF) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.close()
+}
+
+func (a *AOF) close() error {
+	if a.file == nil {
+		return nil
+	}
+	syncErr := a.file.Sync()
+	err := a.file.Close()
+	a.file = nil
+	if err == nil {
+		err = syncErr
+	}
+	return err
+}
+
+// Rewrite rewrites the AOF file to optimize storage
+func (a *AOF) Rewrite(getCurrentState func() ([]protocol.ArrayValue, error)) error {
 	// Get the current state of the database
 	commands, err := getCurrentState()
 	if err != nil {
 		return fmt.Errorf("error getting current state: %w", err)
 	}
 
-	// Create a temporary file for the new AOF
-	tempFile, err := os.CreateTemp("", "appendonly.orion.temp")
+	// Create a temporary file for the new AOF next to the current one so the
+	// final rename never crosses filesystems
+	tempFile, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".temp")
 	if err != nil {
 		return fmt.Errorf("error creating temp file: %w", err)
 	}
@@ -181,16 +282,23 @@ func RewriteAOF(getCurrentState func() ([]protocol.ArrayValue, error)) error {
 		}
 	}
 
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	// Close the current AOF file
-	if err := CloseAOF(); err != nil {
+	if err := a.close(); err != nil {
 		return fmt.Errorf("error closing current AOF file: %w", err)
 	}
 
 	// Rename the temporary file to the AOF file
-	if err := os.Rename(tempFile.Name(), "appendonly.orion"); err != nil {
+	if err := os.Rename(tempFile.Name(), a.path); err != nil {
+		// Keep appending to the old file rather than losing writes
+		if openErr := a.open(); openErr != nil {
+			return fmt.Errorf("error reopening AOF file: %w", openErr)
+		}
 		return fmt.Errorf("error renaming temp file: %w", err)
 	}
 
 	// Reinitialize the AOF
-	return InitAOF()
+	return a.open()
 }
@@ -4,40 +4,109 @@ import (
 	"fmt"
 	"orion/src/persistence"
 	"orion/src/protocol"
-	"sync"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
-var (
-	bgSaveMutex      sync.Mutex
-	bgSaveInProgress bool
-)
-
 // HandleBGSave handles the BGSAVE command
-func HandleBGSave(args []protocol.ORSPValue) protocol.ORSPValue {
-	bgSaveMutex.Lock()
-	if bgSaveInProgress {
-		bgSaveMutex.Unlock()
+func HandleBGSave(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	ctx.bgSaveMutex.Lock()
+	if ctx.bgSaveInProgress {
+		ctx.bgSaveMutex.Unlock()
 		return protocol.ErrorValue("BGSAVE already in progress")
 	}
-	bgSaveInProgress = true
-	bgSaveMutex.Unlock()
+	ctx.bgSaveInProgress = true
+	ctx.bgSaveMutex.Unlock()
+
+	filename := ctx.SnapshotPath(time.Now())
 
 	go func() {
-		defer func() {
-			bgSaveMutex.Lock()
-			bgSaveInProgress = false
-			bgSaveMutex.Unlock()
-		}()
-
-		filename := fmt.Sprintf("dump_%d.orion", time.Now().Unix())
-		err := persistence.SaveToFile(filename)
+		start := time.Now()
+		err := persistence.SaveToFile(ctx.Store, filename)
+		if ctx.OnLatency != nil {
+			ctx.OnLatency("snapshot", time.Since(start))
+		}
+		ctx.bgSaveMutex.Lock()
+		ctx.bgSaveInProgress = false
+		ctx.lastSave = time.Now()
+		ctx.lastSaveFailed = err != nil
+		ctx.bgSaveMutex.Unlock()
+
 		if err != nil {
-			fmt.Printf("Error in BGSAVE: %v\n", err)
+			ctx.logger("store").Error("Error in BGSAVE", "file", filename, "err", err)
 		} else {
-			fmt.Printf("Background save completed: %s\n", filename)
+			ctx.logger("store").Info("Background save completed", "file", filename, "duration", time.Since(start))
 		}
 	}()
 
 	return protocol.SimpleStringValue("Background saving started")
 }
+
+// SaveStatus reports whether a BGSAVE is running, when the last one
+// finished (zero if none has) and whether it succeeded
+func (in *Instance) SaveStatus() (inProgress bool, lastSave time.Time, lastOK bool) {
+	in.bgSaveMutex.Lock()
+	defer in.bgSaveMutex.Unlock()
+	return in.bgSaveInProgress, in.lastSave, !in.lastSaveFailed
+}
+
+// SnapshotPath returns where a snapshot taken at now is written: DBFilename
+// inside Dir with the unix time before the extension, dump_<unix time>.orion
+// by default
+func (in *Instance) SnapshotPath(now time.Time) string {
+	dbfilename := in.DBFilename
+	if dbfilename == "" {
+		dbfilename = "dump.orion"
+	}
+	ext := filepath.Ext(dbfilename)
+	name := fmt.Sprintf("%s_%d%s", strings.TrimSuffix(dbfilename, ext), now.Unix(), ext)
+	return filepath.Join(in.Dir, name)
+}
+
+// LatestSnapshot returns the newest snapshot SnapshotPath named in Dir,
+// falling back to DBFilename itself as written before snapshots were
+// timestamped. It reports false when there is none.
+func (in *Instance) LatestSnapshot() (string, bool) {
+	dbfilename := in.DBFilename
+	if dbfilename == "" {
+		dbfilename = "dump.orion"
+	}
+	ext := filepath.Ext(dbfilename)
+	base := strings.TrimSuffix(dbfilename, ext)
+	matches, _ := filepath.Glob(filepath.Join(in.Dir, globEscape(base)+"_*"+globEscape(ext)))
+
+	latest, latestTime := "", int64(-1)
+	for _, path := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), base+"_"), ext)
+		unix, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		if unix > latestTime {
+			latest, latestTime = path, unix
+		}
+	}
+	if latest != "" {
+		return latest, true
+	}
+	legacy := filepath.Join(in.Dir, dbfilename)
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, true
+	}
+	return "", false
+}
+
+// globEscape quotes the characters filepath.Match treats specially
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
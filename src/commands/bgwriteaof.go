@@ -1,20 +1,26 @@
 package commands
 
 import (
-	"fmt"
-	"orion/src/aof"
-	"orion/src/data"
 	"orion/src/protocol"
+	"time"
 )
 
 // HandleBGRewriteAOF handles the BGREWRITEAOF command
-func HandleBGRewriteAOF(args []protocol.ORSPValue) protocol.ORSPValue {
+func HandleBGRewriteAOF(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	if ctx.AOF == nil {
+		return protocol.ErrorValue("ERR append only file is disabled")
+	}
+
 	go func() {
-		err := aof.RewriteAOF(data.Store.GetAllCommands)
+		start := time.Now()
+		err := ctx.AOF.Rewrite(ctx.Store.GetAllCommands)
+		if ctx.OnLatency != nil {
+			ctx.OnLatency("aof-rewrite", time.Since(start))
+		}
 		if err != nil {
-			fmt.Printf("Error in BGREWRITEAOF: %v\n", err)
+			ctx.logger("aof").Error("Error in BGREWRITEAOF", "err", err)
 		} else {
-			fmt.Println("Background AOF rewrite completed")
+			ctx.logger("aof").Info("Background AOF rewrite completed", "duration", time.Since(start))
 		}
 	}()
 	return protocol.SimpleStringValue("Background AOF rewrite started")
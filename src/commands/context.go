@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"log/slog"
+	"orion/src/aof"
+	"orion/src/data"
+	"orion/src/logging"
+	"orion/src/protocol"
+	"sync"
+	"time"
+)
+
+// Instance groups the state shared by every connection to one Orion engine
+type Instance struct {
+	Store *data.DataStore
+	AOF   *aof.AOF // nil when persistence is disabled
+	Dir   string   // directory for snapshot files
+
+	// DBFilename names snapshot files, each BGSAVE inserts a timestamp
+	// before the extension
+	DBFilename string
+
+	// OnLatency, if set, is told how long background saves ("snapshot") and
+	// AOF rewrites ("aof-rewrite") take
+	OnLatency func(event string, d time.Duration)
+
+	// Logger receives the messages of background saves and rewrites, the
+	// process-wide logs when nil
+	Logger *slog.Logger
+
+	bgSaveMutex      sync.Mutex
+	bgSaveInProgress bool
+	lastSave         time.Time // when the last BGSAVE finished
+	lastSaveFailed   bool
+}
+
+// logger returns the logger of subsystem
+func (i *Instance) logger(subsystem string) *slog.Logger {
+	if i.Logger == nil {
+		return logging.For(subsystem)
+	}
+	return i.Logger.With("subsystem", subsystem)
+}
+
+// Context is handed to every command handler. The server creates one per
+// connection and embedded callers get a fresh one per call.
+type Context struct {
+	*Instance
+
+	ID        int64  // connection id, 0 for embedded calls
+	Addr      string // remote address of the client, empty for embedded calls
+	LocalAddr string // address of the listener the client connected to
+	Family    string // "ipv4", "ipv6" or "unix", empty for embedded calls
+	Name      string // set by HELLO SETNAME
+	User      string // ACL user set by AUTH, empty until the client authenticates
+	Script    bool   // set for commands called from a Lua script
+	Protocol  int    // negotiated protocol version, protocol.RESP2 until HELLO 3
+
+	propagate protocol.ArrayValue
+}
+
+// Propagate replaces the command line written to the AOF for the command being
+// executed. Handlers whose effect depends on time or randomness use it to
+// record a deterministic form.
+func (ctx *Context) Propagate(command protocol.ArrayValue) {
+	ctx.propagate = command
+}
+
+// TakePropagated returns and clears the command set by Propagate
+func (ctx *Context) TakePropagated() protocol.ArrayValue {
+	command := ctx.propagate
+	ctx.propagate = nil
+	return command
+}
@@ -3,17 +3,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleDBSize returns the number of keys in the data store
-func HandleDBSize(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 0 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'dbsize' command")
-	}
-
+func HandleDBSize(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	// Get the number of keys in the data store
-	dbSize := data.Store.DBSize()
+	dbSize := ctx.Store.DBSize()
 	return protocol.IntegerValue(dbSize)
 }
@@ -1,22 +1,17 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleGet retrieves the value for a key from the data store
-func HandleGet(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 1 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'get' command")
-	}
-
+func HandleGet(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
 	}
 
-	value, exists := data.Store.Get(string(key))
+	value, exists := ctx.Store.Get(string(key))
 	if !exists {
 		return protocol.NullValue{}
 	}
@@ -3,22 +3,17 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleGetDel retrieves the value of a key and deletes it from the data store
-func HandleGetDel(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 1 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'getdel' command")
-	}
-
+func HandleGetDel(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
 	}
 
-	value, exists := data.Store.GetDel(string(key))
+	value, exists := ctx.Store.GetDel(string(key))
 	if !exists {
 		return protocol.NullValue{}
 	}
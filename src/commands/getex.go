@@ -3,17 +3,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 )
 
 // HandleGetEx retrieves the value of a key and sets its expiration in seconds
-func HandleGetEx(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'getex' command")
-	}
-
+func HandleGetEx(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -29,7 +24,7 @@ func HandleGetEx(args []protocol.ORSPValue) protocol.ORSPValue {
 		return protocol.ErrorValue("ERR invalid seconds argument")
 	}
 
-	value, exists := data.Store.GetEx(string(key), seconds)
+	value, exists := ctx.Store.GetEx(string(key), seconds)
 	if !exists {
 		return protocol.NullValue{}
 	}
@@ -3,17 +3,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 )
 
 // HandleGetRange retrieves a substring of the string value stored at a key
-func HandleGetRange(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 3 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'getrange' command")
-	}
-
+func HandleGetRange(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -39,7 +34,7 @@ func HandleGetRange(args []protocol.ORSPValue) protocol.ORSPValue {
 		return protocol.ErrorValue("ERR invalid end index")
 	}
 
-	value := data.Store.GetRange(string(key), start, end)
+	value := ctx.Store.GetRange(string(key), start, end)
 
 	return protocol.BulkStringValue(value)
 }
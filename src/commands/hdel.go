@@ -1,17 +1,11 @@
 package commands
 
 import (
-	"orion/src/aof"
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleHDel deletes one or more fields from a hash stored at key
-func HandleHDel(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) < 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'hdel' command")
-	}
-
+func HandleHDel(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -27,14 +21,7 @@ func HandleHDel(args []protocol.ORSPValue) protocol.ORSPValue {
 		fields[i] = string(field)
 	}
 
-	deleted := data.Store.HDel(string(key), fields...)
-
-	// Log to AOF if any fields were deleted
-	if deleted > 0 {
-		if err := aof.AppendCommand(protocol.ArrayValue(args)); err != nil {
-			return protocol.ErrorValue("ERR failed to write to AOF")
-		}
-	}
+	deleted := ctx.Store.HDel(string(key), fields...)
 
 	return protocol.IntegerValue(deleted)
 }
@@ -1,22 +1,17 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleHLen returns the number of fields in a hash stored at key
-func HandleHLen(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 1 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'hlen' command")
-	}
-
+func HandleHLen(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
 	}
 
-	length := data.Store.HLen(string(key))
+	length := ctx.Store.HLen(string(key))
 
 	return protocol.IntegerValue(length)
 }
@@ -1,12 +1,11 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleHSet sets field-value pairs in a hash stored at key
-func HandleHSet(args []protocol.ORSPValue) protocol.ORSPValue {
+func HandleHSet(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	if len(args) < 3 || len(args)%2 == 0 {
 		return protocol.ErrorValue("ERR wrong number of arguments for 'hset' command")
 	}
@@ -26,7 +25,7 @@ func HandleHSet(args []protocol.ORSPValue) protocol.ORSPValue {
 		fieldValues[i] = string(value)
 	}
 
-	created := data.Store.HSet(string(key), fieldValues...)
+	created := ctx.Store.HSet(string(key), fieldValues...)
 	if created < 0 {
 		return protocol.ErrorValue("ERR invalid number of field-value pairs")
 	}
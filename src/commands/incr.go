@@ -3,22 +3,17 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleIncr increments the integer value of a key by 1
-func HandleIncr(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 1 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'incr' command")
-	}
-
+func HandleIncr(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
 	}
 
-	newValue, err := data.Store.Incr(string(key))
+	newValue, err := ctx.Store.Incr(string(key))
 	if err != nil {
 		return protocol.ErrorValue("ERR " + err.Error())
 	}
@@ -3,17 +3,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 )
 
 // HandleIncrBy increments the integer value of a key by a specified amount
-func HandleIncrBy(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'incrby' command")
-	}
-
+func HandleIncrBy(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -29,7 +24,7 @@ func HandleIncrBy(args []protocol.ORSPValue) protocol.ORSPValue {
 		return protocol.ErrorValue("ERR increment must be an integer")
 	}
 
-	newValue, err := data.Store.IncrBy(string(key), increment)
+	newValue, err := ctx.Store.IncrBy(string(key), increment)
 	if err != nil {
 		return protocol.ErrorValue("ERR " + err.Error())
 	}
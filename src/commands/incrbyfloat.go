@@ -3,17 +3,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 )
 
 // HandleIncrByFloat increments the float value of a key by a specified amount
-func HandleIncrByFloat(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'incrbyfloat' command")
-	}
-
+func HandleIncrByFloat(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -29,7 +24,7 @@ func HandleIncrByFloat(args []protocol.ORSPValue) protocol.ORSPValue {
 		return protocol.ErrorValue("ERR increment is not a valid float")
 	}
 
-	newValue, err := data.Store.IncrByFloat(string(key), increment)
+	newValue, err := ctx.Store.IncrByFloat(string(key), increment)
 	if err != nil {
 		return protocol.ErrorValue("ERR " + err.Error())
 	}
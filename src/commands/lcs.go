@@ -3,17 +3,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strings"
 )
 
 // HandleLCS calculates the longest common subsequence between the value of a key and a given string
-func HandleLCS(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'lcs' command")
-	}
-
+func HandleLCS(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -24,7 +19,7 @@ func HandleLCS(args []protocol.ORSPValue) protocol.ORSPValue {
 		return protocol.ErrorValue("ERR invalid compare string")
 	}
 
-	value, exists := data.Store.Get(string(key))
+	value, exists := ctx.Store.Get(string(key))
 	if !exists {
 		return protocol.NullValue{}
 	}
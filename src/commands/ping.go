@@ -7,7 +7,7 @@ import (
 )
 
 // HandlePing responds with "PONG"
-func HandlePing(args []protocol.ORSPValue) protocol.ORSPValue {
+func HandlePing(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	if len(args) == 0 {
 		return protocol.SimpleStringValue("PONG")
 	}
@@ -1,16 +1,11 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleSAdd adds the specified members to the set stored at key
-func HandleSAdd(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) < 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'sadd' command")
-	}
-
+func HandleSAdd(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -25,6 +20,6 @@ func HandleSAdd(args []protocol.ORSPValue) protocol.ORSPValue {
 		members[i] = string(member)
 	}
 
-	added := data.Store.SAdd(string(key), members...)
+	added := ctx.Store.SAdd(string(key), members...)
 	return protocol.IntegerValue(added)
 }
@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"orion/src/data"
+	"orion/src/protocol"
+	"slices"
+	"testing"
+)
+
+func newTestContext(t *testing.T) *Context {
+	t.Helper()
+	store := data.NewDataStore()
+	t.Cleanup(store.Close)
+	return &Context{Instance: &Instance{Store: store}}
+}
+
+func bulkArgs(args ...string) []protocol.ORSPValue {
+	values := make([]protocol.ORSPValue, len(args))
+	for i, arg := range args {
+		values[i] = protocol.BulkStringValue(arg)
+	}
+	return values
+}
+
+// Handlers get their arguments without the command name, so the first set
+// is a key like the others
+func TestSDiffKeyOffsets(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.Store.SAdd("a", "1", "2", "3")
+	ctx.Store.SAdd("b", "2")
+	ctx.Store.SAdd("c", "3")
+
+	reply, ok := HandleSDiff(ctx, bulkArgs("a", "b", "c")).(protocol.ArrayValue)
+	if !ok || len(reply) != 1 || reply[0] != protocol.BulkStringValue("1") {
+		t.Errorf("SDIFF a b c got %#v, want [1]", reply)
+	}
+	reply, ok = HandleSDiff(ctx, bulkArgs("a")).(protocol.ArrayValue)
+	if !ok || len(reply) != 3 {
+		t.Errorf("SDIFF a got %#v, want the 3 members of a", reply)
+	}
+
+	if got := HandleSDiffStore(ctx, bulkArgs("dest", "a", "b")); got != protocol.IntegerValue(2) {
+		t.Errorf("SDIFFSTORE dest a b got %#v, want 2", got)
+	}
+	members := ctx.Store.SMembers("dest")
+	slices.Sort(members)
+	if !slices.Equal(members, []string{"1", "3"}) {
+		t.Errorf("dest holds %v, want [1 3]", members)
+	}
+}
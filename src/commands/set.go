@@ -1,18 +1,13 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 	"time"
 )
 
 // HandleSet sets a key-value pair in the data store
-func HandleSet(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) < 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'set' command")
-	}
-
+func HandleSet(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -72,13 +67,13 @@ func HandleSet(args []protocol.ORSPValue) protocol.ORSPValue {
 	}
 
 	// Check XX and NX conditions
-	exists := data.Store.Exists(string(key))
+	exists := ctx.Store.Exists(string(key))
 	if (xx && !exists) || (nx && exists) {
 		return protocol.NullValue{}
 	}
 
 	// Set the value
-	data.Store.Set(string(key), string(value), expiration)
+	ctx.Store.Set(string(key), string(value), expiration)
 
 	return protocol.SimpleStringValue("OK")
 }
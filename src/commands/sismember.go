@@ -1,16 +1,11 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleSIsMember handles the SISMEMBER command
-func HandleSIsMember(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'sismember' command")
-	}
-
+func HandleSIsMember(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -21,7 +16,7 @@ func HandleSIsMember(args []protocol.ORSPValue) protocol.ORSPValue {
 		return protocol.ErrorValue("ERR invalid member")
 	}
 
-	isMember := data.Store.SIsMember(string(key), string(member))
+	isMember := ctx.Store.SIsMember(string(key), string(member))
 
 	if isMember {
 		return protocol.IntegerValue(1)
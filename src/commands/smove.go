@@ -1,16 +1,11 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleSMove handles the SMOVE command
-func HandleSMove(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 3 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'smove' command")
-	}
-
+func HandleSMove(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	source, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid source key")
@@ -26,7 +21,7 @@ func HandleSMove(args []protocol.ORSPValue) protocol.ORSPValue {
 		return protocol.ErrorValue("ERR invalid member")
 	}
 
-	moved := data.Store.SMove(string(source), string(destination), string(member))
+	moved := ctx.Store.SMove(string(source), string(destination), string(member))
 
 	if moved {
 		return protocol.IntegerValue(1)
@@ -1,13 +1,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 )
 
 // HandleSPop handles the SPOP command
-func HandleSPop(args []protocol.ORSPValue) protocol.ORSPValue {
+func HandleSPop(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	if len(args) < 1 || len(args) > 2 {
 		return protocol.ErrorValue("ERR wrong number of arguments for 'spop' command")
 	}
@@ -30,7 +29,7 @@ func HandleSPop(args []protocol.ORSPValue) protocol.ORSPValue {
 		}
 	}
 
-	poppedMembers := data.Store.SPop(string(key), count)
+	poppedMembers := ctx.Store.SPop(string(key), count)
 
 	if len(poppedMembers) == 0 {
 		return protocol.NullValue{}
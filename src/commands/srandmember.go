@@ -1,13 +1,12 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 )
 
 // HandleSRandMember handles the SRANDMEMBER command
-func HandleSRandMember(args []protocol.ORSPValue) protocol.ORSPValue {
+func HandleSRandMember(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	if len(args) < 1 || len(args) > 2 {
 		return protocol.ErrorValue("ERR wrong number of arguments for 'srandmember' command")
 	}
@@ -30,7 +29,7 @@ func HandleSRandMember(args []protocol.ORSPValue) protocol.ORSPValue {
 		}
 	}
 
-	result := data.Store.SRandMember(string(key), count)
+	result := ctx.Store.SRandMember(string(key), count)
 	if len(result) == 0 {
 		return protocol.NullValue{}
 	}
@@ -1,16 +1,11 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleSRem handles the SREM command
-func HandleSRem(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) < 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'srem' command")
-	}
-
+func HandleSRem(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
@@ -25,7 +20,7 @@ func HandleSRem(args []protocol.ORSPValue) protocol.ORSPValue {
 		members[i] = string(member)
 	}
 
-	removed := data.Store.SRem(string(key), members...)
+	removed := ctx.Store.SRem(string(key), members...)
 
 	return protocol.IntegerValue(removed)
 }
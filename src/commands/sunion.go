@@ -1,16 +1,11 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleSUnion handles the SUNION command
-func HandleSUnion(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) < 1 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'sunion' command")
-	}
-
+func HandleSUnion(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	keys := make([]string, len(args))
 	for i, arg := range args {
 		key, ok := arg.(protocol.BulkStringValue)
@@ -20,7 +15,7 @@ func HandleSUnion(args []protocol.ORSPValue) protocol.ORSPValue {
 		keys[i] = string(key)
 	}
 
-	result := data.Store.SUnion(keys...)
+	result := ctx.Store.SUnion(keys...)
 	response := make(protocol.ArrayValue, len(result))
 	for i, member := range result {
 		response[i] = protocol.BulkStringValue(member)
@@ -1,16 +1,11 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleSUnionStore handles the SUNIONSTORE command
-func HandleSUnionStore(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) < 2 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'sunionstore' command")
-	}
-
+func HandleSUnionStore(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	destination, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid destination key")
@@ -25,6 +20,6 @@ func HandleSUnionStore(args []protocol.ORSPValue) protocol.ORSPValue {
 		keys[i] = string(key)
 	}
 
-	count := data.Store.SUnionStore(string(destination), keys...)
+	count := ctx.Store.SUnionStore(string(destination), keys...)
 	return protocol.IntegerValue(count)
 }
@@ -1,19 +1,14 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 	"strconv"
 	"strings"
 )
 
 // HandleTime retrieves the current server time using ORSP
-func HandleTime(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 0 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'time' command")
-	}
-
-	response, err := data.Store.Time()
+func HandleTime(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	response, err := ctx.Store.Time()
 	if err != nil {
 		return protocol.ErrorValue("ERR " + err.Error())
 	}
@@ -1,22 +1,17 @@
 package commands
 
 import (
-	"orion/src/data"
 	"orion/src/protocol"
 )
 
 // HandleTTL retrieves the TTL for a given key using ORSP
-func HandleTTL(args []protocol.ORSPValue) protocol.ORSPValue {
-	if len(args) != 1 {
-		return protocol.ErrorValue("ERR wrong number of arguments for 'ttl' command")
-	}
-
+func HandleTTL(ctx *Context, args []protocol.ORSPValue) protocol.ORSPValue {
 	key, ok := args[0].(protocol.BulkStringValue)
 	if !ok {
 		return protocol.ErrorValue("ERR invalid key")
 	}
 
-	ttl := data.Store.TTL(string(key))
+	ttl := ctx.Store.TTL(string(key))
 
 	switch {
 	case ttl == -2:
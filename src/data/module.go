@@ -0,0 +1,180 @@
+package data
+
+import (
+	"fmt"
+	"orion/src/protocol"
+	"sort"
+)
+
+// ModuleType describes a data type defined by an extension module. The
+// callbacks let the persistence layer handle values it knows nothing about.
+type ModuleType struct {
+	Name string
+
+	// Save and Load serialize a value for snapshots
+	Save func(value any) ([]byte, error)
+	Load func(data []byte) (any, error)
+
+	// Rewrite returns the commands that recreate key during an AOF rewrite
+	Rewrite func(key string, value any) []protocol.ArrayValue
+}
+
+// moduleValue is a value stored under a module-defined type
+type moduleValue struct {
+	typeName string
+	value    any
+}
+
+// ErrWrongType is returned when a key holds a value of another module type
+var ErrWrongType = fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// RegisterType makes a module data type known to the store
+func (ds *DataStore) RegisterType(t ModuleType) error {
+	ds.lock()
+	defer ds.mu.Unlock()
+
+	if t.Name == "" {
+		return fmt.Errorf("module type needs a name")
+	}
+	if _, exists := ds.types[t.Name]; exists {
+		return fmt.Errorf("module type %s is already registered", t.Name)
+	}
+	ds.types[t.Name] = t
+	return nil
+}
+
+// Type returns the module type registered under name
+func (ds *DataStore) Type(name string) (ModuleType, bool) {
+	ds.rlock()
+	defer ds.mu.RUnlock()
+	t, ok := ds.types[name]
+	return t, ok
+}
+
+// GetModuleValue returns the module value stored at key and its type name
+func (ds *DataStore) GetModuleValue(key string) (string, any, bool) {
+	ds.rlock()
+	defer ds.mu.RUnlock()
+	mv, exists := ds.moduleStore[key]
+	return mv.typeName, mv.value, exists
+}
+
+// SetModuleValue stores value under key as a value of the named type
+func (ds *DataStore) SetModuleValue(key, typeName string, value any) error {
+	ds.lock()
+	defer ds.mu.Unlock()
+
+	if _, ok := ds.types[typeName]; !ok {
+		return fmt.Errorf("unknown module type %s", typeName)
+	}
+	ds.moduleStore[key] = moduleValue{typeName: typeName, value: value}
+	return nil
+}
+
+// UpdateModuleValue atomically reads and replaces the value at key. fn gets the
+// current value (nil if the key is missing) and returns the new one; returning
+// a nil value deletes the key.
+func (ds *DataStore) UpdateModuleValue(key, typeName string, fn func(value any, exists bool) (any, error)) error {
+	ds.lock()
+	defer ds.mu.Unlock()
+
+	if _, ok := ds.types[typeName]; !ok {
+		return fmt.Errorf("unknown module type %s", typeName)
+	}
+
+	current, exists := ds.moduleStore[key]
+	if exists && current.typeName != typeName {
+		return ErrWrongType
+	}
+
+	value, err := fn(current.value, exists)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		delete(ds.moduleStore, key)
+		return nil
+	}
+	ds.moduleStore[key] = moduleValue{typeName: typeName, value: value}
+	return nil
+}
+
+// DelModuleValue removes the module value at key
+func (ds *DataStore) DelModuleValue(key string) bool {
+	ds.lock()
+	defer ds.mu.Unlock()
+	_, exists := ds.moduleStore[key]
+	delete(ds.moduleStore, key)
+	return exists
+}
+
+// ModuleEntry is a serialized module value as written to snapshots
+type ModuleEntry struct {
+	Key  string
+	Type string
+	Data []byte
+}
+
+// SaveModuleValues serializes every module value with its type's Save callback
+func (ds *DataStore) SaveModuleValues() ([]ModuleEntry, error) {
+	ds.rlock()
+	defer ds.mu.RUnlock()
+	return ds.moduleEntries()
+}
+
+// moduleEntries serializes the module values, the caller must hold ds.mu
+func (ds *DataStore) moduleEntries() ([]ModuleEntry, error) {
+	entries := make([]ModuleEntry, 0, len(ds.moduleStore))
+	for key, mv := range ds.moduleStore {
+		t := ds.types[mv.typeName]
+		if t.Save == nil {
+			continue
+		}
+		payload, err := t.Save(mv.value)
+		if err != nil {
+			return nil, fmt.Errorf("error saving %s key %s: %w", mv.typeName, key, err)
+		}
+		entries = append(entries, ModuleEntry{Key: key, Type: mv.typeName, Data: payload})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// ViewModuleValue calls fn with the module value stored at key while the
+// store is locked for reading, so fn sees the value as no writer is changing
+// it. fn must not modify the value or call back into the store. It reports
+// whether the key exists.
+func (ds *DataStore) ViewModuleValue(key string, fn func(typeName string, value any)) bool {
+	ds.rlock()
+	defer ds.mu.RUnlock()
+	mv, exists := ds.moduleStore[key]
+	if exists {
+		fn(mv.typeName, mv.value)
+	}
+	return exists
+}
+
+// LoadModuleValue restores a snapshot entry with its type's Load callback
+func (ds *DataStore) LoadModuleValue(entry ModuleEntry) error {
+	t, ok := ds.Type(entry.Type)
+	if !ok || t.Load == nil {
+		return fmt.Errorf("no loader for module type %s", entry.Type)
+	}
+	value, err := t.Load(entry.Data)
+	if err != nil {
+		return fmt.Errorf("error loading %s key %s: %w", entry.Type, entry.Key, err)
+	}
+	return ds.SetModuleValue(entry.Key, entry.Type, value)
+}
+
+// moduleRewriteCommands returns the commands recreating every module value,
+// the caller must hold ds.mu
+func (ds *DataStore) moduleRewriteCommands() []protocol.ArrayValue {
+	var commands []protocol.ArrayValue
+	for key, mv := range ds.moduleStore {
+		if t := ds.types[mv.typeName]; t.Rewrite != nil {
+			commands = append(commands, t.Rewrite(key, mv.value)...)
+		}
+	}
+	return commands
+}
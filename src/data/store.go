@@ -2,12 +2,14 @@ package data
 
 import (
 	"fmt"
+	"log/slog"
 	"math/rand"
-	"orion/src/aof"
+	"orion/src/logging"
 	"orion/src/protocol"
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,44 +21,157 @@ type DataStore struct {
 	hashStore map[string]map[string]string   // Field for hashes
 	TTLStore  map[string]int64               // Stores TTL (Time to Live) for each key in seconds
 	startTime time.Time
-}
 
-// Store is the global instance of DataStore
+	moduleStore map[string]moduleValue // values of module-defined types
+	types       map[string]ModuleType  // module-defined types by name
+	onExpire    func(key string)       // called (outside the lock) for each expired key
+	expiredKeys atomic.Int64           // keys removed by expiration so far
+
+	logger atomic.Pointer[slog.Logger]
 
-var Store *DataStore
+	// latencyHook is told how long expiry cycles and waits for mu take
+	latencyHook atomic.Pointer[func(event string, d time.Duration)]
 
-func init() {
-	Store = NewDataStore()
-	go Store.startExpirationCheck() // Start the expiration check goroutine for Store
+	interval  atomic.Int64  // how often expired keys are removed, read every tick
+	stop      chan struct{} // closed by Close to stop the expiration goroutine
+	closeOnce sync.Once
 }
 
-// NewDataStore initializes a new data store
+// NewDataStore initializes a new data store and starts its expiration
+// goroutine. Call Close to stop it.
 func NewDataStore() *DataStore {
 	ds := &DataStore{
 		store:     make(map[string]string),
 		setStore:  make(map[string]map[string]struct{}), // Initialize setStore ( for implementation of sets )
 		hashStore: make(map[string]map[string]string),   // Initialize hashStore
 		TTLStore:  make(map[string]int64),
+		startTime: time.Now(),
+
+		moduleStore: make(map[string]moduleValue),
+		types:       make(map[string]ModuleType),
+
+		stop: make(chan struct{}),
 	}
+	ds.interval.Store(int64(time.Second))
+	ds.logger.Store(logging.For("store"))
+	go ds.startExpirationCheck()
 	return ds
 }
 
+// OnExpire registers fn to be called for every key removed by expiration
+func (ds *DataStore) OnExpire(fn func(key string)) {
+	ds.lock()
+	defer ds.mu.Unlock()
+	ds.onExpire = fn
+}
+
+// SetLogger makes the store log to logger
+func (ds *DataStore) SetLogger(logger *slog.Logger) {
+	ds.logger.Store(logger)
+}
+
+// SetLatencyHook makes the store report how long each expiry cycle takes
+// ("expire-cycle") and how long each operation waits for the store lock
+// ("store-lock"). A nil fn stops the reports and the timing.
+func (ds *DataStore) SetLatencyHook(fn func(event string, d time.Duration)) {
+	if fn == nil {
+		ds.latencyHook.Store(nil)
+		return
+	}
+	ds.latencyHook.Store(&fn)
+}
+
+// lock takes ds.mu, timing the wait when a latency hook is set
+func (ds *DataStore) lock() {
+	hook := ds.latencyHook.Load()
+	if hook == nil {
+		ds.mu.Lock()
+		return
+	}
+	start := time.Now()
+	ds.mu.Lock()
+	(*hook)("store-lock", time.Since(start))
+}
+
+// rlock takes ds.mu for reading, timing the wait when a latency hook is set
+func (ds *DataStore) rlock() {
+	hook := ds.latencyHook.Load()
+	if hook == nil {
+		ds.mu.RLock()
+		return
+	}
+	start := time.Now()
+	ds.mu.RLock()
+	(*hook)("store-lock", time.Since(start))
+}
+
+// SetHz sets how many times per second expired keys are removed. TTLs keep
+// their one second resolution, a higher rate only removes keys sooner after
+// they expire. The new rate applies from the next tick, SetHz never blocks.
+func (ds *DataStore) SetHz(hz int) {
+	if hz <= 0 {
+		return
+	}
+	ds.interval.Store(int64(time.Second / time.Duration(hz)))
+}
+
+// Close stops the background expiration goroutine
+func (ds *DataStore) Close() {
+	ds.closeOnce.Do(func() {
+		close(ds.stop)
+	})
+}
+
 // startExpirationCheck is a goroutine to periodically check and remove expired keys
 func (ds *DataStore) startExpirationCheck() {
-	ticker := time.NewTicker(time.Second)
+	interval := time.Duration(ds.interval.Load())
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	// TTLs count down in whole seconds whatever the tick rate, elapsed
+	// carries the time not yet taken off them
+	last, elapsed := time.Now(), time.Duration(0)
 	for {
-		<-ticker.C
-		ds.mu.Lock()
+		select {
+		case <-ds.stop:
+			return
+		case now := <-ticker.C:
+			elapsed += now.Sub(last)
+			last = now
+		}
+		if next := time.Duration(ds.interval.Load()); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+		seconds := int64(elapsed / time.Second)
+		elapsed -= time.Duration(seconds) * time.Second
+
+		cycleStart := time.Now()
+		var expired []string
+		ds.lock()
 		for key, ttl := range ds.TTLStore {
 			if ttl <= 0 {
 				delete(ds.store, key)
 				delete(ds.TTLStore, key)
+				expired = append(expired, key)
 			} else {
-				ds.TTLStore[key] = ttl - 1
+				ds.TTLStore[key] = ttl - seconds
 			}
 		}
+		onExpire := ds.onExpire
 		ds.mu.Unlock()
+		ds.expiredKeys.Add(int64(len(expired)))
+		if hook := ds.latencyHook.Load(); hook != nil {
+			(*hook)("expire-cycle", time.Since(cycleStart))
+		}
+		if len(expired) > 0 {
+			ds.logger.Load().Debug("Expiry cycle", "expired", len(expired), "duration", time.Since(cycleStart))
+		}
+
+		if onExpire != nil {
+			for _, key := range expired {
+				onExpire(key)
+			}
+		}
 	}
 }
 
@@ -66,7 +181,7 @@ func (ds *DataStore) startExpirationCheck() {
 
 // GetAllData returns all data in the store
 func (ds *DataStore) GetAllData() map[string]string {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	data := make(map[string]string)
@@ -76,9 +191,26 @@ func (ds *DataStore) GetAllData() map[string]string {
 	return data
 }
 
+// Snapshot returns the string keys and the serialized module values, taken
+// under one lock so they reflect the same moment
+func (ds *DataStore) Snapshot() (map[string]string, []ModuleEntry, error) {
+	ds.rlock()
+	defer ds.mu.RUnlock()
+
+	strings := make(map[string]string, len(ds.store))
+	for k, v := range ds.store {
+		strings[k] = v
+	}
+	modules, err := ds.moduleEntries()
+	if err != nil {
+		return nil, nil, err
+	}
+	return strings, modules, nil
+}
+
 // GetAllCommands returns all commands to recreate the current state
 func (ds *DataStore) GetAllCommands() ([]protocol.ArrayValue, error) {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	var commands []protocol.ArrayValue
@@ -124,12 +256,14 @@ func (ds *DataStore) GetAllCommands() ([]protocol.ArrayValue, error) {
 		commands = append(commands, cmd)
 	}
 
+	commands = append(commands, ds.moduleRewriteCommands()...)
+
 	return commands, nil
 }
 
 // Set stores a value associated with a key
 func (ds *DataStore) Set(key, value string, ttl time.Duration) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	ds.store[key] = value
@@ -140,30 +274,11 @@ func (ds *DataStore) Set(key, value string, ttl time.Duration) {
 	} else {
 		delete(ds.TTLStore, key)
 	}
-
-	// Append to AOF
-	// Prepare ORSP command for AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("SET"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(value),
-	}
-
-	if ttl > 0 {
-		command = append(command,
-			protocol.BulkStringValue("PX"),
-			protocol.BulkStringValue(fmt.Sprintf("%d", ttl.Milliseconds())),
-		)
-	}
-
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
 }
 
 // Exists checks if a key exists in the store
 func (ds *DataStore) Exists(key string) bool {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 	_, exists := ds.store[key]
 	return exists
@@ -171,7 +286,7 @@ func (ds *DataStore) Exists(key string) bool {
 
 // Get retrieves a value associated with a key
 func (ds *DataStore) Get(key string) (string, bool) {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 	value, exists := ds.store[key]
 	return value, exists
@@ -179,30 +294,18 @@ func (ds *DataStore) Get(key string) (string, bool) {
 
 // Append appends a value to the string at the specified key
 func (ds *DataStore) Append(key, value string) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 	if existing, exists := ds.store[key]; exists {
 		ds.store[key] = existing + value
 	} else {
 		ds.store[key] = value
 	}
-
-	// Append to AOF
-	// Prepare ORSP command for AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("APPEND"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(value),
-	}
-
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
 }
 
 // DecrBy decrements the integer value of a key by the given number
 func (ds *DataStore) DecrBy(key string, decrement int) (int, error) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	existing, exists := ds.store[key]
@@ -221,96 +324,47 @@ func (ds *DataStore) DecrBy(key string, decrement int) (int, error) {
 
 	ds.store[key] = strconv.Itoa(value)
 
-	// Prepare ORSP command for AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("DECRBY"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(strconv.Itoa(decrement)),
-	}
-
-	if err := aof.AppendCommand(command); err != nil {
-		return value, fmt.Errorf("error appending to AOF: %w", err)
-	}
-
 	return value, nil
 }
 
 // Decr decrements the integer value of a key by 1
 func (ds *DataStore) Decr(key string) (int, error) {
-	// Prepare ORSP command for AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("DECR"),
-		protocol.BulkStringValue(key),
-	}
-
-	if err := aof.AppendCommand(command); err != nil {
-		return 0, fmt.Errorf("error appending to AOF: %w", err)
-	}
-
 	return ds.DecrBy(key, 1)
 }
 
 // Del deletes a key from the store
 func (ds *DataStore) Del(key string) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 	delete(ds.store, key)
-
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("DEL"),
-		protocol.BulkStringValue(key),
-	}
-
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Errorf("error appending to AOF: %w", err)
-	}
+	delete(ds.moduleStore, key)
 }
 
 // GetDel retrieves a value associated with a key and deletes the key
 func (ds *DataStore) GetDel(key string) (string, bool) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 	value, exists := ds.store[key]
 	if exists {
 		delete(ds.store, key)
-
-		// Append to AOF
-		command := protocol.ArrayValue{
-			protocol.BulkStringValue("GETDEL"),
-			protocol.BulkStringValue(key),
-		}
-		if err := aof.AppendCommand(command); err != nil {
-			fmt.Println("Error appending to AOF:", err)
-		}
 	}
 	return value, exists
 }
 
 // GetEx retrieves a value associated with a key and sets expiration in seconds
 func (ds *DataStore) GetEx(key string, seconds int64) (string, bool) {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 	value, exists := ds.store[key]
 	if exists && seconds > 0 {
 		ds.TTLStore[key] = seconds
-
-		// Append to AOF
-		command := protocol.ArrayValue{
-			protocol.BulkStringValue("GETEX"),
-			protocol.BulkStringValue(key),
-			protocol.BulkStringValue(seconds),
-		}
-		if err := aof.AppendCommand(command); err != nil {
-			fmt.Println("Error appending to AOF:", err)
-		}
 	}
 	return value, exists
 }
 
 // GetRange retrieves a substring of the string value stored at a key
 func (ds *DataStore) GetRange(key string, start, end int) string {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 	value, exists := ds.store[key]
 	if !exists {
@@ -338,40 +392,22 @@ func (ds *DataStore) GetRange(key string, start, end int) string {
 
 // GetSet sets a new value for a key and returns its old value
 func (ds *DataStore) GetSet(key, value string) (string, bool) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 	oldValue, exists := ds.store[key]
 	ds.store[key] = value
 
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("GETSET"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(value),
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
-
 	return oldValue, exists
 }
 
 // Incr increments the integer value of a key by 1
 func (ds *DataStore) Incr(key string) (int, error) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	value, exists := ds.store[key]
 	if !exists {
 		ds.store[key] = "1"
-		// Append to AOF
-		command := protocol.ArrayValue{
-			protocol.BulkStringValue("INCR"),
-			protocol.BulkStringValue(key),
-		}
-		if err := aof.AppendCommand(command); err != nil {
-			fmt.Println("Error appending to AOF:", err)
-		}
 		return 1, nil
 	}
 
@@ -382,35 +418,18 @@ func (ds *DataStore) Incr(key string) (int, error) {
 
 	intValue++
 	ds.store[key] = strconv.Itoa(intValue)
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("INCR"),
-		protocol.BulkStringValue(key),
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
 
 	return intValue, nil
 }
 
 // IncrBy increments the integer value of a key by a specified amount
 func (ds *DataStore) IncrBy(key string, increment int) (int, error) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	value, exists := ds.store[key]
 	if !exists {
 		ds.store[key] = strconv.Itoa(increment)
-		// Append to AOF
-		command := protocol.ArrayValue{
-			protocol.BulkStringValue("INCRBY"),
-			protocol.BulkStringValue(key),
-			protocol.BulkStringValue(strconv.Itoa(increment)),
-		}
-		if err := aof.AppendCommand(command); err != nil {
-			fmt.Println("Error appending to AOF:", err)
-		}
 		return increment, nil
 	}
 
@@ -421,36 +440,18 @@ func (ds *DataStore) IncrBy(key string, increment int) (int, error) {
 
 	intValue += increment
 	ds.store[key] = strconv.Itoa(intValue)
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("INCRBY"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(strconv.Itoa(increment)),
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
 
 	return intValue, nil
 }
 
 // IncrByFloat increments the float value of a key by a specified amount
 func (ds *DataStore) IncrByFloat(key string, increment float64) (float64, error) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	value, exists := ds.store[key]
 	if !exists {
 		ds.store[key] = strconv.FormatFloat(increment, 'f', -1, 64)
-		// Append to AOF
-		command := protocol.ArrayValue{
-			protocol.BulkStringValue("INCRBYFLOAT"),
-			protocol.BulkStringValue(key),
-			protocol.BulkStringValue(strconv.FormatFloat(increment, 'f', -1, 64)),
-		}
-		if err := aof.AppendCommand(command); err != nil {
-			fmt.Println("Error appending to AOF:", err)
-		}
 		return increment, nil
 	}
 
@@ -461,41 +462,21 @@ func (ds *DataStore) IncrByFloat(key string, increment float64) (float64, error)
 
 	floatValue += increment
 	ds.store[key] = strconv.FormatFloat(floatValue, 'f', -1, 64)
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("INCRBYFLOAT"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(strconv.FormatFloat(floatValue, 'f', -1, 64)),
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
 
 	return floatValue, nil
 }
 
 // SetEx stores a value with a specified TTL (in seconds)
 func (ds *DataStore) SetEx(key, value string, seconds int64) {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 	ds.store[key] = value
 	ds.TTLStore[key] = seconds
-
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("SETEX"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(seconds),
-		protocol.BulkStringValue(value),
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
 }
 
 // TTL retrieves the TTL of a key in seconds
 func (ds *DataStore) TTL(key string) int64 {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 	if _, exists := ds.store[key]; !exists {
 		return -1 // Key does not exist
@@ -519,7 +500,7 @@ func (ds *DataStore) Time() (string, error) {
 
 // Info gathers various statistics about the server and formats them
 func (ds *DataStore) Info() string {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	// Get memory statistics
@@ -551,15 +532,10 @@ func (ds *DataStore) Info() string {
 	return info
 }
 
-// GetUptimeSeconds returns the uptime of the server in seconds
+// GetUptimeSeconds returns the uptime of the server in seconds. startTime
+// never changes, so it takes no lock and Info can call it holding ds.mu.
 func (ds *DataStore) GetUptimeSeconds() int64 {
-	ds.mu.RLock()
-	defer ds.mu.RUnlock()
-
-	// Calculate the diff	uptime := time.Since(ds.startTime).Seconds()e
-	uptime := time.Since(ds.startTime).Seconds()
-
-	return int64(uptime)
+	return int64(time.Since(ds.startTime).Seconds())
 }
 
 // getUptimeDays returns the uptime of the server in days
@@ -567,9 +543,9 @@ func (ds *DataStore) GetUptimeDays() int64 {
 	return ds.GetUptimeSeconds() / (60 * 60 * 24)
 }
 
-// getKeyspaceInfo collects keyspace information
+// getKeyspaceInfo collects keyspace information, the caller must hold ds.mu
 func (ds *DataStore) getKeyspaceInfo() string {
-	numKeys := len(ds.store)
+	numKeys := len(ds.store) + len(ds.setStore) + len(ds.hashStore) + len(ds.moduleStore)
 	return fmt.Sprintf("db0:keys=%d", numKeys)
 }
 
@@ -589,7 +565,7 @@ func humanReadableBytes(bytes uint64) string {
 
 // DBSize returns the number of keys in the data store
 func (ds *DataStore) DBSize() int {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	// Count keys in the main store
@@ -601,27 +577,63 @@ func (ds *DataStore) DBSize() int {
 	// Count keys in the hash store
 	hashStoreSize := len(ds.hashStore)
 
+	// Count keys holding module-defined types
+	moduleStoreSize := len(ds.moduleStore)
+
 	// Return the total number of keys
-	return mainStoreSize + setStoreSize + hashStoreSize
+	return mainStoreSize + setStoreSize + hashStoreSize + moduleStoreSize
+}
+
+// ExpiredKeys returns how many keys expiration has removed
+func (ds *DataStore) ExpiredKeys() int64 {
+	return ds.expiredKeys.Load()
+}
+
+// KeysByType returns the number of keys holding each type, module-defined
+// types by their name
+func (ds *DataStore) KeysByType() map[string]int {
+	ds.rlock()
+	defer ds.mu.RUnlock()
+
+	counts := map[string]int{
+		"string": len(ds.store),
+		"set":    len(ds.setStore),
+		"hash":   len(ds.hashStore),
+	}
+	for _, v := range ds.moduleStore {
+		counts[v.typeName]++
+	}
+	return counts
+}
+
+// ExpireStats returns how many keys have a TTL and their average remaining
+// time to live
+func (ds *DataStore) ExpireStats() (expires int, avgTTL time.Duration) {
+	ds.rlock()
+	defer ds.mu.RUnlock()
+
+	var total int64
+	for _, ttl := range ds.TTLStore {
+		total += ttl
+	}
+	if len(ds.TTLStore) == 0 {
+		return 0, 0
+	}
+	return len(ds.TTLStore), time.Duration(total) * time.Second / time.Duration(len(ds.TTLStore))
 }
 
 // FLUSHALL UNIVERSAL .....
 
 // FlushAll clears all key-value pairs from the store
 func (ds *DataStore) FlushAll() {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	ds.store = make(map[string]string)
 	ds.setStore = make(map[string]map[string]struct{})
 	ds.hashStore = make(map[string]map[string]string)
 	ds.TTLStore = make(map[string]int64)
-
-	// Append to AOF
-	command := protocol.ArrayValue{protocol.BulkStringValue("FLUSHALL")}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
+	ds.moduleStore = make(map[string]moduleValue)
 }
 
 // ENDS HERE
@@ -632,7 +644,7 @@ func (ds *DataStore) FlushAll() {
 
 // SAdd adds the specified members to the set stored at key
 func (ds *DataStore) SAdd(key string, members ...string) int {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	if _, exists := ds.setStore[key]; !exists {
@@ -647,24 +659,12 @@ func (ds *DataStore) SAdd(key string, members ...string) int {
 		}
 	}
 
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("SADD"),
-		protocol.BulkStringValue(key),
-	}
-	for _, member := range members {
-		command = append(command, protocol.BulkStringValue(member))
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
-
 	return added
 }
 
 // SMembers returns all the members of the set value stored at key
 func (ds *DataStore) SMembers(key string) []string {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	set, exists := ds.setStore[key]
@@ -682,7 +682,7 @@ func (ds *DataStore) SMembers(key string) []string {
 
 // SIsMember returns if member is a member of the set stored at key
 func (ds *DataStore) SIsMember(key, member string) bool {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	set, exists := ds.setStore[key]
@@ -696,7 +696,7 @@ func (ds *DataStore) SIsMember(key, member string) bool {
 
 // SCard returns the cardinality (number of elements) of the set stored at key
 func (ds *DataStore) SCard(key string) int {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	if set, exists := ds.setStore[key]; exists {
@@ -707,7 +707,7 @@ func (ds *DataStore) SCard(key string) int {
 
 // SMove moves member from the set at source to the set at destination
 func (ds *DataStore) SMove(source, destination, member string) bool {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	sourceSet, sourceExists := ds.setStore[source]
@@ -726,23 +726,12 @@ func (ds *DataStore) SMove(source, destination, member string) bool {
 	delete(sourceSet, member)
 	ds.setStore[destination][member] = struct{}{}
 
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("SMOVE"),
-		protocol.BulkStringValue(source),
-		protocol.BulkStringValue(destination),
-		protocol.BulkStringValue(member),
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
-
 	return true
 }
 
 // SPop removes and returns one or more random members from the set
 func (ds *DataStore) SPop(key string, count int) []string {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	set, exists := ds.setStore[key]
@@ -764,22 +753,12 @@ func (ds *DataStore) SPop(key string, count int) []string {
 		}
 	}
 
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("SPOP"),
-		protocol.BulkStringValue(key),
-		protocol.BulkStringValue(fmt.Sprintf("%d", count)),
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
-
 	return members
 }
 
 // SRem removes one or more members from the set
 func (ds *DataStore) SRem(key string, members ...string) int {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	set, exists := ds.setStore[key]
@@ -795,43 +774,15 @@ func (ds *DataStore) SRem(key string, members ...string) int {
 		}
 	}
 
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("SREM"),
-		protocol.BulkStringValue(key),
-	}
-	for _, member := range members {
-		command = append(command, protocol.BulkStringValue(member))
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
-
 	return removed
 }
 
 // SDiff returns the difference between the sets stored at the given keys
 func (ds *DataStore) SDiff(keys ...string) []string {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
-	if len(keys) == 0 {
-		return []string{}
-	}
-
-	result := ds.setStore[keys[0]]
-
-	for _, key := range keys[1:] {
-		set, exists := ds.setStore[key]
-		if exists {
-			for member := range result {
-				if _, ok := set[member]; ok {
-					delete(result, member)
-				}
-			}
-		}
-	}
-
+	result := ds.diff(keys)
 	members := make([]string, 0, len(result))
 	for member := range result {
 		members = append(members, member)
@@ -842,40 +793,36 @@ func (ds *DataStore) SDiff(keys ...string) []string {
 
 // SDiffStore stores the difference between the sets stored at the given keys in the destination key
 func (ds *DataStore) SDiffStore(destination string, keys ...string) int {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
-	if len(keys) == 0 {
-		return 0
-	}
+	result := ds.diff(keys)
+	ds.setStore[destination] = result
 
-	result := ds.setStore[keys[0]]
+	return len(result)
+}
 
-	for _, key := range keys[1:] {
-		set, exists := ds.setStore[key]
-		if exists {
-			for member := range result {
-				if _, ok := set[member]; ok {
-					delete(result, member)
-				}
-			}
-		}
+// diff returns a new set holding the members of the first set that are in
+// none of the others, called with ds.mu held
+func (ds *DataStore) diff(keys []string) map[string]struct{} {
+	result := make(map[string]struct{})
+	if len(keys) == 0 {
+		return result
 	}
-
-	if _, exists := ds.setStore[destination]; !exists {
-		ds.setStore[destination] = make(map[string]struct{})
+	for member := range ds.setStore[keys[0]] {
+		result[member] = struct{}{}
 	}
-
-	for member := range result {
-		ds.setStore[destination][member] = struct{}{}
+	for _, key := range keys[1:] {
+		for member := range ds.setStore[key] {
+			delete(result, member)
+		}
 	}
-
-	return len(result)
+	return result
 }
 
 // SUnion returns the union of all the given sets
 func (ds *DataStore) SUnion(keys ...string) []string {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	unionSet := make(map[string]struct{})
@@ -898,7 +845,7 @@ func (ds *DataStore) SUnion(keys ...string) []string {
 
 // SUnionStore stores the union of all the given sets in a new set at destination
 func (ds *DataStore) SUnionStore(destination string, keys ...string) int {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	unionSet := make(map[string]struct{})
@@ -913,24 +860,12 @@ func (ds *DataStore) SUnionStore(destination string, keys ...string) int {
 
 	ds.setStore[destination] = unionSet
 
-	// Append to AOF
-	command := protocol.ArrayValue{
-		protocol.BulkStringValue("SUNIONSTORE"),
-		protocol.BulkStringValue(destination),
-	}
-	for _, key := range keys {
-		command = append(command, protocol.BulkStringValue(key))
-	}
-	if err := aof.AppendCommand(command); err != nil {
-		fmt.Println("Error appending to AOF:", err)
-	}
-
 	return len(unionSet)
 }
 
 // SRandMember returns random members from the set
 func (ds *DataStore) SRandMember(key string, count int) []string {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	set, exists := ds.setStore[key]
@@ -965,7 +900,7 @@ func (ds *DataStore) SRandMember(key string, count int) []string {
 
 // HSet sets the value of a field in a hash stored at key
 func (ds *DataStore) HSet(key string, fieldValues ...string) int {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	if len(fieldValues)%2 != 0 {
@@ -992,7 +927,7 @@ func (ds *DataStore) HSet(key string, fieldValues ...string) int {
 
 // HGet gets the value of a field from a hash
 func (ds *DataStore) HGet(key, field string) (string, bool) {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	hash, exists := ds.hashStore[key]
@@ -1006,7 +941,7 @@ func (ds *DataStore) HGet(key, field string) (string, bool) {
 
 // HExists checks if a field exists in a hash
 func (ds *DataStore) HExists(key, field string) bool {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	hash, exists := ds.hashStore[key]
@@ -1020,7 +955,7 @@ func (ds *DataStore) HExists(key, field string) bool {
 
 // HDel deletes fields from a hash
 func (ds *DataStore) HDel(key string, fields ...string) int {
-	ds.mu.Lock()
+	ds.lock()
 	defer ds.mu.Unlock()
 
 	hash, exists := ds.hashStore[key]
@@ -1046,7 +981,7 @@ func (ds *DataStore) HDel(key string, fields ...string) int {
 
 // HLen returns the number of fields in a hash
 func (ds *DataStore) HLen(key string) int {
-	ds.mu.RLock()
+	ds.rlock()
 	defer ds.mu.RUnlock()
 
 	hash, exists := ds.hashStore[key]
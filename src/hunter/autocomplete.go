@@ -11,6 +11,11 @@ import (
 var commandList = []string{
 	// Server Management commands
 	"BGSAVE", "BGREWRITEAOF", "FLUSHALL", "PING", "TIME", "INFO", "DBSIZE",
+	"COMMAND", "HELLO", "AUTH", "ACL", "CLIENT",
+	"MODULE", "CONFIG", "SHUTDOWN", "SLOWLOG", "LATENCY", "MONITOR",
+
+	// Scripting commands
+	"EVAL", "EVALSHA", "EVAL_RO", "SCRIPT",
 
 	// String commands
 	"SET", "GET", "APPEND", "GETDEL", "GETEX", "GETSET", "GETRANGE",
@@ -2,6 +2,8 @@ package hunter
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"orion/src/protocol"
@@ -9,6 +11,8 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -25,45 +29,134 @@ var asciiArt = `
 \___/____/\________/\__/_____/ \______/ \________/\____/___/  
 `
 
+// Options configures how Connect reaches the server
+type Options struct {
+	// Port is the port dev mode connects to, 6379 when zero
+	Port int
+
+	// TLS connects over TLS. The server only speaks TLS on its tls-port, so
+	// set Port to it or enter it in custom mode. Unix sockets are always
+	// plaintext and can't be combined with TLS.
+	TLS bool
+	// CACertFile holds the CA certificates the server certificate is
+	// verified against, the system roots are used when it is empty
+	CACertFile string
+	// CertFile and KeyFile hold the client certificate for servers with
+	// tls-auth-clients enabled
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify accepts any server certificate, for development only
+	InsecureSkipVerify bool
+
+	// User and Password authenticate the connection, User defaults to the
+	// default user
+	User     string
+	Password string
+}
+
+// tlsConfig builds the client TLS configuration for serverName
+func (o Options) tlsConfig(serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if o.CACertFile != "" {
+		caPEM, err := os.ReadFile(o.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", o.CACertFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
 // Connect initializes the CLI client and connects to the server.
-func Connect() {
+func Connect(opts Options) {
 	color.Cyan(asciiArt)
 	color.Yellow("Welcome to Hunter CLI 1.0!")
 	color.Yellow("Read more about hunter on https://orion.thestarsociety.tech/docs/packages/hunter")
 	color.Magenta("Made with love by The Star Society")
 
-	mode := promptInput("Select mode (dev/custom): ", color.FgGreen)
+	mode := promptInput("Select mode (dev/custom/socket): ", color.FgGreen)
 	mode = strings.ToLower(mode)
 
-	var serverIP, serverPort string
+	var serverIP, serverPort, socketPath string
 
 	//dev mode is the default mode of hunter which is used for local development
 	//custom mode is used for connecting to a custom server
+	//socket mode is used for connecting to a local server over its unixsocket
 
 	if mode == "dev" {
 		serverIP = "127.0.0.1"
 		serverPort = "6379"
+		if opts.Port != 0 {
+			serverPort = strconv.Itoa(opts.Port)
+		}
 		color.Green("Dev mode selected. Using IP: %s and Port: %s", serverIP, serverPort)
 	} else if mode == "custom" {
 		serverIP = promptInput("Enter server IP: ", color.FgGreen)
 		serverPort = promptInput("Enter server port: ", color.FgGreen)
+	} else if mode == "socket" {
+		if opts.TLS {
+			color.Red("The Unix socket doesn't speak TLS, connect without --tls. Exiting...")
+			return
+		}
+		socketPath = promptInput("Enter socket path: ", color.FgGreen)
 	} else {
 		color.Red("Invalid mode selected. Exiting...")
 		return
 	}
 
-	serverAddr := fmt.Sprintf("%s:%s", serverIP, serverPort)
+	network, serverAddr := "tcp", net.JoinHostPort(serverIP, serverPort)
+	if socketPath != "" {
+		network, serverAddr = "unix", socketPath
+	}
 
 	showLoader()
 
-	conn, err := net.Dial("tcp", serverAddr)
+	var conn net.Conn
+	var err error
+	if opts.TLS {
+		var tlsConfig *tls.Config
+		tlsConfig, err = opts.tlsConfig(serverIP)
+		if err != nil {
+			color.Red("Error loading TLS configuration: %v", err)
+			return
+		}
+		conn, err = tls.Dial(network, serverAddr, tlsConfig)
+	} else {
+		conn, err = net.Dial(network, serverAddr)
+	}
 	if err != nil {
 		color.Red("Error connecting to server: %v", err)
 		return
 	}
 	defer conn.Close()
 
-	color.Green("Connected to server at %s", serverAddr)
+	if opts.TLS {
+		color.Green("Connected to server at %s over TLS", serverAddr)
+	} else {
+		color.Green("Connected to server at %s", serverAddr)
+	}
+
+	// Switch to RESP3 so maps, sets and doubles keep their types, servers that
+	// don't know HELLO simply stay on RESP2
+	respReader := bufio.NewReader(conn)
+	if err := negotiateProtocol(conn, respReader, opts.User, opts.Password); err != nil {
+		color.Red("Error negotiating protocol: %v", err)
+		return
+	}
 
 	// Initialize command history
 	history := NewCommandHistory()
@@ -110,11 +203,18 @@ func Connect() {
 			continue
 		}
 
-		// Add to history
-		history.Add(input)
+		// Add to history, except commands carrying passwords
+		if !hasSecrets(input) {
+			history.Add(input)
+		}
 
-		// Convert input to ORSP array
-		args := parseInput(input)
+		// Convert input to ORSP array, quoting follows the server's inline
+		// command rules
+		args, err := protocol.SplitArgs(input)
+		if err != nil {
+			color.Red("Invalid argument(s): %v", err)
+			continue
+		}
 		orspArray := make(protocol.ArrayValue, len(args))
 		for i, arg := range args {
 			orspArray[i] = protocol.BulkStringValue(arg)
@@ -128,18 +228,104 @@ func Connect() {
 		}
 
 		// Read and unmarshal the response
-		respReader := bufio.NewReader(conn)
 		response, err := protocol.Unmarshal(respReader)
 		if err != nil {
 			color.Red("Error reading response: %v", err)
 			continue
 		}
 
-		// Print the response
-		printResponse(response)
+		// Print the response, INFO field by field
+		if info, ok := response.(protocol.BulkStringValue); ok && strings.EqualFold(args[0], "INFO") {
+			printInfo(string(info))
+		} else {
+			printResponse(response)
+		}
+
+		// The connection only streams commands after MONITOR
+		if _, ok := response.(protocol.SimpleStringValue); ok && strings.EqualFold(args[0], "MONITOR") {
+			streamMonitor(respReader)
+			return
+		}
 	}
 }
 
+// streamMonitor prints the MONITOR feed until the connection closes or
+// Ctrl+C exits
+func streamMonitor(reader *bufio.Reader) {
+	color.Yellow("Monitoring the server, press Ctrl+C to exit")
+	for {
+		line, err := protocol.Unmarshal(reader)
+		if err != nil {
+			color.Red("Monitor stopped: %v", err)
+			return
+		}
+		if entry, ok := line.(protocol.SimpleStringValue); ok {
+			printMonitorLine(string(entry))
+		} else {
+			printResponse(line)
+		}
+	}
+}
+
+// printMonitorLine prints a line such as
+// 1700000000.123456 [0 127.0.0.1:50000] "set" "key" "value"
+// with a readable time and the command name highlighted
+func printMonitorLine(line string) {
+	stamp, rest, ok1 := strings.Cut(line, " [")
+	source, command, ok2 := strings.Cut(rest, "] ")
+	secs, micros, ok3 := strings.Cut(stamp, ".")
+	sec, err1 := strconv.ParseInt(secs, 10, 64)
+	usec, err2 := strconv.ParseInt(micros, 10, 64)
+	if !ok1 || !ok2 || !ok3 || err1 != nil || err2 != nil {
+		fmt.Println(line)
+		return
+	}
+	_, source, _ = strings.Cut(source, " ") // drop the DB number
+
+	name, args, _ := strings.Cut(command, " ")
+	fmt.Printf("%s %s %s %s\n",
+		color.HiBlackString(time.Unix(sec, usec*1000).Format("15:04:05.000000")),
+		color.CyanString("%-21s", source),
+		color.New(color.FgYellow, color.Bold).Sprint(strings.ToUpper(strings.Trim(name, `"`))),
+		args)
+}
+
+// negotiateProtocol sends HELLO 3 and consumes the server's reply
+func negotiateProtocol(conn net.Conn, reader *bufio.Reader, user, password string) error {
+	hello := protocol.ArrayValue{protocol.BulkStringValue("HELLO"), protocol.BulkStringValue("3")}
+	if password != "" {
+		if user == "" {
+			user = "default"
+		}
+		hello = append(hello, protocol.BulkStringValue("AUTH"), protocol.BulkStringValue(user), protocol.BulkStringValue(password))
+	}
+	if _, err := conn.Write([]byte(hello.Marshal())); err != nil {
+		return err
+	}
+	reply, err := protocol.Unmarshal(reader)
+	if errValue, ok := reply.(protocol.ErrorValue); ok && password != "" {
+		return errValue
+	}
+	return err
+}
+
+// hasSecrets reports whether input is a command whose arguments include a
+// password, which must not be kept in the history
+func hasSecrets(input string) bool {
+	fields := strings.Fields(strings.ToUpper(input))
+	switch {
+	case fields[0] == "AUTH":
+		return true
+	case fields[0] == "HELLO" && slices.Contains(fields, "AUTH"):
+		return true
+	case fields[0] == "ACL" && len(fields) > 1 && fields[1] == "SETUSER":
+		return true
+	case fields[0] == "CONFIG" && slices.Contains(fields, "REQUIREPASS"):
+		return true
+	}
+	return false
+}
+
 // handleLocalCommand processes commands that are handled locally by the CLI
 func handleLocalCommand(input string, history *CommandHistory) bool {
 	cmd := strings.ToUpper(strings.Fields(input)[0])
@@ -227,6 +413,22 @@ func clearScreen() {
 	color.Yellow("Read more about hunter on https://orion.thestarsociety.tech/docs/packages/hunter")
 }
 
+// printInfo prints the reply of INFO with its section headers and field
+// names highlighted
+func printInfo(info string) {
+	for _, line := range strings.Split(strings.TrimSuffix(info, "\r\n"), "\r\n") {
+		switch {
+		case line == "":
+			fmt.Println()
+		case strings.HasPrefix(line, "#"):
+			color.Yellow("%s", line)
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			fmt.Printf("%s:%s\n", color.HiBlackString(field), color.CyanString(value))
+		}
+	}
+}
+
 func printResponse(response protocol.ORSPValue) {
 	switch v := response.(type) {
 	case protocol.SimpleStringValue:
@@ -258,6 +460,12 @@ func printResponse(response protocol.ORSPValue) {
 			fmt.Printf("%s: ", color.HiYellowString(key))
 			printResponse(value)
 		}
+	case protocol.AttributeValue:
+		printResponse(v.Value)
+		for key, value := range v.Attributes {
+			fmt.Printf("%s ", color.HiBlackString("| %s:", key))
+			printResponse(value)
+		}
 	case protocol.SetValue:
 		for _, item := range v {
 			printResponse(item)
@@ -271,37 +479,3 @@ func printResponse(response protocol.ORSPValue) {
 		color.HiRed("Unknown type: %T", v)
 	}
 }
-
-func parseInput(input string) []string {
-	var args []string
-	var currentArg strings.Builder
-	inQuotes := false
-
-	for _, char := range input {
-		switch char {
-		case '"':
-			inQuotes = !inQuotes
-			if !inQuotes && currentArg.Len() > 0 {
-				args = append(args, currentArg.String())
-				currentArg.Reset()
-			}
-		case ' ':
-			if !inQuotes {
-				if currentArg.Len() > 0 {
-					args = append(args, currentArg.String())
-					currentArg.Reset()
-				}
-			} else {
-				currentArg.WriteRune(char)
-			}
-		default:
-			currentArg.WriteRune(char)
-		}
-	}
-
-	if currentArg.Len() > 0 {
-		args = append(args, currentArg.String())
-	}
-
-	return args
-}
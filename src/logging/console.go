@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// consoleHandler prints records the way the server always printed them,
+// [LEVEL] message, followed by their attributes as key=value
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	min    slog.Leveler
+	attrs  string // attributes added with WithAttrs, already formatted
+	prefix string // group names added with WithGroup, dot separated
+}
+
+func newConsoleHandler(w io.Writer, min slog.Leveler) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, min: min}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.min.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString("[" + levelName(r.Level) + "] ")
+	sb.WriteString(r.Message)
+	sb.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendConsoleAttr(&sb, h.prefix, a)
+		return true
+	})
+	sb.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, sb.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	for _, a := range attrs {
+		appendConsoleAttr(&sb, h.prefix, a)
+	}
+	out := *h
+	out.attrs += sb.String()
+	return &out
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	out := *h
+	out.prefix += name + "."
+	return &out
+}
+
+func appendConsoleAttr(sb *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendConsoleAttr(sb, prefix, ga)
+		}
+		return
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	sb.WriteString(" " + prefix + a.Key + "=" + value)
+}
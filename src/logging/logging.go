@@ -0,0 +1,335 @@
+// Package logging holds Orion's structured logs, built on log/slog. Messages
+// go to the console, to info.log and error.log in the log directory and
+// optionally to syslog, executed commands go to commands.log. Packages log
+// through a subsystem logger from For, which follows Setup and SetLevel.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log files created in Config.Dir
+const (
+	InfoLogFile    = "info.log"
+	ErrorLogFile   = "error.log"
+	CommandLogFile = "commands.log"
+)
+
+// LevelVerbose sits between debug and notice, for messages such as
+// accepted and closed connections
+const LevelVerbose = slog.Level(-2)
+
+// Levels are the names loglevel accepts, from the most to the least verbose
+var Levels = []string{"debug", "verbose", "notice", "warning"}
+
+// Config configures Setup
+type Config struct {
+	// Dir is the directory holding the log files
+	Dir string
+	// Level is the least severe level logged: debug, verbose, notice or
+	// warning. error.log only gets warnings and errors whatever the level.
+	Level string
+	// Format is text for key=value lines or json for one object per line
+	Format string
+
+	// Rotation rotates the log files every hour or day, or never with none
+	Rotation string
+	// MaxSize rotates a log file once it would grow past this many bytes,
+	// zero disables size rotation
+	MaxSize int64
+	// MaxAge removes rotated files older than this, zero keeps them
+	MaxAge time.Duration
+	// MaxFiles is how many rotated files are kept per log file, zero keeps
+	// them all
+	MaxFiles int
+	// Compress gzips rotated files
+	Compress bool
+
+	// Syslog also sends messages to the local syslog daemon, under
+	// SyslogIdent and SyslogFacility (user, daemon or local0 to local7)
+	Syslog         bool
+	SyslogIdent    string
+	SyslogFacility string
+
+	// Console receives messages in a compact form, nil disables it
+	Console io.Writer
+}
+
+var (
+	level slog.LevelVar
+
+	// root receives the messages of every subsystem logger, it discards
+	// them until Setup
+	root     atomic.Pointer[slog.Handler]
+	commands atomic.Pointer[slog.Logger]
+
+	// mu serializes Setup and Close, the files and closers they own
+	mu      sync.Mutex
+	closers []io.Closer
+)
+
+func init() {
+	var h slog.Handler = discardHandler{}
+	root.Store(&h)
+}
+
+// ParseLevel returns the level named by loglevel
+func ParseLevel(name string) (slog.Level, error) {
+	switch name {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "verbose":
+		return LevelVerbose, nil
+	case "notice":
+		return slog.LevelInfo, nil
+	case "warning":
+		return slog.LevelWarn, nil
+	}
+	return 0, fmt.Errorf("invalid log level '%s', it must be debug, verbose, notice or warning", name)
+}
+
+// SetLevel changes the least severe level logged
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Setup opens the log files and sends every logger's messages to them, the
+// console and syslog. Files of a previous Setup are closed.
+func Setup(cfg Config) error {
+	l, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	if cfg.Format != "text" && cfg.Format != "json" {
+		return fmt.Errorf("invalid log format '%s', it must be text or json", cfg.Format)
+	}
+	rotation := rotateOptions{
+		interval: cfg.Rotation,
+		maxSize:  cfg.MaxSize,
+		maxAge:   cfg.MaxAge,
+		maxFiles: cfg.MaxFiles,
+		compress: cfg.Compress,
+	}
+	if err := rotation.validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	var opened []io.Closer
+	fail := func(err error) error {
+		for _, c := range opened {
+			c.Close()
+		}
+		return err
+	}
+	open := func(name string) (*rotatingFile, error) {
+		f, err := openRotatingFile(filepath.Join(cfg.Dir, name), rotation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		opened = append(opened, f)
+		return f, nil
+	}
+	newHandler := func(w io.Writer, min slog.Leveler) slog.Handler {
+		opts := handlerOptions(min)
+		if cfg.Format == "json" {
+			return slog.NewJSONHandler(w, opts)
+		}
+		return slog.NewTextHandler(w, opts)
+	}
+
+	infoFile, err := open(InfoLogFile)
+	if err != nil {
+		return fail(err)
+	}
+	errorFile, err := open(ErrorLogFile)
+	if err != nil {
+		return fail(err)
+	}
+	commandFile, err := open(CommandLogFile)
+	if err != nil {
+		return fail(err)
+	}
+
+	handlers := fanoutHandler{
+		newHandler(infoFile, &level),
+		newHandler(errorFile, slog.LevelWarn),
+	}
+	if cfg.Console != nil {
+		handlers = append(handlers, newConsoleHandler(cfg.Console, &level))
+	}
+	if cfg.Syslog {
+		h, closer, err := newSyslogHandler(cfg.SyslogIdent, cfg.SyslogFacility, handlerOptions(&level))
+		if err != nil {
+			return fail(fmt.Errorf("failed to connect to syslog: %w", err))
+		}
+		opened = append(opened, closer)
+		handlers = append(handlers, h)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	level.Set(l)
+	var h slog.Handler = handlers
+	root.Store(&h)
+	// Commands are an audit trail of their own, logged whatever the level
+	commands.Store(slog.New(newHandler(commandFile, slog.LevelInfo)))
+	previous := closers
+	closers = opened
+	for _, c := range previous {
+		c.Close()
+	}
+	For("logging").Info("Logging system initialized")
+	return nil
+}
+
+// Close stops logging and closes the log files, waiting for rotated files
+// to be compressed
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	var h slog.Handler = discardHandler{}
+	root.Store(&h)
+	commands.Store(nil)
+	var errs []error
+	for _, c := range closers {
+		errs = append(errs, c.Close())
+	}
+	closers = nil
+	return errors.Join(errs...)
+}
+
+// Logger returns the process-wide logger set up by Setup. Embedders running
+// several servers can give each its own logger instead.
+func Logger() *slog.Logger {
+	return slog.New(switchHandler{})
+}
+
+// For returns the process-wide logger of a subsystem such as aof, store or
+// net. Its messages carry a subsystem attribute.
+func For(subsystem string) *slog.Logger {
+	return Logger().With("subsystem", subsystem)
+}
+
+// Command logs a command run by the client at addr to commands.log
+func Command(addr, command string) {
+	if l := commands.Load(); l != nil {
+		l.Info("command", "client", addr, "command", command)
+	}
+}
+
+// handlerOptions names LevelVerbose in the output
+func handlerOptions(min slog.Leveler) *slog.HandlerOptions {
+	return &slog.HandlerOptions{
+		Level: min,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				a.Value = slog.StringValue(levelName(a.Value.Any().(slog.Level)))
+			}
+			return a
+		},
+	}
+}
+
+func levelName(l slog.Level) string {
+	if l == LevelVerbose {
+		return "VERBOSE"
+	}
+	return l.String()
+}
+
+// switchHandler sends records to the current root handler, so loggers made
+// before Setup follow it. Attributes and groups are replayed on the root
+// handler for each record.
+type switchHandler struct {
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h switchHandler) current() slog.Handler {
+	handler := *root.Load()
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+	return handler
+}
+
+func (h switchHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return (*root.Load()).Enabled(ctx, l)
+}
+
+func (h switchHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h switchHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return switchHandler{with: append(h.with[:len(h.with):len(h.with)], func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})}
+}
+
+func (h switchHandler) WithGroup(name string) slog.Handler {
+	return switchHandler{with: append(h.with[:len(h.with):len(h.with)], func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})}
+}
+
+// fanoutHandler sends each record to every handler enabled for its level
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
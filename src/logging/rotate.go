@@ -0,0 +1,246 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateOptions says when a log file is rotated and which rotated files are
+// kept
+type rotateOptions struct {
+	interval string // none, hourly or daily
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+	compress bool
+}
+
+func (o rotateOptions) validate() error {
+	switch o.interval {
+	case "none", "hourly", "daily":
+	default:
+		return fmt.Errorf("invalid log rotation '%s', it must be none, hourly or daily", o.interval)
+	}
+	if o.maxSize < 0 || o.maxAge < 0 || o.maxFiles < 0 {
+		return fmt.Errorf("log rotation limits can't be negative")
+	}
+	return nil
+}
+
+// next returns when a file opened at now is due for rotation, zero when it
+// never is
+func (o rotateOptions) next(now time.Time) time.Time {
+	switch o.interval {
+	case "hourly":
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location())
+	case "daily":
+		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+// rotatedSuffix is appended to the name of a rotated file, before .gz
+const rotatedSuffix = "20060102-150405"
+
+// rotatingFile is a log file that renames itself aside and starts over once
+// it is due by time or size. Rotation happens inside Write, under the
+// file's own lock, so it can't wait on anything that is logging.
+// Compression and retention of the rotated files run in the background.
+type rotatingFile struct {
+	path string
+	opts rotateOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	due  time.Time // next time based rotation, zero when there is none
+
+	cleanup sync.Mutex     // serializes compression and retention
+	wg      sync.WaitGroup // background cleanups, waited for by Close
+}
+
+func openRotatingFile(path string, opts rotateOptions) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, opts: opts}
+	if err := f.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens path for appending, called with f.mu held
+func (f *rotatingFile) open(now time.Time) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	f.due = f.opts.next(now)
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	now := time.Now()
+	bySize := f.opts.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.maxSize
+	byTime := !f.due.IsZero() && !now.Before(f.due)
+	if bySize || byTime {
+		if err := f.rotate(now); err != nil {
+			// Keep logging to whatever file is open rather than losing
+			// messages
+			fmt.Fprintf(os.Stderr, "Error rotating %s: %v\n", f.path, err)
+		}
+	}
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file aside and opens a new one, called with f.mu held
+func (f *rotatingFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	rotated := f.path + "." + now.Format(rotatedSuffix)
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = f.path + "." + now.Format(rotatedSuffix) + "-" + strconv.Itoa(i)
+	}
+	renameErr := os.Rename(f.path, rotated)
+	if err := f.open(now); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.cleanup.Lock()
+		defer f.cleanup.Unlock()
+		if f.opts.compress {
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "Error compressing %s: %v\n", rotated, err)
+			}
+		}
+		f.prune(now)
+	}()
+	return nil
+}
+
+// prune removes the rotated files past the retention limits, the newest
+// are kept
+func (f *rotatingFile) prune(now time.Time) {
+	if f.opts.maxAge == 0 && f.opts.maxFiles == 0 {
+		return
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	type rotatedFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []rotatedFile
+	for _, path := range matches {
+		if strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, rotatedFile{path, info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	for i, file := range files {
+		tooMany := f.opts.maxFiles > 0 && i >= f.opts.maxFiles
+		tooOld := f.opts.maxAge > 0 && now.Sub(file.modTime) > f.opts.maxAge
+		if tooMany || tooOld {
+			os.Remove(file.path)
+		}
+	}
+}
+
+// Close closes the file and waits for the background cleanups
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+// compressFile replaces path with path.gz
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(tmp)
+		}
+	}()
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	// Keep the rotation time so retention by age still applies
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
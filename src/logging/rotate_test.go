@@ -0,0 +1,265 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rotated lists the rotated files of path, sorted by name
+func rotated(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func write(t *testing.T, f *rotatingFile, s string) {
+	t.Helper()
+	if _, err := f.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateOptions(t *testing.T) {
+	for _, o := range []rotateOptions{
+		{interval: "weekly"},
+		{interval: "none", maxSize: -1},
+		{interval: "none", maxAge: -time.Hour},
+		{interval: "daily", maxFiles: -1},
+	} {
+		if o.validate() == nil {
+			t.Errorf("%+v is valid", o)
+		}
+	}
+
+	now := time.Date(2024, 3, 31, 23, 40, 5, 0, time.UTC)
+	tests := map[string]time.Time{
+		"none":   {},
+		"hourly": time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		"daily":  time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for interval, want := range tests {
+		if got := (rotateOptions{interval: interval}).next(now); !got.Equal(want) {
+			t.Errorf("%s rotation after %v is due at %v, want %v", interval, now, got, want)
+		}
+	}
+	if got := (rotateOptions{interval: "hourly"}).next(now.Add(-time.Hour)); !got.Equal(time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("hourly rotation after %v is due at %v", now.Add(-time.Hour), got)
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "info.log")
+	f, err := openRotatingFile(path, rotateOptions{interval: "none", maxSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// A message larger than the limit still goes into an empty file
+	big := strings.Repeat("x", 150) + "\n"
+	write(t, f, big)
+	if files := rotated(t, path); len(files) != 0 {
+		t.Fatalf("the first message rotated the empty file into %v", files)
+	}
+	// The next message would grow the file past the limit
+	write(t, f, "next\n")
+	write(t, f, "more\n")
+	files := rotated(t, path)
+	if len(files) != 1 {
+		t.Fatalf("one size rotation left %v", files)
+	}
+	if data, _ := os.ReadFile(files[0]); string(data) != big {
+		t.Errorf("the rotated file holds %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "next\nmore\n" {
+		t.Errorf("the new file holds %q", data)
+	}
+
+	// Rotating twice within a second doesn't overwrite the first file
+	write(t, f, strings.Repeat("y", 100))
+	write(t, f, strings.Repeat("z", 100))
+	if files := rotated(t, path); len(files) != 3 {
+		t.Errorf("three size rotations left %v", files)
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "info.log")
+	f, err := openRotatingFile(path, rotateOptions{interval: "hourly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.due.IsZero() || f.due.Sub(time.Now()) > time.Hour {
+		t.Fatalf("an hourly file opened now is due at %v", f.due)
+	}
+
+	write(t, f, "before\n")
+	f.mu.Lock()
+	f.due = time.Now().Add(-time.Second)
+	f.mu.Unlock()
+	write(t, f, "after\n")
+
+	files := rotated(t, path)
+	if len(files) != 1 {
+		t.Fatalf("the time rotation left %v", files)
+	}
+	if data, _ := os.ReadFile(files[0]); string(data) != "before\n" {
+		t.Errorf("the rotated file holds %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("the new file holds %q", data)
+	}
+	if !f.due.After(time.Now()) {
+		t.Errorf("after rotating the file is due at %v", f.due)
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "info.log")
+	f, err := openRotatingFile(path, rotateOptions{interval: "none", maxSize: 10, compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, f, "first line\n")
+	write(t, f, "second line\n")
+	// Close waits for the compression
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("closed\n")); err != os.ErrClosed {
+		t.Errorf("Write after Close got %v", err)
+	}
+
+	files := rotated(t, path)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".gz") {
+		t.Fatalf("compressing left %v", files)
+	}
+	gz, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "first line\n" {
+		t.Errorf("the compressed file holds %q", data)
+	}
+}
+
+func TestRotateRetention(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "info.log")
+	now := time.Now()
+	// Rotated files from one to five hours old, plus files prune leaves alone
+	for i := 1; i <= 5; i++ {
+		name := path + "." + now.Add(-time.Duration(i)*time.Hour).Format(rotatedSuffix)
+		if i%2 == 0 {
+			name += ".gz"
+		}
+		if err := os.WriteFile(name, []byte("old\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keep := []string{path + ".20000101-000000.gz.tmp", filepath.Join(dir, "error.log.20000101-000000")}
+	for _, name := range keep {
+		if err := os.WriteFile(name, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ages := func() []time.Duration {
+		var ages []time.Duration
+		for _, name := range rotated(t, path) {
+			if strings.HasSuffix(name, ".tmp") {
+				continue
+			}
+			info, err := os.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ages = append(ages, now.Sub(info.ModTime()).Round(time.Hour))
+		}
+		return ages
+	}
+
+	// Nothing is removed without limits
+	(&rotatingFile{path: path, opts: rotateOptions{}}).prune(now)
+	if got := ages(); len(got) != 5 {
+		t.Fatalf("pruning without limits left %v", got)
+	}
+	// maxAge removes the files older than it
+	(&rotatingFile{path: path, opts: rotateOptions{maxAge: 150 * time.Minute}}).prune(now)
+	if got := ages(); len(got) != 2 {
+		t.Errorf("pruning files older than 2h30m left %v", got)
+	}
+	// maxFiles keeps the newest
+	(&rotatingFile{path: path, opts: rotateOptions{maxFiles: 1}}).prune(now)
+	if got := ages(); len(got) != 1 || got[0] != time.Hour {
+		t.Errorf("keeping one file left %v", got)
+	}
+	for _, name := range keep {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("pruning removed %s", name)
+		}
+	}
+
+	// Rotation prunes in the background
+	f, err := openRotatingFile(path, rotateOptions{interval: "none", maxSize: 1, maxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, f, "a\n")
+	write(t, f, "b\n")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ages(); len(got) != 1 || got[0] != 0 {
+		t.Errorf("rotating with max-files 1 left files %v old", got)
+	}
+}
+
+func TestSetupRotation(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{Dir: dir, Level: "notice", Format: "text", Rotation: "monthly"}
+	if err := Setup(cfg); err == nil {
+		t.Fatal("Setup accepted monthly rotation")
+	}
+	cfg.Rotation, cfg.MaxSize, cfg.MaxFiles = "none", 200, 2
+	if err := Setup(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	// Every log file rotates by size and keeps max-files rotated files
+	log := For("test")
+	for i := 0; i < 20; i++ {
+		log.Warn("disk almost full", "attempt", i)
+		Command("127.0.0.1:50000", "SET k v")
+	}
+	if err := Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{InfoLogFile, ErrorLogFile, CommandLogFile} {
+		path := filepath.Join(dir, name)
+		if files := rotated(t, path); len(files) != 2 {
+			t.Errorf("%s was rotated into %v, want 2 files", name, files)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() > 200 {
+			t.Errorf("%s grew to %v past max-size: %v", name, info.Size(), err)
+		}
+	}
+}
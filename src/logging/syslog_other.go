@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+func newSyslogHandler(ident, facility string, opts *slog.HandlerOptions) (slog.Handler, io.Closer, error) {
+	return nil, nil, errors.New("syslog isn't supported on this platform")
+}
@@ -0,0 +1,95 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// syslogHandler formats records as key=value text without time and level,
+// which syslog adds itself, and sends them with the matching severity
+type syslogHandler struct {
+	w     *syslog.Writer
+	mu    *sync.Mutex
+	buf   *bytes.Buffer
+	inner slog.Handler // writes to buf
+}
+
+func newSyslogHandler(ident, facility string, opts *slog.HandlerOptions) (slog.Handler, io.Closer, error) {
+	priority, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid syslog facility '%s'", facility)
+	}
+	w, err := syslog.New(priority|syslog.LOG_NOTICE, ident)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	textOpts := *opts
+	textOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+	buf := &bytes.Buffer{}
+	h := &syslogHandler{w: w, mu: &sync.Mutex{}, buf: buf, inner: slog.NewTextHandler(buf, &textOpts)}
+	return h, w, nil
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.inner.Enabled(ctx, l)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(h.buf.String(), "\n")
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(line)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(line)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Notice(line)
+	case r.Level >= LevelVerbose:
+		return h.w.Info(line)
+	}
+	return h.w.Debug(line)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := *h
+	out.inner = h.inner.WithAttrs(attrs)
+	return &out
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	out := *h
+	out.inner = h.inner.WithGroup(name)
+	return &out
+}
@@ -0,0 +1,183 @@
+// Package ratewindow is an example Orion module providing sliding-window rate
+// counters. Build the server with -tags ratewindow to compile it in.
+//
+//	RW.HIT key window-ms limit [TIME unix-ms]   records a hit unless limit hits
+//	                                            already fall inside the window
+//	RW.COUNT key                                counts the hits in the window
+package ratewindow
+
+import (
+	"encoding/json"
+	"orion/src/commands"
+	"orion/src/data"
+	"orion/src/protocol"
+	"orion/src/server"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const typeName = "ratewindow"
+
+func init() {
+	server.RegisterModule(module{})
+}
+
+// window is the value stored for each rate window key
+type window struct {
+	Window int64   `json:"window"` // length in milliseconds
+	Hits   []int64 `json:"hits"`   // hit times in unix milliseconds, oldest first
+}
+
+// trim drops the hits that fell out of the window at now
+func (w *window) trim(now int64) {
+	i := 0
+	for i < len(w.Hits) && w.Hits[i] <= now-w.Window {
+		i++
+	}
+	w.Hits = w.Hits[i:]
+}
+
+type module struct{}
+
+func (module) Name() string { return typeName }
+
+func (module) Register(ctx *server.ModuleContext) error {
+	err := ctx.DefineType(data.ModuleType{
+		Name: typeName,
+		Save: func(value any) ([]byte, error) {
+			return json.Marshal(value)
+		},
+		Load: func(payload []byte) (any, error) {
+			w := &window{}
+			err := json.Unmarshal(payload, w)
+			return w, err
+		},
+		Rewrite: func(key string, value any) []protocol.ArrayValue {
+			w := value.(*window)
+			cmds := make([]protocol.ArrayValue, 0, len(w.Hits))
+			for _, hit := range w.Hits {
+				cmds = append(cmds, hitCommand(key, w.Window, 0, hit))
+			}
+			return cmds
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.AddCommand(&server.Command{
+		Name: "RW.HIT", Handler: handleHit, Arity: -4, Flags: server.FlagWrite | server.FlagDenyOOM | server.FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@ratewindow"},
+		Group: "module", Summary: "Records a hit in a sliding rate window unless the limit is reached",
+		Syntax: "RW.HIT key window-ms limit [TIME unix-ms]", Complexity: "O(N) where N is the number of expired hits",
+		// The hit is only recorded under the limit, so the window is read too
+		KeySpecs: []server.KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: server.KeyRead | server.KeyWrite}},
+	}); err != nil {
+		return err
+	}
+
+	return ctx.AddCommand(&server.Command{
+		Name: "RW.COUNT", Handler: handleCount, Arity: 2, Flags: server.FlagReadOnly | server.FlagFast,
+		FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@ratewindow"},
+		Group: "module", Summary: "Returns the number of hits inside a rate window",
+		Syntax: "RW.COUNT key", Complexity: "O(N) where N is the number of hits",
+	})
+}
+
+// handleHit implements RW.HIT
+func handleHit(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	if len(args) != 3 && len(args) != 5 {
+		return protocol.ErrorValue("ERR wrong number of arguments for 'rw.hit' command")
+	}
+
+	key, _ := args[0].(protocol.BulkStringValue)
+	windowMs, err := intArg(args[1])
+	if err != nil || windowMs <= 0 {
+		return protocol.ErrorValue("ERR window must be a positive integer")
+	}
+	limit, err := intArg(args[2])
+	if err != nil || limit < 0 {
+		return protocol.ErrorValue("ERR limit must be a non-negative integer")
+	}
+
+	now := time.Now().UnixMilli()
+	if len(args) == 5 {
+		option, _ := args[3].(protocol.BulkStringValue)
+		if strings.ToUpper(string(option)) != "TIME" {
+			return protocol.ErrorValue("ERR syntax error")
+		}
+		if now, err = intArg(args[4]); err != nil {
+			return protocol.ErrorValue("ERR value is not an integer or out of range")
+		}
+	}
+
+	recorded := false
+	err = ctx.Store.UpdateModuleValue(string(key), typeName, func(value any, exists bool) (any, error) {
+		w := &window{Window: windowMs}
+		if exists {
+			w = value.(*window)
+			w.Window = windowMs
+		}
+		w.trim(now)
+		if limit == 0 || int64(len(w.Hits)) < limit {
+			w.Hits = append(w.Hits, now)
+			recorded = true
+		}
+		return w, nil
+	})
+	if err != nil {
+		return protocol.ErrorValue(err.Error())
+	}
+
+	// Record the hit time so replaying the AOF reaches the same decision
+	ctx.Propagate(hitCommand(string(key), windowMs, limit, now))
+
+	if recorded {
+		return protocol.IntegerValue(1)
+	}
+	return protocol.IntegerValue(0)
+}
+
+// handleCount implements RW.COUNT
+func handleCount(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	key, _ := args[0].(protocol.BulkStringValue)
+
+	// Count under the store's read lock without trimming, RW.HIT changes
+	// the window in place and reads never modify it
+	var count int64
+	wrongType := false
+	ctx.Store.ViewModuleValue(string(key), func(typ string, value any) {
+		if typ != typeName {
+			wrongType = true
+			return
+		}
+		w := value.(*window)
+		cutoff := time.Now().UnixMilli() - w.Window
+		for _, hit := range w.Hits {
+			if hit > cutoff {
+				count++
+			}
+		}
+	})
+	if wrongType {
+		return protocol.ErrorValue(data.ErrWrongType.Error())
+	}
+	return protocol.IntegerValue(count)
+}
+
+func hitCommand(key string, windowMs, limit, at int64) protocol.ArrayValue {
+	return protocol.ArrayValue{
+		protocol.BulkStringValue("RW.HIT"),
+		protocol.BulkStringValue(key),
+		protocol.BulkStringValue(strconv.FormatInt(windowMs, 10)),
+		protocol.BulkStringValue(strconv.FormatInt(limit, 10)),
+		protocol.BulkStringValue("TIME"),
+		protocol.BulkStringValue(strconv.FormatInt(at, 10)),
+	}
+}
+
+func intArg(arg protocol.ORSPValue) (int64, error) {
+	s, _ := arg.(protocol.BulkStringValue)
+	return strconv.ParseInt(string(s), 10, 64)
+}
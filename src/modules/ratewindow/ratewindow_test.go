@@ -0,0 +1,72 @@
+package ratewindow
+
+import (
+	"context"
+	"orion/src/protocol"
+	"orion/src/server"
+	"sync"
+	"testing"
+)
+
+func newServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.New(server.Config{Dir: t.TempDir(), Modules: []string{typeName}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestHitAndCount(t *testing.T) {
+	s := newServer(t)
+	ctx := context.Background()
+	for i, want := range []protocol.IntegerValue{1, 1, 0} {
+		reply, err := s.Do(ctx, "RW.HIT", "api", "60000", "2")
+		if err != nil || reply != want {
+			t.Fatalf("hit %d got %#v, %v, want %d", i, reply, err, want)
+		}
+	}
+	if reply, _ := s.Do(ctx, "RW.COUNT", "api"); reply != protocol.IntegerValue(2) {
+		t.Errorf("RW.COUNT got %#v, want 2", reply)
+	}
+	// Hits far in the past have left the window
+	if reply, _ := s.Do(ctx, "RW.HIT", "old", "1000", "0", "TIME", "1"); reply != protocol.IntegerValue(1) {
+		t.Fatalf("RW.HIT TIME got %#v", reply)
+	}
+	if reply, _ := s.Do(ctx, "RW.COUNT", "old"); reply != protocol.IntegerValue(0) {
+		t.Errorf("RW.COUNT of expired hits got %#v, want 0", reply)
+	}
+}
+
+// Run with -race: RW.COUNT reads the window RW.HIT changes in place
+func TestConcurrentHitAndCount(t *testing.T) {
+	s := newServer(t)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := s.Do(ctx, "RW.HIT", "shared", "60000", "0"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := s.Do(ctx, "RW.COUNT", "shared"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if reply, _ := s.Do(ctx, "RW.COUNT", "shared"); reply != protocol.IntegerValue(800) {
+		t.Errorf("RW.COUNT got %#v, want 800", reply)
+	}
+}
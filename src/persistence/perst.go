@@ -2,11 +2,23 @@ package persistence
 
 import (
 	"encoding/gob"
+	"io"
 	"orion/src/data"
 	"os"
 )
 
-func SaveToFile(filename string) error {
+// snapshot is the layout written by SaveToFile
+type snapshot struct {
+	Strings map[string]string
+	Modules []data.ModuleEntry // values of module-defined types
+}
+
+func SaveToFile(ds *data.DataStore, filename string) error {
+	strings, modules, err := ds.Snapshot()
+	if err != nil {
+		return err
+	}
+
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -14,5 +26,41 @@ func SaveToFile(filename string) error {
 	defer file.Close()
 
 	encoder := gob.NewEncoder(file)
-	return encoder.Encode(data.Store.GetAllData())
+	return encoder.Encode(snapshot{
+		Strings: strings,
+		Modules: modules,
+	})
+}
+
+// LoadFromFile restores a snapshot written by SaveToFile into ds. Module
+// types must be registered before loading. Snapshots from before module
+// values were saved, a bare map of the string keys, are read too.
+func LoadFromFile(ds *data.DataStore, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(file).Decode(&snap); err != nil {
+		if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+			return err
+		}
+		var legacy map[string]string
+		if legacyErr := gob.NewDecoder(file).Decode(&legacy); legacyErr != nil {
+			return err
+		}
+		snap = snapshot{Strings: legacy}
+	}
+
+	for key, value := range snap.Strings {
+		ds.Set(key, value, 0)
+	}
+	for _, entry := range snap.Modules {
+		if err := ds.LoadModuleValue(entry); err != nil {
+			return err
+		}
+	}
+	return nil
 }
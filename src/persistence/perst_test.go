@@ -0,0 +1,81 @@
+package persistence
+
+import (
+	"encoding/gob"
+	"orion/src/data"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newStore(t *testing.T) *data.DataStore {
+	t.Helper()
+	ds := data.NewDataStore()
+	t.Cleanup(ds.Close)
+	err := ds.RegisterType(data.ModuleType{
+		Name: "counter",
+		Save: func(value any) ([]byte, error) { return []byte(value.(string)), nil },
+		Load: func(payload []byte) (any, error) { return string(payload), nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.orion")
+	src := newStore(t)
+	src.Set("greeting", "hello", 0)
+	src.Set("empty", "", 0)
+	if err := src.SetModuleValue("hits", "counter", "42"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveToFile(src, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newStore(t)
+	if err := LoadFromFile(dst, filename); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"greeting": "hello", "empty": ""} {
+		if got, ok := dst.Get(key); !ok || got != want {
+			t.Errorf("%s = %q, %v after loading, want %q", key, got, ok, want)
+		}
+	}
+	if typ, value, ok := dst.GetModuleValue("hits"); !ok || typ != "counter" || value != "42" {
+		t.Errorf("hits = %s %v, %v after loading", typ, value, ok)
+	}
+}
+
+// Snapshots used to be a bare gob map of the string keys
+func TestLoadLegacySnapshot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.orion")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(file).Encode(map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	ds := newStore(t)
+	if err := LoadFromFile(ds, filename); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ds.Get("k"); !ok || got != "v" {
+		t.Errorf("k = %q, %v after loading a legacy snapshot", got, ok)
+	}
+}
+
+func TestLoadCorruptSnapshot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.orion")
+	if err := os.WriteFile(filename, []byte("not a snapshot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFromFile(newStore(t), filename); err == nil {
+		t.Error("a corrupt snapshot loaded without error")
+	}
+}
@@ -0,0 +1,254 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// legacyMarshalSet is the fmt/+= encoder Marshal used before the Encoder, kept
+// to measure against
+func legacyMarshalSet(v SetValue) string {
+	s := fmt.Sprintf("~%d\r\n", len(v))
+	for _, item := range v {
+		s += fmt.Sprintf("$%d\r\n%s\r\n", len(item.(BulkStringValue)), item)
+	}
+	return s
+}
+
+// legacyReadCommand is the ReadString based parser Unmarshal used before the
+// Decoder, kept to measure against
+func legacyReadCommand(reader *bufio.Reader) (ArrayValue, error) {
+	reader.ReadByte()
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line))
+	arr := make(ArrayValue, n)
+	for i := range arr {
+		reader.ReadByte()
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, _ := strconv.Atoi(strings.TrimSpace(line))
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		reader.ReadString('\n')
+		arr[i] = BulkStringValue(data)
+	}
+	return arr, nil
+}
+
+func members(n int) []ORSPValue {
+	values := make([]ORSPValue, n)
+	for i := range values {
+		values[i] = BulkStringValue("member:" + strconv.Itoa(i))
+	}
+	return values
+}
+
+func BenchmarkMarshalSetLegacy(b *testing.B) {
+	set := SetValue(members(10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		legacyMarshalSet(set)
+	}
+}
+
+func BenchmarkMarshalSet(b *testing.B) {
+	set := SetValue(members(10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = set.Marshal()
+	}
+}
+
+func BenchmarkEncodeSMembers(b *testing.B) {
+	reply := ArrayValue(members(10000))
+	enc := NewEncoder(io.Discard)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		enc.Encode(reply)
+		enc.Flush()
+	}
+}
+
+func BenchmarkAppendValue(b *testing.B) {
+	reply := ArrayValue(members(10000))
+	var buf []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = AppendValue(buf[:0], reply)
+	}
+}
+
+// commandStream returns n SET commands as sent by a client
+func commandStream(n int) []byte {
+	var buf []byte
+	for i := 0; i < n; i++ {
+		cmd := ArrayValue{BulkStringValue("SET"), BulkStringValue("key:" + strconv.Itoa(i)), BulkStringValue("value")}
+		buf = AppendValue(buf, cmd)
+	}
+	return buf
+}
+
+func BenchmarkReadCommandLegacy(b *testing.B) {
+	stream := commandStream(1000)
+	src := bytes.NewReader(stream)
+	reader := bufio.NewReader(src)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.SetBytes(int64(len(stream)))
+	for i := 0; i < b.N; i++ {
+		src.Reset(stream)
+		reader.Reset(src)
+		for j := 0; j < 1000; j++ {
+			if _, err := legacyReadCommand(reader); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkReadCommand(b *testing.B) {
+	stream := commandStream(1000)
+	src := bytes.NewReader(stream)
+	reader := bufio.NewReader(src)
+	dec := NewDecoder(reader)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.SetBytes(int64(len(stream)))
+	for i := 0; i < b.N; i++ {
+		src.Reset(stream)
+		reader.Reset(src)
+		for j := 0; j < 1000; j++ {
+			if _, err := dec.ReadCommand(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	stream := commandStream(1000)
+	src := bytes.NewReader(stream)
+	reader := bufio.NewReader(src)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.SetBytes(int64(len(stream)))
+	for i := 0; i < b.N; i++ {
+		src.Reset(stream)
+		reader.Reset(src)
+		for j := 0; j < 1000; j++ {
+			if _, err := Unmarshal(reader); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// failingWriter fails every write and counts the attempts
+type failingWriter struct {
+	writes int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errWriteFailed
+}
+
+func TestEncodeReturnsWriteErrors(t *testing.T) {
+	big := strings.Repeat("x", 100)
+	tests := []struct {
+		name  string
+		value func() ORSPValue
+	}{
+		{"bulk string", func() ORSPValue { return BulkStringValue(big) }},
+		{"array", func() ORSPValue { return ArrayValue(members(100)) }},
+		{"set", func() ORSPValue { return SetValue(members(100)) }},
+		{"map", func() ORSPValue { return MapValue{"a": BulkStringValue(big), "b": IntegerValue(1)} }},
+		{"push", func() ORSPValue { return PushValue{Kind: "message", Data: members(100)} }},
+		{"streamed string", func() ORSPValue { return StreamedStringValue{Reader: strings.NewReader(big)} }},
+		{"streamed array", func() ORSPValue {
+			items := members(100)
+			return StreamedArrayValue{Next: func() (ORSPValue, bool) {
+				if len(items) == 0 {
+					return nil, false
+				}
+				item := items[0]
+				items = items[1:]
+				return item, true
+			}}
+		}},
+		{"error", func() ORSPValue { return ErrorValue("ERR " + big) }},
+	}
+	for _, tt := range tests {
+		for _, proto := range []int{RESP3, RESP2} {
+			w := &failingWriter{}
+			enc := NewEncoder(bufio.NewWriterSize(w, 16))
+			enc.SetProtocol(proto)
+			if err := enc.Encode(tt.value()); !errors.Is(err, errWriteFailed) {
+				t.Errorf("%s over RESP%d: got %v, want %v", tt.name, proto, err, errWriteFailed)
+			}
+			// The encoder stops at the first failure instead of encoding
+			// the rest into a broken writer
+			if w.writes != 1 {
+				t.Errorf("%s over RESP%d: %d writes, want 1", tt.name, proto, w.writes)
+			}
+		}
+	}
+}
+
+func TestReadCommandReusesArgs(t *testing.T) {
+	stream := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nfirst\r\n*2\r\n$3\r\nGET\r\n$6\r\nsecond\r\nDEL third\r\n"
+	dec := NewDecoder(strings.NewReader(stream))
+
+	first, err := dec.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Callers keeping a command copy the slice, the strings are their own
+	kept := append(ArrayValue(nil), first...)
+	value := first[2]
+
+	second, err := dec.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ArrayValue{BulkStringValue("GET"), BulkStringValue("second")}); second.Marshal() != want.Marshal() {
+		t.Errorf("second command is %v, want %v", second, want)
+	}
+	third, err := dec.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ArrayValue{BulkStringValue("DEL"), BulkStringValue("third")}); third.Marshal() != want.Marshal() {
+		t.Errorf("inline command is %v, want %v", third, want)
+	}
+
+	if want := (ArrayValue{BulkStringValue("SET"), BulkStringValue("k"), BulkStringValue("first")}); kept.Marshal() != want.Marshal() {
+		t.Errorf("copied first command became %v", kept)
+	}
+	if value != BulkStringValue("first") {
+		t.Errorf("argument of the first command became %q", value)
+	}
+	// The slice itself is shared, which is why it must be copied
+	if &first[0] != &third[0] {
+		t.Error("ReadCommand allocated a new argument slice")
+	}
+}
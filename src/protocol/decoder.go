@@ -0,0 +1,685 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultMaxBulkLen is the default proto-max-bulk-len, the largest bulk
+	// string a client may send
+	DefaultMaxBulkLen = 512 * 1024 * 1024
+	// DefaultMaxMultibulkLen is the default limit on the element count of an
+	// array, set, map or push
+	DefaultMaxMultibulkLen = 1024 * 1024
+
+	// maxLineLen bounds lines that have no length prefix (simple strings,
+	// errors, numbers), so a client can't grow the scratch buffer forever
+	maxLineLen = 64 * 1024
+	// maxNesting bounds how deep aggregates may be nested
+	maxNesting = 128
+	// maxPrealloc caps the capacity reserved up front for an aggregate, the
+	// rest is only allocated as elements actually arrive
+	maxPrealloc = 1024
+)
+
+// ProtocolError reports input that breaks ORSP framing. The stream can't be
+// resynchronized after one, so servers reply with the error and close the
+// connection.
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
+}
+
+func protocolError(format string, args ...any) error {
+	return &ProtocolError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// Decoder reads ORSP values from a buffered stream. Lines are parsed in place
+// from the reader's buffer instead of being copied into strings first.
+type Decoder struct {
+	r    *bufio.Reader
+	line []byte     // scratch space for lines longer than the read buffer
+	args ArrayValue // reused by ReadCommand
+
+	maxBulkLen      int // zero means unlimited
+	maxMultibulkLen int // zero means unlimited
+	depth           int
+}
+
+// NewDecoder returns a decoder reading from r. If r is already a
+// *bufio.Reader it is used directly. The decoder has no size limits until
+// SetLimits is called.
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br}
+}
+
+// SetLimits bounds the length of bulk strings and the element count of
+// aggregates, zero disables a limit
+func (d *Decoder) SetLimits(maxBulkLen, maxMultibulkLen int) {
+	d.maxBulkLen = maxBulkLen
+	d.maxMultibulkLen = maxMultibulkLen
+}
+
+// Buffered returns the number of bytes that can be read without blocking
+func (d *Decoder) Buffered() int {
+	return d.r.Buffered()
+}
+
+// Decode reads the next value
+func (d *Decoder) Decode() (ORSPValue, error) {
+	typeChar, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err // Return EOF directly, it's handled in LoadAOF
+		}
+		return nil, fmt.Errorf("error reading type character: %w", err)
+	}
+
+	switch typeChar {
+	case SimpleString:
+		line, err := d.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading simple string: %w", err)
+		}
+		return SimpleStringValue(line), nil
+	case Error:
+		line, err := d.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading error value: %w", err)
+		}
+		return ErrorValue(line), nil
+	case Integer:
+		n, err := d.readInt()
+		if err != nil {
+			return nil, fmt.Errorf("error reading integer: %w", err)
+		}
+		return IntegerValue(n), nil
+	case BulkString:
+		return d.decodeBulkString()
+	case Array:
+		return d.decodeArray()
+	case Null:
+		line, err := d.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading null terminator: %w", err)
+		}
+		if len(line) != 0 {
+			return nil, protocolError("invalid null")
+		}
+		return NullValue{}, nil
+	case Boolean:
+		line, err := d.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading boolean value: %w", err)
+		}
+		if len(line) != 1 || (line[0] != 't' && line[0] != 'f') {
+			return nil, protocolError("invalid boolean")
+		}
+		return BooleanValue(line[0] == 't'), nil
+	case Double:
+		line, err := d.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading double value: %w", err)
+		}
+		f, err := strconv.ParseFloat(string(line), 64)
+		if err != nil {
+			return nil, protocolError("invalid double %q", line)
+		}
+		return DoubleValue(f), nil
+	case BigNumber:
+		line, err := d.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading big number: %w", err)
+		}
+		n, ok := new(big.Int).SetString(string(line), 10)
+		if !ok {
+			return nil, protocolError("invalid big number %q", line)
+		}
+		return &BigNumberValue{*n}, nil
+	case BulkError:
+		return d.decodeBulkError()
+	case VerbatimString:
+		return d.decodeVerbatimString()
+	case Map:
+		return d.decodeMap()
+	case Set:
+		items, err := d.decodeItems("set")
+		if err != nil {
+			return nil, err
+		}
+		return SetValue(items), nil
+	case Push:
+		return d.decodePush()
+	case Attribute:
+		return d.decodeAttribute()
+	default:
+		return nil, protocolError("unknown type %q", typeChar)
+	}
+}
+
+// ReadCommand reads a command sent as an array of bulk strings, or as an
+// inline command line if the input doesn't start with '*'. The returned
+// slice is reused by the next call, callers that keep the arguments around
+// must copy it.
+func (d *Decoder) ReadCommand() (ArrayValue, error) {
+	typeChar, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error reading type character: %w", err)
+	}
+	if typeChar != Array {
+		d.r.UnreadByte()
+		return d.readInlineCommand()
+	}
+
+	n, err := d.readLength(d.maxMultibulkLen, "multibulk")
+	if err != nil {
+		return nil, err
+	}
+	args := d.args[:0]
+	for i := 0; i < n; i++ {
+		typeChar, err := d.r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading argument %d: %w", i, err)
+		}
+		if typeChar != BulkString {
+			return nil, protocolError("expected '$', got %q", typeChar)
+		}
+		length, err := d.readLength(d.maxBulkLen, "bulk")
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, protocolError("invalid bulk length")
+		}
+		arg, err := d.readPayload(length)
+		if err != nil {
+			return nil, fmt.Errorf("error reading argument %d: %w", i, err)
+		}
+		args = append(args, BulkStringValue(arg))
+	}
+	d.args = args
+	return args, nil
+}
+
+// readInlineCommand reads the next non-empty inline command line
+func (d *Decoder) readInlineCommand() (ArrayValue, error) {
+	for {
+		fields, err := d.readInline()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		args := d.args[:0]
+		for _, field := range fields {
+			args = append(args, BulkStringValue(field))
+		}
+		d.args = args
+		return args, nil
+	}
+}
+
+func (d *Decoder) decodeBulkString() (ORSPValue, error) {
+	if d.streamed() {
+		return d.decodeStreamedString()
+	}
+	n, err := d.readLength(d.maxBulkLen, "bulk")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bulk string length: %w", err)
+	}
+	if n < 0 {
+		return NullValue{}, nil // RESP2 null bulk string
+	}
+	s, err := d.readPayload(n)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bulk string data: %w", err)
+	}
+	return BulkStringValue(s), nil
+}
+
+func (d *Decoder) decodeArray() (ORSPValue, error) {
+	items, err := d.decodeItems("array")
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		return NullValue{}, nil // RESP2 null array
+	}
+	return ArrayValue(items), nil
+}
+
+// decodeItems reads a length-prefixed or streamed list of values. Only arrays
+// may have the null length, for which it returns nil.
+func (d *Decoder) decodeItems(kind string) ([]ORSPValue, error) {
+	if d.streamed() {
+		return d.decodeStreamedItems(kind)
+	}
+	n, err := d.readLength(d.maxMultibulkLen, "multibulk")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s length: %w", kind, err)
+	}
+	if n < 0 {
+		if kind != "array" {
+			return nil, protocolError("invalid %s length", kind)
+		}
+		return nil, nil
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	items := make([]ORSPValue, 0, min(n, maxPrealloc))
+	for i := 0; i < n; i++ {
+		item, err := d.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s element %d: %w", kind, i, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (d *Decoder) decodeBulkError() (ORSPValue, error) {
+	n, err := d.readLength(d.maxBulkLen, "bulk")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bulk error length: %w", err)
+	}
+	code, err := d.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading bulk error code: %w", err)
+	}
+	codeStr := string(code)
+	message, err := d.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading bulk error message: %w", err)
+	}
+
+	expectedLength := len(codeStr) + len(message) + 2 // +2 for the \r\n
+	if n != expectedLength {
+		return nil, protocolError("bulk error length mismatch: expected %d, got %d", n, expectedLength)
+	}
+	return BulkErrorValue{Code: codeStr, Message: string(message)}, nil
+}
+
+func (d *Decoder) decodeVerbatimString() (ORSPValue, error) {
+	n, err := d.readLength(d.maxBulkLen, "bulk")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing verbatim string length: %w", err)
+	}
+	if n < 0 {
+		return nil, protocolError("invalid verbatim string length")
+	}
+	data, err := d.readPayload(n)
+	if err != nil {
+		return nil, fmt.Errorf("error reading verbatim string data: %w", err)
+	}
+	format, value, ok := strings.Cut(data, ":")
+	if !ok {
+		return nil, protocolError("invalid verbatim string format")
+	}
+	return VerbatimStringValue{Format: format, Value: value}, nil
+}
+
+func (d *Decoder) decodeMap() (ORSPValue, error) {
+	if d.streamed() {
+		return d.decodePairs(-1, "map")
+	}
+	n, err := d.readLength(d.maxMultibulkLen, "multibulk")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing map length: %w", err)
+	}
+	if n < 0 {
+		return nil, protocolError("invalid map length")
+	}
+	return d.decodePairs(n, "map")
+}
+
+// decodeAttribute reads the attributes and then the reply they describe
+func (d *Decoder) decodeAttribute() (ORSPValue, error) {
+	n, err := d.readLength(d.maxMultibulkLen, "multibulk")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing attribute length: %w", err)
+	}
+	if n < 0 {
+		return nil, protocolError("invalid attribute length")
+	}
+	attributes, err := d.decodePairs(n, "attribute")
+	if err != nil {
+		return nil, err
+	}
+	// The attributed value counts as nested, or chained attributes could
+	// recurse without bound
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	value, err := d.Decode()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("error unmarshaling attributed value: %w", err)
+	}
+	return AttributeValue{Attributes: attributes, Value: value}, nil
+}
+
+// decodePairs reads n field/value pairs, or pairs up to the end marker when
+// n is negative
+func (d *Decoder) decodePairs(n int, kind string) (MapValue, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	m := make(MapValue, min(max(n, 0), maxPrealloc))
+	for i := 0; n < 0 || i < n; i++ {
+		if n < 0 {
+			end, err := d.streamEnd(i)
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				break
+			}
+		}
+		key, err := d.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s key %d: %w", kind, i, err)
+		}
+		var keyStr string
+		switch k := key.(type) {
+		case SimpleStringValue:
+			keyStr = string(k)
+		case BulkStringValue:
+			keyStr = string(k)
+		default:
+			return nil, protocolError("%s key must be a string, got %T", kind, key)
+		}
+		value, err := d.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s value %d: %w", kind, i, err)
+		}
+		m[keyStr] = value
+	}
+	return m, nil
+}
+
+func (d *Decoder) decodePush() (ORSPValue, error) {
+	n, err := d.readLength(d.maxMultibulkLen, "multibulk")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing push length: %w", err)
+	}
+	if n < 1 {
+		return nil, protocolError("push needs a kind")
+	}
+	kind, err := d.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading push kind: %w", err)
+	}
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	push := PushValue{Kind: string(kind), Data: make([]ORSPValue, 0, min(n-1, maxPrealloc))}
+	for i := 0; i < n-1; i++ {
+		item, err := d.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling push data element %d: %w", i, err)
+		}
+		push.Data = append(push.Data, item)
+	}
+	return push, nil
+}
+
+// streamed consumes a "?" length, which starts a streamed string or aggregate
+func (d *Decoder) streamed() bool {
+	// Every valid length line is at least three bytes long, so peeking
+	// never waits for input a well-formed stream doesn't send
+	b, err := d.r.Peek(3)
+	if err != nil || b[0] != '?' || b[1] != '\r' || b[2] != '\n' {
+		return false
+	}
+	d.r.Discard(3)
+	return true
+}
+
+// decodeStreamedString joins ';' chunks until the empty one
+func (d *Decoder) decodeStreamedString() (ORSPValue, error) {
+	var sb strings.Builder
+	for {
+		typeChar, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("error reading string chunk: %w", err)
+		}
+		if typeChar != StreamedChunk {
+			return nil, protocolError("expected ';', got %q", typeChar)
+		}
+		n, err := d.readLength(d.maxBulkLen, "bulk")
+		if err != nil {
+			return nil, fmt.Errorf("error parsing chunk length: %w", err)
+		}
+		if n < 0 || (d.maxBulkLen > 0 && sb.Len()+n > d.maxBulkLen) {
+			return nil, protocolError("invalid bulk length")
+		}
+		if n == 0 {
+			return BulkStringValue(sb.String()), nil
+		}
+		chunk, err := d.readPayload(n)
+		if err != nil {
+			return nil, fmt.Errorf("error reading string chunk: %w", err)
+		}
+		sb.WriteString(chunk)
+	}
+}
+
+// decodeStreamedItems reads values until the end marker. The result is never
+// nil, an empty streamed array isn't the null array.
+func (d *Decoder) decodeStreamedItems(kind string) ([]ORSPValue, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	items := []ORSPValue{}
+	for i := 0; ; i++ {
+		end, err := d.streamEnd(i)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return items, nil
+		}
+		item, err := d.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s element %d: %w", kind, i, err)
+		}
+		items = append(items, item)
+	}
+}
+
+// streamEnd consumes the end marker of a streamed aggregate if it comes next.
+// n is the number of elements read so far, checked against the multibulk
+// limit like a length prefix would be.
+func (d *Decoder) streamEnd(n int) (bool, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+	if b[0] != StreamedEnd {
+		if d.maxMultibulkLen > 0 && n >= d.maxMultibulkLen {
+			return false, protocolError("invalid multibulk length")
+		}
+		return false, nil
+	}
+	d.r.Discard(1)
+	line, err := d.readLine()
+	if err != nil {
+		return false, err
+	}
+	if len(line) != 0 {
+		return false, protocolError("invalid streamed aggregate end")
+	}
+	return true, nil
+}
+
+func (d *Decoder) enter() error {
+	if d.depth >= maxNesting {
+		return protocolError("too many nested aggregates")
+	}
+	d.depth++
+	return nil
+}
+
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+// readLine returns the next CRLF-terminated line without its terminator. The
+// slice is only valid until the next read.
+func (d *Decoder) readLine() ([]byte, error) {
+	line, err := d.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// The line is longer than the read buffer, collect it piecewise
+		d.line = append(d.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			if len(d.line) > maxLineLen {
+				return nil, protocolError("too big line")
+			}
+			line, err = d.r.ReadSlice('\n')
+			d.line = append(d.line, line...)
+		}
+		line = d.line
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, protocolError("expected CRLF line ending")
+	}
+	return line[:len(line)-2], nil
+}
+
+// readPayload reads n bytes followed by CRLF. Memory grows with the data that
+// actually arrives, not with the length the peer announced.
+func (d *Decoder) readPayload(n int) (string, error) {
+	var s string
+	if n <= d.r.Buffered() {
+		b, _ := d.r.Peek(n)
+		s = string(b)
+		d.r.Discard(n)
+	} else {
+		var sb strings.Builder
+		sb.Grow(min(n, 64*1024))
+		if _, err := io.CopyN(&sb, d.r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		s = sb.String()
+	}
+
+	for _, want := range [2]byte{'\r', '\n'} {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if c != want {
+			return "", protocolError("expected CRLF after payload")
+		}
+	}
+	return s, nil
+}
+
+// readLength reads a length prefix. -1 is the null length, anything above
+// limit (when non-zero) is rejected.
+func (d *Decoder) readLength(limit int, kind string) (int, error) {
+	line, err := d.readLine()
+	if err != nil {
+		return 0, err
+	}
+	n, err := parseInt(line)
+	if err != nil || n < -1 || n > int64(maxInt) || (limit > 0 && n > int64(limit)) {
+		return 0, protocolError("invalid %s length", kind)
+	}
+	return int(n), nil
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+func (d *Decoder) readInt() (int64, error) {
+	line, err := d.readLine()
+	if err != nil {
+		return 0, err
+	}
+	n, err := parseInt(line)
+	if err != nil {
+		return 0, protocolError("invalid integer %q", line)
+	}
+	return n, nil
+}
+
+var errInvalidInt = errors.New("invalid integer")
+
+// parseInt parses a decimal integer without converting b to a string
+func parseInt(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, errInvalidInt
+	}
+	negative := b[0] == '-'
+	if negative || b[0] == '+' {
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, errInvalidInt
+		}
+	}
+	// 19 digits always fit in a uint64, the int64 range is checked below
+	if len(b) > 19 {
+		return 0, errInvalidInt
+	}
+	var n uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, errInvalidInt
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	if n > 1<<63 || (!negative && n == 1<<63) {
+		return 0, errInvalidInt
+	}
+	if negative {
+		return -int64(n), nil
+	}
+	return int64(n), nil
+}
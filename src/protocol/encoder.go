@@ -0,0 +1,445 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Encoder writes ORSP values to a buffered stream. Scalars are appended into
+// the writer's free buffer space and aggregates are streamed element by
+// element, so encoding a reply does not allocate.
+type Encoder struct {
+	w     *bufio.Writer
+	resp2 bool
+	chunk []byte // read buffer for streamed strings
+}
+
+// NewEncoder returns an encoder writing to w. If w is already a
+// *bufio.Writer it is used directly.
+func NewEncoder(w io.Writer) *Encoder {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	return &Encoder{w: bw}
+}
+
+// SetProtocol selects the protocol version replies are encoded for. With
+// RESP2, RESP3-only types are downgraded the same way Downgrade does.
+func (e *Encoder) SetProtocol(proto int) {
+	e.resp2 = proto < RESP3
+}
+
+// Encode writes v to the buffer, call Flush to send it. A failed write is
+// returned as soon as it happens, the rest of v is not written.
+func (e *Encoder) Encode(v ORSPValue) error {
+	switch v := v.(type) {
+	case ArrayValue:
+		return e.encodeAggregate(Array, v)
+	case SetValue:
+		if e.resp2 {
+			return e.encodeAggregate(Array, v)
+		}
+		return e.encodeAggregate(Set, v)
+	case PushValue:
+		prefix := byte(Push)
+		if e.resp2 {
+			prefix = Array
+		}
+		if err := e.writeHeader(prefix, len(v.Data)+1); err != nil {
+			return err
+		}
+		if e.resp2 {
+			if err := e.writeBulkString(v.Kind); err != nil {
+				return err
+			}
+		} else {
+			buf, err := e.buffer(len(v.Kind) + 2)
+			if err != nil {
+				return err
+			}
+			if err := e.write(appendLine(buf, v.Kind)); err != nil {
+				return err
+			}
+		}
+		for _, item := range v.Data {
+			if err := e.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case MapValue:
+		return e.encodeMap(Map, v)
+	case AttributeValue:
+		// RESP2 has no attributes, those clients only get the reply
+		if !e.resp2 {
+			if err := e.encodeMap(Attribute, v.Attributes); err != nil {
+				return err
+			}
+		}
+		return e.Encode(v.Value)
+	case StreamedStringValue:
+		if e.resp2 {
+			// RESP2 needs the length up front
+			data, err := io.ReadAll(v.Reader)
+			if writeErr := e.writeBulkString(string(data)); writeErr != nil {
+				return writeErr
+			}
+			return err
+		}
+		return e.encodeStreamedString(v.Reader)
+	case StreamedArrayValue:
+		if e.resp2 {
+			return e.encodeAggregate(Array, drain(v))
+		}
+		if err := e.writeString("*?\r\n"); err != nil {
+			return err
+		}
+		for item, ok := v.Next(); ok; item, ok = v.Next() {
+			if err := e.Encode(item); err != nil {
+				return err
+			}
+		}
+		return e.writeString(".\r\n")
+	case BulkStringValue:
+		return e.writeBulkString(string(v))
+	default:
+		buf, err := e.buffer(64)
+		if err != nil {
+			return err
+		}
+		return e.write(appendValue(buf, v, e.resp2))
+	}
+}
+
+// Flush sends the buffered replies to the underlying writer
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+// Buffered returns the number of bytes waiting to be flushed
+func (e *Encoder) Buffered() int {
+	return e.w.Buffered()
+}
+
+func (e *Encoder) encodeAggregate(prefix byte, items []ORSPValue) error {
+	if err := e.writeHeader(prefix, len(items)); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := e.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMap writes a map or attribute with its fields sorted, so replies are
+// stable. RESP2 clients get maps as flat arrays.
+func (e *Encoder) encodeMap(prefix byte, m MapValue) error {
+	fields := sortedFields(m)
+	var err error
+	if e.resp2 {
+		err = e.writeHeader(Array, 2*len(m))
+	} else {
+		err = e.writeHeader(prefix, len(m))
+	}
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := e.writeBulkString(field); err != nil {
+			return err
+		}
+		if err := e.Encode(m[field]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamChunkSize is how much of a streamed string is read per chunk
+const streamChunkSize = 16 * 1024
+
+// encodeStreamedString copies r in chunks, so only one chunk is held in
+// memory no matter how large the string is. A read error ends the string
+// early and is returned.
+func (e *Encoder) encodeStreamedString(r io.Reader) error {
+	if err := e.writeString("$?\r\n"); err != nil {
+		return err
+	}
+	if e.chunk == nil {
+		e.chunk = make([]byte, streamChunkSize)
+	}
+	for {
+		n, err := r.Read(e.chunk)
+		if n > 0 {
+			if writeErr := e.writeChunk(e.chunk[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err != nil {
+			if writeErr := e.writeString(";0\r\n"); writeErr != nil {
+				return writeErr
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (e *Encoder) writeChunk(chunk []byte) error {
+	if err := e.writeHeader(StreamedChunk, len(chunk)); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(chunk); err != nil {
+		return err
+	}
+	return e.writeString("\r\n")
+}
+
+func (e *Encoder) writeHeader(prefix byte, n int) error {
+	buf, err := e.buffer(maxHeaderLen)
+	if err != nil {
+		return err
+	}
+	return e.write(appendHeader(buf, prefix, n))
+}
+
+// writeBulkString copies large payloads straight into the writer instead of
+// growing a scratch buffer to their size
+func (e *Encoder) writeBulkString(s string) error {
+	if len(s)+maxHeaderLen > e.w.Size() {
+		if err := e.writeHeader(BulkString, len(s)); err != nil {
+			return err
+		}
+		if err := e.writeString(s); err != nil {
+			return err
+		}
+		return e.writeString("\r\n")
+	}
+	buf, err := e.buffer(len(s) + maxHeaderLen)
+	if err != nil {
+		return err
+	}
+	return e.write(AppendBulkString(buf, s))
+}
+
+// maxHeaderLen bounds a type byte, a length and a CRLF
+const maxHeaderLen = 24
+
+// buffer returns the writer's free space, flushing first if fewer than n
+// bytes are left so appending doesn't allocate
+func (e *Encoder) buffer(n int) ([]byte, error) {
+	if e.w.Available() < n && e.w.Buffered() > 0 {
+		if err := e.w.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return e.w.AvailableBuffer(), nil
+}
+
+func (e *Encoder) write(b []byte) error {
+	_, err := e.w.Write(b)
+	return err
+}
+
+func (e *Encoder) writeString(s string) error {
+	_, err := e.w.WriteString(s)
+	return err
+}
+
+// AppendValue appends the ORSP encoding of v to dst
+func AppendValue(dst []byte, v ORSPValue) []byte {
+	return appendValue(dst, v, false)
+}
+
+// AppendBulkString appends s encoded as a bulk string to dst
+func AppendBulkString(dst []byte, s string) []byte {
+	dst = appendHeader(dst, BulkString, len(s))
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func appendHeader(dst []byte, prefix byte, n int) []byte {
+	dst = append(dst, prefix)
+	dst = strconv.AppendInt(dst, int64(n), 10)
+	return append(dst, '\r', '\n')
+}
+
+func appendLine(dst []byte, s string) []byte {
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// appendDouble formats f the way RESP3 spells infinities and NaN
+func appendDouble(dst []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(dst, "inf"...)
+	case math.IsInf(f, -1):
+		return append(dst, "-inf"...)
+	case math.IsNaN(f):
+		return append(dst, "nan"...)
+	}
+	return strconv.AppendFloat(dst, f, 'g', -1, 64)
+}
+
+// appendValue encodes v, downgrading RESP3-only types when resp2 is set
+func appendValue(dst []byte, v ORSPValue, resp2 bool) []byte {
+	switch v := v.(type) {
+	case SimpleStringValue:
+		return appendLine(append(dst, SimpleString), string(v))
+	case ErrorValue:
+		return appendLine(append(dst, Error), string(v))
+	case IntegerValue:
+		dst = strconv.AppendInt(append(dst, Integer), int64(v), 10)
+		return append(dst, '\r', '\n')
+	case BulkStringValue:
+		return AppendBulkString(dst, string(v))
+	case NullValue:
+		if resp2 {
+			return append(dst, "$-1\r\n"...)
+		}
+		return append(dst, "_\r\n"...)
+	case NullBulkStringValue:
+		return append(dst, "$-1\r\n"...)
+	case BooleanValue:
+		switch {
+		case resp2 && bool(v):
+			return append(dst, ":1\r\n"...)
+		case resp2:
+			return append(dst, ":0\r\n"...)
+		case bool(v):
+			return append(dst, "#t\r\n"...)
+		default:
+			return append(dst, "#f\r\n"...)
+		}
+	case DoubleValue:
+		if resp2 {
+			return AppendBulkString(dst, string(appendDouble(nil, float64(v))))
+		}
+		dst = appendDouble(append(dst, Double), float64(v))
+		return append(dst, '\r', '\n')
+	case *BigNumberValue:
+		if v == nil {
+			if resp2 {
+				return append(dst, "$-1\r\n"...)
+			}
+			return append(dst, "(nil\r\n"...)
+		}
+		if resp2 {
+			return AppendBulkString(dst, v.String())
+		}
+		dst = v.Append(append(dst, BigNumber), 10)
+		return append(dst, '\r', '\n')
+	case BulkErrorValue:
+		if resp2 {
+			return appendLine(append(dst, Error), v.Code+" "+v.Message)
+		}
+		// The payload is the code and message lines
+		dst = appendHeader(dst, BulkError, len(v.Code)+2+len(v.Message))
+		dst = appendLine(dst, v.Code)
+		return appendLine(dst, v.Message)
+	case VerbatimStringValue:
+		if resp2 {
+			return AppendBulkString(dst, v.Value)
+		}
+		dst = appendHeader(dst, VerbatimString, len(v.Format)+1+len(v.Value))
+		dst = append(append(dst, v.Format...), ':')
+		return appendLine(dst, v.Value)
+	case ArrayValue:
+		return appendAggregate(dst, Array, v, resp2)
+	case SetValue:
+		if resp2 {
+			return appendAggregate(dst, Array, v, resp2)
+		}
+		return appendAggregate(dst, Set, v, resp2)
+	case PushValue:
+		if resp2 {
+			dst = appendHeader(dst, Array, len(v.Data)+1)
+			dst = AppendBulkString(dst, v.Kind)
+		} else {
+			dst = appendHeader(dst, Push, len(v.Data)+1)
+			dst = appendLine(dst, v.Kind)
+		}
+		for _, item := range v.Data {
+			dst = appendValue(dst, item, resp2)
+		}
+		return dst
+	case MapValue:
+		return appendMap(dst, Map, v, resp2)
+	case AttributeValue:
+		if !resp2 {
+			dst = appendMap(dst, Attribute, v.Attributes, resp2)
+		}
+		return appendValue(dst, v.Value, resp2)
+	case StreamedStringValue:
+		data, _ := io.ReadAll(v.Reader)
+		if resp2 {
+			return AppendBulkString(dst, string(data))
+		}
+		dst = append(dst, BulkString, '?', '\r', '\n')
+		if len(data) > 0 {
+			dst = appendHeader(dst, StreamedChunk, len(data))
+			dst = appendLine(dst, string(data))
+		}
+		return append(dst, StreamedChunk, '0', '\r', '\n')
+	case StreamedArrayValue:
+		if resp2 {
+			return appendAggregate(dst, Array, drain(v), resp2)
+		}
+		dst = append(dst, Array, '?', '\r', '\n')
+		for item, ok := v.Next(); ok; item, ok = v.Next() {
+			dst = appendValue(dst, item, resp2)
+		}
+		return append(dst, StreamedEnd, '\r', '\n')
+	default:
+		return append(dst, v.Marshal()...)
+	}
+}
+
+func appendAggregate(dst []byte, prefix byte, items []ORSPValue, resp2 bool) []byte {
+	dst = appendHeader(dst, prefix, len(items))
+	for _, item := range items {
+		dst = appendValue(dst, item, resp2)
+	}
+	return dst
+}
+
+func appendMap(dst []byte, prefix byte, m MapValue, resp2 bool) []byte {
+	fields := sortedFields(m)
+	if resp2 {
+		dst = appendHeader(dst, Array, 2*len(m))
+	} else {
+		dst = appendHeader(dst, prefix, len(m))
+	}
+	for _, field := range fields {
+		dst = AppendBulkString(dst, field)
+		dst = appendValue(dst, m[field], resp2)
+	}
+	return dst
+}
+
+func sortedFields(m MapValue) []string {
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// drain collects the rest of a streamed array
+func drain(v StreamedArrayValue) ArrayValue {
+	var items ArrayValue
+	for item, ok := v.Next(); ok; item, ok = v.Next() {
+		items = append(items, item)
+	}
+	return items
+}
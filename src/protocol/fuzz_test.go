@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeAll reads one value with the limits a server would use
+func decodeAll(data []byte) (ORSPValue, error) {
+	d := NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+	d.SetLimits(1024*1024, 1024)
+	return d.Decode()
+}
+
+// sameValue compares decoded values, treating NaN doubles as equal
+func sameValue(a, b ORSPValue) bool {
+	switch a := a.(type) {
+	case DoubleValue:
+		b, ok := b.(DoubleValue)
+		if math.IsNaN(float64(a)) {
+			return ok && math.IsNaN(float64(b))
+		}
+		return ok && a == b
+	case ArrayValue:
+		b, ok := b.(ArrayValue)
+		return ok && sameValues(a, b)
+	case SetValue:
+		b, ok := b.(SetValue)
+		return ok && sameValues(a, b)
+	case PushValue:
+		b, ok := b.(PushValue)
+		return ok && a.Kind == b.Kind && sameValues(a.Data, b.Data)
+	case AttributeValue:
+		b, ok := b.(AttributeValue)
+		return ok && sameValue(a.Attributes, b.Attributes) && sameValue(a.Value, b.Value)
+	case MapValue:
+		b, ok := b.(MapValue)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if other, exists := b[key]; !exists || !sameValue(value, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+func sameValues(a, b []ORSPValue) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameValue(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// FuzzUnmarshal feeds arbitrary bytes to the decoder. It must never panic,
+// and whatever it accepts must survive a Marshal/Unmarshal round trip.
+func FuzzUnmarshal(f *testing.F) {
+	f.Add([]byte("*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"))
+	f.Add([]byte("%1\r\n+field\r\n:42\r\n"))
+	f.Add([]byte("*2147483647\r\n"))
+	f.Add([]byte("$-1\r\n"))
+	f.Add([]byte("|1\r\n+ttl\r\n:3600\r\n$5\r\nhello\r\n"))
+	f.Add([]byte("*?\r\n:1\r\n$?\r\n;2\r\nab\r\n;0\r\n.\r\n"))
+	f.Add([]byte(strings.Repeat("|0\r\n", maxNesting+1) + "+OK\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, err := decodeAll(data)
+		if err != nil {
+			return
+		}
+		encoded := AppendValue(nil, value)
+		again, err := decodeAll(encoded)
+		if err != nil {
+			t.Fatalf("re-decoding %q (from %q): %v", encoded, data, err)
+		}
+		if !sameValue(value, again) {
+			t.Fatalf("round trip changed %#v into %#v", value, again)
+		}
+	})
+}
+
+// FuzzRoundTrip builds values from fuzzed scalars and checks that every
+// value Marshal produces decodes back to itself
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("hello", "world", int64(42), 3.14, true)
+	f.Add("", "\r\n", int64(-1), math.Inf(1), false)
+	f.Add("fmt", "a:b", int64(math.MinInt64), math.NaN(), true)
+
+	f.Fuzz(func(t *testing.T, s, line string, n int64, fl float64, b bool) {
+		// Line-based types can't carry CR or LF
+		line = string(bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, []byte(line)))
+
+		value := ArrayValue{
+			BulkStringValue(s),
+			SimpleStringValue(line),
+			ErrorValue(line),
+			IntegerValue(n),
+			DoubleValue(fl),
+			BooleanValue(b),
+			NullValue{},
+			VerbatimStringValue{Format: "txt", Value: s},
+			BulkErrorValue{Code: "ERR", Message: line},
+			MapValue{s: IntegerValue(n), line: BulkStringValue(s)},
+			SetValue{BulkStringValue(s), IntegerValue(n)},
+			PushValue{Kind: line, Data: []ORSPValue{BulkStringValue(s)}},
+			ArrayValue{ArrayValue{}, ArrayValue{BooleanValue(!b)}},
+			AttributeValue{Attributes: MapValue{line: IntegerValue(n)}, Value: BulkStringValue(s)},
+		}
+
+		decoded, err := Unmarshal(bufio.NewReader(bytes.NewReader([]byte(value.Marshal()))))
+		if err != nil {
+			t.Fatalf("decoding %q: %v", value.Marshal(), err)
+		}
+		if !sameValue(value, decoded) {
+			t.Fatalf("round trip changed %#v into %#v", value, decoded)
+		}
+
+		// Any prefix of a valid stream is an incomplete value, never a panic
+		encoded := []byte(value.Marshal())
+		_, err = decodeAll(encoded[:len(encoded)/2])
+		if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			t.Fatalf("truncated input: got %v, want an EOF error", err)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrUnbalancedQuotes is returned by SplitArgs for a quote that is never
+// closed or is followed by something other than a space
+var ErrUnbalancedQuotes = errors.New("unbalanced quotes")
+
+// SplitArgs splits an inline command line into arguments. Arguments are
+// separated by whitespace and may be quoted: double quotes understand the
+// escapes \n, \r, \t, \b, \a, \\, \" and \xHH, single quotes only \'. A
+// closing quote must be followed by whitespace or the end of the line.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					arg.WriteByte(unhex(line[i+2])<<4 | unhex(line[i+3]))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					arg.WriteByte(unescape(line[i]))
+				case c == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+			case inSingle:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg.WriteByte('\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+			default:
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					arg.WriteByte(c)
+				}
+			}
+			i++
+		}
+		args = append(args, arg.String())
+	}
+}
+
+// readInline reads a command sent as a plain text line, the way telnet and nc
+// send it. A bare LF is accepted as the line ending.
+func (d *Decoder) readInline() ([]string, error) {
+	line, err := d.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		d.line = append(d.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			if len(d.line) > maxLineLen {
+				return nil, protocolError("too big inline request")
+			}
+			line, err = d.r.ReadSlice('\n')
+			d.line = append(d.line, line...)
+		}
+		line = d.line
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	args, err := SplitArgs(string(line))
+	if err != nil {
+		return nil, protocolError("%v in request", err)
+	}
+	return args, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string // nil when splitting must fail
+	}{
+		{"", []string{}},
+		{"   \t ", []string{}},
+		{"PING", []string{"PING"}},
+		{"  SET  k\tv  ", []string{"SET", "k", "v"}},
+		{`SET k "hello world"`, []string{"SET", "k", "hello world"}},
+		{`SET k 'hello world'`, []string{"SET", "k", "hello world"}},
+		{`"\x41\x62" "\x4"`, []string{"Ab", "x4"}},
+		{`"a\nb\r\t\b\a"`, []string{"a\nb\r\t\b\a"}},
+		{`"say \"hi\"" "back\\slash" "\q"`, []string{`say "hi"`, `back\slash`, "q"}},
+		{`'it\'s' 'no \n escapes'`, []string{"it's", `no \n escapes`}},
+		{`"" ''`, []string{"", ""}},
+		{`SET k ""`, []string{"SET", "k", ""}},
+		{`foo"bar baz"`, []string{"foobar baz"}},
+		{`"unterminated`, nil},
+		{`'unterminated`, nil},
+		{`"escaped quote\"`, nil},
+		{`"trailing backslash\`, nil},
+		{`"closed"x`, nil},
+		{`'closed'x`, nil},
+		{`"closed""again"`, nil},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.line)
+		if tt.want == nil {
+			if err != ErrUnbalancedQuotes {
+				t.Errorf("SplitArgs(%q) = %q, %v, want %v", tt.line, got, err, ErrUnbalancedQuotes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitArgs(%q): %v", tt.line, err)
+			continue
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+// quoteArg double quotes s so SplitArgs reads it back as one argument
+func quoteArg(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&sb, `\x%02x`, s[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// FuzzSplitArgs feeds arbitrary lines to SplitArgs. It must never panic, and
+// the arguments it accepts, quoted again, must split back the same.
+func FuzzSplitArgs(f *testing.F) {
+	for _, seed := range []string{
+		"SET k v",
+		`SET k "a\x41\n\"b"`,
+		`'it\'s' ""`,
+		`"open`,
+		`"closed"x`,
+		"\t \r\n",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		args, err := SplitArgs(line)
+		if err != nil {
+			return
+		}
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = quoteArg(arg)
+		}
+		again, err := SplitArgs(strings.Join(quoted, " "))
+		if err != nil {
+			t.Fatalf("re-splitting %q: %v", args, err)
+		}
+		if len(again) != len(args) || (len(args) > 0 && !reflect.DeepEqual(again, args)) {
+			t.Fatalf("%q split back as %q", args, again)
+		}
+	})
+}
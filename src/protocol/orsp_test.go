@@ -0,0 +1,303 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeBigNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // empty when decoding must fail
+	}{
+		{"(0\r\n", "0"},
+		{"(3492890328409238509324850943850943825024385\r\n", "3492890328409238509324850943850943825024385"},
+		{"(-3492890328409238509324850943850943825024385\r\n", "-3492890328409238509324850943850943825024385"},
+		{"(+42\r\n", "42"},
+		{"(\r\n", ""},
+		{"(-\r\n", ""},
+		{"(12a\r\n", ""},
+		{"(0x1f\r\n", ""},
+		{"(1_000\r\n", ""},
+		{"(1.5\r\n", ""},
+		{"(42\n", ""},
+	}
+	for _, tt := range tests {
+		value, err := decodeAll([]byte(tt.input))
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("decoding %q: got %v, want an error", tt.input, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decoding %q: %v", tt.input, err)
+			continue
+		}
+		n, ok := value.(*BigNumberValue)
+		if !ok || n.String() != tt.want {
+			t.Errorf("decoding %q: got %#v, want %s", tt.input, value, tt.want)
+			continue
+		}
+		if got := n.Marshal(); got != "("+tt.want+"\r\n" {
+			t.Errorf("re-encoding %q: got %q", tt.input, got)
+		}
+	}
+}
+
+func TestDecodeAttribute(t *testing.T) {
+	value, err := decodeAll([]byte("|1\r\n+key-popularity\r\n%2\r\n$1\r\na\r\n,0.1923\r\n$1\r\nb\r\n,0.0012\r\n*2\r\n:2039123\r\n:9543892\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AttributeValue{
+		Attributes: MapValue{"key-popularity": MapValue{"a": DoubleValue(0.1923), "b": DoubleValue(0.0012)}},
+		Value:      ArrayValue{IntegerValue(2039123), IntegerValue(9543892)},
+	}
+	if !sameValue(value, want) {
+		t.Fatalf("got %#v, want %#v", value, want)
+	}
+
+	if _, err := decodeAll([]byte("|1\r\n+ttl\r\n:1\r\n")); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("attribute without a reply: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := decodeAll([]byte("|-1\r\n:1\r\n")); !isProtocolError(err) {
+		t.Errorf("null attribute: got %v, want a protocol error", err)
+	}
+
+	// Chained attributes nest like aggregates do
+	if _, err := decodeAll([]byte(strings.Repeat("|0\r\n", maxNesting) + "+OK\r\n")); err != nil {
+		t.Errorf("%d chained attributes: %v", maxNesting, err)
+	}
+	for _, input := range []string{
+		strings.Repeat("|0\r\n", maxNesting+1) + "+OK\r\n",
+		strings.Repeat("*1\r\n|0\r\n", maxNesting/2+1) + "+OK\r\n",
+	} {
+		if _, err := decodeAll([]byte(input)); !isProtocolError(err) {
+			t.Errorf("attributes nested %d deep: got %v, want a protocol error", strings.Count(input, "\r\n")-1, err)
+		}
+	}
+}
+
+func TestDecodeStreamed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  ORSPValue
+	}{
+		{"string", "$?\r\n;4\r\nHell\r\n;6\r\no worl\r\n;1\r\nd\r\n;0\r\n", BulkStringValue("Hello world")},
+		{"empty string", "$?\r\n;0\r\n", BulkStringValue("")},
+		{"binary chunk", "$?\r\n;4\r\na\r\nb\r\n;0\r\n", BulkStringValue("a\r\nb")},
+		{"array", "*?\r\n:1\r\n:2\r\n:3\r\n.\r\n", ArrayValue{IntegerValue(1), IntegerValue(2), IntegerValue(3)}},
+		{"empty array", "*?\r\n.\r\n", ArrayValue{}},
+		{"nested", "*?\r\n*?\r\n$?\r\n;1\r\nx\r\n;0\r\n.\r\n.\r\n", ArrayValue{ArrayValue{BulkStringValue("x")}}},
+		{"set", "~?\r\n+a\r\n+b\r\n.\r\n", SetValue{SimpleStringValue("a"), SimpleStringValue("b")}},
+		{"map", "%?\r\n+a\r\n:1\r\n+b\r\n:2\r\n.\r\n", MapValue{"a": IntegerValue(1), "b": IntegerValue(2)}},
+	}
+	for _, tt := range tests {
+		value, err := decodeAll([]byte(tt.input))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameValue(value, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, value, tt.want)
+		}
+	}
+}
+
+func TestDecodeStreamedErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"wrong chunk type", "$?\r\n$4\r\nHell\r\n;0\r\n"},
+		{"negative chunk", "$?\r\n;-1\r\n"},
+		{"chunk over the bulk limit", "$?\r\n;1048577\r\n"},
+		{"chunks over the bulk limit", "$?\r\n" + strings.Repeat(";524288\r\n"+strings.Repeat("x", 524288)+"\r\n", 3) + ";0\r\n"},
+		{"too many elements", "*?\r\n" + strings.Repeat(":1\r\n", 1025) + ".\r\n"},
+		{"end with data", "*?\r\n.x\r\n"},
+		{"end as a map key", "%?\r\n+a\r\n.\r\n"},
+		{"streamed push", ">?\r\n+kind\r\n.\r\n"},
+	}
+	for _, tt := range tests {
+		if _, err := decodeAll([]byte(tt.input)); !isProtocolError(err) {
+			t.Errorf("%s: got %v, want a protocol error", tt.name, err)
+		}
+	}
+
+	for _, input := range []string{"$?\r\n;4\r\nHell\r\n", "*?\r\n:1\r\n"} {
+		if _, err := decodeAll([]byte(input)); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("decoding unterminated %q: got %v, want %v", input, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestEncodeStreamed(t *testing.T) {
+	items := func(values ...ORSPValue) StreamedArrayValue {
+		return StreamedArrayValue{Next: func() (ORSPValue, bool) {
+			if len(values) == 0 {
+				return nil, false
+			}
+			v := values[0]
+			values = values[1:]
+			return v, true
+		}}
+	}
+	tests := []struct {
+		name      string
+		value     func() ORSPValue
+		resp3     string
+		resp2     string
+		marshaled string
+	}{
+		{
+			name:      "string",
+			value:     func() ORSPValue { return StreamedStringValue{Reader: iotest.OneByteReader(strings.NewReader("abc"))} },
+			resp3:     "$?\r\n;1\r\na\r\n;1\r\nb\r\n;1\r\nc\r\n;0\r\n",
+			resp2:     "$3\r\nabc\r\n",
+			marshaled: "$?\r\n;3\r\nabc\r\n;0\r\n",
+		},
+		{
+			name:      "empty string",
+			value:     func() ORSPValue { return StreamedStringValue{Reader: strings.NewReader("")} },
+			resp3:     "$?\r\n;0\r\n",
+			resp2:     "$0\r\n\r\n",
+			marshaled: "$?\r\n;0\r\n",
+		},
+		{
+			name:      "array",
+			value:     func() ORSPValue { return items(IntegerValue(1), NullValue{}, BooleanValue(true)) },
+			resp3:     "*?\r\n:1\r\n_\r\n#t\r\n.\r\n",
+			resp2:     "*3\r\n:1\r\n$-1\r\n:1\r\n",
+			marshaled: "*?\r\n:1\r\n_\r\n#t\r\n.\r\n",
+		},
+		{
+			name:      "empty array",
+			value:     func() ORSPValue { return items() },
+			resp3:     "*?\r\n.\r\n",
+			resp2:     "*0\r\n",
+			marshaled: "*?\r\n.\r\n",
+		},
+		{
+			name: "attribute",
+			value: func() ORSPValue {
+				return AttributeValue{Attributes: MapValue{"ttl": IntegerValue(60)}, Value: BulkStringValue("v")}
+			},
+			resp3:     "|1\r\n$3\r\nttl\r\n:60\r\n$1\r\nv\r\n",
+			resp2:     "$1\r\nv\r\n",
+			marshaled: "|1\r\n$3\r\nttl\r\n:60\r\n$1\r\nv\r\n",
+		},
+	}
+	for _, tt := range tests {
+		for _, proto := range []int{RESP3, RESP2} {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetProtocol(proto)
+			if err := enc.Encode(tt.value()); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			enc.Flush()
+			want := tt.resp3
+			if proto == RESP2 {
+				want = tt.resp2
+			}
+			if buf.String() != want {
+				t.Errorf("%s over RESP%d: got %q, want %q", tt.name, proto, buf.String(), want)
+			}
+		}
+		if got := tt.value().Marshal(); got != tt.marshaled {
+			t.Errorf("%s: Marshal() = %q, want %q", tt.name, got, tt.marshaled)
+		}
+		if got := Downgrade(tt.value()).Marshal(); got != tt.resp2 {
+			t.Errorf("%s: Downgrade() = %q, want %q", tt.name, got, tt.resp2)
+		}
+	}
+}
+
+func TestEncodeStreamedStringReadError(t *testing.T) {
+	errBroken := errors.New("broken")
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetProtocol(RESP3)
+	err := enc.Encode(StreamedStringValue{Reader: io.MultiReader(strings.NewReader("ab"), iotest.ErrReader(errBroken))})
+	if !errors.Is(err, errBroken) {
+		t.Fatalf("got %v, want %v", err, errBroken)
+	}
+	enc.Flush()
+	// The string is still terminated so the stream stays in sync
+	if want := "$?\r\n;2\r\nab\r\n;0\r\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func isProtocolError(err error) bool {
+	var protoErr *ProtocolError
+	return errors.As(err, &protoErr)
+}
+
+func TestDowngradeNulls(t *testing.T) {
+	tests := []struct {
+		name  string
+		value ORSPValue
+		resp2 string
+	}{
+		{"null", NullValue{}, "$-1\r\n"},
+		{"nil big number", (*BigNumberValue)(nil), "$-1\r\n"},
+		{"in an array", ArrayValue{NullValue{}, IntegerValue(1)}, "*2\r\n$-1\r\n:1\r\n"},
+		{"in a map", MapValue{"b": NullValue{}, "a": BooleanValue(false)}, "*4\r\n$1\r\na\r\n:0\r\n$1\r\nb\r\n$-1\r\n"},
+		{"in a set", SetValue{NullValue{}}, "*1\r\n$-1\r\n"},
+		{"in a push", PushValue{Kind: "message", Data: []ORSPValue{NullValue{}}}, "*2\r\n$7\r\nmessage\r\n$-1\r\n"},
+		{"nested", ArrayValue{ArrayValue{MapValue{"k": NullValue{}}}}, "*1\r\n*1\r\n*2\r\n$1\r\nk\r\n$-1\r\n"},
+	}
+	for _, tt := range tests {
+		if got := Downgrade(tt.value).Marshal(); got != tt.resp2 {
+			t.Errorf("%s: Downgrade() = %q, want %q", tt.name, got, tt.resp2)
+		}
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetProtocol(RESP2)
+		if err := enc.Encode(tt.value); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		enc.Flush()
+		if buf.String() != tt.resp2 {
+			t.Errorf("%s over RESP2: got %q, want %q", tt.name, buf.String(), tt.resp2)
+		}
+	}
+}
+
+func TestDowngradeDoubles(t *testing.T) {
+	// RESP2 spells doubles the way RESP3 does, whether Downgrade or the
+	// encoder turns them into bulk strings
+	tests := []struct {
+		value DoubleValue
+		resp2 string
+	}{
+		{1.5, "$3\r\n1.5\r\n"},
+		{-0.25, "$5\r\n-0.25\r\n"},
+		{DoubleValue(math.Inf(1)), "$3\r\ninf\r\n"},
+		{DoubleValue(math.Inf(-1)), "$4\r\n-inf\r\n"},
+		{DoubleValue(math.NaN()), "$3\r\nnan\r\n"},
+	}
+	for _, tt := range tests {
+		if got := Downgrade(tt.value).Marshal(); got != tt.resp2 {
+			t.Errorf("%v: Downgrade() = %q, want %q", tt.value, got, tt.resp2)
+		}
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetProtocol(RESP2)
+		if err := enc.Encode(tt.value); err != nil {
+			t.Fatalf("%v: %v", tt.value, err)
+		}
+		enc.Flush()
+		if buf.String() != tt.resp2 {
+			t.Errorf("%v over RESP2: got %q, want %q", tt.value, buf.String(), tt.resp2)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"io"
+	"sort"
+)
+
+// Protocol versions a client can select with HELLO
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// NullBulkStringValue is the RESP2 null reply
+type NullBulkStringValue struct{}
+
+func (v NullBulkStringValue) Marshal() string {
+	return "$-1\r\n"
+}
+
+// Downgrade rewrites v using only types a RESP2 client understands: maps
+// become flat field/value arrays, sets and pushes become arrays, nulls become
+// null bulk strings, booleans become integers and doubles, big numbers and
+// verbatim strings become bulk strings. Attributes are dropped and streamed
+// values are collected, RESP2 needs their length up front.
+func Downgrade(v ORSPValue) ORSPValue {
+	switch v := v.(type) {
+	case NullValue:
+		return NullBulkStringValue{}
+	case BooleanValue:
+		if v {
+			return IntegerValue(1)
+		}
+		return IntegerValue(0)
+	case DoubleValue:
+		return BulkStringValue(appendDouble(nil, float64(v)))
+	case *BigNumberValue:
+		if v == nil {
+			return NullBulkStringValue{}
+		}
+		return BulkStringValue(v.String())
+	case BulkErrorValue:
+		return ErrorValue(v.Code + " " + v.Message)
+	case VerbatimStringValue:
+		return BulkStringValue(v.Value)
+	case MapValue:
+		// Sort the fields so RESP2 replies are stable
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		flat := make(ArrayValue, 0, 2*len(v))
+		for _, field := range fields {
+			flat = append(flat, BulkStringValue(field), Downgrade(v[field]))
+		}
+		return flat
+	case SetValue:
+		return downgradeAll(v)
+	case PushValue:
+		return append(ArrayValue{BulkStringValue(v.Kind)}, downgradeAll(v.Data)...)
+	case ArrayValue:
+		return downgradeAll(v)
+	case AttributeValue:
+		return Downgrade(v.Value)
+	case StreamedStringValue:
+		data, _ := io.ReadAll(v.Reader)
+		return BulkStringValue(data)
+	case StreamedArrayValue:
+		return downgradeAll(drain(v))
+	default:
+		return v
+	}
+}
+
+func downgradeAll(values []ORSPValue) ArrayValue {
+	downgraded := make(ArrayValue, len(values))
+	for i, item := range values {
+		downgraded[i] = Downgrade(item)
+	}
+	return downgraded
+}
@@ -0,0 +1,628 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUser is the user connections are authenticated as when it needs no
+// password, and the one AUTH <password> checks
+const defaultUser = "default"
+
+// aclUser is one ACL user. Users are never changed in place: ACL SETUSER
+// builds a new one, so a user fetched from the registry can be read without
+// locking. Command rules are kept in order, the last one matching a command
+// decides, so commands registered later are judged the same way.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords []string // hex SHA-256 digests
+	commands  []string // rules such as +@all, -@dangerous or +config|get
+	keys      []keyPattern
+	channels  []string // glob patterns
+}
+
+// keyPattern grants read and/or write access to the keys matching pattern
+type keyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+// newACLUser returns a user that is off and may do nothing, the state
+// ACL SETUSER starts a new user from
+func newACLUser(name string) *aclUser {
+	return &aclUser{name: name}
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commands = slices.Clone(u.commands)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// applyRule changes u according to one ACL SETUSER rule. Command rules are
+// checked against table.
+func (u *aclUser) applyRule(rule string, table *CommandTable) error {
+	switch lower := strings.ToLower(rule); lower {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass, u.passwords = true, nil
+	case "resetpass":
+		u.nopass, u.passwords = false, nil
+	case "allkeys":
+		u.keys = []keyPattern{{pattern: "*", read: true, write: true}}
+	case "resetkeys":
+		u.keys = nil
+	case "allchannels":
+		u.channels = []string{"*"}
+	case "resetchannels":
+		u.channels = nil
+	case "allcommands":
+		u.commands = []string{"+@all"}
+	case "nocommands":
+		u.commands = nil
+	case "reset":
+		*u = *newACLUser(u.name)
+	default:
+		if rule == "" {
+			return errors.New("Syntax error")
+		}
+		switch rule[0] {
+		case '>':
+			u.addPassword(hashPassword(rule[1:]))
+		case '<':
+			u.removePassword(hashPassword(rule[1:]))
+		case '#':
+			hash := strings.ToLower(rule[1:])
+			if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha256.Size*2 {
+				return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+			}
+			u.addPassword(hash)
+		case '!':
+			u.removePassword(strings.ToLower(rule[1:]))
+		case '~':
+			u.keys = append(u.keys, keyPattern{pattern: rule[1:], read: true, write: true})
+		case '%':
+			perms, pattern, ok := strings.Cut(rule[1:], "~")
+			if !ok || perms == "" {
+				return errors.New("Syntax error")
+			}
+			p := keyPattern{pattern: pattern}
+			for _, c := range strings.ToUpper(perms) {
+				switch c {
+				case 'R':
+					p.read = true
+				case 'W':
+					p.write = true
+				default:
+					return errors.New("Syntax error")
+				}
+			}
+			u.keys = append(u.keys, p)
+		case '&':
+			u.channels = append(u.channels, rule[1:])
+		case '+', '-':
+			return u.addCommandRule(rule[:1]+strings.ToLower(rule[1:]), table)
+		default:
+			return errors.New("Syntax error")
+		}
+	}
+	return nil
+}
+
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+	if !slices.Contains(u.passwords, hash) {
+		u.passwords = append(u.passwords, hash)
+	}
+}
+
+func (u *aclUser) removePassword(hash string) {
+	u.passwords = slices.DeleteFunc(u.passwords, func(p string) bool { return p == hash })
+}
+
+// addCommandRule appends a +/- command or category rule. A rule for @all
+// overrides every earlier rule and a rule replaces an earlier one for the same
+// target, which keeps ACL LIST short.
+func (u *aclUser) addCommandRule(rule string, table *CommandTable) error {
+	target := rule[1:]
+	switch {
+	case target == "@all":
+		u.commands = nil
+	case strings.HasPrefix(target, "@"):
+		if !slices.Contains(aclCategories(table), target[1:]) {
+			return errors.New("Unknown command or category name in ACL")
+		}
+	default:
+		name, sub, hasSub := strings.Cut(target, "|")
+		cmd, ok := table.Lookup(name)
+		if ok && hasSub && len(cmd.Subcommands) > 0 {
+			ok = cmd.resolve(protocol.ArrayValue{protocol.BulkStringValue(name), protocol.BulkStringValue(sub)}) != cmd
+		}
+		if !ok {
+			return errors.New("Unknown command or category name in ACL")
+		}
+	}
+	u.commands = slices.DeleteFunc(u.commands, func(r string) bool { return r[1:] == target })
+	u.commands = append(u.commands, rule)
+	return nil
+}
+
+// canRun reports whether the user may run cmd with the full command line args
+func (u *aclUser) canRun(cmd *Command, args protocol.ArrayValue) bool {
+	name := strings.ToLower(cmd.Root().Name)
+	var sub string
+	if len(args) > 1 {
+		arg, _ := args[1].(protocol.BulkStringValue)
+		sub = strings.ToLower(string(arg))
+	}
+	categories := cmd.ACLCategories()
+
+	allowed := false
+	for _, rule := range u.commands {
+		target := rule[1:]
+		var match bool
+		switch {
+		case target == "@all":
+			match = true
+		case strings.HasPrefix(target, "@"):
+			match = slices.Contains(categories, target)
+		default:
+			cmdName, subName, hasSub := strings.Cut(target, "|")
+			match = cmdName == name && (!hasSub || subName == sub)
+		}
+		if match {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// canAccessKey reports whether the key patterns grant the access needed
+func (u *aclUser) canAccessKey(key string, read, write bool) bool {
+	var canRead, canWrite bool
+	for _, p := range u.keys {
+		if globMatch(p.pattern, key, false) {
+			canRead = canRead || p.read
+			canWrite = canWrite || p.write
+		}
+	}
+	return (!read || canRead) && (!write || canWrite)
+}
+
+func (u *aclUser) canAccessChannel(channel string) bool {
+	for _, pattern := range u.channels {
+		if globMatch(pattern, channel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword reports whether password opens an enabled user
+func (u *aclUser) checkPassword(password string) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	hash := []byte(hashPassword(password))
+	ok := false
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare(hash, []byte(p)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// flags lists the on/off and nopass state the way ACL GETUSER reports it
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *aclUser) describeCommands() string {
+	if len(u.commands) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.commands, " ")
+}
+
+func (u *aclUser) describeKeys() string {
+	patterns := make([]string, len(u.keys))
+	for i, p := range u.keys {
+		switch {
+		case p.read && p.write:
+			patterns[i] = "~" + p.pattern
+		case p.read:
+			patterns[i] = "%R~" + p.pattern
+		default:
+			patterns[i] = "%W~" + p.pattern
+		}
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (u *aclUser) describeChannels() string {
+	patterns := make([]string, len(u.channels))
+	for i, pattern := range u.channels {
+		patterns[i] = "&" + pattern
+	}
+	return strings.Join(patterns, " ")
+}
+
+// describe returns the rules that recreate u, as shown by ACL LIST and
+// written to the aclfile. Passwords only appear as hashes.
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name}
+	parts = append(parts, u.flags()...)
+	for _, hash := range u.passwords {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.describeKeys(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if channels := u.describeChannels(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+	parts = append(parts, u.describeCommands())
+	return strings.Join(parts, " ")
+}
+
+// aclCategories lists the categories of the commands in table, without the
+// leading '@'
+func aclCategories(table *CommandTable) []string {
+	seen := map[string]bool{"all": true}
+	for _, cmd := range aclCommands(table) {
+		for _, category := range cmd.ACLCategories() {
+			seen[strings.TrimPrefix(category, "@")] = true
+		}
+	}
+	categories := make([]string, 0, len(seen))
+	for category := range seen {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// aclCommands lists the commands in table the way ACL judges them: the
+// subcommands of a container command stand in for it, since each has its
+// own categories
+func aclCommands(table *CommandTable) []*Command {
+	var cmds []*Command
+	for _, cmd := range table.Commands() {
+		if len(cmd.Subcommands) == 0 {
+			cmds = append(cmds, cmd)
+		} else {
+			cmds = append(cmds, cmd.Subcommands...)
+		}
+	}
+	return cmds
+}
+
+// aclRegistry holds the users of a Server and the log of denied attempts
+type aclRegistry struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+	log   aclLog
+}
+
+// newDefaultUser returns the default user as it is before requirepass or an
+// aclfile change it: on, passwordless and allowed everything
+func newDefaultUser() *aclUser {
+	return &aclUser{
+		name:     defaultUser,
+		enabled:  true,
+		nopass:   true,
+		commands: []string{"+@all"},
+		keys:     []keyPattern{{pattern: "*", read: true, write: true}},
+		channels: []string{"*"},
+	}
+}
+
+func newACLRegistry() *aclRegistry {
+	return &aclRegistry{users: map[string]*aclUser{defaultUser: newDefaultUser()}}
+}
+
+// user returns the named user or nil
+func (r *aclRegistry) user(name string) *aclUser {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.users[name]
+}
+
+// sortedUsers returns every user ordered by name
+func (r *aclRegistry) sortedUsers() []*aclUser {
+	r.mu.RLock()
+	users := make([]*aclUser, 0, len(r.users))
+	for _, u := range r.users {
+		users = append(users, u)
+	}
+	r.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+	return users
+}
+
+// setUser creates or updates the named user. Either every rule applies or
+// the user is left untouched.
+func (r *aclRegistry) setUser(name string, rules []string, table *CommandTable) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := newACLUser(name)
+	if current, ok := r.users[name]; ok {
+		u = current.clone()
+	}
+	for _, rule := range rules {
+		if err := u.applyRule(rule, table); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	r.users[name] = u
+	return nil
+}
+
+// deleteUsers removes the named users and returns how many existed
+func (r *aclRegistry) deleteUsers(names []string) (int, error) {
+	if slices.Contains(names, defaultUser) {
+		return 0, errors.New("The 'default' user cannot be removed")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deleted := 0
+	for _, name := range names {
+		if _, ok := r.users[name]; ok {
+			delete(r.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// setDefaultPassword implements requirepass: an empty password makes the
+// default user passwordless again
+func (r *aclRegistry) setDefaultPassword(password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u := r.users[defaultUser].clone()
+	u.passwords = nil
+	u.nopass = password == ""
+	if password != "" {
+		u.passwords = []string{hashPassword(password)}
+	}
+	r.users[defaultUser] = u
+}
+
+// autoUser is the user new connections start authenticated as, empty when
+// they have to AUTH first
+func (r *aclRegistry) autoUser() string {
+	if u := r.user(defaultUser); u != nil && u.enabled && u.nopass {
+		return defaultUser
+	}
+	return ""
+}
+
+// loadFile replaces every user with the ones defined in path, one
+// "user <name> <rules...>" line each. The default user keeps its built-in
+// rules unless the file redefines it. Nothing changes if the file has an error.
+func (r *aclRegistry) loadFile(path string, table *CommandTable) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	users := map[string]*aclUser{defaultUser: newDefaultUser()}
+	defined := make(map[string]bool)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := protocol.SplitArgs(line)
+		if err != nil || len(args) < 2 || args[0] != "user" {
+			return fmt.Errorf("%s:%d: should start with user keyword followed by the username", path, i+1)
+		}
+		name := args[1]
+		if defined[name] {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, i+1, name)
+		}
+		defined[name] = true
+
+		u := newACLUser(name)
+		for _, rule := range args[2:] {
+			if err := u.applyRule(rule, table); err != nil {
+				return fmt.Errorf("%s:%d: %v. Error in user declaration '%s'", path, i+1, err, name)
+			}
+		}
+		users[name] = u
+	}
+
+	r.mu.Lock()
+	r.users = users
+	r.mu.Unlock()
+	return nil
+}
+
+// saveFile writes every user to path
+func (r *aclRegistry) saveFile(path string) error {
+	var sb strings.Builder
+	for _, u := range r.sortedUsers() {
+		sb.WriteString(u.describe())
+		sb.WriteByte('\n')
+	}
+	return writeFileAtomic(path, []byte(sb.String()))
+}
+
+// aclLogGroupWindow is how long a denial is folded into an identical earlier one
+const aclLogGroupWindow = 60 * time.Second
+
+// aclLogEntry is one denied command, key, channel or authentication
+type aclLogEntry struct {
+	id         int64
+	count      int
+	reason     string // command, key, channel or auth
+	context    string // toplevel or lua
+	object     string
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+// aclLog keeps the most recent denials, newest first, for ACL LOG
+type aclLog struct {
+	mu      sync.Mutex
+	entries []*aclLogEntry
+	nextID  int64
+}
+
+func (l *aclLog) add(entry aclLogEntry, maxLen int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for i, e := range l.entries {
+		if e.reason == entry.reason && e.context == entry.context && e.object == entry.object &&
+			e.username == entry.username && now.Sub(e.updated) < aclLogGroupWindow {
+			e.count++
+			e.updated = now
+			e.clientInfo = entry.clientInfo
+			copy(l.entries[1:i+1], l.entries[:i])
+			l.entries[0] = e
+			return
+		}
+	}
+
+	entry.id = l.nextID
+	l.nextID++
+	entry.count = 1
+	entry.created, entry.updated = now, now
+	l.entries = append([]*aclLogEntry{&entry}, l.entries...)
+	if len(l.entries) > maxLen {
+		l.entries = l.entries[:maxLen]
+	}
+}
+
+func (l *aclLog) list(count int) []aclLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if count > len(l.entries) {
+		count = len(l.entries)
+	}
+	entries := make([]aclLogEntry, count)
+	for i := range entries {
+		entries[i] = *l.entries[i]
+	}
+	return entries
+}
+
+func (l *aclLog) reset() {
+	l.mu.Lock()
+	l.entries = nil
+	l.mu.Unlock()
+}
+
+// logDenied records a denial for username in ACL LOG
+func (s *Server) logDenied(ctx *commands.Context, username, reason, object string) {
+	logContext := "toplevel"
+	if ctx.Script {
+		logContext = "lua"
+	}
+	s.acl.log.add(aclLogEntry{
+		reason:     reason,
+		context:    logContext,
+		object:     object,
+		username:   username,
+		clientInfo: fmt.Sprintf("id=%d addr=%s laddr=%s name=%s user=%s", ctx.ID, ctx.Addr, ctx.LocalAddr, ctx.Name, ctx.User),
+	}, s.Config().ACLLogMaxLen)
+}
+
+// authenticated reports whether ctx may run commands. Embedded calls and the
+// AOF replay always may.
+func (s *Server) authenticated(ctx *commands.Context) bool {
+	if ctx.ID == 0 {
+		return true
+	}
+	u := s.acl.user(ctx.User)
+	return u != nil && u.enabled
+}
+
+// aclHook enforces the permissions of the connection's user. Commands flagged
+// no-auth run before authentication; everything else needs an enabled user
+// allowed to run the command on its keys and channels.
+func (s *Server) aclHook() Hook {
+	return Hook{
+		Name: "acl",
+		Before: func(ctx *commands.Context, call *Call) error {
+			if ctx.ID == 0 {
+				return nil
+			}
+			u := s.acl.user(ctx.User)
+			if u == nil || !u.enabled {
+				if call.Command.Is(FlagNoAuth) {
+					return nil
+				}
+				return protocol.ErrorValue("NOAUTH Authentication required.")
+			}
+
+			if !u.canRun(call.Command, call.Args) {
+				s.logDenied(ctx, u.name, "command", strings.ToLower(call.Command.Name))
+				return protocol.ErrorValue(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", u.name, strings.ToLower(call.Command.Name)))
+			}
+
+			// Pub/sub commands locate channels where other commands locate keys
+			if call.Command.Is(FlagPubSub) {
+				for _, i := range call.Command.KeyIndexes(len(call.Args)) {
+					channel := bulkString(call.Args[i])
+					if !u.canAccessChannel(channel) {
+						s.logDenied(ctx, u.name, "channel", channel)
+						return protocol.ErrorValue("NOPERM No permissions to access a channel")
+					}
+				}
+				return nil
+			}
+			for _, k := range call.Command.Keys(call.Args) {
+				key := bulkString(call.Args[k.Index])
+				if !u.canAccessKey(key, k.Flags&KeyRead != 0, k.Flags&KeyWrite != 0) {
+					s.logDenied(ctx, u.name, "key", key)
+					return protocol.ErrorValue("NOPERM No permissions to access a key")
+				}
+			}
+			return nil
+		},
+	}
+}
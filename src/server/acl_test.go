@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"orion/src/protocol"
+)
+
+// login opens a connection authenticated as username
+func login(t *testing.T, addr, username, password string) *testClient {
+	t.Helper()
+	c := dial(t, addr)
+	if reply := c.do("AUTH", username, password); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("AUTH %s got %#v", username, reply)
+	}
+	return c
+}
+
+// setUser runs ACL SETUSER and fails the test unless it succeeds
+func setUser(t *testing.T, s *Server, name string, rules ...string) {
+	t.Helper()
+	reply, err := s.Do(context.Background(), append([]string{"ACL", "SETUSER", name}, rules...)...)
+	if err != nil || reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("ACL SETUSER %s %v got %#v, %v", name, rules, reply, err)
+	}
+}
+
+func TestAuthRequirePass(t *testing.T) {
+	s, addr := startServer(t, Config{RequirePass: "secret"})
+	c := dial(t, addr)
+
+	if reply := c.do("GET", "k"); !isError(reply, "NOAUTH ") {
+		t.Errorf("GET before AUTH got %#v", reply)
+	}
+	if reply := c.do("PING"); !isError(reply, "NOAUTH ") {
+		t.Errorf("PING before AUTH got %#v", reply)
+	}
+	if reply := c.do("AUTH", "wrong"); !isError(reply, "WRONGPASS ") {
+		t.Errorf("AUTH with a wrong password got %#v", reply)
+	}
+	if reply := c.do("AUTH", "nosuchuser", "secret"); !isError(reply, "WRONGPASS ") {
+		t.Errorf("AUTH as an unknown user got %#v", reply)
+	}
+	if reply := c.do("AUTH", "a", "b", "c"); !isError(reply, "ERR syntax error") {
+		t.Errorf("AUTH with three arguments got %#v", reply)
+	}
+	if reply := c.do("AUTH", "secret"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("AUTH secret got %#v", reply)
+	}
+	if reply := c.do("ACL", "WHOAMI"); reply != protocol.BulkStringValue("default") {
+		t.Errorf("ACL WHOAMI got %#v", reply)
+	}
+	if reply := c.do("SET", "k", "v"); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("SET after AUTH got %#v", reply)
+	}
+
+	// The failed attempts are in the ACL log
+	reply, _ := s.Do(context.Background(), "ACL", "LOG")
+	entries, _ := reply.(protocol.ArrayValue)
+	if len(entries) == 0 {
+		t.Fatalf("ACL LOG got %#v", reply)
+	}
+	if entry := entries[0].(protocol.MapValue); entry["reason"] != protocol.BulkStringValue("auth") {
+		t.Errorf("ACL LOG entry %v", entry)
+	}
+}
+
+func TestAuthDefaultUser(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	c := dial(t, addr)
+
+	// Without requirepass every connection is the passwordless default user
+	if reply := c.do("ACL", "WHOAMI"); reply != protocol.BulkStringValue("default") {
+		t.Errorf("ACL WHOAMI got %#v", reply)
+	}
+	if reply := c.do("AUTH", "anything"); !isError(reply, "ERR AUTH <password> called without any password configured") {
+		t.Errorf("AUTH without requirepass got %#v", reply)
+	}
+
+	// Turning the default user off leaves new connections unauthenticated
+	setUser(t, s, "alice", "on", ">pw", "+@all", "~*")
+	setUser(t, s, "default", "off")
+	c = dial(t, addr)
+	if reply := c.do("GET", "k"); !isError(reply, "NOAUTH ") {
+		t.Errorf("GET with the default user off got %#v", reply)
+	}
+	if reply := c.do("AUTH", "alice", "pw"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("AUTH alice got %#v", reply)
+	}
+	if reply := c.do("ACL", "WHOAMI"); reply != protocol.BulkStringValue("alice") {
+		t.Errorf("ACL WHOAMI got %#v", reply)
+	}
+}
+
+func TestACLKeyPatterns(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	s.Do(context.Background(), "SET", "shared", "1")
+	setUser(t, s, "reader", "on", ">pw", "+@all", "%R~shared", "~own:*")
+	setUser(t, s, "writer", "on", ">pw", "+@all", "%W~shared", "~own:*")
+	reader := login(t, addr, "reader", "pw")
+	writer := login(t, addr, "writer", "pw")
+
+	tests := []struct {
+		c    *testClient
+		args []string
+		ok   bool
+	}{
+		{reader, []string{"GET", "shared"}, true},
+		{reader, []string{"SET", "shared", "2"}, false},
+		// Write commands that also return the value need both permissions
+		{reader, []string{"GETDEL", "shared"}, false},
+		{reader, []string{"GETSET", "shared", "2"}, false},
+		{reader, []string{"INCR", "shared"}, false},
+		{reader, []string{"GET", "other"}, false},
+		{reader, []string{"SET", "own:k", "v"}, true},
+
+		{writer, []string{"SET", "shared", "1"}, true},
+		{writer, []string{"GET", "shared"}, false},
+		{writer, []string{"SET", "shared", "1", "GET"}, false},
+		{writer, []string{"GETDEL", "shared"}, false},
+		{writer, []string{"DEL", "shared"}, true},
+
+		// The destination is only written, the sources only read
+		{reader, []string{"SDIFFSTORE", "own:dest", "shared"}, true},
+		{writer, []string{"SDIFFSTORE", "shared", "own:src"}, true},
+		{writer, []string{"SDIFFSTORE", "own:dest", "shared"}, false},
+		{reader, []string{"SMOVE", "shared", "own:dest", "m"}, false},
+	}
+	for _, tt := range tests {
+		reply := tt.c.do(tt.args...)
+		if denied := isError(reply, "NOPERM No permissions to access a key"); denied == tt.ok {
+			t.Errorf("%v got %#v, want allowed %v", tt.args, reply, tt.ok)
+		}
+	}
+}
+
+func TestACLCategories(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	setUser(t, s, "app", "on", ">pw", "+@all", "-@admin", "~*")
+	c := login(t, addr, "app", "pw")
+
+	// WHOAMI and CAT are open to everyone, the rest of ACL is admin
+	if reply := c.do("ACL", "WHOAMI"); reply != protocol.BulkStringValue("app") {
+		t.Errorf("ACL WHOAMI got %#v", reply)
+	}
+	reply := c.do("ACL", "CAT")
+	categories, _ := reply.(protocol.ArrayValue)
+	if !slices.Contains(categories, protocol.ORSPValue(protocol.BulkStringValue("admin"))) {
+		t.Errorf("ACL CAT got %#v", reply)
+	}
+	if reply := c.do("ACL", "SETUSER", "app", "+@all"); !isError(reply, "NOPERM User app has no permissions to run the 'acl|setuser' command") {
+		t.Errorf("ACL SETUSER got %#v", reply)
+	}
+	if reply := c.do("CONFIG", "GET", "hz"); !isError(reply, "NOPERM ") {
+		t.Errorf("CONFIG GET got %#v", reply)
+	}
+	if reply := c.do("SET", "k", "v"); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("SET got %#v", reply)
+	}
+
+	// ACL CAT lists the subcommands under their own categories
+	reply = c.do("ACL", "CAT", "admin")
+	names, _ := reply.(protocol.ArrayValue)
+	if !slices.Contains(names, protocol.ORSPValue(protocol.BulkStringValue("acl|setuser"))) ||
+		slices.Contains(names, protocol.ORSPValue(protocol.BulkStringValue("acl|whoami"))) {
+		t.Errorf("ACL CAT admin got %#v", reply)
+	}
+	if reply := c.do("ACL", "CAT", "nosuchcategory"); !isError(reply, "ERR Unknown category") {
+		t.Errorf("ACL CAT nosuchcategory got %#v", reply)
+	}
+
+	// A single subcommand can be granted back
+	setUser(t, s, "app", "+acl|getuser")
+	if reply := c.do("ACL", "GETUSER", "app"); isError(reply, "") {
+		t.Errorf("ACL GETUSER after +acl|getuser got %#v", reply)
+	}
+	if reply := c.do("ACL", "USERS"); !isError(reply, "NOPERM ") {
+		t.Errorf("ACL USERS got %#v", reply)
+	}
+}
+
+func TestACLSetUserDelUser(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	c := dial(t, addr)
+
+	for _, rules := range [][]string{
+		{"+nosuchcommand"},
+		{"+acl|nosuchsub"},
+		{"+@nosuchcategory"},
+		{"bogus"},
+	} {
+		if reply := c.do(append([]string{"ACL", "SETUSER", "bob"}, rules...)...); !isError(reply, "ERR ") {
+			t.Errorf("ACL SETUSER bob %v got %#v", rules, reply)
+		}
+	}
+	if reply := c.do("ACL", "GETUSER", "bob"); reply != (protocol.NullValue{}) {
+		t.Errorf("a failed ACL SETUSER created bob: %#v", reply)
+	}
+
+	setUser(t, s, "bob", "on", ">pw", "+get", "~k*")
+	reply, _ := s.Do(context.Background(), "ACL", "GETUSER", "bob")
+	user, _ := reply.(protocol.MapValue)
+	if user["keys"] != protocol.BulkStringValue("~k*") || !strings.Contains(string(user["commands"].(protocol.BulkStringValue)), "+get") {
+		t.Errorf("ACL GETUSER bob got %#v", reply)
+	}
+	if reply := c.do("ACL", "USERS"); !slices.Equal(reply.(protocol.ArrayValue), protocol.ArrayValue{protocol.BulkStringValue("bob"), protocol.BulkStringValue("default")}) {
+		t.Errorf("ACL USERS got %#v", reply)
+	}
+	bob := login(t, addr, "bob", "pw")
+	if reply := bob.do("SET", "k", "v"); !isError(reply, "NOPERM ") {
+		t.Errorf("SET as bob got %#v", reply)
+	}
+
+	if reply := c.do("ACL", "DELUSER", "default"); !isError(reply, "ERR The 'default' user cannot be removed") {
+		t.Errorf("ACL DELUSER default got %#v", reply)
+	}
+	if reply := c.do("ACL", "DELUSER", "bob", "nobody"); reply != protocol.IntegerValue(1) {
+		t.Errorf("ACL DELUSER bob nobody got %#v", reply)
+	}
+	if reply := c.do("AUTH", "bob", "pw"); !isError(reply, "WRONGPASS ") {
+		t.Errorf("AUTH as a deleted user got %#v", reply)
+	}
+	if reply := c.do("ACL", "WHOAMI", "extra"); !isError(reply, "ERR wrong number of arguments for 'acl|whoami' command") {
+		t.Errorf("ACL WHOAMI extra got %#v", reply)
+	}
+}
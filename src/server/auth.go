@@ -0,0 +1,195 @@
+package server
+
+import (
+	"orion/src/commands"
+	"orion/src/protocol"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleAuth implements AUTH [username] password
+func (s *Server) handleAuth(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	if len(args) > 2 {
+		return protocol.ErrorValue("ERR syntax error")
+	}
+	username, password := defaultUser, bulkString(args[len(args)-1])
+	if len(args) == 2 {
+		username = bulkString(args[0])
+	} else if u := s.acl.user(defaultUser); u != nil && u.nopass {
+		return protocol.ErrorValue("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if reply := s.authenticate(ctx, username, password); reply != nil {
+		return reply
+	}
+	return protocol.SimpleStringValue("OK")
+}
+
+// authenticate switches ctx to username if password opens it and returns
+// nil, or the error reply. AUTH and HELLO AUTH share it.
+func (s *Server) authenticate(ctx *commands.Context, username, password string) protocol.ORSPValue {
+	u := s.acl.user(username)
+	if u == nil || !u.checkPassword(password) {
+		s.logDenied(ctx, username, "auth", "AUTH")
+		return protocol.ErrorValue("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	ctx.User = username
+	return nil
+}
+
+func bulkString(v protocol.ORSPValue) string {
+	s, _ := v.(protocol.BulkStringValue)
+	return string(s)
+}
+
+// handleACL implements the ACL subcommands. Their arity is checked by the
+// dispatcher, only upper bounds are left to check here.
+func (s *Server) handleACL(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sub, _ := args[0].(protocol.BulkStringValue)
+	name := strings.ToUpper(string(sub))
+	args = args[1:]
+	wrongArgs := protocol.ErrorValue("ERR wrong number of arguments for 'acl|" + strings.ToLower(name) + "' command")
+
+	switch name {
+	case "SETUSER":
+		rules := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			rules[i] = bulkString(arg)
+		}
+		if err := s.acl.setUser(bulkString(args[0]), rules, s.commands); err != nil {
+			return protocol.ErrorValue("ERR " + err.Error())
+		}
+		return protocol.SimpleStringValue("OK")
+
+	case "GETUSER":
+		u := s.acl.user(bulkString(args[0]))
+		if u == nil {
+			return protocol.NullValue{}
+		}
+		flags := make(protocol.ArrayValue, 0, 2)
+		for _, flag := range u.flags() {
+			flags = append(flags, protocol.BulkStringValue(flag))
+		}
+		passwords := make(protocol.ArrayValue, len(u.passwords))
+		for i, hash := range u.passwords {
+			passwords[i] = protocol.BulkStringValue(hash)
+		}
+		return protocol.MapValue{
+			"flags":     flags,
+			"passwords": passwords,
+			"commands":  protocol.BulkStringValue(u.describeCommands()),
+			"keys":      protocol.BulkStringValue(u.describeKeys()),
+			"channels":  protocol.BulkStringValue(u.describeChannels()),
+			"selectors": protocol.ArrayValue{},
+		}
+
+	case "DELUSER":
+		names := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = bulkString(arg)
+		}
+		deleted, err := s.acl.deleteUsers(names)
+		if err != nil {
+			return protocol.ErrorValue("ERR " + err.Error())
+		}
+		return protocol.IntegerValue(deleted)
+
+	case "LIST", "USERS":
+		users := s.acl.sortedUsers()
+		reply := make(protocol.ArrayValue, len(users))
+		for i, u := range users {
+			if name == "LIST" {
+				reply[i] = protocol.BulkStringValue(u.describe())
+			} else {
+				reply[i] = protocol.BulkStringValue(u.name)
+			}
+		}
+		return reply
+
+	case "WHOAMI":
+		if ctx.ID == 0 {
+			return protocol.BulkStringValue(defaultUser)
+		}
+		return protocol.BulkStringValue(ctx.User)
+
+	case "CAT":
+		if len(args) > 1 {
+			return wrongArgs
+		}
+		categories := aclCategories(s.commands)
+		var reply protocol.ArrayValue
+		if len(args) == 0 {
+			for _, category := range categories {
+				if category != "all" {
+					reply = append(reply, protocol.BulkStringValue(category))
+				}
+			}
+			return reply
+		}
+		category := strings.ToLower(bulkString(args[0]))
+		if !slices.Contains(categories, category) {
+			return protocol.ErrorValue("ERR Unknown category '" + category + "'")
+		}
+		reply = protocol.ArrayValue{}
+		for _, cmd := range aclCommands(s.commands) {
+			if category == "all" || slices.Contains(cmd.ACLCategories(), "@"+category) {
+				reply = append(reply, protocol.BulkStringValue(strings.ToLower(cmd.Name)))
+			}
+		}
+		return reply
+
+	case "LOG":
+		if len(args) > 1 {
+			return wrongArgs
+		}
+		count := s.Config().ACLLogMaxLen
+		if len(args) == 1 {
+			arg := bulkString(args[0])
+			if strings.EqualFold(arg, "RESET") {
+				s.acl.log.reset()
+				return protocol.SimpleStringValue("OK")
+			}
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return protocol.ErrorValue("ERR value is out of range, must be positive")
+			}
+			count = n
+		}
+		entries := s.acl.log.list(count)
+		reply := make(protocol.ArrayValue, len(entries))
+		for i, e := range entries {
+			reply[i] = protocol.MapValue{
+				"count":                  protocol.IntegerValue(e.count),
+				"reason":                 protocol.BulkStringValue(e.reason),
+				"context":                protocol.BulkStringValue(e.context),
+				"object":                 protocol.BulkStringValue(e.object),
+				"username":               protocol.BulkStringValue(e.username),
+				"age-seconds":            protocol.DoubleValue(time.Since(e.updated).Seconds()),
+				"client-info":            protocol.BulkStringValue(e.clientInfo),
+				"entry-id":               protocol.IntegerValue(e.id),
+				"timestamp-created":      protocol.IntegerValue(e.created.UnixMilli()),
+				"timestamp-last-updated": protocol.IntegerValue(e.updated.UnixMilli()),
+			}
+		}
+		return reply
+
+	case "SAVE", "LOAD":
+		path := s.Config().ACLFile
+		if path == "" {
+			return protocol.ErrorValue("ERR This Orion instance is not configured to use an ACL file. Set aclfile in orion.conf to store users.")
+		}
+		var err error
+		if name == "SAVE" {
+			err = s.acl.saveFile(path)
+		} else {
+			err = s.acl.loadFile(path, s.commands)
+		}
+		if err != nil {
+			return protocol.ErrorValue("ERR " + err.Error())
+		}
+		return protocol.SimpleStringValue("OK")
+	}
+
+	return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try ACL SETUSER, GETUSER, DELUSER, LIST, USERS, WHOAMI, CAT, LOG, SAVE or LOAD")
+}
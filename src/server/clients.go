@@ -0,0 +1,453 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// client is a connection in the client registry. The connection's goroutine
+// owns its commands.Context and copies what CLIENT LIST shows into the
+// guarded fields around every command, so other connections never touch the
+// context itself.
+type client struct {
+	id      int64
+	conn    net.Conn
+	output  *clientOutput
+	family  string
+	addr    string
+	laddr   string
+	created time.Time
+
+	mu       sync.Mutex
+	name     string
+	user     string
+	resp     int
+	lastCmd  string
+	lastSeen time.Time
+	qbuf     int  // bytes read ahead of the current command
+	noEvict  bool // set by CLIENT NO-EVICT
+
+	killed     atomic.Bool  // set by CLIENT KILL, the connection closes after its reply
+	throttled  atomic.Int64 // commands delayed or rejected by a rate limit
+	monitoring atomic.Bool  // set by MONITOR, the connection streams commands after its reply
+
+	// Reply suppression set by CLIENT REPLY, only used by the connection's
+	// goroutine
+	replyOff  bool
+	replySkip int
+}
+
+// startCommand records the command the connection is about to run
+func (c *client) startCommand(name string, qbuf int) {
+	c.mu.Lock()
+	c.lastCmd = strings.ToLower(name)
+	c.lastSeen = time.Now()
+	c.qbuf = qbuf
+	c.mu.Unlock()
+}
+
+// endCommand copies the connection state the command may have changed
+func (c *client) endCommand(ctx *commands.Context) {
+	c.mu.Lock()
+	c.name = ctx.Name
+	c.user = ctx.User
+	c.resp = ctx.Protocol
+	c.mu.Unlock()
+}
+
+// takeReply reports whether the reply to the command that just ran is sent
+func (c *client) takeReply() bool {
+	if c.replySkip > 0 {
+		c.replySkip--
+		return false
+	}
+	return !c.replyOff
+}
+
+// info formats c the way CLIENT LIST and CLIENT INFO show it
+func (c *client) info(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flags := ""
+	if c.family == "unix" {
+		flags += "U"
+	}
+	if c.noEvict {
+		flags += "e"
+	}
+	if c.killed.Load() {
+		flags += "A"
+	}
+	if c.monitoring.Load() {
+		flags += "O"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s family=%s name=%s age=%d idle=%d flags=%s db=%d sub=0 psub=0 multi=-1 qbuf=%d omem=%d cmd=%s user=%s resp=%d throttled=%d",
+		c.id, c.addr, c.laddr, c.family, c.name,
+		int64(now.Sub(c.created)/time.Second), int64(now.Sub(c.lastSeen)/time.Second),
+		flags, dbIndex, c.qbuf, c.output.size(), c.lastCmd, c.user, c.resp, c.throttled.Load())
+}
+
+// clientRegistry holds the connected clients of a Server
+type clientRegistry struct {
+	mu      sync.RWMutex
+	clients map[int64]*client
+}
+
+func (r *clientRegistry) add(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clients == nil {
+		r.clients = make(map[int64]*client)
+	}
+	r.clients[c.id] = c
+}
+
+func (r *clientRegistry) remove(c *client) {
+	r.mu.Lock()
+	delete(r.clients, c.id)
+	r.mu.Unlock()
+}
+
+func (r *clientRegistry) get(id int64) *client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clients[id]
+}
+
+// list returns every client ordered by ID
+func (r *clientRegistry) list() []*client {
+	r.mu.RLock()
+	clients := make([]*client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+func (r *clientRegistry) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.clients)
+}
+
+// clientPause holds off client commands during CLIENT PAUSE
+type clientPause struct {
+	mu      sync.Mutex
+	until   time.Time
+	all     bool          // every command is paused, not only writes
+	changed chan struct{} // closed when the pause is lifted or replaced
+}
+
+// pause holds off commands until until. A pause in effect is only ever
+// extended or made stricter.
+func (p *clientPause) pause(until time.Time, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().Before(p.until) {
+		all = all || p.all
+		if p.until.After(until) {
+			until = p.until
+		}
+	}
+	p.until, p.all = until, all
+	p.notify()
+}
+
+// actions reports what is paused: none, write or all
+func (p *clientPause) actions() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case !time.Now().Before(p.until):
+		return "none"
+	case p.all:
+		return "all"
+	}
+	return "write"
+}
+
+func (p *clientPause) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until, p.all = time.Time{}, false
+	p.notify()
+}
+
+// notify wakes the waiting commands up, it is called with p.mu held
+func (p *clientPause) notify() {
+	if p.changed != nil {
+		close(p.changed)
+	}
+	p.changed = make(chan struct{})
+}
+
+// wait blocks while cmd is paused. CLIENT itself is never paused so the
+// pause can be lifted. WRITE pauses hold off write commands and the scripts
+// that may write.
+func (p *clientPause) wait(cmd *Command) {
+	if cmd.Root().Name == "CLIENT" {
+		return
+	}
+	mayWrite := cmd.Is(FlagWrite) || (slices.Contains(cmd.Categories, "@scripting") && !cmd.Is(FlagReadOnly))
+	for {
+		p.mu.Lock()
+		remaining := time.Until(p.until)
+		paused := remaining > 0 && (p.all || mayWrite)
+		changed := p.changed
+		p.mu.Unlock()
+		if !paused {
+			return
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-changed:
+		}
+		timer.Stop()
+	}
+}
+
+// handleClient implements the CLIENT subcommands. Their arity is checked by
+// the dispatcher, only upper bounds are left to check here.
+func (s *Server) handleClient(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sub, _ := args[0].(protocol.BulkStringValue)
+	name := strings.ToUpper(string(sub))
+	args = args[1:]
+	wrongArgs := protocol.ErrorValue("ERR wrong number of arguments for 'client|" + strings.ToLower(name) + "' command")
+	self := s.clients.get(ctx.ID)
+
+	switch name {
+	case "ID":
+		return protocol.IntegerValue(ctx.ID)
+
+	case "SETNAME":
+		clientName := bulkString(args[0])
+		if strings.ContainsAny(clientName, " \n") {
+			return protocol.ErrorValue("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		ctx.Name = clientName
+		return protocol.SimpleStringValue("OK")
+
+	case "GETNAME":
+		if ctx.Name == "" {
+			return protocol.NullValue{}
+		}
+		return protocol.BulkStringValue(ctx.Name)
+
+	case "INFO":
+		if self == nil {
+			return protocol.ErrorValue("ERR CLIENT INFO is only available to connected clients")
+		}
+		return protocol.BulkStringValue(self.info(time.Now()) + "\n")
+
+	case "LIST":
+		return s.clientList(args)
+
+	case "KILL":
+		return s.clientKill(ctx, args)
+
+	case "PAUSE":
+		if len(args) > 2 {
+			return wrongArgs
+		}
+		timeout, err := strconv.ParseInt(bulkString(args[0]), 10, 64)
+		if err != nil || timeout < 0 {
+			return protocol.ErrorValue("ERR timeout is not an integer or out of range")
+		}
+		all := true
+		if len(args) == 2 {
+			switch strings.ToUpper(bulkString(args[1])) {
+			case "ALL":
+			case "WRITE":
+				all = false
+			default:
+				return protocol.ErrorValue("ERR CLIENT PAUSE mode must be WRITE or ALL")
+			}
+		}
+		s.pause.pause(time.Now().Add(time.Duration(timeout)*time.Millisecond), all)
+		return protocol.SimpleStringValue("OK")
+
+	case "UNPAUSE":
+		s.pause.unpause()
+		return protocol.SimpleStringValue("OK")
+
+	case "NO-EVICT":
+		var noEvict bool
+		switch strings.ToUpper(bulkString(args[0])) {
+		case "ON":
+			noEvict = true
+		case "OFF":
+		default:
+			return protocol.ErrorValue("ERR syntax error")
+		}
+		if self != nil {
+			self.mu.Lock()
+			self.noEvict = noEvict
+			self.mu.Unlock()
+		}
+		return protocol.SimpleStringValue("OK")
+
+	case "REPLY":
+		mode := strings.ToUpper(bulkString(args[0]))
+		if mode != "ON" && mode != "OFF" && mode != "SKIP" {
+			return protocol.ErrorValue("ERR syntax error")
+		}
+		if self == nil {
+			return protocol.SimpleStringValue("OK")
+		}
+		// The mode applies to the reply of this command too, SKIP covers
+		// this command and the next one
+		switch mode {
+		case "ON":
+			self.replyOff = false
+		case "OFF":
+			self.replyOff = true
+		case "SKIP":
+			self.replySkip = 2
+		}
+		return protocol.SimpleStringValue("OK")
+	}
+
+	return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try CLIENT LIST, INFO, ID, SETNAME, GETNAME, KILL, PAUSE, UNPAUSE, NO-EVICT or REPLY")
+}
+
+// clientList implements CLIENT LIST [TYPE type] [ID id [id ...]]
+func (s *Server) clientList(args []protocol.ORSPValue) protocol.ORSPValue {
+	clients := s.clients.list()
+	if len(args) > 0 {
+		switch option := strings.ToUpper(bulkString(args[0])); {
+		case option == "TYPE" && len(args) == 2:
+			switch strings.ToLower(bulkString(args[1])) {
+			case "normal":
+			case "master", "replica", "pubsub":
+				// Every client is a normal one
+				clients = nil
+			default:
+				return protocol.ErrorValue("ERR Unknown client type '" + bulkString(args[1]) + "'")
+			}
+		case option == "ID" && len(args) > 1:
+			ids := make(map[int64]bool, len(args)-1)
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseInt(bulkString(arg), 10, 64)
+				if err != nil || id <= 0 {
+					return protocol.ErrorValue("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+			filtered := clients[:0]
+			for _, c := range clients {
+				if ids[c.id] {
+					filtered = append(filtered, c)
+				}
+			}
+			clients = filtered
+		default:
+			return protocol.ErrorValue("ERR syntax error")
+		}
+	}
+
+	var sb strings.Builder
+	now := time.Now()
+	for _, c := range clients {
+		sb.WriteString(c.info(now))
+		sb.WriteByte('\n')
+	}
+	return protocol.BulkStringValue(sb.String())
+}
+
+// clientKill implements both CLIENT KILL addr:port and
+// CLIENT KILL [ID id] [ADDR addr] [LADDR addr] [USER username] [SKIPME yes|no]
+func (s *Server) clientKill(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	// The old form kills one client by address and fails if there is none
+	if len(args) == 1 {
+		addr := bulkString(args[0])
+		for _, c := range s.clients.list() {
+			if c.addr == addr {
+				s.killClient(c, ctx)
+				return protocol.SimpleStringValue("OK")
+			}
+		}
+		return protocol.ErrorValue("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return protocol.ErrorValue("ERR syntax error")
+	}
+
+	var id int64
+	var addr, laddr, user string
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := bulkString(args[i+1])
+		switch strings.ToUpper(bulkString(args[i])) {
+		case "ID":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return protocol.ErrorValue("ERR client-id should be greater than 0")
+			}
+			id = n
+		case "ADDR":
+			addr = value
+		case "LADDR":
+			laddr = value
+		case "USER":
+			if s.acl.user(value) == nil {
+				return protocol.ErrorValue("ERR No such user '" + value + "'")
+			}
+			user = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return protocol.ErrorValue("ERR syntax error")
+			}
+		default:
+			return protocol.ErrorValue("ERR syntax error")
+		}
+	}
+
+	killed := 0
+	for _, c := range s.clients.list() {
+		c.mu.Lock()
+		cUser := c.user
+		c.mu.Unlock()
+		if (id != 0 && c.id != id) || (addr != "" && c.addr != addr) ||
+			(laddr != "" && c.laddr != laddr) || (user != "" && cUser != user) {
+			continue
+		}
+		if skipMe && c.id == ctx.ID {
+			continue
+		}
+		s.killClient(c, ctx)
+		killed++
+	}
+	return protocol.IntegerValue(killed)
+}
+
+// killClient disconnects c. The calling client is only marked, so it gets
+// the reply to CLIENT KILL before its connection closes.
+func (s *Server) killClient(c *client, ctx *commands.Context) {
+	c.killed.Store(true)
+	if c.id != ctx.ID {
+		c.conn.Close()
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"orion/src/protocol"
+)
+
+// clientFields parses the lines of CLIENT LIST into their fields
+func clientFields(t *testing.T, reply protocol.ORSPValue) []map[string]string {
+	t.Helper()
+	text, ok := reply.(protocol.BulkStringValue)
+	if !ok {
+		t.Fatalf("CLIENT LIST got %#v", reply)
+	}
+	var clients []map[string]string
+	for _, line := range strings.Split(strings.TrimSuffix(string(text), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := make(map[string]string)
+		for _, field := range strings.Fields(line) {
+			key, value, _ := strings.Cut(field, "=")
+			fields[key] = value
+		}
+		clients = append(clients, fields)
+	}
+	return clients
+}
+
+func TestClientList(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	a, b := dial(t, addr), dial(t, addr)
+	a.do("CLIENT", "SETNAME", "worker")
+	b.do("SET", "k", "v")
+	aID := strconv.FormatInt(int64(a.do("CLIENT", "ID").(protocol.IntegerValue)), 10)
+
+	clients := clientFields(t, a.do("CLIENT", "LIST"))
+	if len(clients) != 2 {
+		t.Fatalf("CLIENT LIST shows %d clients, want 2: %v", len(clients), clients)
+	}
+	for _, c := range clients {
+		if c["id"] == aID {
+			if c["name"] != "worker" || c["cmd"] != "client|list" || c["flags"] != "N" || c["user"] != "default" {
+				t.Errorf("CLIENT LIST shows the caller as %v", c)
+			}
+		} else if c["cmd"] != "set" || c["name"] != "" {
+			t.Errorf("CLIENT LIST shows the other client as %v", c)
+		}
+	}
+
+	if clients := clientFields(t, a.do("CLIENT", "LIST", "ID", aID, "999")); len(clients) != 1 || clients[0]["id"] != aID {
+		t.Errorf("CLIENT LIST ID %s 999 got %v", aID, clients)
+	}
+	if clients := clientFields(t, a.do("CLIENT", "LIST", "TYPE", "normal")); len(clients) != 2 {
+		t.Errorf("CLIENT LIST TYPE normal got %v", clients)
+	}
+	if clients := clientFields(t, a.do("CLIENT", "LIST", "TYPE", "pubsub")); len(clients) != 0 {
+		t.Errorf("CLIENT LIST TYPE pubsub got %v", clients)
+	}
+	for _, args := range [][]string{
+		{"TYPE", "nosuchtype"},
+		{"ID", "x"},
+		{"ID"},
+		{"BOGUS", "1"},
+	} {
+		if reply := a.do(append([]string{"CLIENT", "LIST"}, args...)...); !isError(reply, "ERR ") {
+			t.Errorf("CLIENT LIST %v got %#v", args, reply)
+		}
+	}
+
+	if reply := a.do("CLIENT", "SETNAME", "two words"); !isError(reply, "ERR Client names cannot contain spaces") {
+		t.Errorf("CLIENT SETNAME with a space got %#v", reply)
+	}
+	if reply := a.do("CLIENT", "GETNAME"); reply != protocol.BulkStringValue("worker") {
+		t.Errorf("CLIENT GETNAME got %#v", reply)
+	}
+	info := clientFields(t, a.do("CLIENT", "INFO"))
+	if len(info) != 1 || info[0]["id"] != aID || info[0]["name"] != "worker" {
+		t.Errorf("CLIENT INFO got %v", info)
+	}
+
+	// Users without @admin manage their own connection but see no others
+	setUser(t, s, "app", "on", ">pw", "+@all", "-@admin")
+	app := login(t, addr, "app", "pw")
+	if reply := app.do("CLIENT", "SETNAME", "app"); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("CLIENT SETNAME as app got %#v", reply)
+	}
+	if info := clientFields(t, app.do("CLIENT", "INFO")); len(info) != 1 || info[0]["name"] != "app" || info[0]["user"] != "app" {
+		t.Errorf("CLIENT INFO as app got %v", info)
+	}
+	if reply := app.do("CLIENT", "LIST"); !isError(reply, "NOPERM User app has no permissions to run the 'client|list' command") {
+		t.Errorf("CLIENT LIST as app got %#v", reply)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	setUser(t, s, "bob", "on", ">pw", "+@all", "~*")
+	admin := dial(t, addr)
+	byID, byAddr, bob := dial(t, addr), dial(t, addr), login(t, addr, "bob", "pw")
+
+	// closed waits for the server to close the client's connection
+	closed := func(c *testClient) bool {
+		t.Helper()
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := c.dec.Decode()
+		return err != nil
+	}
+
+	id := strconv.FormatInt(int64(byID.do("CLIENT", "ID").(protocol.IntegerValue)), 10)
+	if reply := admin.do("CLIENT", "KILL", "ID", id); reply != protocol.IntegerValue(1) {
+		t.Errorf("CLIENT KILL ID %s got %#v", id, reply)
+	}
+	if !closed(byID) {
+		t.Error("CLIENT KILL ID left the connection open")
+	}
+
+	// The old form takes the address and fails when nothing matches
+	if reply := admin.do("CLIENT", "KILL", byAddr.conn.LocalAddr().String()); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("CLIENT KILL addr got %#v", reply)
+	}
+	if !closed(byAddr) {
+		t.Error("CLIENT KILL addr left the connection open")
+	}
+	if reply := admin.do("CLIENT", "KILL", "127.0.0.1:1"); !isError(reply, "ERR No such client") {
+		t.Errorf("CLIENT KILL of an unknown address got %#v", reply)
+	}
+
+	if reply := admin.do("CLIENT", "KILL", "USER", "bob"); reply != protocol.IntegerValue(1) {
+		t.Errorf("CLIENT KILL USER bob got %#v", reply)
+	}
+	if !closed(bob) {
+		t.Error("CLIENT KILL USER left bob's connection open")
+	}
+
+	for _, args := range [][]string{
+		{"ID", "0"},
+		{"USER", "nobody"},
+		{"SKIPME", "maybe"},
+		{"ID"},
+		{"BOGUS", "1"},
+	} {
+		if reply := admin.do(append([]string{"CLIENT", "KILL"}, args...)...); !isError(reply, "ERR ") {
+			t.Errorf("CLIENT KILL %v got %#v", args, reply)
+		}
+	}
+
+	// The caller is skipped unless SKIPME no, then it gets the reply first
+	if reply := admin.do("CLIENT", "KILL", "USER", "default"); reply != protocol.IntegerValue(0) {
+		t.Errorf("CLIENT KILL USER default got %#v", reply)
+	}
+	if reply := admin.do("CLIENT", "KILL", "USER", "default", "SKIPME", "no"); reply != protocol.IntegerValue(1) {
+		t.Errorf("CLIENT KILL USER default SKIPME no got %#v", reply)
+	}
+	if !closed(admin) {
+		t.Error("CLIENT KILL SKIPME no left the caller connected")
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	_, addr := startServer(t, Config{})
+	c := dial(t, addr)
+
+	c.send("CLIENT", "REPLY", "SKIP")
+	c.send("SET", "skipped", "1")
+	c.send("CLIENT", "REPLY", "OFF")
+	c.send("SET", "off", "1")
+	c.send("CLIENT", "REPLY", "ON")
+	if reply := c.read(); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("the first reply after CLIENT REPLY ON is %#v", reply)
+	}
+	for _, key := range []string{"skipped", "off"} {
+		if reply := c.do("GET", key); reply != protocol.BulkStringValue("1") {
+			t.Errorf("GET %s got %#v, the command without a reply didn't run", key, reply)
+		}
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	_, addr := startServer(t, Config{})
+	admin, c := dial(t, addr), dial(t, addr)
+
+	if reply := admin.do("CLIENT", "PAUSE", "10000", "WRITE"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CLIENT PAUSE got %#v", reply)
+	}
+	if reply := c.do("GET", "k"); reply != (protocol.NullValue{}) {
+		t.Errorf("GET during a WRITE pause got %#v", reply)
+	}
+	c.send("SET", "k", "v")
+	time.Sleep(50 * time.Millisecond)
+	if reply := admin.do("GET", "k"); reply != (protocol.NullValue{}) {
+		t.Errorf("SET ran during the WRITE pause, GET got %#v", reply)
+	}
+	if reply := admin.do("CLIENT", "UNPAUSE"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CLIENT UNPAUSE got %#v", reply)
+	}
+	if reply := c.read(); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("SET after CLIENT UNPAUSE got %#v", reply)
+	}
+
+	if reply := admin.do("CLIENT", "PAUSE", "-1"); !isError(reply, "ERR timeout is not an integer") {
+		t.Errorf("CLIENT PAUSE -1 got %#v", reply)
+	}
+	if reply := admin.do("CLIENT", "PAUSE", "10", "SOME"); !isError(reply, "ERR CLIENT PAUSE mode must be WRITE or ALL") {
+		t.Errorf("CLIENT PAUSE 10 SOME got %#v", reply)
+	}
+}
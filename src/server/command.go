@@ -0,0 +1,137 @@
+package server
+
+import (
+	"orion/src/commands"
+	"orion/src/protocol"
+	"strings"
+)
+
+// handleCommandCommand implements COMMAND and its subcommands
+func (s *Server) handleCommandCommand(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	if len(args) == 0 {
+		cmds := s.commands.Commands()
+		response := make(protocol.ArrayValue, len(cmds))
+		for i, cmd := range cmds {
+			response[i] = commandInfo(cmd)
+		}
+		return response
+	}
+
+	sub, ok := args[0].(protocol.BulkStringValue)
+	if !ok {
+		return protocol.ErrorValue("ERR invalid subcommand")
+	}
+
+	switch strings.ToUpper(string(sub)) {
+	case "COUNT":
+		if len(args) != 1 {
+			return protocol.ErrorValue("ERR wrong number of arguments for 'command|count' command")
+		}
+		return protocol.IntegerValue(s.commands.Len())
+
+	case "INFO":
+		if len(args) == 1 {
+			return s.handleCommandCommand(ctx, nil)
+		}
+		response := make(protocol.ArrayValue, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			name, _ := arg.(protocol.BulkStringValue)
+			cmd, exists := s.commands.Lookup(string(name))
+			if !exists {
+				response = append(response, protocol.NullValue{})
+				continue
+			}
+			response = append(response, commandInfo(cmd))
+		}
+		return response
+
+	case "DOCS":
+		var cmds []*Command
+		if len(args) == 1 {
+			cmds = s.commands.Commands()
+		} else {
+			for _, arg := range args[1:] {
+				name, _ := arg.(protocol.BulkStringValue)
+				if cmd, exists := s.commands.Lookup(string(name)); exists {
+					cmds = append(cmds, cmd)
+				}
+			}
+		}
+		response := make(protocol.MapValue, len(cmds))
+		for _, cmd := range cmds {
+			response[strings.ToLower(cmd.Name)] = commandDocs(cmd)
+		}
+		return response
+
+	case "GETKEYS":
+		if len(args) < 2 {
+			return protocol.ErrorValue("ERR wrong number of arguments for 'command|getkeys' command")
+		}
+		name, _ := args[1].(protocol.BulkStringValue)
+		cmd, exists := s.commands.Lookup(string(name))
+		if !exists {
+			return protocol.ErrorValue("ERR Invalid command specified")
+		}
+		line := args[1:]
+		cmd = cmd.resolve(line)
+		if !cmd.CheckArity(len(line)) {
+			return protocol.ErrorValue("ERR Invalid number of arguments specified for command")
+		}
+		// The same keys the ACL checks, key specs included
+		keys := cmd.Keys(line)
+		if len(keys) == 0 {
+			return protocol.ErrorValue("ERR The command has no key arguments")
+		}
+		response := make(protocol.ArrayValue, len(keys))
+		for i, key := range keys {
+			response[i] = line[key.Index]
+		}
+		return response
+
+	default:
+		return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try COMMAND COUNT, INFO, DOCS or GETKEYS")
+	}
+}
+
+// commandInfo builds the COMMAND INFO reply for a single command
+func commandInfo(cmd *Command) protocol.ArrayValue {
+	return protocol.ArrayValue{
+		protocol.BulkStringValue(strings.ToLower(cmd.Name)),
+		protocol.IntegerValue(cmd.Arity),
+		stringSet(cmd.Flags.Names()),
+		protocol.IntegerValue(cmd.FirstKey),
+		protocol.IntegerValue(cmd.LastKey),
+		protocol.IntegerValue(cmd.Step),
+		stringSet(cmd.ACLCategories()),
+	}
+}
+
+// commandDocs builds the COMMAND DOCS entry for a single command
+func commandDocs(cmd *Command) protocol.MapValue {
+	docs := protocol.MapValue{
+		"summary": protocol.BulkStringValue(cmd.Summary),
+		"group":   protocol.BulkStringValue(cmd.Group),
+	}
+	if cmd.Syntax != "" {
+		docs["syntax"] = protocol.BulkStringValue(cmd.Syntax)
+	}
+	if cmd.Complexity != "" {
+		docs["complexity"] = protocol.BulkStringValue(cmd.Complexity)
+	}
+	if len(cmd.Subcommands) > 0 {
+		subcommands := make(protocol.MapValue, len(cmd.Subcommands))
+		for _, sub := range cmd.Subcommands {
+			subcommands[strings.ToLower(sub.Name)] = commandDocs(sub)
+		}
+		docs["subcommands"] = subcommands
+	}
+	return docs
+}
+
+func stringSet(items []string) protocol.SetValue {
+	set := make(protocol.SetValue, len(items))
+	for i, item := range items {
+		set[i] = protocol.SimpleStringValue(item)
+	}
+	return set
+}
@@ -0,0 +1,745 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"orion/src/commands"
+	"orion/src/logging"
+	"orion/src/protocol"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configParam is one setting of the config registry. Every directive of
+// orion.conf, every --name command-line override and every CONFIG GET/SET
+// parameter goes through it.
+type configParam struct {
+	name      string
+	immutable bool // only read at startup, CONFIG SET refuses to change it
+	multi     bool // the value is a list of arguments rather than one string
+
+	get func(cfg *Config) string
+	set func(cfg *Config, value string) error
+	// format is how CONFIG REWRITE spells the value if it differs from get
+	format func(cfg *Config) string
+	// apply makes a value changed by CONFIG SET take effect. It is nil for
+	// settings that are read each time they are used. An error undoes the
+	// whole CONFIG SET.
+	apply func(s *Server, cfg *Config) error
+}
+
+// startupOnly marks p as immutable
+func (p configParam) startupOnly() configParam {
+	p.immutable = true
+	return p
+}
+
+func (p configParam) onSet(apply func(s *Server, cfg *Config) error) configParam {
+	p.apply = apply
+	return p
+}
+
+var configParams = []configParam{
+	intParam("port", 0, 65535, func(c *Config) *int { return &c.Port }).startupOnly(),
+	{
+		name:      "bind",
+		immutable: true,
+		multi:     true,
+		get:       func(c *Config) string { return strings.Join(c.Bind, " ") },
+		set: func(c *Config, value string) error {
+			c.Bind = strings.Fields(value)
+			return nil
+		},
+	},
+	stringParam("unixsocket", func(c *Config) *string { return &c.UnixSocket }).startupOnly(),
+	{
+		name:      "unixsocketperm",
+		immutable: true,
+		get:       func(c *Config) string { return strconv.FormatUint(uint64(c.UnixSocketPerm), 8) },
+		set: func(c *Config, value string) error {
+			perm, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || perm > 0777 {
+				return errors.New("argument must be an octal permission mode such as 700")
+			}
+			c.UnixSocketPerm = os.FileMode(perm)
+			return nil
+		},
+	},
+	intParam("metrics-port", 0, 65535, func(c *Config) *int { return &c.MetricsPort }).startupOnly(),
+	stringParam("metrics-bind", func(c *Config) *string { return &c.MetricsBind }).startupOnly(),
+	stringParam("dir", func(c *Config) *string { return &c.Dir }).startupOnly(),
+	stringParam("logdir", func(c *Config) *string { return &c.LogDir }).startupOnly(),
+	enumParam("loglevel", logging.Levels, func(c *Config) *string { return &c.LogLevel }).onSet(func(s *Server, cfg *Config) error {
+		return logging.SetLevel(cfg.LogLevel)
+	}),
+	enumParam("log-format", []string{"text", "json"}, func(c *Config) *string { return &c.LogFormat }).startupOnly(),
+	enumParam("log-rotation", []string{"none", "hourly", "daily"}, func(c *Config) *string { return &c.LogRotation }).startupOnly(),
+	memoryParam("log-max-size", 0, func(c *Config) *int { return &c.LogMaxSize }).startupOnly(),
+	durationParam("log-max-age", 24*time.Hour, func(c *Config) *time.Duration { return &c.LogMaxAge }).startupOnly(),
+	intParam("log-max-files", 0, 1<<31-1, func(c *Config) *int { return &c.LogMaxFiles }).startupOnly(),
+	boolParam("log-compress", func(c *Config) *bool { return &c.LogCompress }).startupOnly(),
+	boolParam("syslog-enabled", func(c *Config) *bool { return &c.SyslogEnabled }).startupOnly(),
+	stringParam("syslog-ident", func(c *Config) *string { return &c.SyslogIdent }).startupOnly(),
+	enumParam("syslog-facility", []string{"user", "daemon", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}, func(c *Config) *string { return &c.SyslogFacility }).startupOnly(),
+	boolParam("appendonly", func(c *Config) *bool { return &c.AppendOnly }).startupOnly(),
+	stringParam("appendfilename", func(c *Config) *string { return &c.AppendFilename }).startupOnly(),
+	stringParam("dbfilename", func(c *Config) *string { return &c.DBFilename }).onSet(func(s *Server, cfg *Config) error {
+		s.instance.DBFilename = cfg.DBFilename
+		return nil
+	}),
+	intParam("hz", 1, 500, func(c *Config) *int { return &c.Hz }).onSet(func(s *Server, cfg *Config) error {
+		s.instance.Store.SetHz(cfg.Hz)
+		return nil
+	}),
+	intParam("tls-port", 0, 65535, func(c *Config) *int { return &c.TLSPort }).startupOnly(),
+	stringParam("tls-cert-file", func(c *Config) *string { return &c.TLSCertFile }).onSet(reloadTLS),
+	stringParam("tls-key-file", func(c *Config) *string { return &c.TLSKeyFile }).onSet(reloadTLS),
+	stringParam("tls-ca-cert-file", func(c *Config) *string { return &c.TLSCACertFile }).onSet(reloadTLS),
+	enumParam("tls-auth-clients", []string{"yes", "no", "optional"}, func(c *Config) *string { return &c.TLSAuthClients }).onSet(reloadTLS),
+	stringParam("requirepass", func(c *Config) *string { return &c.RequirePass }).onSet(func(s *Server, cfg *Config) error {
+		if cfg.ACLFile != "" {
+			return errors.New("requirepass can't be used together with aclfile")
+		}
+		s.acl.setDefaultPassword(cfg.RequirePass)
+		return nil
+	}),
+	stringParam("aclfile", func(c *Config) *string { return &c.ACLFile }).startupOnly(),
+	intParam("acllog-max-len", 1, 1<<31-1, func(c *Config) *int { return &c.ACLLogMaxLen }),
+	durationParam("lua-time-limit", time.Millisecond, func(c *Config) *time.Duration { return &c.LuaTimeLimit }),
+	intParam("maxclients", 1, 1<<31-1, func(c *Config) *int { return &c.MaxClients }),
+	durationParam("timeout", time.Second, func(c *Config) *time.Duration { return &c.Timeout }).onSet((*Server).setTimeouts),
+	durationParam("client-query-timeout", time.Second, func(c *Config) *time.Duration { return &c.ClientQueryTimeout }).onSet((*Server).setTimeouts),
+	durationParam("tcp-keepalive", time.Second, func(c *Config) *time.Duration { return &c.TCPKeepAlive }),
+	{
+		name: "slowlog-log-slower-than",
+		get:  func(c *Config) string { return strconv.FormatInt(c.SlowlogLogSlowerThan.Microseconds(), 10) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if limit := int64(math.MaxInt64 / time.Microsecond); n < -1 || n > limit {
+				return fmt.Errorf("argument must be between -1 and %d inclusive", limit)
+			}
+			// Zero is the default in Config, a nanosecond records every
+			// command all the same
+			c.SlowlogLogSlowerThan = time.Duration(n) * time.Microsecond
+			if n == 0 {
+				c.SlowlogLogSlowerThan = time.Nanosecond
+			}
+			return nil
+		},
+		apply: (*Server).setSlowlog,
+	},
+	intParam("slowlog-max-len", 1, 1<<31-1, func(c *Config) *int { return &c.SlowlogMaxLen }).onSet((*Server).setSlowlog),
+	durationParam("latency-monitor-threshold", time.Millisecond, func(c *Config) *time.Duration { return &c.LatencyMonitorThreshold }).onSet((*Server).setLatencyMonitor),
+	memoryParam("maxmemory", 0, func(c *Config) *int { return &c.MaxMemory }).onSet((*Server).setMaxMemory),
+	{
+		name: "serve-stale-data",
+		get: func(c *Config) string {
+			if c.RefuseStaleData {
+				return "no"
+			}
+			return "yes"
+		},
+		set: func(c *Config, value string) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				c.RefuseStaleData = false
+			case "no":
+				c.RefuseStaleData = true
+			default:
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+		apply: (*Server).setServeStale,
+	},
+	durationParam("shutdown-timeout", time.Second, func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	memoryParam("proto-max-bulk-len", 1024*1024, func(c *Config) *int { return &c.ProtoMaxBulkLen }),
+	intParam("proto-max-multibulk-len", 1, 1<<31-1, func(c *Config) *int { return &c.ProtoMaxMultibulkLen }),
+	{
+		name:  "client-output-buffer-limit",
+		multi: true,
+		get: func(c *Config) string {
+			limit := c.ClientOutputBufferLimit
+			return fmt.Sprintf("normal %d %d %d", limit.Hard, limit.Soft, int64(limit.SoftPeriod/time.Second))
+		},
+		format: func(c *Config) string {
+			limit := c.ClientOutputBufferLimit
+			return fmt.Sprintf("normal %s %s %d", formatMemory(limit.Hard), formatMemory(limit.Soft), int64(limit.SoftPeriod/time.Second))
+		},
+		set: func(c *Config, value string) error {
+			fields := strings.Fields(value)
+			if len(fields) != 4 {
+				return errors.New("wrong number of arguments in buffer limit configuration")
+			}
+			if !strings.EqualFold(fields[0], "normal") {
+				return errors.New("invalid client class specified in buffer limit configuration")
+			}
+			hard, err1 := parseMemory(fields[1])
+			soft, err2 := parseMemory(fields[2])
+			seconds, err3 := strconv.ParseInt(fields[3], 10, 64)
+			if err1 != nil || err2 != nil || err3 != nil || hard < 0 || soft < 0 || seconds < 0 {
+				return errors.New("error in hard, soft or soft_seconds setting in buffer limit configuration")
+			}
+			c.ClientOutputBufferLimit = OutputBufferLimit{Hard: hard, Soft: soft, SoftPeriod: time.Duration(seconds) * time.Second}
+			return nil
+		},
+	},
+	{
+		name:  "ratelimit",
+		multi: true,
+		get:   func(c *Config) string { return formatRateLimits(c.RateLimits, false) },
+		format: func(c *Config) string {
+			return formatRateLimits(c.RateLimits, true)
+		},
+		set: func(c *Config, value string) error {
+			limits, err := parseRateLimits(value)
+			if err != nil {
+				return err
+			}
+			c.RateLimits = limits
+			return nil
+		},
+		apply: (*Server).setRateLimits,
+	},
+	enumParam("ratelimit-mode", []string{"reject", "delay"}, func(c *Config) *string { return &c.RateLimitMode }).onSet((*Server).setRateLimits),
+	{
+		name:      "modules",
+		immutable: true,
+		multi:     true,
+		get:       func(c *Config) string { return strings.Join(c.Modules, " ") },
+		set: func(c *Config, value string) error {
+			c.Modules = strings.Fields(value)
+			return nil
+		},
+	},
+}
+
+// reloadTLS applies changed certificate settings to new TLS connections
+func reloadTLS(s *Server, cfg *Config) error {
+	return s.reloadTLS(*cfg)
+}
+
+func lookupConfigParam(name string) *configParam {
+	for i := range configParams {
+		if strings.EqualFold(configParams[i].name, name) {
+			return &configParams[i]
+		}
+	}
+	return nil
+}
+
+func stringParam(name string, field func(*Config) *string) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+// enumParam accepts one of values, ignoring case
+func enumParam(name string, values []string, field func(*Config) *string) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			for _, v := range values {
+				if strings.EqualFold(v, value) {
+					*field(c) = v
+					return nil
+				}
+			}
+			return fmt.Errorf("argument must be one of: %s", strings.Join(values, ", "))
+		},
+	}
+}
+
+func boolParam(name string, field func(*Config) *bool) configParam {
+	return configParam{
+		name: name,
+		get: func(c *Config) string {
+			if *field(c) {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Config, value string) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				*field(c) = true
+			case "no":
+				*field(c) = false
+			default:
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+	}
+}
+
+func intParam(name string, min, max int, field func(*Config) *int) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+// durationParam is a duration given as an integer number of units
+func durationParam(name string, unit time.Duration, field func(*Config) *time.Duration) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return strconv.FormatInt(int64(*field(c)/unit), 10) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be a non-negative integer")
+			}
+			*field(c) = time.Duration(n) * unit
+			return nil
+		},
+	}
+}
+
+// memoryParam is a byte count that may carry a unit such as 512mb
+func memoryParam(name string, min int64, field func(*Config) *int) configParam {
+	return configParam{
+		name:   name,
+		get:    func(c *Config) string { return strconv.Itoa(*field(c)) },
+		format: func(c *Config) string { return formatMemory(int64(*field(c))) },
+		set: func(c *Config, value string) error {
+			n, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			if n < min || n > int64(maxInt) {
+				return fmt.Errorf("argument must be a memory value between %d and %d bytes", min, maxInt)
+			}
+			*field(c) = int(n)
+			return nil
+		},
+	}
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// parseMemory parses a byte count with an optional k, kb, m, mb, g or gb
+// suffix. The single-letter units are powers of 1000, the others of 1024.
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+		{"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000}, {"b", 1},
+	}
+	lower := strings.ToLower(value)
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, scale = strings.TrimSuffix(lower, unit.suffix), unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/scale {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * scale, nil
+}
+
+// formatMemory spells n with the largest binary unit that divides it exactly
+func formatMemory(n int64) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n%(1<<30) == 0:
+		return strconv.FormatInt(n>>30, 10) + "gb"
+	case n%(1<<20) == 0:
+		return strconv.FormatInt(n>>20, 10) + "mb"
+	case n%(1<<10) == 0:
+		return strconv.FormatInt(n>>10, 10) + "kb"
+	default:
+		return strconv.FormatInt(n, 10)
+	}
+}
+
+// applyDirective sets the parameter a config file line or command-line
+// override names
+func (cfg *Config) applyDirective(args []string) error {
+	p := lookupConfigParam(args[0])
+	if p == nil {
+		return fmt.Errorf("bad directive or wrong number of arguments: '%s'", args[0])
+	}
+	if len(args) < 2 || (!p.multi && len(args) != 2) {
+		return fmt.Errorf("wrong number of arguments for '%s'", p.name)
+	}
+	if err := p.set(cfg, strings.Join(args[1:], " ")); err != nil {
+		return fmt.Errorf("invalid argument for '%s': %v", p.name, err)
+	}
+	return nil
+}
+
+// loadFile applies the directives of an orion.conf file. Blank lines and
+// lines starting with '#' are ignored, arguments are split with the inline
+// command quoting rules.
+func (cfg *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := protocol.SplitArgs(line)
+		if err == nil && len(args) > 0 {
+			err = cfg.applyDirective(args)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+// LoadConfigFile reads an orion.conf file on top of DefaultConfig. The file
+// is remembered in ConfigFile so CONFIG REWRITE can update it.
+func LoadConfigFile(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if err := cfg.loadFile(path); err != nil {
+		return cfg, err
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	cfg.ConfigFile = path
+	return cfg, nil
+}
+
+// ConfigFromArgs builds the standalone server's configuration from its
+// command line: an optional config file, given first or with --config, then
+// --name value overrides for any config parameter, e.g.
+//
+//	orion-server orion.conf --port 7000 --appendonly no
+func ConfigFromArgs(args []string) (Config, error) {
+	cfg := DefaultConfig()
+	var path string
+	if len(args) > 0 && !isOption(args[0]) {
+		path, args = args[0], args[1:]
+	}
+
+	var overrides [][]string
+	for len(args) > 0 {
+		if !isOption(args[0]) {
+			return cfg, fmt.Errorf("unexpected argument '%s'", args[0])
+		}
+		directive := []string{strings.TrimLeft(args[0], "-")}
+		args = args[1:]
+		if name, value, ok := strings.Cut(directive[0], "="); ok {
+			directive = []string{name, value}
+		}
+		for len(args) > 0 && !isOption(args[0]) {
+			directive, args = append(directive, args[0]), args[1:]
+		}
+
+		if strings.EqualFold(directive[0], "config") {
+			if len(directive) != 2 {
+				return cfg, errors.New("--config needs exactly one file name")
+			}
+			path = directive[1]
+			continue
+		}
+		overrides = append(overrides, directive)
+	}
+
+	if path != "" {
+		var err error
+		if cfg, err = LoadConfigFile(path); err != nil {
+			return cfg, err
+		}
+	}
+	for _, directive := range overrides {
+		if err := cfg.applyDirective(directive); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// isOption reports whether arg is a --name or -name option rather than a
+// value, negative numbers are values
+func isOption(arg string) bool {
+	name := strings.TrimLeft(arg, "-")
+	return len(name) < len(arg) && len(name) > 0 && (name[0] < '0' || name[0] > '9')
+}
+
+// Config returns the configuration in effect, including changes made with
+// CONFIG SET
+func (s *Server) Config() Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
+// handleConfig implements CONFIG GET, SET, REWRITE and RESETSTAT
+func (s *Server) handleConfig(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sub, _ := args[0].(protocol.BulkStringValue)
+
+	switch strings.ToUpper(string(sub)) {
+	case "GET":
+		if len(args) < 2 {
+			return protocol.ErrorValue("ERR wrong number of arguments for 'config|get' command")
+		}
+		cfg := s.Config()
+		response := protocol.MapValue{}
+		for _, p := range configParams {
+			for _, arg := range args[1:] {
+				pattern, _ := arg.(protocol.BulkStringValue)
+				if globMatch(string(pattern), p.name, true) {
+					response[p.name] = protocol.BulkStringValue(p.get(&cfg))
+					break
+				}
+			}
+		}
+		return response
+
+	case "SET":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return protocol.ErrorValue("ERR wrong number of arguments for 'config|set' command")
+		}
+		return s.configSet(args[1:])
+
+	case "REWRITE":
+		if len(args) != 1 {
+			return protocol.ErrorValue("ERR wrong number of arguments for 'config|rewrite' command")
+		}
+		if err := s.rewriteConfig(); err != nil {
+			s.log.Error("CONFIG REWRITE failed", "err", err)
+			return protocol.ErrorValue("ERR Rewriting config file: " + err.Error())
+		}
+		s.log.Info("CONFIG REWRITE executed with success")
+		return protocol.SimpleStringValue("OK")
+
+	case "RESETSTAT":
+		if len(args) != 1 {
+			return protocol.ErrorValue("ERR wrong number of arguments for 'config|resetstat' command")
+		}
+		s.stats.reset()
+		s.commandStats.reset()
+		return protocol.SimpleStringValue("OK")
+
+	default:
+		return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try CONFIG GET, CONFIG SET, CONFIG REWRITE or CONFIG RESETSTAT")
+	}
+}
+
+// configSet applies parameter/value pairs all at once: if any of them is
+// rejected none is changed
+func (s *Server) configSet(pairs []protocol.ORSPValue) protocol.ORSPValue {
+	next := s.Config()
+	changed := make([]*configParam, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		name, _ := pairs[i].(protocol.BulkStringValue)
+		value, _ := pairs[i+1].(protocol.BulkStringValue)
+
+		p := lookupConfigParam(string(name))
+		if p == nil {
+			return protocol.ErrorValue("ERR Unknown option or number of arguments for CONFIG SET - '" + string(name) + "'")
+		}
+		for _, other := range changed {
+			if other == p {
+				return protocol.ErrorValue("ERR Duplicate parameter - '" + p.name + "'")
+			}
+		}
+		if p.immutable {
+			return protocol.ErrorValue("ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - can't set immutable config")
+		}
+		if err := p.set(&next, string(value)); err != nil {
+			return protocol.ErrorValue("ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - " + err.Error())
+		}
+		changed = append(changed, p)
+	}
+
+	prev := s.Config()
+	s.configMu.Lock()
+	s.config = next
+	s.configMu.Unlock()
+	for i, p := range changed {
+		if p.apply == nil {
+			continue
+		}
+		if err := p.apply(s, &next); err != nil {
+			// Put the previous values back, including the ones applied already
+			s.configMu.Lock()
+			s.config = prev
+			s.configMu.Unlock()
+			for _, q := range changed[:i] {
+				if q.apply != nil {
+					q.apply(s, &prev)
+				}
+			}
+			return protocol.ErrorValue("ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - " + err.Error())
+		}
+	}
+	return protocol.SimpleStringValue("OK")
+}
+
+// rewriteSignature precedes the settings CONFIG REWRITE appends
+const rewriteSignature = "# Generated by CONFIG REWRITE"
+
+// rewriteConfig writes the configuration in effect back to ConfigFile. Lines
+// of known directives are updated in place, duplicates are dropped, comments
+// and everything else are kept. Settings missing from the file are appended
+// if they differ from the defaults.
+func (s *Server) rewriteConfig() error {
+	cfg := s.Config()
+	if cfg.ConfigFile == "" {
+		return errors.New("the server is running without a config file")
+	}
+
+	content, err := os.ReadFile(cfg.ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = nil
+	}
+
+	written := make(map[string]bool)
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == rewriteSignature {
+			continue
+		}
+		args, err := protocol.SplitArgs(trimmed)
+		if trimmed == "" || trimmed[0] == '#' || err != nil || len(args) == 0 {
+			out = append(out, line)
+			continue
+		}
+		p := lookupConfigParam(args[0])
+		if p == nil {
+			out = append(out, line)
+			continue
+		}
+		if !written[p.name] {
+			written[p.name] = true
+			out = append(out, p.line(&cfg))
+		}
+	}
+
+	defaults := DefaultConfig()
+	var appended []string
+	for i := range configParams {
+		p := &configParams[i]
+		if !written[p.name] && p.get(&cfg) != p.get(&defaults) {
+			appended = append(appended, p.line(&cfg))
+		}
+	}
+	if len(appended) > 0 {
+		out = append(out, rewriteSignature)
+		out = append(out, appended...)
+	}
+
+	return writeFileAtomic(cfg.ConfigFile, []byte(strings.Join(out, "\n")+"\n"))
+}
+
+// line formats p as a config file directive
+func (p *configParam) line(cfg *Config) string {
+	value := p.get(cfg)
+	if p.format != nil {
+		value = p.format(cfg)
+	}
+	if !p.multi || value == "" {
+		value = quoteConfigValue(value)
+	}
+	return p.name + " " + value
+}
+
+// quoteConfigValue quotes value when SplitArgs would not read it back as a
+// single argument
+func quoteConfigValue(value string) string {
+	needsQuotes := value == ""
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c <= ' ' || c == '"' || c == '\'' || c == '\\' || c >= 0x7f {
+			needsQuotes = true
+			break
+		}
+	}
+	if !needsQuotes {
+		return value
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so a
+// crash never leaves a half-written config behind
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"orion/src/protocol"
+)
+
+// configGet returns the value CONFIG GET reports for name
+func configGet(t *testing.T, s *Server, name string) string {
+	t.Helper()
+	reply, err := s.Do(context.Background(), "CONFIG", "GET", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := reply.(protocol.MapValue)[name].(protocol.BulkStringValue)
+	return string(value)
+}
+
+func TestConfigGetSet(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+
+	reply, _ := s.Do(ctx, "CONFIG", "GET", "slowlog-*", "hz")
+	got, _ := reply.(protocol.MapValue)
+	for _, name := range []string{"slowlog-log-slower-than", "slowlog-max-len", "hz"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("CONFIG GET slowlog-* hz lacks %s: %v", name, got)
+		}
+	}
+
+	if reply, _ := s.Do(ctx, "CONFIG", "SET", "hz", "50", "maxmemory", "2mb"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CONFIG SET got %#v", reply)
+	}
+	if hz, maxmemory := configGet(t, s, "hz"), configGet(t, s, "maxmemory"); hz != "50" || maxmemory != "2097152" {
+		t.Errorf("after CONFIG SET hz is %s and maxmemory %s", hz, maxmemory)
+	}
+
+	for _, args := range [][]string{
+		{"hz", "1000"},
+		{"hz", "10", "maxmemory", "lots"},
+		{"hz", "10", "nosuchparam", "1"},
+		{"hz", "10", "hz", "20"},
+		{"dir", "/tmp"},
+	} {
+		reply, _ := s.Do(ctx, append([]string{"CONFIG", "SET"}, args...)...)
+		if !isError(reply, "ERR ") {
+			t.Errorf("CONFIG SET %v got %#v", args, reply)
+		}
+		if hz := configGet(t, s, "hz"); hz != "50" {
+			t.Errorf("the failed CONFIG SET %v left hz at %s", args, hz)
+		}
+	}
+}
+
+func TestConfigSetRollsBackApplied(t *testing.T) {
+	dir := t.TempDir()
+	aclFile := filepath.Join(dir, "users.acl")
+	if err := os.WriteFile(aclFile, []byte("user default on nopass ~* &* +@all\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startServer(t, Config{Dir: dir, ACLFile: aclFile})
+	before := configGet(t, s, "maxmemory")
+
+	// maxmemory is applied before requirepass fails, and must be put back
+	reply, _ := s.Do(context.Background(), "CONFIG", "SET", "maxmemory", "1", "requirepass", "secret")
+	if !isError(reply, "ERR CONFIG SET failed (possibly related to argument 'requirepass')") {
+		t.Fatalf("CONFIG SET got %#v", reply)
+	}
+	if got := configGet(t, s, "maxmemory"); got != before {
+		t.Errorf("maxmemory is %s after the failed CONFIG SET, want %s", got, before)
+	}
+	if reply := dial(t, addr).do("SET", "k", "v"); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("SET after the failed CONFIG SET got %#v, maxmemory is still in effect", reply)
+	}
+}
+
+func TestConfigRewritePreservesComments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orion.conf")
+	original := strings.Join([]string{
+		"# Orion configuration",
+		"",
+		"# expiry rate",
+		"hz 5",
+		"   # indented comment",
+		"hz 6",
+		"maxclients 100",
+	}, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Dir = dir
+	s, _ := startServer(t, cfg)
+	ctx := context.Background()
+
+	for _, args := range [][]string{
+		{"CONFIG", "SET", "hz", "20", "requirepass", "two words"},
+		{"CONFIG", "REWRITE"},
+	} {
+		if reply, _ := s.Do(ctx, args...); reply != protocol.SimpleStringValue("OK") {
+			t.Fatalf("%v got %#v", args, reply)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"# Orion configuration",
+		"",
+		"# expiry rate",
+		"hz 20",
+		"   # indented comment",
+		"maxclients 100",
+		rewriteSignature,
+	}, "\n") + "\n"
+	if !strings.HasPrefix(string(content), want) {
+		t.Errorf("rewritten config:\n%s\nwant it to start with:\n%s", content, want)
+	}
+	if !strings.Contains(string(content), "\nrequirepass \"two words\"\n") {
+		t.Errorf("rewritten config lacks the quoted requirepass:\n%s", content)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("rewritten config mode %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// The file loads back to the same settings
+	again, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Hz != 20 || again.RequirePass != "two words" || again.MaxClients != 100 {
+		t.Errorf("reloaded hz %d, requirepass %q, maxclients %d", again.Hz, again.RequirePass, again.MaxClients)
+	}
+
+	withoutFile, _ := startServer(t, Config{})
+	if reply, _ := withoutFile.Do(ctx, "CONFIG", "REWRITE"); !isError(reply, "ERR Rewriting config file") {
+		t.Errorf("CONFIG REWRITE without a config file got %#v", reply)
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+	s.Do(ctx, "SET", "k", "v")
+	s.Do(ctx, "NOSUCHCOMMAND")
+
+	if reply, _ := s.Do(ctx, "CONFIG", "RESETSTAT"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CONFIG RESETSTAT got %#v", reply)
+	}
+	if n := s.stats.commandsProcessed.Load(); n != 0 {
+		t.Errorf("%d commands processed after CONFIG RESETSTAT", n)
+	}
+	if n := s.stats.errorReplies.Load(); n != 0 {
+		t.Errorf("%d error replies after CONFIG RESETSTAT", n)
+	}
+	reply, _ := s.Do(ctx, "INFO", "commandstats", "errorstats")
+	for _, line := range strings.Split(string(reply.(protocol.BulkStringValue)), "\r\n") {
+		if strings.HasPrefix(line, "cmdstat_set") || strings.HasPrefix(line, "errorstat_") {
+			t.Errorf("INFO after CONFIG RESETSTAT still has %s", line)
+		}
+	}
+	// The data is not statistics
+	if reply, _ := s.Do(ctx, "GET", "k"); reply != protocol.BulkStringValue("v") {
+		t.Errorf("GET after CONFIG RESETSTAT got %#v", reply)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+// globMatch reports whether s matches the glob-style pattern the way Redis
+// matches KEYS and CONFIG GET patterns: '*' matches any run of bytes, '?' a
+// single byte, '[abc]', '[^abc]' and '[a-z]' a byte from a class and '\'
+// escapes the next byte.
+func globMatch(pattern, s string, nocase bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var matched bool
+			matched, pattern = matchClass(pattern[1:], s[0], nocase)
+			if !matched {
+				return false
+			}
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || !sameByte(pattern[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the class at the start of pattern, just past
+// the '[', and returns the pattern following the closing ']'
+func matchClass(pattern string, c byte, nocase bool) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			pattern = pattern[1:]
+			matched = matched || sameByte(pattern[0], c, nocase)
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if nocase {
+				lo, hi, c = lower(lo), lower(hi), lower(c)
+			}
+			matched = matched || (lo <= c && c <= hi)
+			pattern = pattern[2:]
+		default:
+			matched = matched || sameByte(pattern[0], c, nocase)
+		}
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // the ']'
+	}
+	return matched != not, pattern
+}
+
+func sameByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -8,59 +8,203 @@ import (
 )
 
 // CommandHandler is the function signature for command handlers
-type CommandHandler func(args []protocol.ORSPValue) protocol.ORSPValue
-
-// CommandMap maps command names to their handlers
-var CommandMap = map[string]CommandHandler{
-
-	//Server Management commands
-	"BGSAVE":       commands.HandleBGSave,
-	"BGREWRITEAOF": commands.HandleBGRewriteAOF,
-	"FLUSHALL":     commands.HandleFlushAll,
-	"PING":         commands.HandlePing,
-	"TIME":         commands.HandleTime,
-	"INFO":         commands.HandleInfo,
-	"DBSIZE":       commands.HandleDBSize,
-
-	//String commands
-
-	"SET":         commands.HandleSet,
-	"GET":         commands.HandleGet,
-	"APPEND":      commands.HandleAppend,
-	"GETDEL":      commands.HandleGetDel,
-	"GETEX":       commands.HandleGetEx,
-	"GETSET":      commands.HandleGetSet,
-	"GETRANGE":    commands.HandleGetRange,
-	"INCR":        commands.HandleIncr,
-	"INCRBY":      commands.HandleIncrBy,
-	"INCRBYFLOAT": commands.HandleIncrByFloat,
-	"LCS":         commands.HandleLCS,
-	"TTL":         commands.HandleTTL,
-
-	//set commands
-	"SADD":        commands.HandleSAdd,
-	"SCARD":       commands.HandleSCard,
-	"SMEMBERS":    commands.HandleSMembers,
-	"SISMEMBER":   commands.HandleSIsMember,
-	"SREM":        commands.HandleSRem,
-	"SPOP":        commands.HandleSPop,
-	"SMOVE":       commands.HandleSMove,
-	"SDIFF":       commands.HandleSDiff,
-	"SDIFFSTORE":  commands.HandleSDiffStore,
-	"SUNION":      commands.HandleSUnion,
-	"SUNIONSTORE": commands.HandleSUnionStore,
-	"SRANDMEMBER": commands.HandleSRandMember,
-
-	//hash commands
-	"HSET":    commands.HandleHSet,
-	"HGET":    commands.HandleHGet,
-	"HDEL":    commands.HandleHDel,
-	"HEXISTS": commands.HandleHExists,
-	"HLEN":    commands.HandleHLen,
+type CommandHandler func(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue
+
+// DefaultCommands returns the built-in commands. Every Server registers a
+// fresh copy so tables can be changed per instance.
+func DefaultCommands() []*Command {
+	return []*Command{
+
+		//Server Management commands
+		{Name: "BGSAVE", Handler: commands.HandleBGSave, Arity: -1, Flags: FlagAdmin | FlagNoScript,
+			Group: "server", Summary: "Asynchronously saves the database to disk", Syntax: "BGSAVE", Complexity: "O(1)"},
+		{Name: "BGREWRITEAOF", Handler: commands.HandleBGRewriteAOF, Arity: 1, Flags: FlagAdmin | FlagNoScript,
+			Group: "server", Summary: "Asynchronously rewrites the append-only file", Syntax: "BGREWRITEAOF", Complexity: "O(1)"},
+		{Name: "FLUSHALL", Handler: commands.HandleFlushAll, Arity: 1, Flags: FlagWrite, Categories: []string{"@keyspace", "@dangerous"},
+			Group: "server", Summary: "Removes all keys", Syntax: "FLUSHALL", Complexity: "O(N) where N is the total number of keys"},
+		{Name: "PING", Handler: commands.HandlePing, Arity: -1, Flags: FlagFast | FlagStale, Categories: []string{"@connection"},
+			Group: "connection", Summary: "Returns the server's liveliness response", Syntax: "PING [message]", Complexity: "O(1)"},
+		{Name: "TIME", Handler: commands.HandleTime, Arity: 1, Flags: FlagFast | FlagStale,
+			Group: "server", Summary: "Returns the server time", Syntax: "TIME", Complexity: "O(1)"},
+		{Name: "DBSIZE", Handler: commands.HandleDBSize, Arity: 1, Flags: FlagReadOnly | FlagFast, Categories: []string{"@keyspace"},
+			Group: "server", Summary: "Returns the number of keys in the database", Syntax: "DBSIZE", Complexity: "O(1)"},
+
+		//String commands
+		{Name: "SET", Handler: commands.HandleSet, Arity: -3, Flags: FlagWrite | FlagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyWrite, Options: setGetOption}}, Categories: []string{"@string"},
+			Group: "string", Summary: "Sets the string value of a key", Syntax: "SET key value [EX seconds | PX milliseconds] [NX | XX]", Complexity: "O(1)"},
+		{Name: "GET", Handler: commands.HandleGet, Arity: 2, Flags: FlagReadOnly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@string"},
+			Group: "string", Summary: "Returns the string value of a key", Syntax: "GET key", Complexity: "O(1)"},
+		{Name: "APPEND", Handler: commands.HandleAppend, Arity: 3, Flags: FlagWrite | FlagDenyOOM | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@string"},
+			Group: "string", Summary: "Appends a string to the value of a key", Syntax: "APPEND key value", Complexity: "O(1)"},
+		{Name: "GETDEL", Handler: commands.HandleGetDel, Arity: 2, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}}, Categories: []string{"@string"},
+			Group: "string", Summary: "Returns the string value of a key after deleting the key", Syntax: "GETDEL key", Complexity: "O(1)"},
+		{Name: "GETEX", Handler: commands.HandleGetEx, Arity: 3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}}, Categories: []string{"@string"},
+			Group: "string", Summary: "Returns the string value of a key after setting its expiration time", Syntax: "GETEX key seconds", Complexity: "O(1)"},
+		{Name: "GETSET", Handler: commands.HandleGetSet, Arity: 3, Flags: FlagWrite | FlagDenyOOM | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}}, Categories: []string{"@string"},
+			Group: "string", Summary: "Returns the previous string value of a key after setting it to a new value", Syntax: "GETSET key value", Complexity: "O(1)"},
+		{Name: "GETRANGE", Handler: commands.HandleGetRange, Arity: 4, Flags: FlagReadOnly, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@string"},
+			Group: "string", Summary: "Returns a substring of the string stored at a key", Syntax: "GETRANGE key start end", Complexity: "O(N) where N is the length of the returned string"},
+		{Name: "INCR", Handler: commands.HandleIncr, Arity: 2, Flags: FlagWrite | FlagDenyOOM | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}}, Categories: []string{"@string"},
+			Group: "string", Summary: "Increments the integer value of a key by one", Syntax: "INCR key", Complexity: "O(1)"},
+		{Name: "INCRBY", Handler: commands.HandleIncrBy, Arity: 3, Flags: FlagWrite | FlagDenyOOM | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}}, Categories: []string{"@string"},
+			Group: "string", Summary: "Increments the integer value of a key by a number", Syntax: "INCRBY key increment", Complexity: "O(1)"},
+		{Name: "INCRBYFLOAT", Handler: commands.HandleIncrByFloat, Arity: 3, Flags: FlagWrite | FlagDenyOOM | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}}, Categories: []string{"@string"},
+			Group: "string", Summary: "Increments the floating point value of a key by a number", Syntax: "INCRBYFLOAT key increment", Complexity: "O(1)"},
+		{Name: "LCS", Handler: commands.HandleLCS, Arity: 3, Flags: FlagReadOnly, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@string"},
+			Group: "string", Summary: "Finds the longest common subsequence between a key and a string", Syntax: "LCS key string", Complexity: "O(N*M) where N and M are the lengths of the strings"},
+		{Name: "TTL", Handler: commands.HandleTTL, Arity: 2, Flags: FlagReadOnly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@keyspace"},
+			Group: "generic", Summary: "Returns the expiration time in seconds of a key", Syntax: "TTL key", Complexity: "O(1)"},
+
+		//set commands
+		{Name: "SADD", Handler: commands.HandleSAdd, Arity: -3, Flags: FlagWrite | FlagDenyOOM | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Adds one or more members to a set", Syntax: "SADD key member [member ...]", Complexity: "O(N) where N is the number of members to be added"},
+		{Name: "SCARD", Handler: commands.HandleSCard, Arity: 2, Flags: FlagReadOnly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Returns the number of members in a set", Syntax: "SCARD key", Complexity: "O(1)"},
+		{Name: "SMEMBERS", Handler: commands.HandleSMembers, Arity: 2, Flags: FlagReadOnly, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Returns all members of a set", Syntax: "SMEMBERS key", Complexity: "O(N) where N is the set cardinality"},
+		{Name: "SISMEMBER", Handler: commands.HandleSIsMember, Arity: 3, Flags: FlagReadOnly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Determines whether a member belongs to a set", Syntax: "SISMEMBER key member", Complexity: "O(1)"},
+		{Name: "SREM", Handler: commands.HandleSRem, Arity: -3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Removes one or more members from a set", Syntax: "SREM key member [member ...]", Complexity: "O(N) where N is the number of members to be removed"},
+		{Name: "SPOP", Handler: commands.HandleSPop, Arity: -2, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}}, Categories: []string{"@set"},
+			Group: "set", Summary: "Removes and returns one or more random members from a set", Syntax: "SPOP key [count]", Complexity: "O(N) where N is the value of the passed count"},
+		{Name: "SMOVE", Handler: commands.HandleSMove, Arity: 4, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 2, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead | KeyWrite}, {FirstKey: 2, LastKey: 2, Step: 1, Flags: KeyWrite}}, Categories: []string{"@set"},
+			Group: "set", Summary: "Moves a member from one set to another", Syntax: "SMOVE source destination member", Complexity: "O(1)"},
+		{Name: "SDIFF", Handler: commands.HandleSDiff, Arity: -2, Flags: FlagReadOnly, FirstKey: 1, LastKey: -1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Returns the difference of multiple sets", Syntax: "SDIFF key [key ...]", Complexity: "O(N) where N is the total number of elements in all given sets"},
+		{Name: "SDIFFSTORE", Handler: commands.HandleSDiffStore, Arity: -3, Flags: FlagWrite | FlagDenyOOM, FirstKey: 1, LastKey: -1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyWrite}, {FirstKey: 2, LastKey: -1, Step: 1, Flags: KeyRead}}, Categories: []string{"@set"},
+			Group: "set", Summary: "Stores the difference of multiple sets in a key", Syntax: "SDIFFSTORE destination key [key ...]", Complexity: "O(N) where N is the total number of elements in all given sets"},
+		{Name: "SUNION", Handler: commands.HandleSUnion, Arity: -2, Flags: FlagReadOnly, FirstKey: 1, LastKey: -1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Returns the union of multiple sets", Syntax: "SUNION key [key ...]", Complexity: "O(N) where N is the total number of elements in all given sets"},
+		{Name: "SUNIONSTORE", Handler: commands.HandleSUnionStore, Arity: -3, Flags: FlagWrite | FlagDenyOOM, FirstKey: 1, LastKey: -1, Step: 1, KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyWrite}, {FirstKey: 2, LastKey: -1, Step: 1, Flags: KeyRead}}, Categories: []string{"@set"},
+			Group: "set", Summary: "Stores the union of multiple sets in a key", Syntax: "SUNIONSTORE destination key [key ...]", Complexity: "O(N) where N is the total number of elements in all given sets"},
+		{Name: "SRANDMEMBER", Handler: commands.HandleSRandMember, Arity: -2, Flags: FlagReadOnly, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@set"},
+			Group: "set", Summary: "Returns one or more random members from a set", Syntax: "SRANDMEMBER key [count]", Complexity: "O(N) where N is the absolute value of the passed count"},
+
+		//hash commands
+		{Name: "HSET", Handler: commands.HandleHSet, Arity: -4, Flags: FlagWrite | FlagDenyOOM | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@hash"},
+			Group: "hash", Summary: "Creates or modifies the value of fields in a hash", Syntax: "HSET key field value [field value ...]", Complexity: "O(N) where N is the number of fields being set"},
+		{Name: "HGET", Handler: commands.HandleHGet, Arity: 3, Flags: FlagReadOnly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@hash"},
+			Group: "hash", Summary: "Returns the value of a field in a hash", Syntax: "HGET key field", Complexity: "O(1)"},
+		{Name: "HDEL", Handler: commands.HandleHDel, Arity: -3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@hash"},
+			Group: "hash", Summary: "Deletes one or more fields from a hash", Syntax: "HDEL key field [field ...]", Complexity: "O(N) where N is the number of fields to be removed"},
+		{Name: "HEXISTS", Handler: commands.HandleHExists, Arity: 3, Flags: FlagReadOnly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@hash"},
+			Group: "hash", Summary: "Determines whether a field exists in a hash", Syntax: "HEXISTS key field", Complexity: "O(1)"},
+		{Name: "HLEN", Handler: commands.HandleHLen, Arity: 2, Flags: FlagReadOnly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Categories: []string{"@hash"},
+			Group: "hash", Summary: "Returns the number of fields in a hash", Syntax: "HLEN key", Complexity: "O(1)"},
+	}
 }
 
-// HandleCommand routes the command to the correct handler
-func HandleCommand(command protocol.ArrayValue) protocol.ORSPValue {
+// setGetOption makes SET read its key when it is called with GET
+func setGetOption(args protocol.ArrayValue) KeyFlag {
+	for _, arg := range args[min(3, len(args)):] {
+		if strings.EqualFold(bulkString(arg), "GET") {
+			return KeyRead
+		}
+	}
+	return 0
+}
+
+// serverCommands returns the commands that need access to the Server itself
+func (s *Server) serverCommands() []*Command {
+	return []*Command{
+		{Name: "COMMAND", Handler: s.handleCommandCommand, Arity: -1, Flags: FlagLoading | FlagStale, Categories: []string{"@connection"},
+			Group: "server", Summary: "Returns detailed information about commands", Syntax: "COMMAND [COUNT | INFO [name ...] | DOCS [name ...] | GETKEYS command [arg ...]]", Complexity: "O(N) where N is the number of commands to look up"},
+		{Name: "HELLO", Handler: s.handleHello, Arity: -1, Flags: FlagNoScript | FlagFast | FlagNoAuth | FlagLoading | FlagStale, Categories: []string{"@connection"},
+			Group: "connection", Summary: "Handshakes with the server and selects the protocol version", Syntax: "HELLO [protover [AUTH username password] [SETNAME clientname]]", Complexity: "O(1)"},
+		{Name: "AUTH", Handler: s.handleAuth, Arity: -2, Flags: FlagNoScript | FlagFast | FlagNoAuth | FlagLoading | FlagStale, Categories: []string{"@connection"},
+			Group: "connection", Summary: "Authenticates the connection as an ACL user", Syntax: "AUTH [username] password", Complexity: "O(N) where N is the number of passwords defined for the user"},
+		{Name: "ACL", Handler: s.handleACL, Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+			Group: "server", Summary: "Manages the ACL users and shows denied attempts", Syntax: "ACL SETUSER username [rule ...] | GETUSER username | DELUSER username [username ...] | LIST | USERS | WHOAMI | CAT [category] | LOG [count | RESET] | SAVE | LOAD", Complexity: "Depends on subcommand",
+			// WHOAMI and CAT are open to every user, the others manage users
+			Subcommands: []*Command{
+				{Name: "SETUSER", Arity: -3, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Creates and modifies an ACL user and its rules", Syntax: "ACL SETUSER username [rule [rule ...]]", Complexity: "O(N) where N is the number of rules provided"},
+				{Name: "GETUSER", Arity: 3, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Lists the ACL rules of a user", Syntax: "ACL GETUSER username", Complexity: "O(N) where N is the number of password, command and pattern rules that the user has"},
+				{Name: "DELUSER", Arity: -3, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Deletes ACL users", Syntax: "ACL DELUSER username [username ...]", Complexity: "O(1) amortized time considering the typical user"},
+				{Name: "LIST", Arity: 2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Dumps the effective rules in ACL file format", Syntax: "ACL LIST", Complexity: "O(N) where N is the number of configured users"},
+				{Name: "USERS", Arity: 2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Lists all ACL users", Syntax: "ACL USERS", Complexity: "O(N) where N is the number of configured users"},
+				{Name: "WHOAMI", Arity: 2, Flags: FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Returns the authenticated username of the current connection", Syntax: "ACL WHOAMI", Complexity: "O(1)"},
+				{Name: "CAT", Arity: -2, Flags: FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Lists the ACL categories, or the commands inside a category", Syntax: "ACL CAT [category]", Complexity: "O(1) since the categories and commands are a fixed set"},
+				{Name: "LOG", Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Lists recent security events generated due to ACL rules", Syntax: "ACL LOG [count | RESET]", Complexity: "O(N) with N being the number of entries shown"},
+				{Name: "SAVE", Arity: 2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Saves the effective ACL rules in the configured ACL file", Syntax: "ACL SAVE", Complexity: "O(N) where N is the number of configured users"},
+				{Name: "LOAD", Arity: 2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Reloads the rules from the configured ACL file", Syntax: "ACL LOAD", Complexity: "O(N) where N is the number of configured users"},
+			}},
+		{Name: "CLIENT", Handler: s.handleClient, Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale, Categories: []string{"@connection"}, lock: lockNone,
+			Group: "connection", Summary: "Lists, names, kills and pauses client connections", Syntax: "CLIENT LIST [TYPE type] [ID id ...] | INFO | ID | SETNAME name | GETNAME | KILL addr | KILL [ID id] [ADDR addr] [LADDR addr] [USER username] [SKIPME yes|no] | PAUSE timeout [WRITE | ALL] | UNPAUSE | NO-EVICT ON|OFF | REPLY ON|OFF|SKIP", Complexity: "Depends on subcommand",
+			// The subcommands about the caller's own connection are open to
+			// every user, the others reach other connections
+			Subcommands: []*Command{
+				{Name: "ID", Arity: 2, Flags: FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Returns the unique client ID of the connection", Syntax: "CLIENT ID", Complexity: "O(1)"},
+				{Name: "SETNAME", Arity: 3, Flags: FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Sets the connection name", Syntax: "CLIENT SETNAME connection-name", Complexity: "O(1)"},
+				{Name: "GETNAME", Arity: 2, Flags: FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Returns the name of the connection", Syntax: "CLIENT GETNAME", Complexity: "O(1)"},
+				{Name: "INFO", Arity: 2, Flags: FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Returns information about the connection", Syntax: "CLIENT INFO", Complexity: "O(1)"},
+				{Name: "REPLY", Arity: 3, Flags: FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Instructs the server whether to reply to commands", Syntax: "CLIENT REPLY ON | OFF | SKIP", Complexity: "O(1)"},
+				{Name: "LIST", Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Lists open connections", Syntax: "CLIENT LIST [TYPE type] [ID id [id ...]]", Complexity: "O(N) where N is the number of client connections"},
+				{Name: "KILL", Arity: -3, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Terminates open connections", Syntax: "CLIENT KILL addr | KILL [ID id] [ADDR addr] [LADDR addr] [USER username] [SKIPME yes|no]", Complexity: "O(N) where N is the number of client connections"},
+				{Name: "PAUSE", Arity: -3, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Suspends commands processing", Syntax: "CLIENT PAUSE timeout [WRITE | ALL]", Complexity: "O(1)"},
+				{Name: "UNPAUSE", Arity: 2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Resumes processing commands from paused clients", Syntax: "CLIENT UNPAUSE", Complexity: "O(N) where N is the number of paused clients"},
+				{Name: "NO-EVICT", Arity: 3, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+					Summary: "Sets the client eviction mode of the connection", Syntax: "CLIENT NO-EVICT ON | OFF", Complexity: "O(1)"},
+			}},
+		{Name: "INFO", Handler: s.handleInfo, Arity: -1, Flags: FlagLoading | FlagStale, Categories: []string{"@dangerous"},
+			Group: "server", Summary: "Returns information and statistics about the server", Syntax: "INFO [section [section ...]]", Complexity: "O(N) where N is the number of keys for the keyspace section, O(1) otherwise"},
+		{Name: "SLOWLOG", Handler: s.handleSlowlog, Arity: -2, Flags: FlagAdmin | FlagLoading | FlagStale, Categories: []string{"@dangerous"}, lock: lockNone,
+			Group: "server", Summary: "Lists or clears the commands that ran longer than slowlog-log-slower-than", Syntax: "SLOWLOG GET [count] | LEN | RESET", Complexity: "O(N) where N is the number of entries returned"},
+		{Name: "MONITOR", Handler: s.handleMonitor, Arity: 1, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale, Categories: []string{"@dangerous"}, lock: lockNone,
+			Group: "server", Summary: "Streams every command the server executes to the connection", Syntax: "MONITOR", Complexity: "O(1)"},
+		{Name: "LATENCY", Handler: s.handleLatency, Arity: -2, Flags: FlagAdmin | FlagLoading | FlagStale, Categories: []string{"@dangerous"}, lock: lockNone,
+			Group: "server", Summary: "Reports the latency spikes of internal events and commands, and advice about them", Syntax: "LATENCY LATEST | HISTORY event | RESET [event ...] | HISTOGRAM [command ...] | DOCTOR", Complexity: "O(N) where N is the number of events or commands reported"},
+		{Name: "CONFIG", Handler: s.handleConfig, Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale, Categories: []string{"@dangerous"}, lock: lockExclusive,
+			Group: "server", Summary: "Reads, changes and persists the server configuration", Syntax: "CONFIG GET parameter [parameter ...] | SET parameter value [parameter value ...] | REWRITE | RESETSTAT", Complexity: "Depends on subcommand"},
+		{Name: "SHUTDOWN", Handler: s.handleShutdown, Arity: -1, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale | FlagAllowBusy, Categories: []string{"@dangerous"}, lock: lockNone,
+			Group: "server", Summary: "Stops the server after the clients are drained, optionally saving a snapshot", Syntax: "SHUTDOWN [NOSAVE | SAVE]", Complexity: "O(N) with N being the number of keys when saving"},
+		{Name: "MODULE", Handler: s.handleModule, Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+			Group: "server", Summary: "Lists the compiled-in modules and whether they are enabled", Syntax: "MODULE LIST", Complexity: "O(N) where N is the number of modules"},
+
+		//scripting commands
+		{Name: "EVAL", Handler: s.handleEval, Arity: -3, Flags: FlagNoScript, Categories: []string{"@scripting"}, lock: lockExclusive,
+			Group: "scripting", Summary: "Executes a server-side Lua script", Syntax: "EVAL script numkeys [key ...] [arg ...]", Complexity: "Depends on the script that is executed"},
+		{Name: "EVALSHA", Handler: s.handleEvalSha, Arity: -3, Flags: FlagNoScript, Categories: []string{"@scripting"}, lock: lockExclusive,
+			Group: "scripting", Summary: "Executes a cached server-side Lua script by its SHA1 digest", Syntax: "EVALSHA sha1 numkeys [key ...] [arg ...]", Complexity: "Depends on the script that is executed"},
+		{Name: "EVAL_RO", Handler: s.handleEvalRO, Arity: -3, Flags: FlagReadOnly | FlagNoScript, Categories: []string{"@scripting"}, lock: lockExclusive,
+			Group: "scripting", Summary: "Executes a read-only server-side Lua script", Syntax: "EVAL_RO script numkeys [key ...] [arg ...]", Complexity: "Depends on the script that is executed"},
+		{Name: "SCRIPT", Handler: s.handleScript, Arity: -2, Flags: FlagNoScript | FlagLoading | FlagStale, Categories: []string{"@scripting"}, lock: lockNone,
+			Group: "scripting", Summary: "Manages the Lua script cache", Syntax: "SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH | KILL", Complexity: "Depends on subcommand",
+			Subcommands: []*Command{
+				{Name: "LOAD", Arity: 3, Flags: FlagNoScript | FlagStale,
+					Summary: "Loads a server-side Lua script to the script cache", Syntax: "SCRIPT LOAD script", Complexity: "O(N) with N being the length in bytes of the script body"},
+				{Name: "EXISTS", Arity: -3, Flags: FlagNoScript,
+					Summary: "Determines whether server-side Lua scripts exist in the script cache", Syntax: "SCRIPT EXISTS sha1 [sha1 ...]", Complexity: "O(N) with N being the number of scripts to check"},
+				{Name: "FLUSH", Arity: -2, Flags: FlagNoScript,
+					Summary: "Removes all server-side Lua scripts from the script cache", Syntax: "SCRIPT FLUSH [ASYNC | SYNC]", Complexity: "O(N) with N being the number of scripts in cache"},
+				{Name: "KILL", Arity: 2, Flags: FlagNoScript | FlagAllowBusy | FlagLoading | FlagStale,
+					Summary: "Terminates a server-side Lua script during execution", Syntax: "SCRIPT KILL", Complexity: "O(1)"},
+			}},
+	}
+}
+
+// HandleCommand routes the command through the hook chain to the correct
+// handler after checking its arity
+func (s *Server) HandleCommand(ctx *commands.Context, command protocol.ArrayValue) protocol.ORSPValue {
 	if len(command) == 0 {
 		return protocol.ErrorValue("Empty command")
 	}
@@ -70,11 +214,85 @@ func HandleCommand(command protocol.ArrayValue) protocol.ORSPValue {
 		return protocol.ErrorValue("Invalid command format")
 	}
 
-	cmd := strings.ToUpper(string(cmdVal))
-	handler, exists := CommandMap[cmd]
+	cmd, exists := s.commands.Lookup(string(cmdVal))
 	if !exists {
-		return protocol.ErrorValue(fmt.Sprintf("Unknown command: %s", cmd))
+		return protocol.ErrorValue(fmt.Sprintf("ERR unknown command '%s'", cmdVal))
+	}
+	cmd = cmd.resolve(command)
+
+	if !cmd.CheckArity(len(command)) {
+		s.commandStats.reject(cmd.Name)
+		return protocol.ErrorValue(cmd.ArityError())
 	}
 
-	return handler(command[1:])
+	// Client commands wait out CLIENT PAUSE and are rate limited, embedded
+	// calls and the commands scripts run never are
+	if ctx.ID != 0 && !ctx.Script {
+		s.pause.wait(cmd)
+		if reply := s.throttle(ctx, cmd, command); reply != nil {
+			s.commandStats.reject(cmd.Name)
+			return reply
+		}
+	}
+
+	// Only the commands that can end a script running past lua-time-limit
+	// get through until it finishes
+	if !cmd.Is(FlagAllowBusy) && s.scripts.isBusy() {
+		s.commandStats.reject(cmd.Name)
+		return protocol.ErrorValue(errBusyScript)
+	}
+	unlock, ok := s.lockExec(cmd)
+	if !ok {
+		s.commandStats.reject(cmd.Name)
+		return protocol.ErrorValue(errBusyScript)
+	}
+	defer unlock()
+	if s.stopped.Load() {
+		s.commandStats.reject(cmd.Name)
+		return protocol.ErrorValue("ERR server is shutting down")
+	}
+
+	return s.execute(ctx, &Call{Command: cmd, Args: command})
+}
+
+// lockExec takes execMu the way cmd needs it. A command waiting for a script
+// gives up with ok false once the script runs past lua-time-limit, so its
+// client gets BUSY instead of hanging until the script ends.
+func (s *Server) lockExec(cmd *Command) (unlock func(), ok bool) {
+	var lock, release func()
+	var try func() bool
+	switch cmd.lock {
+	case lockShared:
+		lock, release, try = s.execMu.RLock, s.execMu.RUnlock, s.execMu.TryRLock
+	case lockExclusive:
+		lock, release, try = s.execMu.Lock, s.execMu.Unlock, s.execMu.TryLock
+	default:
+		return func() {}, true
+	}
+	if try() {
+		return release, true
+	}
+	if cmd.Is(FlagAllowBusy) {
+		lock()
+		return release, true
+	}
+
+	busy := s.scripts.busySignal()
+	acquired := make(chan struct{})
+	abandoned := make(chan struct{})
+	go func() {
+		lock()
+		select {
+		case acquired <- struct{}{}:
+		case <-abandoned:
+			release()
+		}
+	}()
+	select {
+	case <-acquired:
+		return release, true
+	case <-busy:
+		close(abandoned)
+		return nil, false
+	}
 }
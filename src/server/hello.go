@@ -0,0 +1,77 @@
+package server
+
+import (
+	"orion/src/commands"
+	"orion/src/protocol"
+	"strconv"
+	"strings"
+)
+
+// Version is the Orion server version reported by HELLO
+const Version = "0.1.0"
+
+// handleHello implements HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (s *Server) handleHello(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	proto := ctx.Protocol
+	if len(args) > 0 {
+		version, _ := args[0].(protocol.BulkStringValue)
+		v, err := strconv.Atoi(string(version))
+		if err != nil {
+			return protocol.ErrorValue("ERR Protocol version is not an integer or out of range")
+		}
+		if v != protocol.RESP2 && v != protocol.RESP3 {
+			return protocol.ErrorValue("NOPROTO unsupported protocol version")
+		}
+		proto = v
+	}
+
+	name, setName := ctx.Name, false
+	var username, password string
+	auth := false
+	for i := 1; i < len(args); i++ {
+		option, _ := args[i].(protocol.BulkStringValue)
+		switch {
+		case strings.EqualFold(string(option), "AUTH") && i+2 < len(args):
+			username, password, auth = bulkString(args[i+1]), bulkString(args[i+2]), true
+			i += 2
+		case strings.EqualFold(string(option), "SETNAME") && i+1 < len(args):
+			clientName, _ := args[i+1].(protocol.BulkStringValue)
+			if strings.ContainsAny(string(clientName), " \n") {
+				return protocol.ErrorValue("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			name, setName = string(clientName), true
+			i++
+		default:
+			return protocol.ErrorValue("ERR Syntax error in HELLO option '" + string(option) + "'")
+		}
+	}
+
+	if auth {
+		if reply := s.authenticate(ctx, username, password); reply != nil {
+			return reply
+		}
+	} else if !s.authenticated(ctx) {
+		return protocol.ErrorValue("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+
+	// Only switch once every option is known to be valid
+	ctx.Protocol = proto
+	if setName {
+		ctx.Name = name
+	}
+
+	modules := make(protocol.ArrayValue, 0, len(s.modules))
+	for _, m := range s.modules {
+		modules = append(modules, protocol.MapValue{"name": protocol.BulkStringValue(m.name)})
+	}
+
+	return protocol.MapValue{
+		"server":  protocol.BulkStringValue("orion"),
+		"version": protocol.BulkStringValue(Version),
+		"proto":   protocol.IntegerValue(proto),
+		"id":      protocol.IntegerValue(ctx.ID),
+		"mode":    protocol.BulkStringValue("standalone"),
+		"role":    protocol.BulkStringValue("master"),
+		"modules": modules,
+	}
+}
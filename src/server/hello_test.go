@@ -0,0 +1,76 @@
+package server
+
+import (
+	"io"
+	"orion/src/protocol"
+	"testing"
+	"time"
+)
+
+// readRaw returns the next n bytes the server sent, for checking the
+// encoding the decoder would hide
+func (c *testClient) readRaw(n int) string {
+	c.t.Helper()
+	if buffered := c.dec.Buffered(); buffered > 0 {
+		c.t.Fatalf("%d bytes already buffered by the decoder", buffered)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	defer c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, n)
+	if got, err := io.ReadFull(c.conn, buf); err != nil {
+		c.t.Fatalf("read %q: %v", buf[:got], err)
+	}
+	return string(buf)
+}
+
+func TestHelloNullEncoding(t *testing.T) {
+	_, addr := startServer(t, Config{})
+	c := dial(t, addr)
+
+	tests := []struct {
+		proto      string
+		null       string
+		nestedNull string
+	}{
+		{"", "$-1\r\n", "*3\r\n:1\r\n$-1\r\n$1\r\nx\r\n"},
+		{"3", "_\r\n", "*3\r\n:1\r\n_\r\n$1\r\nx\r\n"},
+		{"2", "$-1\r\n", "*3\r\n:1\r\n$-1\r\n$1\r\nx\r\n"},
+	}
+	for _, tt := range tests {
+		if tt.proto != "" {
+			reply := c.do("HELLO", tt.proto)
+			_, isMap := reply.(protocol.MapValue)
+			if isMap != (tt.proto == "3") {
+				t.Errorf("HELLO %s replied with a %T", tt.proto, reply)
+			}
+		}
+		c.send("GET", "missing")
+		if got := c.readRaw(len(tt.null)); got != tt.null {
+			t.Errorf("GET of a missing key over HELLO %q: got %q, want %q", tt.proto, got, tt.null)
+		}
+		c.send("EVAL", "return {1, false, 'x'}", "0")
+		if got := c.readRaw(len(tt.nestedNull)); got != tt.nestedNull {
+			t.Errorf("a null inside an array over HELLO %q: got %q, want %q", tt.proto, got, tt.nestedNull)
+		}
+	}
+}
+
+func TestHelloErrors(t *testing.T) {
+	_, addr := startServer(t, Config{})
+	c := dial(t, addr)
+
+	if reply := c.do("HELLO", "4"); !isError(reply, "NOPROTO ") {
+		t.Errorf("HELLO 4 got %#v", reply)
+	}
+	if reply := c.do("HELLO", "three"); !isError(reply, "ERR Protocol version is not an integer") {
+		t.Errorf("HELLO three got %#v", reply)
+	}
+	if reply := c.do("HELLO", "3", "SETNAME"); !isError(reply, "ERR Syntax error in HELLO option 'SETNAME'") {
+		t.Errorf("HELLO 3 SETNAME without a name got %#v", reply)
+	}
+	// A failed HELLO leaves the protocol alone
+	c.send("GET", "missing")
+	if got := c.readRaw(5); got != "$-1\r\n" {
+		t.Errorf("GET after failed HELLOs got %q, want RESP2", got)
+	}
+}
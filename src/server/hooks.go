@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Call is a parsed command travelling through the hook chain
+type Call struct {
+	Command *Command
+	// Args is the full command line including the command name. Before-hooks
+	// may rewrite it (e.g. to prefix keys) but must keep it valid for Command.
+	Args protocol.ArrayValue
+}
+
+// Hook intercepts every command dispatched by HandleCommand. Either function
+// may be nil.
+//
+// Before runs ahead of the handler in registration order; a non-nil error
+// stops the chain and is sent to the client as an error reply (errors should
+// start with a code such as "ERR" or "NOPERM"). After runs in reverse order
+// once the reply is known, including replies produced by a rejecting hook.
+type Hook struct {
+	Name   string
+	Before func(ctx *commands.Context, call *Call) error
+	After  func(ctx *commands.Context, call *Call, reply protocol.ORSPValue, latency time.Duration)
+}
+
+// hookChain is a copy-on-write list of hooks so dispatch never takes a lock
+type hookChain struct {
+	mu    sync.Mutex
+	hooks atomic.Pointer[[]Hook]
+}
+
+func (c *hookChain) load() []Hook {
+	if hooks := c.hooks.Load(); hooks != nil {
+		return *hooks
+	}
+	return nil
+}
+
+func (c *hookChain) add(h Hook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hooks := append(append([]Hook{}, c.load()...), h)
+	c.hooks.Store(&hooks)
+}
+
+func (c *hookChain) remove(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current := c.load()
+	hooks := make([]Hook, 0, len(current))
+	for _, h := range current {
+		if h.Name != name {
+			hooks = append(hooks, h)
+		}
+	}
+	c.hooks.Store(&hooks)
+	return len(hooks) != len(current)
+}
+
+// AddHook appends h to the server's hook chain
+func (s *Server) AddHook(h Hook) {
+	s.hooks.add(h)
+}
+
+// RemoveHook removes every hook registered under name and reports whether any was found
+func (s *Server) RemoveHook(name string) bool {
+	return s.hooks.remove(name)
+}
+
+// execute runs call through the hook chain and its handler
+func (s *Server) execute(ctx *commands.Context, call *Call) protocol.ORSPValue {
+	hooks := s.hooks.load()
+
+	var reply protocol.ORSPValue
+	for _, h := range hooks {
+		if h.Before == nil {
+			continue
+		}
+		if err := h.Before(ctx, call); err != nil {
+			reply = errorReply(err)
+			break
+		}
+	}
+
+	var latency time.Duration
+	if reply == nil {
+		s.feedMonitors(ctx, call)
+		start := time.Now()
+		reply = call.Command.Handler(ctx, call.Args[1:])
+		latency = time.Since(start)
+		if !s.loading.Load() {
+			_, failed := reply.(protocol.ErrorValue)
+			s.commandStats.record(call.Command.Name, latency, failed)
+		}
+		s.recordSlow(ctx, call, start, latency)
+		if !ctx.Script && !s.loading.Load() {
+			s.recordCommandLatency(call.Command, latency)
+		}
+	} else {
+		s.commandStats.reject(call.Command.Name)
+	}
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].After != nil {
+			hooks[i].After(ctx, call, reply, latency)
+		}
+	}
+
+	return reply
+}
+
+// errorReply turns a hook error into the reply sent to the client
+func errorReply(err error) protocol.ORSPValue {
+	var errValue protocol.ErrorValue
+	if errors.As(err, &errValue) {
+		return errValue
+	}
+	return protocol.ErrorValue(err.Error())
+}
+
+// flagsHook refuses the commands whose flags don't allow them in the
+// server's current state. It runs right after ACL, so clients that aren't
+// allowed a command learn that first.
+func (s *Server) flagsHook() Hook {
+	return Hook{
+		Name: "flags",
+		Before: func(ctx *commands.Context, call *Call) error {
+			cmd := call.Command
+			if ctx.Script && cmd.Flags&(FlagNoScript|FlagAdmin) != 0 {
+				return protocol.ErrorValue("ERR This Orion command is not allowed from script")
+			}
+			// Only clients are refused while loading or stale, the AOF
+			// replay and embedders calling Do aren't
+			if ctx.ID != 0 && !ctx.Script {
+				if s.loading.Load() && !cmd.Is(FlagLoading) {
+					return protocol.ErrorValue("LOADING Orion is loading the dataset in memory")
+				}
+				if s.stale.Load() && !s.serveStale.Load() && !cmd.Is(FlagStale) {
+					return protocol.ErrorValue("STALE The dataset failed to load and serve-stale-data is set to 'no'.")
+				}
+			}
+			if cmd.Is(FlagDenyOOM) && !s.loading.Load() && s.overMaxMemory() {
+				return protocol.ErrorValue("OOM command not allowed when used memory > 'maxmemory'.")
+			}
+			return nil
+		},
+	}
+}
+
+// aofHook feeds successful write commands to the AOF
+func (s *Server) aofHook() Hook {
+	return Hook{
+		Name: "aof",
+		After: func(ctx *commands.Context, call *Call, reply protocol.ORSPValue, _ time.Duration) {
+			command := call.Args
+			if propagated := ctx.TakePropagated(); propagated != nil {
+				command = propagated
+			}
+			if s.loading.Load() || !call.Command.Is(FlagWrite) {
+				return
+			}
+			if _, failed := reply.(protocol.ErrorValue); failed {
+				return
+			}
+			if err := ctx.AOF.AppendCommand(command); err != nil {
+				s.log.Error("Error appending to AOF", "err", err)
+			}
+		},
+	}
+}
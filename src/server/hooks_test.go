@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHookOrder(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	var trace []string
+	for _, name := range []string{"first", "second"} {
+		s.AddHook(Hook{
+			Name: name,
+			Before: func(ctx *commands.Context, call *Call) error {
+				trace = append(trace, "before "+name)
+				return nil
+			},
+			After: func(ctx *commands.Context, call *Call, reply protocol.ORSPValue, latency time.Duration) {
+				trace = append(trace, "after "+name)
+			},
+		})
+	}
+
+	if _, err := s.Do(context.Background(), "PING"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"before first", "before second", "after second", "after first"}
+	if !slices.Equal(trace, want) {
+		t.Errorf("hooks ran as %v, want %v", trace, want)
+	}
+
+	trace = nil
+	if !s.RemoveHook("first") {
+		t.Fatal("RemoveHook didn't find the first hook")
+	}
+	s.Do(context.Background(), "PING")
+	if want := []string{"before second", "after second"}; !slices.Equal(trace, want) {
+		t.Errorf("after RemoveHook hooks ran as %v, want %v", trace, want)
+	}
+	if s.RemoveHook("first") {
+		t.Error("RemoveHook found a hook already removed")
+	}
+}
+
+func TestHookShortCircuit(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+	var laterRan bool
+	var afterReply protocol.ORSPValue
+	s.AddHook(Hook{
+		Name: "quota",
+		Before: func(ctx *commands.Context, call *Call) error {
+			if string(call.Args[0].(protocol.BulkStringValue)) == "SET" {
+				return errors.New("ERR over quota")
+			}
+			return nil
+		},
+		After: func(ctx *commands.Context, call *Call, reply protocol.ORSPValue, latency time.Duration) {
+			afterReply = reply
+		},
+	})
+	s.AddHook(Hook{
+		Name: "later",
+		Before: func(ctx *commands.Context, call *Call) error {
+			laterRan = true
+			return nil
+		},
+	})
+
+	reply, _ := s.Do(ctx, "SET", "k", "v")
+	if reply != protocol.ErrorValue("ERR over quota") {
+		t.Errorf("rejected SET replied %#v", reply)
+	}
+	if laterRan {
+		t.Error("a hook after the rejecting one ran")
+	}
+	if afterReply != reply {
+		t.Errorf("after-hook saw %#v, want the rejection", afterReply)
+	}
+	if reply, _ := s.Do(ctx, "GET", "k"); reply != (protocol.NullValue{}) {
+		t.Errorf("the rejected SET ran, GET replied %#v", reply)
+	}
+}
+
+func TestHookRewritesArgs(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+	s.AddHook(Hook{
+		Name: "prefix",
+		Before: func(ctx *commands.Context, call *Call) error {
+			if len(call.Args) > 1 {
+				call.Args[1] = protocol.BulkStringValue("tenant:" + string(call.Args[1].(protocol.BulkStringValue)))
+			}
+			return nil
+		},
+	})
+	s.Do(ctx, "SET", "k", "v")
+	if value, ok := s.instance.Store.Get("tenant:k"); !ok || value != "v" {
+		t.Errorf("tenant:k holds %q, %v", value, ok)
+	}
+}
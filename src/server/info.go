@@ -0,0 +1,220 @@
+package server
+
+import (
+	"fmt"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// infoSection is one section of INFO, in the order INFO prints them
+type infoSection struct {
+	name  string
+	title string
+	// defaults marks the sections INFO prints without arguments
+	defaults bool
+	lines    func(s *Server) []string
+}
+
+var infoSections = []infoSection{
+	{"server", "Server", true, (*Server).infoServer},
+	{"clients", "Clients", true, (*Server).infoClients},
+	{"memory", "Memory", true, (*Server).infoMemory},
+	{"persistence", "Persistence", true, (*Server).infoPersistence},
+	{"stats", "Stats", true, (*Server).infoStats},
+	{"commandstats", "Commandstats", false, (*Server).infoCommandStats},
+	{"errorstats", "Errorstats", true, (*Server).infoErrorStats},
+	{"keyspace", "Keyspace", true, (*Server).infoKeyspace},
+}
+
+// handleInfo implements INFO [section ...]. Without arguments or with
+// default it prints the default sections, all and everything print every
+// section. Unknown sections are ignored. The reply is a single bulk string
+// of CRLF-terminated lines with a blank line between sections, the way
+// Redis clients parse it.
+func (s *Server) handleInfo(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	wanted := make(map[string]bool, len(args))
+	for _, arg := range args {
+		wanted[strings.ToLower(bulkString(arg))] = true
+	}
+	all := wanted["all"] || wanted["everything"]
+	defaults := len(args) == 0 || wanted["default"]
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] && !(defaults && section.defaults) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + section.title + "\r\n")
+		for _, line := range section.lines(s) {
+			sb.WriteString(line + "\r\n")
+		}
+	}
+	return protocol.BulkStringValue(sb.String())
+}
+
+func (s *Server) infoServer() []string {
+	cfg := s.Config()
+	uptime := s.instance.Store.GetUptimeSeconds()
+	executable, _ := os.Executable()
+	return []string{
+		"orion_version:" + Version,
+		"orion_mode:standalone",
+		"os:" + runtime.GOOS + " " + runtime.GOARCH,
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"run_id:" + s.runID,
+		fmt.Sprintf("tcp_port:%d", cfg.Port),
+		fmt.Sprintf("tls_port:%d", cfg.TLSPort),
+		fmt.Sprintf("uptime_in_seconds:%d", uptime),
+		fmt.Sprintf("uptime_in_days:%d", uptime/(60*60*24)),
+		fmt.Sprintf("hz:%d", cfg.Hz),
+		fmt.Sprintf("goroutines:%d", runtime.NumGoroutine()),
+		"executable:" + executable,
+		"config_file:" + cfg.ConfigFile,
+	}
+}
+
+func (s *Server) infoClients() []string {
+	return []string{
+		fmt.Sprintf("connected_clients:%d", s.clients.len()),
+		fmt.Sprintf("maxclients:%d", s.Config().MaxClients),
+		fmt.Sprintf("monitors:%d", s.monitors.count.Load()),
+		"paused_actions:" + s.pause.actions(),
+	}
+}
+
+func (s *Server) infoMemory() []string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return []string{
+		fmt.Sprintf("used_memory:%d", mem.HeapAlloc),
+		"used_memory_human:" + formatBytes(mem.HeapAlloc),
+		fmt.Sprintf("used_memory_heap_inuse:%d", mem.HeapInuse),
+		fmt.Sprintf("used_memory_sys:%d", mem.Sys),
+		"used_memory_sys_human:" + formatBytes(mem.Sys),
+		fmt.Sprintf("total_allocated_memory:%d", mem.TotalAlloc),
+		fmt.Sprintf("heap_objects:%d", mem.HeapObjects),
+		fmt.Sprintf("gc_cycles:%d", mem.NumGC),
+	}
+}
+
+func (s *Server) infoPersistence() []string {
+	lines := []string{fmt.Sprintf("loading:%d", boolInfo(s.loading.Load()))}
+
+	aofLog := s.instance.AOF
+	lines = append(lines, fmt.Sprintf("aof_enabled:%d", boolInfo(aofLog != nil)))
+	if aofLog != nil {
+		size, _ := aofLog.Size()
+		fsyncs, fsyncTime := aofLog.FsyncStats()
+		lines = append(lines,
+			fmt.Sprintf("aof_current_size:%d", size),
+			fmt.Sprintf("aof_fsyncs:%d", fsyncs),
+			fmt.Sprintf("aof_fsync_usec:%d", fsyncTime.Microseconds()),
+		)
+	}
+
+	inProgress, lastSave, lastOK := s.instance.SaveStatus()
+	status := "ok"
+	if !lastOK {
+		status = "err"
+	}
+	lastSaveTime := int64(-1)
+	if !lastSave.IsZero() {
+		lastSaveTime = lastSave.Unix()
+	}
+	return append(lines,
+		fmt.Sprintf("bgsave_in_progress:%d", boolInfo(inProgress)),
+		"last_bgsave_status:"+status,
+		fmt.Sprintf("last_bgsave_time:%d", lastSaveTime),
+	)
+}
+
+func (s *Server) infoStats() []string {
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", s.stats.connectionsReceived.Load()),
+		fmt.Sprintf("total_commands_processed:%d", s.stats.commandsProcessed.Load()),
+		fmt.Sprintf("total_error_replies:%d", s.stats.errorReplies.Load()),
+		fmt.Sprintf("rejected_connections:%d", s.stats.rejectedConnections.Load()),
+		fmt.Sprintf("timedout_connections:%d", s.stats.timedoutConnections.Load()),
+		fmt.Sprintf("expired_keys:%d", s.instance.Store.ExpiredKeys()),
+		fmt.Sprintf("ratelimit_delayed_commands:%d", s.stats.rateLimitDelayed.Load()),
+		fmt.Sprintf("ratelimit_rejected_commands:%d", s.stats.rateLimitRejected.Load()),
+		fmt.Sprintf("monitor_dropped_lines:%d", s.stats.monitorDropped.Load()),
+	}
+}
+
+func (s *Server) infoCommandStats() []string {
+	names, stats := s.commandStats.sorted()
+	lines := make([]string, len(names))
+	for i, name := range names {
+		st := stats[i]
+		calls, usec := st.calls.Load(), time.Duration(st.total.Load()).Microseconds()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		lines[i] = fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			name, calls, usec, perCall, st.rejected.Load(), st.failed.Load())
+	}
+	return lines
+}
+
+func (s *Server) infoErrorStats() []string {
+	prefixes, counts := s.stats.errorCounts()
+	lines := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		lines[i] = fmt.Sprintf("errorstat_%s:count=%d", prefix, counts[i])
+	}
+	return lines
+}
+
+// infoKeyspace prints the keys, the keys with a TTL and their average TTL in
+// milliseconds, then the keys of each type
+func (s *Server) infoKeyspace() []string {
+	byType := s.instance.Store.KeysByType()
+	expires, avgTTL := s.instance.Store.ExpireStats()
+
+	types := make([]string, 0, len(byType))
+	keys := 0
+	for t, n := range byType {
+		types = append(types, t)
+		keys += n
+	}
+	sort.Strings(types)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "db%d:keys=%d,expires=%d,avg_ttl=%d", dbIndex, keys, expires, avgTTL.Milliseconds())
+	for _, t := range types {
+		fmt.Fprintf(&sb, ",%s=%d", t, byType[t])
+	}
+	return []string{sb.String()}
+}
+
+func boolInfo(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// formatBytes prints n with a binary unit the way used_memory_human does
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
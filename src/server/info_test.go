@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"orion/src/protocol"
+)
+
+// infoField returns the value INFO section reports for field
+func infoField(t *testing.T, s *Server, section, field string) string {
+	t.Helper()
+	reply, err := s.Do(context.Background(), "INFO", section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(reply.(protocol.BulkStringValue)), "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	t.Fatalf("INFO %s lacks %s", section, field)
+	return ""
+}
+
+func TestInfoFormat(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	c := dial(t, addr)
+
+	// One bulk string, the way stock Redis clients parse it
+	c.send("INFO", "server", "clients")
+	if raw := c.readRaw(1); raw != "$" {
+		t.Fatalf("INFO replied with a %q type", raw)
+	}
+	c.dec = protocol.NewDecoder(io.MultiReader(strings.NewReader("$"), c.conn))
+	reply, _ := c.read().(protocol.BulkStringValue)
+	info := string(reply)
+	if !strings.HasPrefix(info, "# Server\r\norion_version:") {
+		t.Errorf("INFO starts with %q", info[:min(len(info), 40)])
+	}
+	if !strings.Contains(info, "\r\n\r\n# Clients\r\n") || !strings.HasSuffix(info, "\r\n") || strings.HasSuffix(info, "\r\n\r\n") {
+		t.Errorf("INFO sections aren't separated by one blank line:\n%q", info)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(info, "\r\n"), "\r\n") {
+		if line != "" && !strings.HasPrefix(line, "# ") && !strings.Contains(line, ":") {
+			t.Errorf("INFO line %q is neither a header nor a field", line)
+		}
+		if strings.Contains(line, "\n") {
+			t.Errorf("INFO line %q holds a bare newline", line)
+		}
+	}
+	// Sections are picked by name, default and everything
+	tests := []struct {
+		args    []string
+		headers []string
+	}{
+		{nil, []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Errorstats", "# Keyspace"}},
+		{[]string{"default"}, []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Errorstats", "# Keyspace"}},
+		{[]string{"everything"}, []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Commandstats", "# Errorstats", "# Keyspace"}},
+		{[]string{"KEYSPACE", "stats"}, []string{"# Stats", "# Keyspace"}},
+		{[]string{"nosuchsection"}, nil},
+	}
+	for _, tt := range tests {
+		reply, _ := s.Do(context.Background(), append([]string{"INFO"}, tt.args...)...)
+		var headers []string
+		for _, line := range strings.Split(string(reply.(protocol.BulkStringValue)), "\r\n") {
+			if strings.HasPrefix(line, "# ") {
+				headers = append(headers, line)
+			}
+		}
+		if strings.Join(headers, ",") != strings.Join(tt.headers, ",") {
+			t.Errorf("INFO %v printed %v, want %v", tt.args, headers, tt.headers)
+		}
+	}
+}
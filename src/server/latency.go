@@ -0,0 +1,285 @@
+package server
+
+import (
+	"fmt"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyHistoryLen is how many samples LATENCY HISTORY keeps per event
+const latencyHistoryLen = 160
+
+// latencySample is the worst latency of an event within one second
+type latencySample struct {
+	time    time.Time
+	latency time.Duration
+}
+
+// latencyEvent is the sampled history of one internal event
+type latencyEvent struct {
+	history []latencySample // oldest first
+	max     time.Duration
+}
+
+// latencyMonitor samples internal events taking at least
+// latency-monitor-threshold: command execution, AOF fsyncs and rewrites,
+// snapshots, expiry cycles and waits for the store lock
+type latencyMonitor struct {
+	threshold atomic.Int64 // latency-monitor-threshold, zero disables the monitor
+
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+// latencyAdvice is what LATENCY DOCTOR says about each event
+var latencyAdvice = map[string]string{
+	"command":      "Slow commands are blocking other clients. Check SLOWLOG GET and INFO commandstats for commands working on large values, such as SMEMBERS on huge sets, and use smaller values or cheaper commands.",
+	"fast-command": "Commands that should run in constant time are slow, which usually means the process is starved of CPU. Check for other processes on the host and for swapping.",
+	"aof-fsync":    "Every write is fsynced to the AOF and the disk is slow to do it. Put the AOF on a faster disk, make sure no other process saturates it, or disable appendonly if losing the last writes on a crash is acceptable.",
+	"aof-rewrite":  "AOF rewrites take long because the dataset is large. Run BGREWRITEAOF when traffic is low.",
+	"snapshot":     "Snapshots take long because the dataset is large or the disk is slow. Run BGSAVE when traffic is low and check the disk throughput.",
+	"expire-cycle": "Many keys with a TTL are scanned or expire at once. Spread out the expiry times of keys created together, and keep hz low since every cycle scans all keys with a TTL.",
+	"store-lock":   "Commands wait for the store lock held by other commands. Look for slow commands in SLOWLOG and for BGSAVE or BGREWRITEAOF running during peak traffic.",
+}
+
+func (s *Server) setLatencyMonitor(cfg *Config) error {
+	s.latency.threshold.Store(int64(cfg.LatencyMonitorThreshold))
+	// Timing every store lock isn't free, only do it while monitoring
+	if cfg.LatencyMonitorThreshold > 0 {
+		s.instance.Store.SetLatencyHook(s.latency.record)
+		s.instance.AOF.SetLatencyHook(s.latency.record)
+	} else {
+		s.instance.Store.SetLatencyHook(nil)
+		s.instance.AOF.SetLatencyHook(nil)
+	}
+	return nil
+}
+
+// record samples event if it took at least the threshold. Samples within
+// the same second are merged, keeping the worst.
+func (m *latencyMonitor) record(event string, latency time.Duration) {
+	threshold := time.Duration(m.threshold.Load())
+	if threshold <= 0 || latency < threshold {
+		return
+	}
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.events == nil {
+		m.events = make(map[string]*latencyEvent)
+	}
+	e := m.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	e.max = max(e.max, latency)
+	if n := len(e.history); n > 0 && e.history[n-1].time.Unix() == now.Unix() {
+		e.history[n-1].latency = max(e.history[n-1].latency, latency)
+		return
+	}
+	e.history = append(e.history, latencySample{time: now, latency: latency})
+	if len(e.history) > latencyHistoryLen {
+		e.history = e.history[1:]
+	}
+}
+
+// sorted returns the sampled event names in order with copies of their
+// history
+func (m *latencyMonitor) sorted() ([]string, []latencyEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.events))
+	for name := range m.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	events := make([]latencyEvent, len(names))
+	for i, name := range names {
+		e := m.events[name]
+		events[i] = latencyEvent{history: append([]latencySample{}, e.history...), max: e.max}
+	}
+	return names, events
+}
+
+// reset clears the given events, or every event when none is given, and
+// returns how many were cleared
+func (m *latencyMonitor) reset(names []string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(names) == 0 {
+		n := len(m.events)
+		m.events = nil
+		return n
+	}
+	cleared := 0
+	for _, name := range names {
+		if _, ok := m.events[name]; ok {
+			delete(m.events, name)
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// recordCommandLatency samples a command that ran, fast commands apart from
+// the others
+func (s *Server) recordCommandLatency(cmd *Command, latency time.Duration) {
+	if cmd.Is(FlagFast) {
+		s.latency.record("fast-command", latency)
+	} else {
+		s.latency.record("command", latency)
+	}
+}
+
+// handleLatency implements LATENCY LATEST, HISTORY, RESET, HISTOGRAM and
+// DOCTOR
+func (s *Server) handleLatency(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sub, _ := args[0].(protocol.BulkStringValue)
+	name := strings.ToUpper(string(sub))
+	args = args[1:]
+	wrongArgs := protocol.ErrorValue("ERR wrong number of arguments for 'latency|" + strings.ToLower(name) + "' command")
+
+	switch name {
+	case "LATEST":
+		if len(args) != 0 {
+			return wrongArgs
+		}
+		names, events := s.latency.sorted()
+		reply := make(protocol.ArrayValue, 0, len(names))
+		for i, event := range names {
+			history := events[i].history
+			if len(history) == 0 {
+				continue
+			}
+			latest := history[len(history)-1]
+			reply = append(reply, protocol.ArrayValue{
+				protocol.BulkStringValue(event),
+				protocol.IntegerValue(latest.time.Unix()),
+				protocol.IntegerValue(latest.latency.Milliseconds()),
+				protocol.IntegerValue(events[i].max.Milliseconds()),
+			})
+		}
+		return reply
+
+	case "HISTORY":
+		if len(args) != 1 {
+			return wrongArgs
+		}
+		event := bulkString(args[0])
+		names, events := s.latency.sorted()
+		reply := protocol.ArrayValue{}
+		for i, n := range names {
+			if n != event {
+				continue
+			}
+			for _, sample := range events[i].history {
+				reply = append(reply, protocol.ArrayValue{
+					protocol.IntegerValue(sample.time.Unix()),
+					protocol.IntegerValue(sample.latency.Milliseconds()),
+				})
+			}
+		}
+		return reply
+
+	case "RESET":
+		names := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = bulkString(arg)
+		}
+		return protocol.IntegerValue(s.latency.reset(names))
+
+	case "HISTOGRAM":
+		return s.latencyHistogram(args)
+
+	case "DOCTOR":
+		if len(args) != 0 {
+			return wrongArgs
+		}
+		return protocol.BulkStringValue(s.latencyDoctor())
+	}
+
+	return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try LATENCY LATEST, HISTORY, RESET, HISTOGRAM or DOCTOR")
+}
+
+// latencyHistogram replies with the latency histogram of the given
+// commands, or of every command called so far. Bucket bounds are in
+// microseconds and counts are cumulative.
+func (s *Server) latencyHistogram(args []protocol.ORSPValue) protocol.ORSPValue {
+	wanted := make(map[string]bool, len(args))
+	for _, arg := range args {
+		wanted[strings.ToLower(bulkString(arg))] = true
+	}
+	names, stats := s.commandStats.sorted()
+	reply := protocol.MapValue{}
+	for i, name := range names {
+		if len(wanted) > 0 && !wanted[name] {
+			continue
+		}
+		st := stats[i]
+		if st.calls.Load() == 0 {
+			continue
+		}
+		histogram := protocol.MapValue{}
+		var cumulative int64
+		for b, bound := range latencyBuckets {
+			cumulative += st.buckets[b].Load()
+			histogram[strconv.FormatInt(bound.Microseconds(), 10)] = protocol.IntegerValue(cumulative)
+		}
+		reply[name] = protocol.MapValue{
+			"calls":          protocol.IntegerValue(st.calls.Load()),
+			"histogram_usec": histogram,
+		}
+	}
+	return reply
+}
+
+// latencyDoctor writes a report of the sampled events with advice for each
+func (s *Server) latencyDoctor() string {
+	var sb strings.Builder
+	threshold := time.Duration(s.latency.threshold.Load())
+	if threshold <= 0 {
+		sb.WriteString("The latency monitor is disabled. Enable it with CONFIG SET latency-monitor-threshold <milliseconds>, for example 100, then run LATENCY DOCTOR again once the latency issue has happened.\n")
+		return sb.String()
+	}
+
+	names, events := s.latency.sorted()
+	if len(names) == 0 {
+		fmt.Fprintf(&sb, "No event took %dms or more since the monitor was enabled or reset. The server looks healthy, or the threshold is too high for the latency you are seeing.\n", threshold.Milliseconds())
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "Latency monitor report, threshold %dms.\n\n", threshold.Milliseconds())
+	for i, name := range names {
+		history := events[i].history
+		var total time.Duration
+		for _, sample := range history {
+			total += sample.latency
+		}
+		avg := total / time.Duration(len(history))
+		var deviation time.Duration
+		for _, sample := range history {
+			deviation += (sample.latency - avg).Abs()
+		}
+		deviation /= time.Duration(len(history))
+
+		fmt.Fprintf(&sb, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, max %dms).",
+			i+1, name, len(history), avg.Milliseconds(), deviation.Milliseconds(), events[i].max.Milliseconds())
+		if len(history) > 1 {
+			span := history[len(history)-1].time.Sub(history[0].time)
+			fmt.Fprintf(&sb, " Spikes come every %d seconds on average.", int64(span.Seconds())/int64(len(history)-1))
+		}
+		sb.WriteString("\n")
+		if advice, ok := latencyAdvice[name]; ok {
+			sb.WriteString("   " + advice + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"orion/src/protocol"
+)
+
+func TestLatencyMonitorRecord(t *testing.T) {
+	var m latencyMonitor
+	m.record("command", time.Second)
+	if len(m.events) != 0 {
+		t.Fatal("the disabled monitor sampled an event")
+	}
+
+	m.threshold.Store(int64(100 * time.Millisecond))
+	m.record("command", 50*time.Millisecond)
+	if len(m.events) != 0 {
+		t.Fatal("an event under the threshold was sampled")
+	}
+	// Samples within one second merge, keeping the worst
+	m.record("command", 300*time.Millisecond)
+	m.record("command", 200*time.Millisecond)
+	m.record("command", 100*time.Millisecond)
+	e := m.events["command"]
+	if len(e.history) != 1 || e.history[0].latency != 300*time.Millisecond || e.max != 300*time.Millisecond {
+		t.Errorf("three spikes in one second sampled as %+v", e)
+	}
+
+	// The history keeps the newest latencyHistoryLen samples
+	full := &latencyEvent{}
+	for i := 0; i < latencyHistoryLen; i++ {
+		full.history = append(full.history, latencySample{time: time.Unix(int64(i), 0), latency: time.Second})
+	}
+	m.events["snapshot"] = full
+	m.record("snapshot", 2*time.Second)
+	if n := len(full.history); n != latencyHistoryLen || full.history[0].time.Unix() != 1 || full.history[n-1].latency != 2*time.Second {
+		t.Errorf("the full history holds %d samples from %v to %v", n, full.history[0].time.Unix(), full.history[n-1].latency)
+	}
+
+	if n := m.reset([]string{"snapshot", "nosuchevent"}); n != 1 {
+		t.Errorf("resetting one known event cleared %d", n)
+	}
+	if n := m.reset(nil); n != 1 || len(m.events) != 0 {
+		t.Errorf("resetting every event cleared %d, %d left", n, len(m.events))
+	}
+}
+
+func TestLatencyCommand(t *testing.T) {
+	// A high threshold keeps the test's own commands out of the samples
+	s, addr := startServer(t, Config{LatencyMonitorThreshold: time.Second})
+	ctx := context.Background()
+	c := dial(t, addr)
+
+	if reply := c.do("LATENCY", "LATEST"); len(reply.(protocol.ArrayValue)) != 0 {
+		t.Errorf("LATENCY LATEST without spikes got %#v", reply)
+	}
+	if reply := c.do("LATENCY", "DOCTOR"); !strings.HasPrefix(string(reply.(protocol.BulkStringValue)), "No event took 1000ms or more") {
+		t.Errorf("LATENCY DOCTOR without spikes got %q", reply)
+	}
+
+	s.latency.record("aof-fsync", 1500*time.Millisecond)
+	s.latency.record("aof-fsync", 2*time.Second)
+	s.latency.record("store-lock", 1200*time.Millisecond)
+
+	latest, _ := c.do("LATENCY", "LATEST").(protocol.ArrayValue)
+	if len(latest) != 2 {
+		t.Fatalf("LATENCY LATEST got %#v", latest)
+	}
+	fsync := latest[0].(protocol.ArrayValue)
+	if fsync[0] != protocol.BulkStringValue("aof-fsync") || fsync[2] != protocol.IntegerValue(2000) || fsync[3] != protocol.IntegerValue(2000) {
+		t.Errorf("LATENCY LATEST reports %#v for aof-fsync", fsync)
+	}
+	if age := time.Now().Unix() - int64(fsync[1].(protocol.IntegerValue)); age < 0 || age > 5 {
+		t.Errorf("the aof-fsync spike is stamped %ds ago", age)
+	}
+	if latest[1].(protocol.ArrayValue)[0] != protocol.BulkStringValue("store-lock") {
+		t.Errorf("LATENCY LATEST isn't sorted by event: %#v", latest)
+	}
+
+	history, _ := c.do("LATENCY", "HISTORY", "aof-fsync").(protocol.ArrayValue)
+	if len(history) != 1 || history[0].(protocol.ArrayValue)[1] != protocol.IntegerValue(2000) {
+		t.Errorf("LATENCY HISTORY aof-fsync got %#v", history)
+	}
+	if reply := c.do("LATENCY", "HISTORY", "nosuchevent"); len(reply.(protocol.ArrayValue)) != 0 {
+		t.Errorf("LATENCY HISTORY of an unknown event got %#v", reply)
+	}
+
+	doctor := string(c.do("LATENCY", "DOCTOR").(protocol.BulkStringValue))
+	for _, want := range []string{
+		"threshold 1000ms",
+		"1. aof-fsync: 1 latency spikes (average 2000ms, mean deviation 0ms, max 2000ms).",
+		latencyAdvice["aof-fsync"],
+		"2. store-lock:",
+		latencyAdvice["store-lock"],
+	} {
+		if !strings.Contains(doctor, want) {
+			t.Errorf("LATENCY DOCTOR lacks %q:\n%s", want, doctor)
+		}
+	}
+
+	if reply := c.do("LATENCY", "RESET", "aof-fsync"); reply != protocol.IntegerValue(1) {
+		t.Errorf("LATENCY RESET aof-fsync got %#v", reply)
+	}
+	if reply := c.do("LATENCY", "RESET"); reply != protocol.IntegerValue(1) {
+		t.Errorf("LATENCY RESET got %#v", reply)
+	}
+	if reply := c.do("LATENCY", "LATEST"); len(reply.(protocol.ArrayValue)) != 0 {
+		t.Errorf("LATENCY LATEST after RESET got %#v", reply)
+	}
+
+	for _, args := range [][]string{
+		{"LATENCY", "LATEST", "extra"},
+		{"LATENCY", "HISTORY"},
+		{"LATENCY", "DOCTOR", "extra"},
+	} {
+		if reply := c.do(args...); !isError(reply, "ERR wrong number of arguments") {
+			t.Errorf("%v got %#v", args, reply)
+		}
+	}
+	if reply := c.do("LATENCY", "NOSUCH"); !isError(reply, "ERR unknown subcommand 'NOSUCH'") {
+		t.Errorf("LATENCY NOSUCH got %#v", reply)
+	}
+
+	// Zero disables the monitor
+	if reply := c.do("CONFIG", "SET", "latency-monitor-threshold", "0"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CONFIG SET latency-monitor-threshold 0 got %#v", reply)
+	}
+	s.latency.record("aof-fsync", time.Hour)
+	if reply := c.do("LATENCY", "LATEST"); len(reply.(protocol.ArrayValue)) != 0 {
+		t.Errorf("the disabled monitor sampled %#v", reply)
+	}
+	if reply := c.do("LATENCY", "DOCTOR"); !strings.HasPrefix(string(reply.(protocol.BulkStringValue)), "The latency monitor is disabled.") {
+		t.Errorf("LATENCY DOCTOR with the monitor disabled got %q", reply)
+	}
+	if reply := c.do("CONFIG", "SET", "latency-monitor-threshold", "-1"); !isError(reply, "ERR ") {
+		t.Errorf("CONFIG SET latency-monitor-threshold -1 got %#v", reply)
+	}
+	if got := configGet(t, s, "latency-monitor-threshold"); got != "0" {
+		t.Errorf("latency-monitor-threshold reads back as %s", got)
+	}
+
+	// HISTOGRAM counts calls per command into cumulative buckets
+	for i := 0; i < 3; i++ {
+		c.do("SET", "k", "v")
+	}
+	reply, _ := s.Do(ctx, "LATENCY", "HISTOGRAM", "SET", "nosuchcommand")
+	histograms, _ := reply.(protocol.MapValue)
+	set, _ := histograms["set"].(protocol.MapValue)
+	if len(histograms) != 1 || set["calls"] != protocol.IntegerValue(3) {
+		t.Fatalf("LATENCY HISTOGRAM SET got %#v", reply)
+	}
+	buckets := set["histogram_usec"].(protocol.MapValue)
+	if len(buckets) != len(latencyBuckets) {
+		t.Errorf("the histogram has %d buckets, want %d", len(buckets), len(latencyBuckets))
+	}
+	last := buckets[strconv.FormatInt(latencyBuckets[len(latencyBuckets)-1].Microseconds(), 10)]
+	if last != protocol.IntegerValue(3) {
+		t.Errorf("the last bucket counts %#v of 3 calls", last)
+	}
+	reply, _ = s.Do(ctx, "LATENCY", "HISTOGRAM")
+	if all, _ := reply.(protocol.MapValue); all["set"] == nil || all["config"] == nil || all["get"] != nil {
+		t.Errorf("LATENCY HISTOGRAM lists %#v", reply)
+	}
+}
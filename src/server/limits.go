@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"runtime/metrics"
+	"time"
+)
+
+// connTimeouts holds the timeout and client-query-timeout settings, read by
+// every connection before each network read
+type connTimeouts struct {
+	idle    time.Duration // closes connections with no request for this long
+	request time.Duration // closes connections that take this long to send one request
+}
+
+func (s *Server) setTimeouts(cfg *Config) error {
+	s.timeouts.Store(&connTimeouts{idle: cfg.Timeout, request: cfg.ClientQueryTimeout})
+	return nil
+}
+
+func (s *Server) setMaxMemory(cfg *Config) error {
+	s.maxMemory.Store(int64(cfg.MaxMemory))
+	return nil
+}
+
+func (s *Server) setServeStale(cfg *Config) error {
+	s.serveStale.Store(!cfg.RefuseStaleData)
+	return nil
+}
+
+// heapObjectsMetric is the heap in use, the used_memory of INFO. Unlike
+// runtime.ReadMemStats reading it doesn't stop the world.
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// overMaxMemory reports whether the heap has grown past maxmemory
+func (s *Server) overMaxMemory() bool {
+	limit := s.maxMemory.Load()
+	if limit <= 0 {
+		return false
+	}
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	return sample[0].Value.Kind() == metrics.KindUint64 && sample[0].Value.Uint64() > uint64(limit)
+}
+
+// deadlineReader sets a read deadline on conn before each read: the idle
+// timeout while waiting for a request, then the query timeout from its first
+// byte on, so clients that trickle a request in can't hold the connection
+type deadlineReader struct {
+	conn     net.Conn
+	timeouts func() *connTimeouts
+	closing  func() bool
+	started  time.Time // when the request being read began, zero between requests
+	idle     bool      // whether the last deadline set was the idle one
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	timeouts := r.timeouts()
+	var deadline time.Time
+	r.idle = r.started.IsZero()
+	if r.idle && timeouts.idle > 0 {
+		deadline = time.Now().Add(timeouts.idle)
+	} else if !r.idle && timeouts.request > 0 {
+		deadline = r.started.Add(timeouts.request)
+	}
+	r.conn.SetReadDeadline(deadline)
+	// Shutdown wakes readers up with a deadline in the past, don't lose it
+	if r.closing() {
+		r.conn.SetReadDeadline(time.Now())
+	}
+
+	n, err := r.conn.Read(p)
+	if n > 0 && r.started.IsZero() {
+		r.started = time.Now()
+	}
+	return n, err
+}
+
+// next is called after each command, pending tells whether the next request
+// has already started arriving
+func (r *deadlineReader) next(pending bool) {
+	if pending {
+		r.started = time.Now()
+	} else {
+		r.started = time.Time{}
+	}
+}
+
+// isTimeout reports whether err is a read deadline expiring
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// setKeepAlive applies tcp-keepalive to a TCP connection, zero disables the
+// probes
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if period <= 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(period)
+}
+
+// connCount is the number of connections being served
+func (s *Server) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// rejectClient tells a client over maxclients why it is turned away
+func rejectClient(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("-ERR max number of clients reached\r\n"))
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"orion/src/protocol"
+)
+
+// closedWithin reports whether the server closes c's connection within d
+func closedWithin(c *testClient, d time.Duration) bool {
+	c.conn.SetReadDeadline(time.Now().Add(d))
+	_, err := c.dec.Decode()
+	return err != nil && !isTimeout(err)
+}
+
+func TestMaxClients(t *testing.T) {
+	s, addr := startServer(t, Config{MaxClients: 2})
+	first, second := dial(t, addr), dial(t, addr)
+	first.do("PING")
+	second.do("PING")
+
+	third := dial(t, addr)
+	if reply := third.read(); !isError(reply, "ERR max number of clients reached") {
+		t.Fatalf("the connection past maxclients got %#v", reply)
+	}
+	if !closedWithin(third, 5*time.Second) {
+		t.Error("the connection past maxclients stayed open")
+	}
+	if n := s.stats.rejectedConnections.Load(); n != 1 {
+		t.Errorf("%d rejected connections, want 1", n)
+	}
+
+	// Closing a connection makes room for another
+	first.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.connCount() > 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if reply := dial(t, addr).do("PING"); reply != protocol.SimpleStringValue("PONG") {
+		t.Errorf("PING after a client left got %#v", reply)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	_, addr := startServer(t, Config{Timeout: 200 * time.Millisecond})
+	idle, busy := dial(t, addr), dial(t, addr)
+
+	// A client sending commands more often than the timeout stays connected
+	for i := 0; i < 6; i++ {
+		if reply := busy.do("PING"); reply != protocol.SimpleStringValue("PONG") {
+			t.Fatalf("PING got %#v", reply)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !closedWithin(idle, 5*time.Second) {
+		t.Error("the idle connection stayed open past timeout")
+	}
+	if reply := busy.do("PING"); reply != protocol.SimpleStringValue("PONG") {
+		t.Errorf("PING on the busy connection got %#v", reply)
+	}
+}
+
+func TestClientQueryTimeout(t *testing.T) {
+	s, addr := startServer(t, Config{ClientQueryTimeout: 300 * time.Millisecond})
+	c := dial(t, addr)
+
+	// Idle between requests is fine, only a request in progress is timed
+	time.Sleep(400 * time.Millisecond)
+	if reply := c.do("PING"); reply != protocol.SimpleStringValue("PONG") {
+		t.Fatalf("PING after idling got %#v", reply)
+	}
+
+	// Trickling a request in byte by byte doesn't keep extending it
+	start := time.Now()
+	request := []byte("*2\r\n$3\r\nGET\r\n$100\r\n")
+	for _, b := range request {
+		if _, err := c.conn.Write([]byte{b}); err != nil {
+			break
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	if !closedWithin(c, 5*time.Second) {
+		t.Fatal("the trickled request kept the connection open")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the connection closed after %v, want about 300ms", elapsed)
+	}
+
+	// CONFIG SET applies to connections already open
+	other := dial(t, addr)
+	if reply := other.do("CONFIG", "SET", "client-query-timeout", "0"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CONFIG SET client-query-timeout got %#v", reply)
+	}
+	if _, err := other.conn.Write([]byte("*1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if _, err := other.conn.Write([]byte("$4\r\nPING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := other.read(); reply != protocol.SimpleStringValue("PONG") {
+		t.Errorf("the slow request after disabling the timeout got %#v", reply)
+	}
+	if got := configGet(t, s, "client-query-timeout"); got != "0" {
+		t.Errorf("CONFIG GET client-query-timeout is %s", got)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listen opens the TCP listeners for every bind address, the TLS listeners
+// for every bind address on the TLS port and the Unix socket configured in
+// cfg. Bind addresses prefixed with '-' are skipped when they are not
+// available, '*' stands for every IPv4 interface and '::*' for every IPv6 one.
+// A zero port disables plain TCP.
+func listen(cfg Config, tlsConfig *tls.Config, logger *slog.Logger) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+
+	if cfg.Port != 0 {
+		tcp, err := listenTCP(cfg.Bind, cfg.Port, logger)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, tcp...)
+	}
+
+	if cfg.TLSPort != 0 {
+		tcp, err := listenTCP(cfg.Bind, cfg.TLSPort, logger)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		for _, listener := range tcp {
+			listeners = append(listeners, tls.NewListener(listener, tlsConfig))
+		}
+	}
+
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("no listener configured, set port, tls-port or unixsocket")
+	}
+	return listeners, nil
+}
+
+// listenTCP listens on port at every bind address
+func listenTCP(binds []string, port int, logger *slog.Logger) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, bind := range binds {
+		network, address, optional := bindAddress(bind, port)
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			if optional {
+				logger.Warn("Skipping optional bind address", "addr", address, "err", err)
+				continue
+			}
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// bindAddress turns a bind entry into the network and address to listen on.
+// IPv4 and IPv6 addresses get their own network so '*' and '::*' can share
+// a port.
+func bindAddress(bind string, port int) (network, address string, optional bool) {
+	host, optional := strings.CutPrefix(bind, "-")
+	network = "tcp"
+	switch host {
+	case "*":
+		network, host = "tcp4", "0.0.0.0"
+	case "::*":
+		network, host = "tcp6", "::"
+	default:
+		if ip := net.ParseIP(host); ip != nil {
+			network = "tcp6"
+			if ip.To4() != nil {
+				network = "tcp4"
+			}
+		}
+	}
+	return network, net.JoinHostPort(host, strconv.Itoa(port)), optional
+}
+
+// listenUnix listens on the socket at path, replacing a stale socket left by
+// a previous run, and applies perm when it is not zero
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("error setting unixsocketperm: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+// connFamily reports the address family of conn and the client address to
+// show for it. Unix socket clients have no address of their own, so they are
+// shown as the socket path with port 0 the way Redis does.
+func connFamily(conn net.Conn) (family, addr string) {
+	switch remote := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		if remote.IP.To4() != nil {
+			return "ipv4", remote.String()
+		}
+		return "ipv6", remote.String()
+	case *net.UnixAddr:
+		return "unix", conn.LocalAddr().String() + ":0"
+	}
+	return "", conn.RemoteAddr().String()
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"orion/src/protocol"
+)
+
+func TestBindAddress(t *testing.T) {
+	tests := []struct {
+		bind     string
+		network  string
+		address  string
+		optional bool
+	}{
+		{"*", "tcp4", "0.0.0.0:6379", false},
+		{"::*", "tcp6", "[::]:6379", false},
+		{"-::*", "tcp6", "[::]:6379", true},
+		{"127.0.0.1", "tcp4", "127.0.0.1:6379", false},
+		{"-::1", "tcp6", "[::1]:6379", true},
+		{"localhost", "tcp", "localhost:6379", false},
+	}
+	for _, tt := range tests {
+		network, address, optional := bindAddress(tt.bind, 6379)
+		if network != tt.network || address != tt.address || optional != tt.optional {
+			t.Errorf("bindAddress(%q) = %s %s %v, want %s %s %v", tt.bind, network, address, optional, tt.network, tt.address, tt.optional)
+		}
+	}
+}
+
+// freePort returns a TCP port nothing listens on right now
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// socketPath returns a Unix socket path short enough for sun_path
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "orion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "orion.sock")
+}
+
+func TestListenersShareHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&syncBuffer{}, nil))
+	path := socketPath(t)
+	cfg := Config{
+		Port:           freePort(t),
+		Bind:           []string{"127.0.0.1", "-::1", "-192.0.2.1"},
+		UnixSocket:     path,
+		UnixSocketPerm: 0700,
+	}
+	listeners, err := listen(cfg, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := startServer(t, Config{})
+	for _, listener := range listeners {
+		go s.Serve(listener)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("socket mode %v, %v, want 0700", info.Mode().Perm(), err)
+	}
+
+	// ::1 is optional, hosts without IPv6 only get the other two
+	addrs := make(map[string]net.Addr)
+	for _, listener := range listeners {
+		switch addr := listener.Addr().(type) {
+		case *net.TCPAddr:
+			if addr.IP.To4() != nil {
+				addrs["ipv4"] = addr
+			} else {
+				addrs["ipv6"] = addr
+			}
+		case *net.UnixAddr:
+			addrs["unix"] = addr
+		}
+	}
+	if addrs["ipv4"] == nil || addrs["unix"] == nil {
+		t.Fatalf("listening on %v", addrs)
+	}
+
+	for family, addr := range addrs {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatalf("dialing the %s listener at %s: %v", family, addr, err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		c := &testClient{t: t, conn: conn, dec: protocol.NewDecoder(conn)}
+		c.do("SET", family, "v")
+		info, _ := c.do("CLIENT", "INFO").(protocol.BulkStringValue)
+		if !strings.Contains(string(info), " family="+family+" ") {
+			t.Errorf("CLIENT INFO over %s: %s", family, info)
+		}
+		conn.Close()
+	}
+
+	// Every listener feeds the same dataset
+	c := dial(t, listeners[0].Addr().String())
+	for family := range addrs {
+		if reply := c.do("GET", family); reply != protocol.BulkStringValue("v") {
+			t.Errorf("GET %s got %#v", family, reply)
+		}
+	}
+}
+
+func TestListenErrors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&syncBuffer{}, nil))
+	if _, err := listen(Config{Bind: []string{"127.0.0.1"}}, nil, logger); err == nil {
+		t.Error("listen without port, tls-port or unixsocket succeeded")
+	}
+	if _, err := listen(Config{Port: freePort(t), Bind: []string{"127.0.0.1", "192.0.2.1"}}, nil, logger); err == nil {
+		t.Error("listen on an address that isn't available succeeded")
+	}
+
+	// An earlier listener on the port makes the bind fail
+	port := freePort(t)
+	taken, err := net.Listen("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	if _, err := listen(Config{Port: port, Bind: []string{"127.0.0.1"}}, nil, logger); err == nil {
+		t.Error("listen on a port in use succeeded")
+	}
+}
+
+func TestListenUnixReplacesStaleSocket(t *testing.T) {
+	path := socketPath(t)
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenUnix(path, 0)
+	if err != nil {
+		t.Fatalf("listening over a stale socket: %v", err)
+	}
+	listener.Close()
+
+	// Anything else at the path is left alone
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(path, 0); err == nil {
+		t.Error("listenUnix replaced a regular file")
+	}
+}
@@ -1,172 +1,84 @@
 // logging @exprays
 // Copyright (c) 2023, exprays <
-// In future more logging levels will be added
 // License: MIT
 
 package server
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"orion/src/logging"
 	"os"
-	"path/filepath"
-	"sync"
-	"time"
 )
 
 var (
-	// Logger instances
-	InfoLogger    *log.Logger
-	ErrorLogger   *log.Logger
-	CommandLogger *log.Logger
-
-	// File handles to close
-	logFiles []*os.File
-
-	// Mutex for thread-safe logging
-	logMutex sync.Mutex
-)
-
-const (
-	LogDir         = "logs"
-	InfoLogFile    = "info.log"
-	ErrorLogFile   = "error.log"
-	CommandLogFile = "commands.log"
+	// serverLog carries the messages logged before a Server exists, each
+	// Server logs through its own loggers
+	serverLog = logging.For("server")
 )
 
-// InitLogging sets up the logging system
-func InitLogging() error {
-	logMutex.Lock()
-	defer logMutex.Unlock()
-
-	// Create logs directory if it doesn't exist
-	if err := os.MkdirAll(LogDir, 0755); err != nil {
-		return fmt.Errorf("failed to create log directory: %v", err)
-	}
-
-	// Set up info logger
-	infoFile, err := os.OpenFile(filepath.Join(LogDir, InfoLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		return fmt.Errorf("failed to open info log file: %v", err)
-	}
-	logFiles = append(logFiles, infoFile)
-	InfoLogger = log.New(infoFile, "INFO: ", log.Ldate|log.Ltime)
-
-	// Set up error logger
-	errorFile, err := os.OpenFile(filepath.Join(LogDir, ErrorLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		return fmt.Errorf("failed to open error log file: %v", err)
-	}
-	logFiles = append(logFiles, errorFile)
-	ErrorLogger = log.New(errorFile, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-
-	// Set up command logger
-	cmdFile, err := os.OpenFile(filepath.Join(LogDir, CommandLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		return fmt.Errorf("failed to open command log file: %v", err)
+// logConfig returns the logging settings of cfg
+func (cfg *Config) logConfig() logging.Config {
+	return logging.Config{
+		Dir:            cfg.LogDir,
+		Level:          cfg.LogLevel,
+		Format:         cfg.LogFormat,
+		Rotation:       cfg.LogRotation,
+		MaxSize:        int64(cfg.LogMaxSize),
+		MaxAge:         cfg.LogMaxAge,
+		MaxFiles:       cfg.LogMaxFiles,
+		Compress:       cfg.LogCompress,
+		Syslog:         cfg.SyslogEnabled,
+		SyslogIdent:    cfg.SyslogIdent,
+		SyslogFacility: cfg.SyslogFacility,
+		Console:        os.Stdout,
 	}
-	logFiles = append(logFiles, cmdFile)
-	CommandLogger = log.New(cmdFile, "", log.Ldate|log.Ltime)
-
-	// Log startup
-	InfoLogger.Println("Logging system initialized")
-
-	// Set up log rotation
-	go rotateLogsDaily()
-
-	return nil
 }
 
-// CloseLogFiles closes all log files
-func CloseLogFiles() {
-	logMutex.Lock()
-	defer logMutex.Unlock()
+// LogDebug logs a debug message
+func LogDebug(format string, v ...interface{}) {
+	logf(slog.LevelDebug, format, v...)
+}
 
-	for _, file := range logFiles {
-		file.Close()
-	}
-	logFiles = nil
+// LogVerbose logs a message shown from the verbose level
+func LogVerbose(format string, v ...interface{}) {
+	logf(logging.LevelVerbose, format, v...)
 }
 
-// LogInfo logs an info message
+// LogInfo logs a notice
 func LogInfo(format string, v ...interface{}) {
-	logMutex.Lock()
-	defer logMutex.Unlock()
+	logf(slog.LevelInfo, format, v...)
+}
 
-	if InfoLogger != nil {
-		InfoLogger.Printf(format, v...)
-		fmt.Printf("[INFO] "+format+"\n", v...)
-	}
+// LogWarning logs a warning, also written to error.log
+func LogWarning(format string, v ...interface{}) {
+	logf(slog.LevelWarn, format, v...)
 }
 
-// LogError logs an error message
+// LogError logs an error message, also written to error.log
 func LogError(format string, v ...interface{}) {
-	logMutex.Lock()
-	defer logMutex.Unlock()
-
-	if ErrorLogger != nil {
-		ErrorLogger.Printf(format, v...)
-		fmt.Printf("[ERROR] "+format+"\n", v...)
-	}
+	logf(slog.LevelError, format, v...)
 }
 
-// LogCommand logs a command
+// LogCommand logs a command to commands.log
 func LogCommand(clientIP string, command string) {
-	logMutex.Lock()
-	defer logMutex.Unlock()
-
-	if CommandLogger != nil {
-		CommandLogger.Printf("[%s] %s", clientIP, command)
-	}
-}
-
-// rotateLogsDaily rotates logs once per day
-func rotateLogsDaily() {
-	for {
-		// Calculate time until next day
-		now := time.Now()
-		nextDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
-		timeUntilNextDay := nextDay.Sub(now)
-
-		// Sleep until next day
-		time.Sleep(timeUntilNextDay)
-
-		// Rotate logs
-		rotateLogFiles()
-	}
+	logging.Command(clientIP, command)
 }
 
-// rotateLogFiles rotates all log files
-func rotateLogFiles() {
-	logMutex.Lock()
-	defer logMutex.Unlock()
-
-	timestamp := time.Now().Format("2006-01-02")
-
-	// Close existing files
-	for _, file := range logFiles {
-		file.Close()
+// logCommand logs a command to the server's logger when it has its own, or
+// to commands.log
+func (s *Server) logCommand(clientIP string, command string) {
+	if s.cmdLog == nil {
+		LogCommand(clientIP, command)
+		return
 	}
-	logFiles = nil
-
-	// Rename existing log files with timestamp
-	renameLogFile(InfoLogFile, timestamp)
-	renameLogFile(ErrorLogFile, timestamp)
-	renameLogFile(CommandLogFile, timestamp)
-
-	// Reinitialize logging
-	_ = InitLogging() // Ignore errors during rotation
+	s.cmdLog.Debug("command", "client", clientIP, "command", command)
 }
 
-// renameLogFile renames a log file with a timestamp
-func renameLogFile(fileName, timestamp string) {
-	oldPath := filepath.Join(LogDir, fileName)
-	newPath := filepath.Join(LogDir, fmt.Sprintf("%s.%s", fileName, timestamp))
-
-	// If the file exists, rename it
-	if _, err := os.Stat(oldPath); err == nil {
-		if err := os.Rename(oldPath, newPath); err != nil {
-			fmt.Printf("Error renaming log file %s: %v\n", fileName, err)
-		}
+func logf(level slog.Level, format string, v ...interface{}) {
+	// Skip formatting messages nobody logs
+	if serverLog.Enabled(context.Background(), level) {
+		serverLog.Log(context.Background(), level, fmt.Sprintf(format, v...))
 	}
 }
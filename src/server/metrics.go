@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// metricsWriter writes metrics in the Prometheus text exposition format
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+// family starts a metric family with its HELP and TYPE lines
+func (mw metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample, labels are given as name/value pairs
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.w.WriteString(name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			mw.w.WriteString(labels[i])
+			mw.w.WriteString(`="`)
+			mw.w.WriteString(labelEscaper.Replace(labels[i+1]))
+			mw.w.WriteByte('"')
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteByte(' ')
+	mw.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	mw.w.WriteByte('\n')
+}
+
+// metric writes a family holding a single unlabelled sample
+func (mw metricsWriter) metric(name, kind, help string, value float64) {
+	mw.family(name, kind, help)
+	mw.sample(name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes every server metric to w
+func (s *Server) writeMetrics(w io.Writer) error {
+	mw := metricsWriter{bufio.NewWriter(w)}
+	cfg := s.Config()
+
+	mw.metric("orion_uptime_seconds", "gauge", "Seconds since the server started.", float64(s.instance.Store.GetUptimeSeconds()))
+
+	mw.metric("orion_connected_clients", "gauge", "Client connections being served.", float64(s.clients.len()))
+	mw.metric("orion_maxclients", "gauge", "Most client connections served at once.", float64(cfg.MaxClients))
+	mw.metric("orion_connections_received_total", "counter", "Client connections accepted.", float64(s.stats.connectionsReceived.Load()))
+	mw.metric("orion_rejected_connections_total", "counter", "Client connections refused because of maxclients.", float64(s.stats.rejectedConnections.Load()))
+	mw.metric("orion_timedout_connections_total", "counter", "Client connections closed by timeout or client-query-timeout.", float64(s.stats.timedoutConnections.Load()))
+
+	mw.metric("orion_commands_processed_total", "counter", "Commands received from clients.", float64(s.stats.commandsProcessed.Load()))
+	mw.metric("orion_error_replies_total", "counter", "Error replies sent to clients.", float64(s.stats.errorReplies.Load()))
+	mw.metric("orion_ratelimit_delayed_commands_total", "counter", "Commands delayed by a rate limit.", float64(s.stats.rateLimitDelayed.Load()))
+	mw.metric("orion_ratelimit_rejected_commands_total", "counter", "Commands rejected by a rate limit.", float64(s.stats.rateLimitRejected.Load()))
+	mw.metric("orion_monitors", "gauge", "Clients streaming commands with MONITOR.", float64(s.monitors.count.Load()))
+	mw.metric("orion_monitor_dropped_lines_total", "counter", "Lines not sent to a MONITOR client that fell behind.", float64(s.stats.monitorDropped.Load()))
+
+	names, stats := s.commandStats.sorted()
+	mw.family("orion_command_calls_total", "counter", "Calls per command that ran, from clients, scripts and embedders alike.")
+	for i, name := range names {
+		mw.sample("orion_command_calls_total", float64(stats[i].calls.Load()), "cmd", name)
+	}
+	mw.family("orion_command_failed_calls_total", "counter", "Calls per command that replied with an error.")
+	for i, name := range names {
+		mw.sample("orion_command_failed_calls_total", float64(stats[i].failed.Load()), "cmd", name)
+	}
+	mw.family("orion_command_rejected_calls_total", "counter", "Calls per command refused before running, by the arity check, ACL or a rate limit.")
+	for i, name := range names {
+		mw.sample("orion_command_rejected_calls_total", float64(stats[i].rejected.Load()), "cmd", name)
+	}
+	mw.family("orion_command_duration_seconds", "histogram", "Time spent running each command, rejected calls excluded.")
+	for i, name := range names {
+		st := stats[i]
+		var cumulative int64
+		for b, bound := range latencyBuckets {
+			cumulative += st.buckets[b].Load()
+			mw.sample("orion_command_duration_seconds_bucket", float64(cumulative), "cmd", name, "le", strconv.FormatFloat(bound.Seconds(), 'g', -1, 64))
+		}
+		calls := st.calls.Load()
+		mw.sample("orion_command_duration_seconds_bucket", float64(calls), "cmd", name, "le", "+Inf")
+		mw.sample("orion_command_duration_seconds_sum", time.Duration(st.total.Load()).Seconds(), "cmd", name)
+		mw.sample("orion_command_duration_seconds_count", float64(calls), "cmd", name)
+	}
+
+	keys := s.instance.Store.KeysByType()
+	types := make([]string, 0, len(keys))
+	for t := range keys {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	mw.family("orion_keys", "gauge", "Keys in the keyspace by type.")
+	for _, t := range types {
+		mw.sample("orion_keys", float64(keys[t]), "type", t)
+	}
+	mw.metric("orion_expired_keys_total", "counter", "Keys removed by expiration.", float64(s.instance.Store.ExpiredKeys()))
+
+	aofEnabled := 0.0
+	if s.instance.AOF != nil {
+		aofEnabled = 1
+	}
+	mw.metric("orion_aof_enabled", "gauge", "Whether the append-only file is enabled.", aofEnabled)
+	if s.instance.AOF != nil {
+		size, err := s.instance.AOF.Size()
+		if err == nil {
+			mw.metric("orion_aof_size_bytes", "gauge", "Size of the append-only file.", float64(size))
+		}
+		fsyncs, fsyncTime := s.instance.AOF.FsyncStats()
+		mw.metric("orion_aof_fsyncs_total", "counter", "Fsyncs of the append-only file.", float64(fsyncs))
+		mw.metric("orion_aof_fsync_seconds_total", "counter", "Time spent in fsyncs of the append-only file.", fsyncTime.Seconds())
+	}
+	loading := 0.0
+	if s.loading.Load() {
+		loading = 1
+	}
+	mw.metric("orion_loading", "gauge", "Whether the append-only file is being replayed.", loading)
+
+	inProgress, lastSave, lastOK := s.instance.SaveStatus()
+	mw.metric("orion_bgsave_in_progress", "gauge", "Whether a BGSAVE is running.", boolMetric(inProgress))
+	mw.metric("orion_last_bgsave_ok", "gauge", "Whether the last BGSAVE succeeded.", boolMetric(lastOK))
+	if !lastSave.IsZero() {
+		mw.metric("orion_last_bgsave_timestamp_seconds", "gauge", "Unix time the last BGSAVE finished.", float64(lastSave.Unix()))
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	mw.metric("orion_memory_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(mem.HeapAlloc))
+	mw.metric("orion_memory_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.", float64(mem.HeapInuse))
+	mw.metric("orion_memory_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", float64(mem.Sys))
+	mw.metric("orion_memory_allocated_bytes_total", "counter", "Bytes allocated for heap objects.", float64(mem.TotalAlloc))
+	mw.metric("orion_gc_cycles_total", "counter", "Completed garbage collection cycles.", float64(mem.NumGC))
+	mw.metric("orion_goroutines", "gauge", "Goroutines that currently exist.", float64(runtime.NumGoroutine()))
+
+	return mw.w.Flush()
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metricsEndpoint serves /metrics, /healthz and /readyz. The standalone
+// server starts it before the AOF is replayed, so it exists without a
+// Server until New returns.
+type metricsEndpoint struct {
+	server atomic.Pointer[Server]
+}
+
+// MetricsHandler returns the HTTP handler serving /metrics in the Prometheus
+// text format, /healthz and /readyz, for embedders running their own HTTP
+// server
+func (s *Server) MetricsHandler() http.Handler {
+	e := &metricsEndpoint{}
+	e.server.Store(s)
+	return e
+}
+
+func (e *metricsEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := e.server.Load()
+	switch r.URL.Path {
+	case "/healthz":
+		io.WriteString(w, "ok\n")
+	case "/readyz":
+		switch {
+		case s == nil || s.loading.Load():
+			http.Error(w, "loading", http.StatusServiceUnavailable)
+		case s.isClosed():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		default:
+			io.WriteString(w, "ok\n")
+		}
+	case "/metrics":
+		if s == nil {
+			http.Error(w, "loading", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := s.writeMetrics(w); err != nil {
+			s.log.Error("Error writing metrics", "err", err)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveMetrics starts the metrics HTTP listener on metrics-bind and
+// metrics-port
+func serveMetrics(cfg Config, e *metricsEndpoint) (*http.Server, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(cfg.MetricsBind, strconv.Itoa(cfg.MetricsPort)))
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: e, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			LogError("Error serving metrics: %v", err)
+		}
+	}()
+	LogInfo("Serving metrics on http://%s/metrics", listener.Addr())
+	return srv, nil
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape fetches path from h and returns the status and body
+func scrape(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+// parseMetrics maps each sample in a Prometheus text exposition, with its
+// labels, to its value. It fails the test on samples without a TYPE.
+func parseMetrics(t *testing.T, body string) map[string]float64 {
+	t.Helper()
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			types[name] = kind
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q", line)
+		}
+		series := line[:i]
+		name, _, _ := strings.Cut(series, "{")
+		family := name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if types[family] == "" {
+				family = strings.TrimSuffix(name, suffix)
+			}
+		}
+		if types[family] == "" {
+			t.Errorf("sample %q has no TYPE", line)
+		}
+		samples[series] = value
+	}
+	return samples
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	c := dial(t, addr)
+	c.do("SET", "k", "v")
+	c.do("GET", "k")
+	c.do("GET", "k")
+	c.do("GET")
+	c.do("INCR", "k")
+
+	h := s.MetricsHandler()
+	code, body := scrape(t, h, "/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("/metrics got %d: %s", code, body)
+	}
+	samples := parseMetrics(t, body)
+	for series, want := range map[string]float64{
+		`orion_command_calls_total{cmd="get"}`:                       2,
+		`orion_command_rejected_calls_total{cmd="get"}`:              1,
+		`orion_command_failed_calls_total{cmd="incr"}`:               1,
+		`orion_command_calls_total{cmd="set"}`:                       1,
+		`orion_command_duration_seconds_bucket{cmd="get",le="+Inf"}`: 2,
+		`orion_command_duration_seconds_count{cmd="get"}`:            2,
+		`orion_keys{type="string"}`:                                  1,
+		`orion_connected_clients`:                                    1,
+		`orion_error_replies_total`:                                  2,
+		`orion_aof_enabled`:                                          0,
+	} {
+		if got, ok := samples[series]; !ok || got != want {
+			t.Errorf("%s is %v (present %v), want %v", series, got, ok, want)
+		}
+	}
+
+	// The buckets are cumulative
+	previous := 0.0
+	for _, bound := range latencyBuckets {
+		series := `orion_command_duration_seconds_bucket{cmd="get",le="` + strconv.FormatFloat(bound.Seconds(), 'g', -1, 64) + `"}`
+		if samples[series] < previous {
+			t.Errorf("%s is %v, below the previous bucket's %v", series, samples[series], previous)
+		}
+		previous = samples[series]
+	}
+
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusOK, "/nosuch": http.StatusNotFound} {
+		if code, _ := scrape(t, h, path); code != want {
+			t.Errorf("%s got %d, want %d", path, code, want)
+		}
+	}
+	s.Close()
+	if code, _ := scrape(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz after Close got %d", code)
+	}
+	if code, _ := scrape(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz after Close got %d", code)
+	}
+}
+
+func TestMetricsEndpointBeforeServer(t *testing.T) {
+	// The standalone server serves the endpoint while the AOF loads
+	e := &metricsEndpoint{}
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable, "/metrics": http.StatusServiceUnavailable} {
+		if code, _ := scrape(t, e, path); code != want {
+			t.Errorf("%s without a server got %d, want %d", path, code, want)
+		}
+	}
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	var sb strings.Builder
+	mw := metricsWriter{bufio.NewWriter(&sb)}
+	mw.sample("m", 1.5, "cmd", "a\"b\\c\nd")
+	mw.w.Flush()
+	if want := `m{cmd="a\"b\\c\nd"} 1.5` + "\n"; sb.String() != want {
+		t.Errorf("sample written as %q, want %q", sb.String(), want)
+	}
+}
+
+func TestInfoCounters(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	c := dial(t, addr)
+	c.do("SET", "k", "v")
+	c.do("INCR", "k")
+	c.do("GET")
+	c.do("NOSUCHCOMMAND")
+	s.Do(context.Background(), "SET", "n", "1", "EX", "100")
+
+	if got := infoField(t, s, "commandstats", "cmdstat_set"); !strings.HasPrefix(got, "calls=2,") || !strings.HasSuffix(got, ",rejected_calls=0,failed_calls=0") {
+		t.Errorf("cmdstat_set:%s", got)
+	}
+	if got := infoField(t, s, "commandstats", "cmdstat_incr"); !strings.HasSuffix(got, ",rejected_calls=0,failed_calls=1") {
+		t.Errorf("cmdstat_incr:%s", got)
+	}
+	if got := infoField(t, s, "commandstats", "cmdstat_get"); !strings.HasPrefix(got, "calls=0,") || !strings.HasSuffix(got, ",rejected_calls=1,failed_calls=0") {
+		t.Errorf("cmdstat_get:%s", got)
+	}
+
+	// Error replies count under their code, only those sent to clients
+	if got := infoField(t, s, "errorstats", "errorstat_ERR"); got != "count=3" {
+		t.Errorf("errorstat_ERR:%s", got)
+	}
+	if got := infoField(t, s, "stats", "total_error_replies"); got != "3" {
+		t.Errorf("total_error_replies:%s", got)
+	}
+	if got := infoField(t, s, "stats", "total_commands_processed"); got != "4" {
+		t.Errorf("total_commands_processed:%s", got)
+	}
+	if got := infoField(t, s, "stats", "total_connections_received"); got != "1" {
+		t.Errorf("total_connections_received:%s", got)
+	}
+	if got := infoField(t, s, "clients", "connected_clients"); got != "1" {
+		t.Errorf("connected_clients:%s", got)
+	}
+	if got := infoField(t, s, "keyspace", "db0"); !strings.HasPrefix(got, "keys=2,expires=1,avg_ttl=") || !strings.HasSuffix(got, ",string=2") {
+		t.Errorf("db0:%s", got)
+	}
+}
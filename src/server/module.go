@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"orion/src/commands"
+	"orion/src/data"
+	"orion/src/protocol"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Module is an extension compiled into the server. Modules register
+// themselves from an init function with RegisterModule and are usually pulled
+// in by a build-tagged file in cmd/server; Config.Modules decides which of the
+// compiled-in modules a Server enables.
+type Module interface {
+	Name() string
+	Register(ctx *ModuleContext) error
+}
+
+var (
+	modulesMu sync.RWMutex
+	modules   = map[string]Module{}
+)
+
+// RegisterModule makes m available to every Server. It panics if a module
+// with the same name is already registered.
+func RegisterModule(m Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	name := strings.ToLower(m.Name())
+	if _, exists := modules[name]; exists {
+		panic("orion: module " + name + " registered twice")
+	}
+	modules[name] = m
+}
+
+// AvailableModules returns the names of all compiled-in modules
+func AvailableModules() []string {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupModule(name string) (Module, bool) {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	m, ok := modules[strings.ToLower(name)]
+	return m, ok
+}
+
+// KeyEventHandler receives keyspace events: the lower-case name of the write
+// command that touched key (e.g. "set", "sadd"), or "expired"
+type KeyEventHandler func(event, key string)
+
+// ModuleContext is handed to Module.Register to extend one Server
+type ModuleContext struct {
+	server   *Server
+	module   string
+	commands []string
+	types    []string
+}
+
+// AddCommand registers a new command. Names already taken are rejected.
+func (m *ModuleContext) AddCommand(cmd *Command) error {
+	if cmd.Name == "" || cmd.Handler == nil {
+		return fmt.Errorf("module %s: command needs a name and a handler", m.module)
+	}
+	if _, exists := m.server.commands.Lookup(cmd.Name); exists {
+		return fmt.Errorf("module %s: command %s already exists", m.module, cmd.Name)
+	}
+	m.server.commands.Register(cmd)
+	m.commands = append(m.commands, strings.ToLower(cmd.Name))
+	return nil
+}
+
+// DefineType registers a custom data type with its persistence callbacks
+func (m *ModuleContext) DefineType(t data.ModuleType) error {
+	if err := m.server.instance.Store.RegisterType(t); err != nil {
+		return fmt.Errorf("module %s: %w", m.module, err)
+	}
+	m.types = append(m.types, t.Name)
+	return nil
+}
+
+// SubscribeKeyEvents calls fn for every key touched by a write command and
+// every key removed by expiration
+func (m *ModuleContext) SubscribeKeyEvents(fn KeyEventHandler) {
+	m.server.keyEvents.add(fn)
+}
+
+// Store returns the data store the module's commands operate on
+func (m *ModuleContext) Store() *data.DataStore {
+	return m.server.instance.Store
+}
+
+// loadedModule records what an enabled module added to the server
+type loadedModule struct {
+	name     string
+	commands []string
+	types    []string
+}
+
+// loadModules enables the named compiled-in modules
+func (s *Server) loadModules(names []string) error {
+	for _, name := range names {
+		m, ok := lookupModule(name)
+		if !ok {
+			return fmt.Errorf("unknown module %q (available: %s)", name, strings.Join(AvailableModules(), ", "))
+		}
+		ctx := &ModuleContext{server: s, module: strings.ToLower(m.Name())}
+		if err := m.Register(ctx); err != nil {
+			return fmt.Errorf("error loading module %s: %w", ctx.module, err)
+		}
+		s.modules = append(s.modules, loadedModule{name: ctx.module, commands: ctx.commands, types: ctx.types})
+		s.log.Info("Module loaded", "module", ctx.module)
+	}
+	return nil
+}
+
+// keyEventBus fans keyspace events out to module subscribers
+type keyEventBus struct {
+	mu       sync.RWMutex
+	handlers []KeyEventHandler
+}
+
+func (b *keyEventBus) add(fn KeyEventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, fn)
+}
+
+func (b *keyEventBus) publish(event, key string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.handlers {
+		fn(event, key)
+	}
+}
+
+func (b *keyEventBus) empty() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.handlers) == 0
+}
+
+// keyEventHook publishes an event for each key of a successful write command
+func (s *Server) keyEventHook() Hook {
+	return Hook{
+		Name: "keyevents",
+		After: func(ctx *commands.Context, call *Call, reply protocol.ORSPValue, _ time.Duration) {
+			if !call.Command.Is(FlagWrite) || s.keyEvents.empty() {
+				return
+			}
+			if _, failed := reply.(protocol.ErrorValue); failed {
+				return
+			}
+			event := strings.ToLower(call.Command.Root().Name)
+			for _, index := range call.Command.KeyIndexes(len(call.Args)) {
+				if key, ok := call.Args[index].(protocol.BulkStringValue); ok {
+					s.keyEvents.publish(event, string(key))
+				}
+			}
+		},
+	}
+}
+
+// handleModule implements MODULE LIST
+func (s *Server) handleModule(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sub, _ := args[0].(protocol.BulkStringValue)
+	if strings.ToUpper(string(sub)) != "LIST" {
+		return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try MODULE LIST")
+	}
+	if len(args) != 1 {
+		return protocol.ErrorValue("ERR wrong number of arguments for 'module|list' command")
+	}
+
+	loaded := make(map[string]loadedModule, len(s.modules))
+	for _, m := range s.modules {
+		loaded[m.name] = m
+	}
+
+	available := AvailableModules()
+	response := make(protocol.ArrayValue, 0, len(available))
+	for _, name := range available {
+		m, enabled := loaded[name]
+		response = append(response, protocol.MapValue{
+			"name":     protocol.BulkStringValue(name),
+			"enabled":  protocol.BooleanValue(enabled),
+			"commands": stringSet(m.commands),
+			"types":    stringSet(m.types),
+		})
+	}
+	return response
+}
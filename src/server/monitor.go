@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitorQueueLen is how many lines a monitor may fall behind before new
+// lines are dropped for it
+const monitorQueueLen = 1024
+
+// monitor is a connection that switched to MONITOR. Lines are queued by the
+// executing clients and written by the monitor's own goroutine.
+type monitor struct {
+	queue chan string
+}
+
+// monitorFeed fans the executed commands out to every monitor
+type monitorFeed struct {
+	count atomic.Int32 // lets publishers skip formatting when nobody watches
+
+	mu       sync.RWMutex
+	monitors map[*monitor]struct{}
+}
+
+func (f *monitorFeed) add() *monitor {
+	m := &monitor{queue: make(chan string, monitorQueueLen)}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.monitors == nil {
+		f.monitors = make(map[*monitor]struct{})
+	}
+	f.monitors[m] = struct{}{}
+	f.count.Add(1)
+	return m
+}
+
+func (f *monitorFeed) remove(m *monitor) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.monitors[m]; ok {
+		delete(f.monitors, m)
+		f.count.Add(-1)
+	}
+}
+
+// publish queues line for every monitor without waiting, monitors whose
+// queue is full miss it. It returns how many did.
+func (f *monitorFeed) publish(line string) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	dropped := 0
+	for m := range f.monitors {
+		select {
+		case m.queue <- line:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// feedMonitors sends the command about to run to the monitors, formatted
+// the way MONITOR prints it, with secrets redacted
+func (s *Server) feedMonitors(ctx *commands.Context, call *Call) {
+	if s.monitors.count.Load() == 0 || s.loading.Load() {
+		return
+	}
+	source := ctx.Addr
+	if ctx.Script {
+		source = "lua"
+	} else if source == "" {
+		source = "embedded"
+	}
+	if dropped := s.monitors.publish(formatMonitorLine(time.Now(), source, call)); dropped > 0 {
+		s.stats.monitorDropped.Add(int64(dropped))
+	}
+}
+
+// formatMonitorLine formats a command as
+// 1700000000.123456 [0 127.0.0.1:50000] "set" "key" "value"
+func formatMonitorLine(now time.Time, source string, call *Call) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d.%06d [%d %s] ", now.Unix(), now.Nanosecond()/1000, dbIndex, source)
+	name := call.Command.Root().Name
+	quoteMonitorArg(&sb, strings.ToLower(name))
+	for _, arg := range redactArgs(name, call.Args[1:]) {
+		sb.WriteByte(' ')
+		quoteMonitorArg(&sb, bulkString(arg))
+	}
+	return sb.String()
+}
+
+// quoteMonitorArg writes arg between double quotes, escaping quotes,
+// backslashes and unprintable bytes so each line stays on one line
+func quoteMonitorArg(sb *strings.Builder, arg string) {
+	sb.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch b := arg[i]; b {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if b < 0x20 || b >= 0x7f {
+				fmt.Fprintf(sb, `\x%02x`, b)
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// handleMonitor implements MONITOR. The connection switches to monitor mode
+// once the reply is sent, see serveMonitor.
+func (s *Server) handleMonitor(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	c := s.clients.get(ctx.ID)
+	if c == nil {
+		return protocol.ErrorValue("ERR MONITOR can only be used by clients connected to the server")
+	}
+	c.monitoring.Store(true)
+	return protocol.SimpleStringValue("OK")
+}
+
+// serveMonitor streams the executed commands to c until the client hangs
+// up, is killed or the server shuts down. Monitors are exempt from the idle
+// timeout and what they send is ignored.
+func (s *Server) serveMonitor(c *client, encoder *protocol.Encoder) {
+	m := s.monitors.add()
+	defer s.monitors.remove(m)
+
+	c.conn.SetReadDeadline(time.Time{})
+	if s.isClosed() {
+		return
+	}
+	// Reading only tells when the connection is gone: a hang-up, CLIENT
+	// KILL closing it or shutdown setting a past read deadline
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		buf := make([]byte, 512)
+		for {
+			if _, err := c.conn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case line := <-m.queue:
+			if err := encoder.Encode(protocol.SimpleStringValue(line)); err != nil {
+				return
+			}
+			// Send what queued up meanwhile in the same write
+			for n := len(m.queue); n > 0; n-- {
+				if err := encoder.Encode(protocol.SimpleStringValue(<-m.queue)); err != nil {
+					return
+				}
+			}
+			if err := encoder.Flush(); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"orion/src/protocol"
+)
+
+func TestFormatMonitorLine(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+	call := &Call{Command: &Command{Name: "SET"}, Args: protocol.ArrayValue{
+		protocol.BulkStringValue("set"),
+		protocol.BulkStringValue(`k"\`),
+		protocol.BulkStringValue("a\r\nb\tc\x00\xff"),
+	}}
+	want := `1700000000.123456 [0 127.0.0.1:50000] "set" "k\"\\" "a\r\nb\tc\x00\xff"`
+	if got := formatMonitorLine(now, "127.0.0.1:50000", call); got != want {
+		t.Errorf("formatMonitorLine got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	m, c := dial(t, addr), dial(t, addr)
+	c.do("CLIENT", "SETNAME", "worker")
+
+	if reply := m.do("MONITOR"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("MONITOR got %#v", reply)
+	}
+	// The monitor registers once the reply is out
+	deadline := time.Now().Add(5 * time.Second)
+	for s.monitors.count.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := infoField(t, s, "clients", "monitors"); got != "1" {
+		t.Errorf("INFO reports %s monitors", got)
+	}
+	for _, f := range clientFields(t, c.do("CLIENT", "LIST")) {
+		if f["name"] != "worker" && (!strings.Contains(f["flags"], "O") || f["db"] != "0") {
+			t.Errorf("CLIENT LIST shows the monitor as %v", f)
+		}
+	}
+
+	source := regexp.QuoteMeta(c.conn.LocalAddr().String())
+	c.do("SET", "k", "a\"b\nc")
+	c.do("AUTH", "wrong")
+	c.do("HELLO", "2", "AUTH", "default", "wrong")
+	c.do("ACL", "SETUSER", "bob", "on", ">secret", "~*")
+	c.do("CONFIG", "SET", "requirepass", "secret", "maxclients", "100")
+	s.Do(context.Background(), "GET", "k")
+
+	// Secrets are redacted the way the slow log and the logs redact them
+	for _, want := range []string{
+		`\[0 embedded\] "info" "clients"`,
+		`\[0 ` + source + `\] "client" "LIST"`,
+		`\[0 ` + source + `\] "set" "k" "a\\"b\\nc"`,
+		`\[0 ` + source + `\] "auth" "\(redacted\)"`,
+		`\[0 ` + source + `\] "hello" "2" "AUTH" "default" "\(redacted\)"`,
+		`\[0 ` + source + `\] "acl" "SETUSER" "bob" "on" "\(redacted\)" "~\*"`,
+		`\[0 ` + source + `\] "config" "SET" "requirepass" "\(redacted\)" "maxclients" "100"`,
+		`\[0 embedded\] "get" "k"`,
+	} {
+		m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, ok := m.read().(protocol.SimpleStringValue)
+		if !ok || !regexp.MustCompile(`^\d+\.\d{6} `+want+`$`).MatchString(string(line)) {
+			t.Fatalf("MONITOR printed %q, want %s", line, want)
+		}
+	}
+
+	// Hanging up removes the monitor
+	m.conn.Close()
+	deadline = time.Now().Add(5 * time.Second)
+	for s.monitors.count.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := s.monitors.count.Load(); n != 0 {
+		t.Errorf("%d monitors left after the monitor hung up", n)
+	}
+}
+
+func TestMonitorFeedDrops(t *testing.T) {
+	var f monitorFeed
+	slow, fast := f.add(), f.add()
+	for i := 0; i < monitorQueueLen; i++ {
+		f.publish("line")
+	}
+	<-fast.queue
+	if dropped := f.publish("one more"); dropped != 1 {
+		t.Errorf("%d monitors missed a line, want the full one only", dropped)
+	}
+	if len(slow.queue) != monitorQueueLen {
+		t.Errorf("the full queue holds %d lines", len(slow.queue))
+	}
+	f.remove(slow)
+	f.remove(slow)
+	if n := f.count.Load(); n != 1 {
+		t.Errorf("%d monitors after removing one twice", n)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// OutputBufferLimit bounds the replies queued for a client that reads them
+// slower than it sends commands. Zero values disable a limit.
+type OutputBufferLimit struct {
+	// Hard disconnects the client as soon as this many bytes are pending
+	Hard int64
+	// Soft disconnects the client once this many bytes have stayed pending
+	// for SoftPeriod
+	Soft       int64
+	SoftPeriod time.Duration
+}
+
+var errOutputBufferLimit = errors.New("output buffer limit reached")
+
+// outputDrainTimeout bounds how long a closing connection waits for its
+// pending replies to be written
+const outputDrainTimeout = 5 * time.Second
+
+// maxSpareOutput is the largest buffer kept around for reuse after a write,
+// so one huge reply doesn't pin its memory for the life of the connection
+const maxSpareOutput = 64 * 1024
+
+// clientOutput is a connection's output buffer. The connection handler
+// appends replies without blocking and a writer goroutine sends them, so a
+// whole pipeline of replies goes out in as few writes as possible and a slow
+// reader can be detected and dropped instead of stalling its handler.
+type clientOutput struct {
+	conn  net.Conn
+	limit OutputBufferLimit
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []byte
+	spare     []byte
+	inflight  int       // bytes handed to conn.Write but not yet written
+	softSince time.Time // when pending first went over the soft limit
+	err       error
+	closed    bool
+	done      chan struct{}
+}
+
+func newClientOutput(conn net.Conn, limit OutputBufferLimit) *clientOutput {
+	o := &clientOutput{conn: conn, limit: limit, done: make(chan struct{})}
+	o.cond = sync.NewCond(&o.mu)
+	go o.writeLoop()
+	return o
+}
+
+// Write queues p to be sent. It fails once the connection is broken or the
+// client went over its output buffer limit.
+func (o *clientOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return 0, o.err
+	}
+	o.pending = append(o.pending, p...)
+	if err := o.checkLimit(); err != nil {
+		o.fail(err)
+		return 0, err
+	}
+	o.cond.Signal()
+	return len(p), nil
+}
+
+// size returns how many reply bytes are waiting to be sent
+func (o *clientOutput) size() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending) + o.inflight
+}
+
+// checkLimit is called with o.mu held
+func (o *clientOutput) checkLimit() error {
+	size := int64(len(o.pending) + o.inflight)
+	if o.limit.Hard > 0 && size > o.limit.Hard {
+		return errOutputBufferLimit
+	}
+	if o.limit.Soft <= 0 || size <= o.limit.Soft {
+		o.softSince = time.Time{}
+		return nil
+	}
+	if o.softSince.IsZero() {
+		o.softSince = time.Now()
+	} else if time.Since(o.softSince) > o.limit.SoftPeriod {
+		return errOutputBufferLimit
+	}
+	return nil
+}
+
+// fail records err and closes the connection, which also stops the reader.
+// It is called with o.mu held.
+func (o *clientOutput) fail(err error) {
+	if o.err == nil {
+		o.err = err
+		o.conn.Close()
+	}
+	o.cond.Signal()
+}
+
+func (o *clientOutput) writeLoop() {
+	defer close(o.done)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for {
+		for len(o.pending) == 0 && !o.closed && o.err == nil {
+			o.cond.Wait()
+		}
+		if o.err != nil || len(o.pending) == 0 {
+			return
+		}
+
+		buf := o.pending
+		o.pending, o.spare = o.spare[:0], nil
+		o.inflight = len(buf)
+		o.mu.Unlock()
+		_, err := o.conn.Write(buf)
+		o.mu.Lock()
+		o.inflight = 0
+		if cap(buf) <= maxSpareOutput {
+			o.spare = buf
+		}
+		if err != nil {
+			o.fail(err)
+		}
+	}
+}
+
+// Close waits for the pending replies to be written, giving up after
+// outputDrainTimeout, and reports why the output failed if it did
+func (o *clientOutput) Close() error {
+	o.mu.Lock()
+	o.closed = true
+	o.cond.Signal()
+	o.mu.Unlock()
+
+	o.conn.SetWriteDeadline(time.Now().Add(outputDrainTimeout))
+	<-o.done
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
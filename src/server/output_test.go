@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"orion/src/protocol"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingListener counts the writes made to the connections it accepts
+type countingListener struct {
+	net.Listener
+	writes atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, writes: &l.writes}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+func TestPipelineRepliesBatched(t *testing.T) {
+	s, err := New(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &countingListener{Listener: inner}
+	go s.Serve(listener)
+	t.Cleanup(func() { s.Close() })
+
+	c := dial(t, inner.Addr().String())
+	const n = 500
+	var pipeline []byte
+	for i := 0; i < n; i++ {
+		pipeline = protocol.AppendValue(pipeline, protocol.ArrayValue{protocol.BulkStringValue("INCR"), protocol.BulkStringValue("counter")})
+	}
+	if _, err := c.conn.Write(pipeline); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		if reply := c.read(); reply != protocol.IntegerValue(i) {
+			t.Fatalf("reply %d is %#v, replies are out of order", i, reply)
+		}
+	}
+	// The pipeline may arrive in a few reads, but not one write per reply
+	if writes := listener.writes.Load(); writes > n/10 {
+		t.Errorf("%d replies took %d writes", n, writes)
+	}
+}
+
+func TestOutputBufferHardLimit(t *testing.T) {
+	var logs syncBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	s, addr := startServer(t, Config{
+		Logger:                  logger,
+		ClientOutputBufferLimit: OutputBufferLimit{Hard: 4 << 20},
+	})
+	s.Do(context.Background(), "SET", "big", strings.Repeat("x", 1<<20))
+
+	// A client that sends commands but never reads their replies
+	c := dial(t, addr)
+	var pipeline []byte
+	for i := 0; i < 64; i++ {
+		pipeline = protocol.AppendValue(pipeline, protocol.ArrayValue{protocol.BulkStringValue("GET"), protocol.BulkStringValue("big")})
+	}
+	c.conn.Write(pipeline)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "output buffer limits") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no disconnect logged:\n%s", logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Draining the socket ends at the disconnect, well before every reply
+	n, _ := io.Copy(io.Discard, c.conn)
+	if n >= 64<<20 {
+		t.Errorf("read %d bytes, the client wasn't disconnected", n)
+	}
+
+	// Clients that keep up are unaffected
+	other := dial(t, addr)
+	for i := 0; i < 4; i++ {
+		if reply := other.do("GET", "big"); isError(reply, "") {
+			t.Fatalf("GET from a reading client got %#v", reply)
+		}
+	}
+}
+
+// stalledConn is a connection whose peer never reads
+func stalledConn(t *testing.T) net.Conn {
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close(); client.Close() })
+	return server
+}
+
+func TestOutputBufferSoftLimit(t *testing.T) {
+	o := newClientOutput(stalledConn(t), OutputBufferLimit{Soft: 10, SoftPeriod: 50 * time.Millisecond})
+	reply := bytes.Repeat([]byte("x"), 20)
+
+	if _, err := o.Write(reply); err != nil {
+		t.Fatalf("first write over the soft limit: %v", err)
+	}
+	if _, err := o.Write(reply); err != nil {
+		t.Fatalf("write within the soft period: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := o.Write(reply); err != errOutputBufferLimit {
+		t.Fatalf("write after the soft period got %v, want %v", err, errOutputBufferLimit)
+	}
+	if err := o.Close(); err != errOutputBufferLimit {
+		t.Errorf("Close got %v, want %v", err, errOutputBufferLimit)
+	}
+}
+
+func TestOutputBufferUnlimited(t *testing.T) {
+	o := newClientOutput(stalledConn(t), OutputBufferLimit{})
+	for i := 0; i < 100; i++ {
+		if _, err := o.Write(make([]byte, 64*1024)); err != nil {
+			t.Fatalf("write %d without limits: %v", i, err)
+		}
+	}
+	if size := o.size(); size != 100*64*1024 {
+		t.Errorf("size() = %d, want every byte pending", size)
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit is a token-bucket budget for the clients it matches. Every
+// matching user, client name or IP gets its own buckets, shared by all of
+// its connections, holding up to one second worth of tokens. Zero rates are
+// unlimited.
+type RateLimit struct {
+	// Match selects the clients, "user:<pattern>", "name:<pattern>" or
+	// "ip:<pattern>" with a glob-style pattern
+	Match string
+	// Commands is how many commands per second the clients may run
+	Commands int64
+	// Bytes is how many request bytes per second the clients may send
+	Bytes int64
+	// Writes is how many write commands per second the clients may run, on
+	// top of the Commands budget
+	Writes int64
+}
+
+// parseRateLimits parses the ratelimit directive, groups of
+// <match> <commands/s> <bytes/s> <writes/s>
+func parseRateLimits(value string) ([]RateLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return nil, errors.New("wrong number of arguments in ratelimit configuration, expected <match> <commands> <bytes> <writes> groups")
+	}
+	var limits []RateLimit
+	for ; len(fields) > 0; fields = fields[4:] {
+		kind, _, found := strings.Cut(fields[0], ":")
+		if !found || (kind != "user" && kind != "name" && kind != "ip") {
+			return nil, fmt.Errorf("invalid ratelimit match '%s', it must start with user:, name: or ip:", fields[0])
+		}
+		commands, err1 := strconv.ParseInt(fields[1], 10, 64)
+		bytes, err2 := parseMemory(fields[2])
+		writes, err3 := strconv.ParseInt(fields[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || commands < 0 || bytes < 0 || writes < 0 {
+			return nil, fmt.Errorf("invalid rates for ratelimit '%s'", fields[0])
+		}
+		limits = append(limits, RateLimit{Match: fields[0], Commands: commands, Bytes: bytes, Writes: writes})
+	}
+	return limits, nil
+}
+
+// formatRateLimits spells limits the way the ratelimit directive takes them
+func formatRateLimits(limits []RateLimit, memory bool) string {
+	groups := make([]string, len(limits))
+	for i, l := range limits {
+		bytes := strconv.FormatInt(l.Bytes, 10)
+		if memory {
+			bytes = formatMemory(l.Bytes)
+		}
+		groups[i] = fmt.Sprintf("%s %d %s %d", l.Match, l.Commands, bytes, l.Writes)
+	}
+	return strings.Join(groups, " ")
+}
+
+// tokenBucket refills at rate tokens per second up to one second worth
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(rate float64, now time.Time) {
+	b.tokens = min(rate, b.tokens+rate*now.Sub(b.last).Seconds())
+	b.last = now
+}
+
+// rateLimitSettings are the ratelimit and ratelimit-mode settings in effect
+type rateLimitSettings struct {
+	limits []RateLimit
+	delay  bool
+}
+
+// rateLimiter holds the buckets of every client matched by a RateLimit
+type rateLimiter struct {
+	settings atomic.Pointer[rateLimitSettings]
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPurge time.Time
+}
+
+// bucketIdleTime is how long an unused bucket is kept, it is full by then
+const bucketIdleTime = time.Minute
+
+func (s *Server) setRateLimits(cfg *Config) error {
+	s.limiter.mu.Lock()
+	s.limiter.buckets = nil
+	s.limiter.mu.Unlock()
+	s.limiter.settings.Store(&rateLimitSettings{limits: cfg.RateLimits, delay: cfg.RateLimitMode == "delay"})
+	return nil
+}
+
+// bucketRequest is the tokens one command takes from one bucket
+type bucketRequest struct {
+	key    string
+	rate   float64
+	amount float64
+}
+
+// throttle charges the command to the buckets of ctx's client. It returns
+// an error reply when the command is rejected, and sleeps instead in delay
+// mode.
+func (s *Server) throttle(ctx *commands.Context, cmd *Command, command protocol.ArrayValue) protocol.ORSPValue {
+	settings := s.limiter.settings.Load()
+	if settings == nil || len(settings.limits) == 0 {
+		return nil
+	}
+
+	var requests []bucketRequest
+	size := -1
+	for _, limit := range settings.limits {
+		kind, pattern, _ := strings.Cut(limit.Match, ":")
+		var identity string
+		switch kind {
+		case "user":
+			identity = ctx.User
+		case "name":
+			identity = ctx.Name
+		case "ip":
+			identity = ctx.Addr
+			if host, _, err := net.SplitHostPort(ctx.Addr); err == nil {
+				identity = host
+			}
+		}
+		if !globMatch(pattern, identity, false) {
+			continue
+		}
+
+		key := limit.Match + "\x00" + identity
+		if limit.Commands > 0 {
+			requests = append(requests, bucketRequest{key + "\x00commands", float64(limit.Commands), 1})
+		}
+		if limit.Writes > 0 && cmd.Is(FlagWrite) {
+			requests = append(requests, bucketRequest{key + "\x00writes", float64(limit.Writes), 1})
+		}
+		if limit.Bytes > 0 {
+			if size < 0 {
+				size = commandSize(command)
+			}
+			requests = append(requests, bucketRequest{key + "\x00bytes", float64(limit.Bytes), float64(size)})
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	wait, ok := s.limiter.take(requests, settings.delay, time.Now())
+	if ok && wait <= 0 {
+		return nil
+	}
+	if c := s.clients.get(ctx.ID); c != nil {
+		c.throttled.Add(1)
+	}
+	if !ok {
+		s.stats.rateLimitRejected.Add(1)
+		return protocol.ErrorValue("BUSY rate limit exceeded, try again later")
+	}
+
+	s.stats.rateLimitDelayed.Add(1)
+	for deadline := time.Now().Add(wait); !s.isClosed(); {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		time.Sleep(min(remaining, 100*time.Millisecond))
+	}
+	return nil
+}
+
+// take charges requests to their buckets. In reject mode nothing is taken
+// unless every bucket has the tokens, in delay mode buckets go into debt and
+// the wait until it is paid back is returned.
+func (l *rateLimiter) take(requests []bucketRequest, delay bool, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	if now.Sub(l.lastPurge) > bucketIdleTime {
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdleTime {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastPurge = now
+	}
+
+	buckets := make([]*tokenBucket, len(requests))
+	for i, r := range requests {
+		b := l.buckets[r.key]
+		if b == nil {
+			b = &tokenBucket{tokens: r.rate, last: now}
+			l.buckets[r.key] = b
+		}
+		b.refill(r.rate, now)
+		buckets[i] = b
+
+		// A command larger than the bucket goes through once it is full
+		if !delay && b.tokens < min(r.amount, r.rate) {
+			return 0, false
+		}
+	}
+
+	var wait time.Duration
+	for i, r := range requests {
+		b := buckets[i]
+		b.tokens -= r.amount
+		if delay && b.tokens < 0 {
+			wait = max(wait, time.Duration(-b.tokens/r.rate*float64(time.Second)))
+		}
+	}
+	return wait, true
+}
+
+// commandSize is the number of argument bytes in command
+func commandSize(command protocol.ArrayValue) int {
+	size := 0
+	for _, arg := range command {
+		if bulk, ok := arg.(protocol.BulkStringValue); ok {
+			size += len(bulk)
+		}
+	}
+	return size
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"orion/src/protocol"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("user:app* 100 1kb 10 ip:10.0.0.? 0 0 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RateLimit{
+		{Match: "user:app*", Commands: 100, Bytes: 1024, Writes: 10},
+		{Match: "ip:10.0.0.?", Commands: 0, Bytes: 0, Writes: 5},
+	}
+	if len(limits) != len(want) || limits[0] != want[0] || limits[1] != want[1] {
+		t.Errorf("parseRateLimits got %+v, want %+v", limits, want)
+	}
+	if got := formatRateLimits(limits, true); got != "user:app* 100 1kb 10 ip:10.0.0.? 0 0 5" {
+		t.Errorf("formatRateLimits got %q", got)
+	}
+
+	for _, value := range []string{
+		"user:app 100 0",
+		"host:app 100 0 0",
+		"app 100 0 0",
+		"user:app -1 0 0",
+		"user:app 100 lots 0",
+		"user:app 100 0 x",
+	} {
+		if _, err := parseRateLimits(value); err == nil {
+			t.Errorf("parseRateLimits(%q) succeeded", value)
+		}
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	var l rateLimiter
+	now := time.Now()
+	commands := []bucketRequest{{key: "c", rate: 2, amount: 1}}
+
+	// A fresh bucket holds one second worth of tokens
+	for i := 0; i < 2; i++ {
+		if _, ok := l.take(commands, false, now); !ok {
+			t.Fatalf("command %d rejected", i+1)
+		}
+	}
+	if _, ok := l.take(commands, false, now); ok {
+		t.Fatal("the third command in the same instant was allowed")
+	}
+	if _, ok := l.take(commands, false, now.Add(500*time.Millisecond)); !ok {
+		t.Error("the bucket didn't refill after half a second")
+	}
+
+	// A rejected command takes no tokens from the other buckets
+	both := []bucketRequest{{key: "w", rate: 10, amount: 1}, {key: "c", rate: 2, amount: 1}}
+	if _, ok := l.take(both, false, now.Add(500*time.Millisecond)); ok {
+		t.Fatal("a command over the commands bucket was allowed")
+	}
+	if b := l.buckets["w"]; b.tokens != 10 {
+		t.Errorf("the rejected command took from the writes bucket, %v tokens left", b.tokens)
+	}
+
+	// A command larger than the bucket goes through once it is full
+	bytes := []bucketRequest{{key: "b", rate: 100, amount: 1000}}
+	if _, ok := l.take(bytes, false, now); !ok {
+		t.Error("a command larger than a full bucket was rejected")
+	}
+	if _, ok := l.take(bytes, false, now.Add(time.Second)); ok {
+		t.Error("the bucket paid the large command back in one second")
+	}
+
+	// Delay mode goes into debt and reports the time to pay it back
+	var delayed rateLimiter
+	if wait, ok := delayed.take([]bucketRequest{{key: "d", rate: 10, amount: 15}}, true, now); !ok || wait != 500*time.Millisecond {
+		t.Errorf("delay mode got a %v wait, %v", wait, ok)
+	}
+}
+
+func TestRateLimitReject(t *testing.T) {
+	s, addr := startServer(t, Config{RateLimits: []RateLimit{{Match: "name:noisy", Commands: 3}}})
+	noisy, quiet := dial(t, addr), dial(t, addr)
+	noisy.do("CLIENT", "SETNAME", "noisy")
+
+	var rejected int
+	for i := 0; i < 10; i++ {
+		if reply := noisy.do("PING"); isError(reply, "BUSY rate limit exceeded") {
+			rejected++
+		} else if reply != protocol.SimpleStringValue("PONG") {
+			t.Fatalf("PING got %#v", reply)
+		}
+	}
+	if rejected < 5 {
+		t.Errorf("%d of 10 PINGs rejected at 3 per second", rejected)
+	}
+	for i := 0; i < 10; i++ {
+		if reply := quiet.do("PING"); reply != protocol.SimpleStringValue("PONG") {
+			t.Fatalf("PING on a client the limit doesn't match got %#v", reply)
+		}
+	}
+
+	if got := infoField(t, s, "stats", "ratelimit_rejected_commands"); got != strconv.Itoa(rejected) {
+		t.Errorf("INFO reports %s rejected commands, want %d", got, rejected)
+	}
+	for _, c := range clientFields(t, quiet.do("CLIENT", "LIST")) {
+		want := "0"
+		if c["name"] == "noisy" {
+			want = strconv.Itoa(rejected)
+		}
+		if c["throttled"] != want {
+			t.Errorf("CLIENT LIST shows %v, want throttled=%s", c, want)
+		}
+	}
+}
+
+func TestRateLimitWrites(t *testing.T) {
+	_, addr := startServer(t, Config{RateLimits: []RateLimit{{Match: "user:default", Writes: 1}}})
+	c := dial(t, addr)
+
+	if reply := c.do("SET", "k", "1"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("the first SET got %#v", reply)
+	}
+	if reply := c.do("SET", "k", "2"); !isError(reply, "BUSY ") {
+		t.Errorf("the second SET got %#v", reply)
+	}
+	for i := 0; i < 5; i++ {
+		if reply := c.do("GET", "k"); reply != protocol.BulkStringValue("1") {
+			t.Fatalf("GET under a writes-only limit got %#v", reply)
+		}
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	s, addr := startServer(t, Config{
+		RateLimits:    []RateLimit{{Match: "ip:127.0.0.1", Commands: 20}},
+		RateLimitMode: "delay",
+	})
+	c := dial(t, addr)
+
+	// 20 commands use up the bucket, the next 10 wait half a second
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		if reply := c.do("PING"); reply != protocol.SimpleStringValue("PONG") {
+			t.Fatalf("PING in delay mode got %#v", reply)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("30 commands at 20 per second took %v", elapsed)
+	}
+	if got := infoField(t, s, "stats", "ratelimit_delayed_commands"); got == "0" {
+		t.Error("INFO reports no delayed commands")
+	}
+
+	// CONFIG SET replaces the limits and their buckets
+	if reply := c.do("CONFIG", "SET", "ratelimit", ""); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CONFIG SET ratelimit got %#v", reply)
+	}
+	start = time.Now()
+	for i := 0; i < 60; i++ {
+		c.do("PING")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("60 commands without a limit took %v, the old limit still holds", elapsed)
+	}
+}
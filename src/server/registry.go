@@ -0,0 +1,328 @@
+package server
+
+import (
+	"fmt"
+	"orion/src/protocol"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CommandFlag describes how a command behaves, the dispatcher and the ACL
+// system use flags instead of hard-coding command names
+type CommandFlag uint32
+
+const (
+	FlagWrite     CommandFlag = 1 << iota // may modify the keyspace
+	FlagReadOnly                          // only reads data
+	FlagDenyOOM                           // may grow memory usage
+	FlagAdmin                             // administrative command
+	FlagPubSub                            // pub/sub related
+	FlagNoScript                          // not allowed from scripts
+	FlagFast                              // O(1) or O(log N)
+	FlagNoAuth                            // allowed before the client authenticates
+	FlagLoading                           // allowed while the AOF is being replayed
+	FlagStale                             // allowed while the dataset is stale
+	FlagAllowBusy                         // allowed while a script runs past lua-time-limit
+)
+
+var flagNames = []struct {
+	flag CommandFlag
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadOnly, "readonly"},
+	{FlagDenyOOM, "denyoom"},
+	{FlagAdmin, "admin"},
+	{FlagPubSub, "pubsub"},
+	{FlagNoScript, "noscript"},
+	{FlagFast, "fast"},
+	{FlagNoAuth, "no_auth"},
+	{FlagLoading, "loading"},
+	{FlagStale, "stale"},
+	{FlagAllowBusy, "allow_busy"},
+}
+
+// Names returns the lower-case names of the flags that are set
+func (f CommandFlag) Names() []string {
+	var names []string
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+// KeyFlag says how a command uses a key, ACL checks it against the read and
+// write permissions of the user's key patterns
+type KeyFlag uint8
+
+const (
+	KeyRead  KeyFlag = 1 << iota // the key's value is returned or used
+	KeyWrite                     // the key is created, changed or deleted
+)
+
+// KeySpec locates some of a command's keys the way FirstKey, LastKey and
+// Step do, and says how the command uses them
+type KeySpec struct {
+	FirstKey int
+	LastKey  int
+	Step     int
+	Flags    KeyFlag
+
+	// Options, when set, returns flags the command's arguments add, such as
+	// KeyRead for SET ... GET
+	Options func(args protocol.ArrayValue) KeyFlag
+}
+
+// CommandKey is a key argument of a command line and how it is used
+type CommandKey struct {
+	Index int
+	Flags KeyFlag
+}
+
+// Command describes a command and the handler that executes it
+type Command struct {
+	Name    string
+	Handler CommandHandler
+
+	// Arity counts the command name itself. A positive arity is an exact
+	// argument count, a negative one is a minimum.
+	Arity int
+	Flags CommandFlag
+
+	// FirstKey, LastKey and Step locate the key arguments (1-based, LastKey
+	// may be negative to count from the end). FirstKey 0 means no keys. For
+	// pubsub commands they locate channels, which ACL checks instead.
+	FirstKey int
+	LastKey  int
+	Step     int
+
+	// KeySpecs say how the command uses its keys when a write command also
+	// reads them, or uses some keys differently from the others. Without
+	// them the keys FirstKey, LastKey and Step locate are read by read-only
+	// commands and written by the others.
+	KeySpecs []KeySpec
+
+	// Categories lists the data-type ACL categories (e.g. "@string"), the
+	// flag-derived ones are added by ACLCategories
+	Categories []string
+
+	// Documentation served by COMMAND DOCS
+	Summary    string
+	Syntax     string
+	Group      string
+	Complexity string
+
+	// Subcommands describe the subcommands of a container command such as
+	// SCRIPT, each with its own arity (counting the container's name),
+	// flags and docs. Register gives them the container's handler, lock and
+	// categories when they have none, and names them SCRIPT|KILL the way
+	// Redis does.
+	Subcommands []*Command
+
+	lock   commandLock
+	parent *Command // the container of a subcommand
+}
+
+// commandLock says how the dispatcher serializes a command with the others
+type commandLock int
+
+const (
+	lockShared    commandLock = iota // runs alongside other shared commands
+	lockExclusive                    // runs alone, used by scripts to stay atomic
+	lockNone                         // takes no lock, so it can reach a running script
+)
+
+// Root returns the container of a subcommand, or the command itself
+func (c *Command) Root() *Command {
+	if c.parent != nil {
+		return c.parent
+	}
+	return c
+}
+
+// resolve returns the subcommand args names, or c when it names none of them
+func (c *Command) resolve(args protocol.ArrayValue) *Command {
+	if len(c.Subcommands) == 0 || len(args) < 2 {
+		return c
+	}
+	name, _ := args[1].(protocol.BulkStringValue)
+	prefix := c.Name + "|"
+	for _, sub := range c.Subcommands {
+		if strings.EqualFold(strings.TrimPrefix(sub.Name, prefix), string(name)) {
+			return sub
+		}
+	}
+	return c
+}
+
+// CheckArity reports whether argc (including the command name) satisfies the arity
+func (c *Command) CheckArity(argc int) bool {
+	if c.Arity >= 0 {
+		return argc == c.Arity
+	}
+	return argc >= -c.Arity
+}
+
+// ArityError is the reply sent when a command is called with the wrong number of arguments
+func (c *Command) ArityError() string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(c.Name))
+}
+
+// Is reports whether all the given flags are set
+func (c *Command) Is(flag CommandFlag) bool {
+	return c.Flags&flag == flag
+}
+
+// ACLCategories returns the command's data-type categories plus the ones implied by its flags
+func (c *Command) ACLCategories() []string {
+	categories := append([]string{}, c.Categories...)
+	if c.Is(FlagWrite) {
+		categories = append(categories, "@write")
+	}
+	if c.Is(FlagReadOnly) {
+		categories = append(categories, "@read")
+	}
+	if c.Is(FlagAdmin) {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if c.Is(FlagPubSub) {
+		categories = append(categories, "@pubsub")
+	}
+	if c.Is(FlagFast) {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	return categories
+}
+
+// KeyIndexes returns the positions of the key arguments in a full command
+// line of argc elements (including the command name)
+func (c *Command) KeyIndexes(argc int) []int {
+	return keyRange(c.FirstKey, c.LastKey, c.Step, argc)
+}
+
+// Keys returns the key arguments of a full command line with how the
+// command uses each of them
+func (c *Command) Keys(args protocol.ArrayValue) []CommandKey {
+	if len(c.KeySpecs) == 0 {
+		flags := KeyWrite
+		if c.Is(FlagReadOnly) {
+			flags = KeyRead
+		}
+		indexes := c.KeyIndexes(len(args))
+		keys := make([]CommandKey, len(indexes))
+		for i, index := range indexes {
+			keys[i] = CommandKey{Index: index, Flags: flags}
+		}
+		return keys
+	}
+
+	var keys []CommandKey
+	for _, spec := range c.KeySpecs {
+		flags := spec.Flags
+		if spec.Options != nil {
+			flags |= spec.Options(args)
+		}
+		for _, index := range keyRange(spec.FirstKey, spec.LastKey, spec.Step, len(args)) {
+			keys = append(keys, CommandKey{Index: index, Flags: flags})
+		}
+	}
+	return keys
+}
+
+// keyRange returns the positions from first to last (negative counts from
+// the end) every step in a command line of argc elements. A first of 0
+// means no keys.
+func keyRange(first, last, step, argc int) []int {
+	if first <= 0 {
+		return nil
+	}
+	if last < 0 {
+		last = argc + last
+	}
+	if step <= 0 {
+		step = 1
+	}
+	var indexes []int
+	for i := first; i <= last && i < argc; i += step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// CommandTable is a case-insensitive registry of commands, each Server owns one
+type CommandTable struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+// NewCommandTable creates a table holding the given commands
+func NewCommandTable(cmds ...*Command) *CommandTable {
+	t := &CommandTable{commands: make(map[string]*Command, len(cmds))}
+	for _, cmd := range cmds {
+		t.Register(cmd)
+	}
+	return t
+}
+
+// Register adds cmd to the table, replacing any command with the same name
+func (t *CommandTable) Register(cmd *Command) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commands[strings.ToUpper(cmd.Name)] = cmd
+	for _, sub := range cmd.Subcommands {
+		if !strings.Contains(sub.Name, "|") {
+			sub.Name = cmd.Name + "|" + strings.ToUpper(sub.Name)
+		}
+		sub.parent = cmd
+		if sub.Handler == nil {
+			sub.Handler = cmd.Handler
+		}
+		sub.lock = cmd.lock
+		if sub.Categories == nil {
+			sub.Categories = cmd.Categories
+		}
+		if sub.Group == "" {
+			sub.Group = cmd.Group
+		}
+	}
+}
+
+// Unregister removes the named command
+func (t *CommandTable) Unregister(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.commands, strings.ToUpper(name))
+}
+
+// Lookup finds a command by name
+func (t *CommandTable) Lookup(name string) (*Command, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	cmd, ok := t.commands[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+// Len returns the number of registered commands
+func (t *CommandTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.commands)
+}
+
+// Commands returns every registered command sorted by name
+func (t *CommandTable) Commands() []*Command {
+	t.mu.RLock()
+	cmds := make([]*Command, 0, len(t.commands))
+	for _, cmd := range t.commands {
+		cmds = append(cmds, cmd)
+	}
+	t.mu.RUnlock()
+
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"strings"
+	"testing"
+)
+
+// isError reports whether reply is an error starting with prefix
+func isError(reply protocol.ORSPValue, prefix string) bool {
+	err, ok := reply.(protocol.ErrorValue)
+	return ok && strings.HasPrefix(string(err), prefix)
+}
+
+func TestDispatchUnknownCommand(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	reply, _ := s.Do(context.Background(), "NoSuchCmd", "x")
+	if reply != protocol.ErrorValue("ERR unknown command 'NoSuchCmd'") {
+		t.Errorf("got %#v", reply)
+	}
+}
+
+func TestDispatchDenyOOM(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+	if _, err := s.Do(ctx, "CONFIG", "SET", "maxmemory", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := s.Do(ctx, "SET", "k", "v"); !isError(reply, "OOM ") {
+		t.Errorf("SET past maxmemory got %#v, want OOM", reply)
+	}
+	// Commands that don't grow memory still run
+	if reply, err := s.Do(ctx, "GET", "k"); err != nil {
+		t.Errorf("GET past maxmemory got %#v", reply)
+	}
+
+	if _, err := s.Do(ctx, "CONFIG", "SET", "maxmemory", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Do(ctx, "SET", "k", "v"); err != nil {
+		t.Errorf("SET without maxmemory: %v", err)
+	}
+}
+
+func TestDispatchLoading(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	c := dial(t, addr)
+	s.loading.Store(true)
+	defer s.loading.Store(false)
+
+	if reply := c.do("GET", "k"); !isError(reply, "LOADING ") {
+		t.Errorf("GET while loading got %#v, want LOADING", reply)
+	}
+	if reply := c.do("INFO", "server"); isError(reply, "") {
+		t.Errorf("INFO while loading got %#v", reply)
+	}
+	// The AOF replay and embedders aren't clients
+	if _, err := s.Do(context.Background(), "SET", "k", "v"); err != nil {
+		t.Errorf("Do while loading: %v", err)
+	}
+}
+
+func TestDispatchStale(t *testing.T) {
+	s, addr := startServer(t, Config{})
+	c := dial(t, addr)
+	s.stale.Store(true)
+
+	// serve-stale-data defaults to yes
+	if reply := c.do("GET", "k"); isError(reply, "") {
+		t.Errorf("GET on stale data got %#v", reply)
+	}
+	if reply := c.do("CONFIG", "SET", "serve-stale-data", "no"); isError(reply, "") {
+		t.Fatalf("CONFIG SET got %#v", reply)
+	}
+	if reply := c.do("GET", "k"); !isError(reply, "STALE ") {
+		t.Errorf("GET on stale data got %#v, want STALE", reply)
+	}
+	if reply := c.do("PING"); reply != protocol.SimpleStringValue("PONG") {
+		t.Errorf("PING on stale data got %#v", reply)
+	}
+	if reply := c.do("CONFIG", "GET", "serve-stale-data"); isError(reply, "") {
+		t.Errorf("CONFIG GET on stale data got %#v", reply)
+	}
+}
+
+func TestDispatchAdminFromScript(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+	for _, cmd := range []string{"SLOWLOG", "CONFIG"} {
+		reply, _ := s.Do(ctx, "EVAL", "return orion.pcall('"+cmd+"', 'LEN')", "0")
+		if !isError(reply, "ERR This Orion command is not allowed from script") {
+			t.Errorf("%s from a script got %#v", cmd, reply)
+		}
+	}
+}
+
+func TestCommandDocsSorted(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	reply, err := s.Do(context.Background(), "COMMAND", "DOCS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := protocol.AppendValue(nil, reply)
+	for i := 0; i < 10; i++ {
+		if got := protocol.AppendValue(nil, reply); string(got) != string(want) {
+			t.Fatal("COMMAND DOCS is encoded in a different order each time")
+		}
+	}
+
+	// Top-level entries are command names followed by their docs map
+	index := func(name string) int {
+		return strings.Index(string(want), fmt.Sprintf("$%d\r\n%s\r\n%%", len(name), name))
+	}
+	if a, b := index("append"), index("set"); a < 0 || b < 0 || a > b {
+		t.Errorf("append at %d and set at %d, want append first", a, b)
+	}
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+	// The legacy range says every argument is a key, the key specs skip the
+	// option between the two keys, the way the ACL checks see it
+	s.Commands().Register(&Command{Name: "KEYSPECS", Handler: commands.HandlePing, Arity: 4, FirstKey: 1, LastKey: -1, Step: 1,
+		KeySpecs: []KeySpec{{FirstKey: 1, LastKey: 1, Step: 1, Flags: KeyRead}, {FirstKey: 3, LastKey: 3, Step: 1, Flags: KeyWrite}}})
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"KEYSPECS", "src", "OPTION", "dst"}, "src dst"},
+		{[]string{"SDIFFSTORE", "dst", "a", "b"}, "dst a b"},
+		{[]string{"SMOVE", "src", "dst", "member"}, "src dst"},
+		{[]string{"GET", "k"}, "k"},
+	}
+	for _, tt := range tests {
+		reply, err := s.Do(ctx, append([]string{"COMMAND", "GETKEYS"}, tt.args...)...)
+		if err != nil {
+			t.Fatalf("COMMAND GETKEYS %v: %v", tt.args, err)
+		}
+		var keys []string
+		for _, key := range reply.(protocol.ArrayValue) {
+			keys = append(keys, string(key.(protocol.BulkStringValue)))
+		}
+		if strings.Join(keys, " ") != tt.want {
+			t.Errorf("COMMAND GETKEYS %v got %v, want %s", tt.args, keys, tt.want)
+		}
+	}
+
+	for args, want := range map[string]string{
+		"PING":         "ERR The command has no key arguments",
+		"GET":          "ERR Invalid number of arguments specified for command",
+		"NOSUCHCMD k":  "ERR Invalid command specified",
+		"CLIENT LIST":  "ERR The command has no key arguments",
+		"KEYSPECS a b": "ERR Invalid number of arguments specified for command",
+	} {
+		if reply, _ := s.Do(ctx, append([]string{"COMMAND", "GETKEYS"}, strings.Fields(args)...)...); reply != protocol.ErrorValue(want) {
+			t.Errorf("COMMAND GETKEYS %s got %#v, want %q", args, reply, want)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+var (
+	errScriptKilled   = errors.New("script killed")
+	errServerShutdown = errors.New("server shutting down")
+)
+
+// errBusyScript is the reply to commands sent while a script runs past
+// lua-time-limit
+const errBusyScript = "BUSY Orion is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE."
+
+// scriptCache holds compiled Lua scripts by SHA1 digest and tracks the script
+// currently running, if any
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[string]*lua.FunctionProto
+	running *runningScript
+	busy    chan struct{} // closed, then replaced, when a script turns busy
+}
+
+// runningScript is the state SCRIPT KILL needs to stop a script. Its fields
+// are guarded by scriptCache.mu.
+type runningScript struct {
+	cancel context.CancelCauseFunc
+	wrote  bool // set once the script ran a write command
+	busy   bool // set once it ran past lua-time-limit
+}
+
+// load compiles body and caches it, returning its digest
+func (c *scriptCache) load(body string) (string, *lua.FunctionProto, error) {
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if proto, exists := c.scripts[sha]; exists {
+		return sha, proto, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(body), "@user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script: %v", err)
+	}
+	proto, err := lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script: %v", err)
+	}
+
+	if c.scripts == nil {
+		c.scripts = make(map[string]*lua.FunctionProto)
+	}
+	c.scripts[sha] = proto
+	return sha, proto, nil
+}
+
+func (c *scriptCache) lookup(sha string) (*lua.FunctionProto, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	proto, exists := c.scripts[strings.ToLower(sha)]
+	return proto, exists
+}
+
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = nil
+}
+
+func (c *scriptCache) start(cancel context.CancelCauseFunc) *runningScript {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = &runningScript{cancel: cancel}
+	return c.running
+}
+
+func (c *scriptCache) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = nil
+}
+
+func (c *scriptCache) markWrite(script *runningScript) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	script.wrote = true
+}
+
+// markBusy records that script ran past lua-time-limit and wakes up the
+// commands waiting for it, so they can reply BUSY
+func (c *scriptCache) markBusy(script *runningScript) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running != script {
+		return
+	}
+	script.busy = true
+	if c.busy != nil {
+		close(c.busy)
+		c.busy = nil
+	}
+}
+
+// isBusy reports whether a script is running past lua-time-limit
+func (c *scriptCache) isBusy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running != nil && c.running.busy
+}
+
+// busySignal returns a channel closed once a script runs past
+// lua-time-limit, already closed if one does
+func (c *scriptCache) busySignal() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.busy == nil {
+		c.busy = make(chan struct{})
+	}
+	if c.running != nil && c.running.busy {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return c.busy
+}
+
+// abort stops the running script even if it already wrote, for SHUTDOWN
+// NOSAVE
+func (c *scriptCache) abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running != nil {
+		c.running.cancel(errServerShutdown)
+	}
+}
+
+// kill stops the running script unless it already modified the dataset
+func (c *scriptCache) kill() protocol.ORSPValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.running == nil {
+		return protocol.ErrorValue("NOTBUSY No scripts in execution right now.")
+	}
+	if c.running.wrote {
+		return protocol.ErrorValue("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+	c.running.cancel(errScriptKilled)
+	return protocol.SimpleStringValue("OK")
+}
+
+// handleEval implements EVAL script numkeys [key ...] [arg ...]
+func (s *Server) handleEval(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	body, _ := args[0].(protocol.BulkStringValue)
+	_, proto, err := s.scripts.load(string(body))
+	if err != nil {
+		return protocol.ErrorValue(err.Error())
+	}
+	return s.runScript(ctx, proto, args[1:], false)
+}
+
+// handleEvalSha implements EVALSHA sha1 numkeys [key ...] [arg ...]
+func (s *Server) handleEvalSha(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sha, _ := args[0].(protocol.BulkStringValue)
+	proto, exists := s.scripts.lookup(string(sha))
+	if !exists {
+		return protocol.ErrorValue("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return s.runScript(ctx, proto, args[1:], false)
+}
+
+// handleEvalRO implements EVAL_RO, scripts run with it may not call write commands
+func (s *Server) handleEvalRO(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	body, _ := args[0].(protocol.BulkStringValue)
+	_, proto, err := s.scripts.load(string(body))
+	if err != nil {
+		return protocol.ErrorValue(err.Error())
+	}
+	return s.runScript(ctx, proto, args[1:], true)
+}
+
+// handleScript implements SCRIPT LOAD, EXISTS, FLUSH and KILL
+func (s *Server) handleScript(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sub, _ := args[0].(protocol.BulkStringValue)
+
+	switch strings.ToUpper(string(sub)) {
+	case "LOAD":
+		body, _ := args[1].(protocol.BulkStringValue)
+		sha, _, err := s.scripts.load(string(body))
+		if err != nil {
+			return protocol.ErrorValue(err.Error())
+		}
+		return protocol.BulkStringValue(sha)
+
+	case "EXISTS":
+		response := make(protocol.ArrayValue, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			sha, _ := arg.(protocol.BulkStringValue)
+			if _, exists := s.scripts.lookup(string(sha)); exists {
+				response = append(response, protocol.IntegerValue(1))
+			} else {
+				response = append(response, protocol.IntegerValue(0))
+			}
+		}
+		return response
+
+	case "FLUSH":
+		// ASYNC and SYNC are accepted for compatibility, flushing is always immediate
+		if len(args) > 2 {
+			return protocol.ErrorValue("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+		}
+		s.scripts.flush()
+		return protocol.SimpleStringValue("OK")
+
+	case "KILL":
+		return s.scripts.kill()
+
+	default:
+		return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try SCRIPT LOAD, SCRIPT EXISTS, SCRIPT FLUSH or SCRIPT KILL")
+	}
+}
+
+// runScript executes a compiled script. The dispatcher holds the exclusive
+// lock, so no other command interleaves with the script's calls. Past
+// lua-time-limit the script keeps running but other clients get BUSY, so they
+// can stop it with SCRIPT KILL or SHUTDOWN NOSAVE.
+func (s *Server) runScript(ctx *commands.Context, proto *lua.FunctionProto, args []protocol.ORSPValue, readOnly bool) protocol.ORSPValue {
+	numKeysArg, _ := args[0].(protocol.BulkStringValue)
+	numKeys, err := strconv.Atoi(string(numKeysArg))
+	if err != nil {
+		return protocol.ErrorValue("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return protocol.ErrorValue("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-1 {
+		return protocol.ErrorValue("ERR Number of keys can't be greater than number of args")
+	}
+
+	L := newScriptState()
+	defer L.Close()
+
+	runCtx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	L.SetContext(runCtx)
+
+	script := s.scripts.start(cancel)
+	defer s.scripts.finish()
+	if timeLimit := s.Config().LuaTimeLimit; timeLimit > 0 {
+		timer := time.AfterFunc(timeLimit, func() {
+			s.log.Warn("Script running past lua-time-limit, other clients get BUSY until it ends", "limit", timeLimit)
+			s.scripts.markBusy(script)
+		})
+		defer timer.Stop()
+	}
+
+	L.SetGlobal("KEYS", stringTable(L, args[1:1+numKeys]))
+	L.SetGlobal("ARGV", stringTable(L, args[1+numKeys:]))
+	L.SetGlobal("orion", s.orionLib(L, ctx, script, readOnly))
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		switch context.Cause(runCtx) {
+		case errScriptKilled:
+			return protocol.ErrorValue("ERR Script killed by user with SCRIPT KILL...")
+		case errServerShutdown:
+			return protocol.ErrorValue("ERR Script killed, the server is shutting down")
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			if errTable, ok := apiErr.Object.(*lua.LTable); ok {
+				if msg, ok := errTable.RawGetString("err").(lua.LString); ok {
+					return protocol.ErrorValue(string(msg))
+				}
+			}
+			return protocol.ErrorValue("ERR Error running script: " + apiErr.Object.String())
+		}
+		return protocol.ErrorValue("ERR Error running script: " + err.Error())
+	}
+
+	return luaToORSP(L.Get(-1))
+}
+
+// newScriptState returns a Lua state with only the side-effect free libraries
+func newScriptState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// Scripts must not touch the filesystem or the console, compile code the
+	// cache never saw or drive the Go runtime's collector
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "print", "_printregs", "collectgarbage"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// orionLib builds the orion table exposed to scripts
+func (s *Server) orionLib(L *lua.LState, ctx *commands.Context, script *runningScript, readOnly bool) *lua.LTable {
+	call := func(L *lua.LState, raise bool) int {
+		reply := s.scriptCall(L, ctx, script, readOnly)
+		if errValue, failed := reply.(protocol.ErrorValue); failed && raise {
+			L.Error(errorTable(L, string(errValue)), 1)
+			return 0
+		}
+		L.Push(orspToLua(L, reply))
+		return 1
+	}
+
+	return L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return call(L, true) },
+		"pcall": func(L *lua.LState) int { return call(L, false) },
+		"error_reply": func(L *lua.LState) int {
+			L.Push(errorTable(L, L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			status := L.NewTable()
+			status.RawSetString("ok", lua.LString(L.CheckString(1)))
+			L.Push(status)
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			sum := sha1.Sum([]byte(L.CheckString(1)))
+			L.Push(lua.LString(hex.EncodeToString(sum[:])))
+			return 1
+		},
+	})
+}
+
+// scriptCall runs one orion.call from a script through the command table and
+// the hook chain, so its effects reach the AOF like any other write
+func (s *Server) scriptCall(L *lua.LState, ctx *commands.Context, script *runningScript, readOnly bool) protocol.ORSPValue {
+	if L.GetTop() == 0 {
+		return protocol.ErrorValue("ERR Please specify at least one argument for this orion lib call")
+	}
+
+	command := make(protocol.ArrayValue, L.GetTop())
+	for i := range command {
+		switch arg := L.Get(i + 1).(type) {
+		case lua.LString:
+			command[i] = protocol.BulkStringValue(arg)
+		case lua.LNumber:
+			command[i] = protocol.BulkStringValue(arg.String())
+		default:
+			return protocol.ErrorValue("ERR Lua orion lib command arguments must be strings or integers")
+		}
+	}
+
+	name, _ := command[0].(protocol.BulkStringValue)
+	cmd, exists := s.commands.Lookup(string(name))
+	if !exists {
+		return protocol.ErrorValue("ERR Unknown Orion command called from script")
+	}
+	cmd = cmd.resolve(command)
+	if readOnly && cmd.Is(FlagWrite) {
+		return protocol.ErrorValue("ERR Write commands are not allowed from read-only scripts.")
+	}
+	if !cmd.CheckArity(len(command)) {
+		return protocol.ErrorValue(cmd.ArityError())
+	}
+
+	scriptCtx := &commands.Context{Instance: ctx.Instance, ID: ctx.ID, Addr: ctx.Addr, LocalAddr: ctx.LocalAddr, Name: ctx.Name, User: ctx.User, Script: true}
+	reply := s.execute(scriptCtx, &Call{Command: cmd, Args: command})
+	// A refused write leaves the dataset alone, so the script stays killable
+	if _, failed := reply.(protocol.ErrorValue); cmd.Is(FlagWrite) && !failed {
+		s.scripts.markWrite(script)
+	}
+	return reply
+}
+
+func errorTable(L *lua.LState, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("err", lua.LString(msg))
+	return t
+}
+
+func stringTable(L *lua.LState, values []protocol.ORSPValue) *lua.LTable {
+	t := L.CreateTable(len(values), 0)
+	for _, v := range values {
+		str, _ := v.(protocol.BulkStringValue)
+		t.Append(lua.LString(str))
+	}
+	return t
+}
+
+// orspToLua converts a command reply to a Lua value
+func orspToLua(L *lua.LState, value protocol.ORSPValue) lua.LValue {
+	switch v := value.(type) {
+	case protocol.IntegerValue:
+		return lua.LNumber(v)
+	case protocol.BulkStringValue:
+		return lua.LString(v)
+	case protocol.SimpleStringValue:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(v))
+		return t
+	case protocol.ErrorValue:
+		return errorTable(L, string(v))
+	case protocol.NullValue:
+		return lua.LFalse
+	case protocol.BooleanValue:
+		return lua.LBool(v)
+	case protocol.DoubleValue:
+		return lua.LNumber(v)
+	case protocol.ArrayValue:
+		return arrayTable(L, v)
+	case protocol.SetValue:
+		return arrayTable(L, v)
+	case protocol.AttributeValue:
+		// Scripts only see the reply, not its metadata
+		return orspToLua(L, v.Value)
+	case protocol.MapValue:
+		// Maps become flat field/value arrays with fields in sorted order
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		t := L.CreateTable(2*len(fields), 0)
+		for _, field := range fields {
+			t.Append(lua.LString(field))
+			t.Append(orspToLua(L, v[field]))
+		}
+		return t
+	default:
+		return lua.LString(value.Marshal())
+	}
+}
+
+func arrayTable(L *lua.LState, values []protocol.ORSPValue) *lua.LTable {
+	t := L.CreateTable(len(values), 0)
+	for _, v := range values {
+		t.Append(orspToLua(L, v))
+	}
+	return t
+}
+
+// luaToORSP converts a script's return value to a reply
+func luaToORSP(value lua.LValue) protocol.ORSPValue {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return protocol.IntegerValue(int64(math.Trunc(float64(v))))
+	case lua.LString:
+		return protocol.BulkStringValue(v)
+	case lua.LBool:
+		if v {
+			return protocol.IntegerValue(1)
+		}
+		return protocol.NullValue{}
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return protocol.ErrorValue(msg)
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return protocol.SimpleStringValue(status)
+		}
+		// Arrays stop at the first nil, like Lua's own length operator
+		response := protocol.ArrayValue{}
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			response = append(response, luaToORSP(item))
+		}
+		return response
+	default:
+		return protocol.NullValue{}
+	}
+}
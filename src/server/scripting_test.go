@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"orion/src/protocol"
+)
+
+func TestEval(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+
+	reply, err := s.Do(ctx, "EVAL", "orion.call('SET', KEYS[1], ARGV[1]) return orion.call('GET', KEYS[1])", "1", "k", "v")
+	if err != nil || reply != protocol.BulkStringValue("v") {
+		t.Errorf("EVAL got %#v, %v", reply, err)
+	}
+	reply, _ = s.Do(ctx, "EVAL", "return {1, 'two', {3}}", "0")
+	want := protocol.ArrayValue{protocol.IntegerValue(1), protocol.BulkStringValue("two"), protocol.ArrayValue{protocol.IntegerValue(3)}}
+	if reply.Marshal() != want.Marshal() {
+		t.Errorf("EVAL returning a table got %#v", reply)
+	}
+	if reply, _ := s.Do(ctx, "EVAL", "return orion.call('NOSUCH')", "0"); !isError(reply, "ERR Unknown Orion command") {
+		t.Errorf("calling an unknown command got %#v", reply)
+	}
+	if reply, _ := s.Do(ctx, "EVAL", "return 1", "2", "k"); !isError(reply, "ERR Number of keys") {
+		t.Errorf("numkeys past the arguments got %#v", reply)
+	}
+}
+
+func TestEvalShaAndScriptCache(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+
+	sha, err := s.Do(ctx, "SCRIPT", "LOAD", "return ARGV[1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := string(sha.(protocol.BulkStringValue))
+	if reply, _ := s.Do(ctx, "EVALSHA", strings.ToUpper(digest), "0", "hi"); reply != protocol.BulkStringValue("hi") {
+		t.Errorf("EVALSHA got %#v", reply)
+	}
+	reply, _ := s.Do(ctx, "SCRIPT", "EXISTS", digest, "0000000000000000000000000000000000000000")
+	if reply.Marshal() != (protocol.ArrayValue{protocol.IntegerValue(1), protocol.IntegerValue(0)}).Marshal() {
+		t.Errorf("SCRIPT EXISTS got %#v", reply)
+	}
+
+	if _, err := s.Do(ctx, "SCRIPT", "FLUSH"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := s.Do(ctx, "EVALSHA", digest, "0"); !isError(reply, "NOSCRIPT ") {
+		t.Errorf("EVALSHA after SCRIPT FLUSH got %#v", reply)
+	}
+	if reply, _ := s.Do(ctx, "SCRIPT", "KILL"); !isError(reply, "NOTBUSY ") {
+		t.Errorf("SCRIPT KILL with no script got %#v", reply)
+	}
+	if reply, _ := s.Do(ctx, "SCRIPT", "KILL", "now"); !isError(reply, "ERR wrong number of arguments for 'script|kill'") {
+		t.Errorf("SCRIPT KILL with an argument got %#v", reply)
+	}
+}
+
+func TestEvalRO(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+	s.Do(ctx, "SET", "k", "v")
+
+	if reply, _ := s.Do(ctx, "EVAL_RO", "return orion.call('GET', KEYS[1])", "1", "k"); reply != protocol.BulkStringValue("v") {
+		t.Errorf("EVAL_RO reading got %#v", reply)
+	}
+	if reply, _ := s.Do(ctx, "EVAL_RO", "return orion.call('SET', KEYS[1], 'x')", "1", "k"); !isError(reply, "ERR Write commands are not allowed from read-only scripts") {
+		t.Errorf("EVAL_RO writing got %#v", reply)
+	}
+	if reply, _ := s.Do(ctx, "GET", "k"); reply != protocol.BulkStringValue("v") {
+		t.Errorf("EVAL_RO changed k to %#v", reply)
+	}
+}
+
+func TestScriptSandbox(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	for _, name := range []string{"print", "load", "loadstring", "dofile", "loadfile", "require", "collectgarbage"} {
+		reply, err := s.Do(context.Background(), "EVAL", "return type("+name+")", "0")
+		if err != nil || reply != protocol.BulkStringValue("nil") {
+			t.Errorf("%s is a %#v in scripts, want nil", name, reply)
+		}
+	}
+}
+
+// startLoop runs a script that never ends on a connection of its own and
+// waits until it runs past lua-time-limit
+func startLoop(t *testing.T, s *Server, addr, script string) *testClient {
+	t.Helper()
+	c := dial(t, addr)
+	c.send("EVAL", script, "0")
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.scripts.isBusy() {
+		if time.Now().After(deadline) {
+			t.Fatal("the script never turned busy")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return c
+}
+
+func TestScriptBusyAndKill(t *testing.T) {
+	_, addr := startServer(t, Config{LuaTimeLimit: 50 * time.Millisecond})
+
+	// A command sent before the script turns busy waits for it, then gets
+	// BUSY too
+	runner := dial(t, addr)
+	runner.send("EVAL", "while true do end", "0")
+	waiting := dial(t, addr)
+	time.Sleep(10 * time.Millisecond)
+	waiting.send("GET", "k")
+	if reply := waiting.read(); !isError(reply, "BUSY ") {
+		t.Errorf("GET waiting for the script got %#v, want BUSY", reply)
+	}
+
+	other := dial(t, addr)
+	if reply := other.do("PING"); !isError(reply, "BUSY ") {
+		t.Errorf("PING during a busy script got %#v, want BUSY", reply)
+	}
+	if reply := other.do("SHUTDOWN"); !isError(reply, "BUSY ") {
+		t.Errorf("SHUTDOWN without NOSAVE during a busy script got %#v, want BUSY", reply)
+	}
+	if reply := other.do("SCRIPT", "KILL"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("SCRIPT KILL got %#v", reply)
+	}
+	if reply := runner.read(); !isError(reply, "ERR Script killed by user") {
+		t.Errorf("the killed script replied %#v", reply)
+	}
+	if reply := other.do("PING"); reply != protocol.SimpleStringValue("PONG") {
+		t.Errorf("PING after SCRIPT KILL got %#v", reply)
+	}
+}
+
+func TestScriptUnkillableShutdownNoSave(t *testing.T) {
+	s, addr := startServer(t, Config{LuaTimeLimit: 50 * time.Millisecond})
+	runner := startLoop(t, s, addr, "orion.call('SET', 'k', 'v') while true do end")
+
+	other := dial(t, addr)
+	if reply := other.do("SCRIPT", "KILL"); !isError(reply, "UNKILLABLE ") {
+		t.Errorf("SCRIPT KILL after a write got %#v, want UNKILLABLE", reply)
+	}
+	if reply := other.do("SHUTDOWN", "NOSAVE"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("SHUTDOWN NOSAVE got %#v", reply)
+	}
+	if reply := runner.read(); !isError(reply, "ERR Script killed") {
+		t.Errorf("the script replied %#v during SHUTDOWN NOSAVE", reply)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SHUTDOWN NOSAVE didn't stop the server")
+	}
+}
+
+// Scripts reach the AOF as the writes they made, so replaying it doesn't
+// depend on the script cache or on the script being deterministic
+func TestScriptEffectsReplicated(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := startServer(t, Config{Dir: dir, AppendOnly: true})
+	ctx := context.Background()
+	if _, err := s.Do(ctx, "EVAL", "orion.call('SET', KEYS[1], ARGV[1]) orion.call('GET', KEYS[1])", "1", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := s.Do(ctx, "EVAL", "orion.call('SET', 'never', 'written') return orion.call('INCR', 'k')", "0"); !isError(reply, "ERR") {
+		t.Fatalf("INCR of a string in a script got %#v", reply)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	aof, err := os.ReadFile(filepath.Join(dir, DefaultConfig().AppendFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(aof), "EVAL") || strings.Contains(string(aof), "GET") {
+		t.Errorf("the AOF holds the script or its reads:\n%q", aof)
+	}
+	if !strings.Contains(string(aof), "$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n") {
+		t.Errorf("the AOF lacks the script's write:\n%q", aof)
+	}
+
+	restarted, _ := startServer(t, Config{Dir: dir, AppendOnly: true})
+	if reply, _ := restarted.Do(ctx, "GET", "k"); reply != protocol.BulkStringValue("v") {
+		t.Errorf("GET k after replaying the AOF got %#v", reply)
+	}
+	if reply, _ := restarted.Do(ctx, "GET", "never"); reply != protocol.BulkStringValue("written") {
+		t.Errorf("GET never after replaying the AOF got %#v, writes before a failing call stay", reply)
+	}
+}
@@ -1,105 +1,753 @@
 package server
 
 import (
-	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"orion/src/aof"
+	"orion/src/commands"
+	"orion/src/data"
+	"orion/src/logging"
+	"orion/src/persistence"
 	"orion/src/protocol"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-// StartServer initializes the TCP server
-func StartServer(port string) {
-	// Initialize logging system
-	err := InitLogging()
-	if err != nil {
-		fmt.Println("Error initializing logging system:", err)
-		return
+// ErrServerClosed is returned by Do and Serve once Close or Shutdown has been
+// called
+var ErrServerClosed = errors.New("orion: server closed")
+
+// dbIndex is the index of Orion's only database. There is no SELECT, every
+// client works on it, so MONITOR, CLIENT LIST and INFO always report it.
+const dbIndex = 0
+
+// Config describes how a Server is set up
+type Config struct {
+	// Port is the TCP port StartServer listens on, zero disables TCP
+	Port int
+	// Bind lists the addresses the TCP port is bound to. '*' is every IPv4
+	// interface, '::*' every IPv6 one and a '-' prefix makes an address
+	// optional.
+	Bind []string
+	// UnixSocket is the path of a Unix domain socket to listen on as well
+	UnixSocket string
+	// UnixSocketPerm sets the permissions of UnixSocket, zero leaves them
+	// to the umask
+	UnixSocketPerm os.FileMode
+	// TLSPort is the port StartServer accepts TLS connections on, zero
+	// disables TLS
+	TLSPort int
+	// TLSCertFile and TLSKeyFile hold the server certificate and its key
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSCACertFile holds the CA certificates client certificates are
+	// verified against
+	TLSCACertFile string
+	// TLSAuthClients is "yes" to require a client certificate, "optional" to
+	// verify one if given and "no" to never ask for one
+	TLSAuthClients string
+	// MetricsPort is the HTTP port StartServer serves /metrics, /healthz and
+	// /readyz on, zero disables it
+	MetricsPort int
+	// MetricsBind is the address the metrics port is bound to
+	MetricsBind string
+	// Dir is the directory holding the AOF and snapshot files
+	Dir string
+	// LogDir is the directory StartServer writes its log files to
+	LogDir string
+	// LogLevel is the least severe level logged: debug, verbose, notice or
+	// warning
+	LogLevel string
+	// LogFormat is text or json
+	LogFormat string
+	// LogRotation rotates the log files hourly, daily or never (none)
+	LogRotation string
+	// LogMaxSize also rotates a log file once it reaches this many bytes,
+	// zero disables it
+	LogMaxSize int
+	// LogMaxAge removes rotated log files older than this, zero keeps them
+	LogMaxAge time.Duration
+	// LogMaxFiles is how many rotated files are kept per log file, zero
+	// keeps them all
+	LogMaxFiles int
+	// LogCompress gzips rotated log files
+	LogCompress bool
+	// SyslogEnabled also logs to the local syslog daemon under SyslogIdent
+	// and SyslogFacility
+	SyslogEnabled  bool
+	SyslogIdent    string
+	SyslogFacility string
+	// AppendOnly enables AOF persistence
+	AppendOnly bool
+	// AppendFilename is the name of the AOF inside Dir
+	AppendFilename string
+	// DBFilename names BGSAVE snapshots inside Dir, each gets a timestamp
+	// before the extension
+	DBFilename string
+	// Hz is how many times per second expired keys are removed
+	Hz int
+	// Modules lists the compiled-in modules to enable
+	Modules []string
+	// LuaTimeLimit is how long a script runs before other clients get BUSY
+	// and may stop it with SCRIPT KILL, zero disables the limit
+	LuaTimeLimit time.Duration
+	// ProtoMaxBulkLen is the largest bulk string a client may send
+	ProtoMaxBulkLen int
+	// ProtoMaxMultibulkLen is the largest number of arguments a client may send
+	ProtoMaxMultibulkLen int
+	// ClientOutputBufferLimit disconnects clients that don't read their
+	// replies fast enough, zero values disable it
+	ClientOutputBufferLimit OutputBufferLimit
+	// RequirePass is the password of the default user, empty for none
+	RequirePass string
+	// ACLFile stores the ACL users, ACL LOAD and ACL SAVE read and write it
+	ACLFile string
+	// ACLLogMaxLen is how many denied attempts ACL LOG keeps
+	ACLLogMaxLen int
+	// MaxClients is how many connections are served at once, more are
+	// refused with an error
+	MaxClients int
+	// Timeout closes connections idle for this long, zero disables it
+	Timeout time.Duration
+	// ClientQueryTimeout closes connections that take longer than this to
+	// send a whole request once it started, zero disables it
+	ClientQueryTimeout time.Duration
+	// TCPKeepAlive is the interval of TCP keepalive probes, zero disables
+	// them
+	TCPKeepAlive time.Duration
+	// RateLimits throttle the clients they match, see RateLimit
+	RateLimits []RateLimit
+	// RateLimitMode is "reject" to refuse commands over a rate limit with a
+	// BUSY error or "delay" to hold them until the budget allows them
+	RateLimitMode string
+	// SlowlogLogSlowerThan is the running time from which commands are
+	// recorded in SLOWLOG, a negative value records none. Zero means the
+	// default, any value under a microsecond records every command and reads
+	// as 0 in CONFIG GET.
+	SlowlogLogSlowerThan time.Duration
+	// SlowlogMaxLen is how many commands SLOWLOG keeps
+	SlowlogMaxLen int
+	// LatencyMonitorThreshold is the latency from which LATENCY samples
+	// internal events and commands, zero disables the latency monitor
+	LatencyMonitorThreshold time.Duration
+	// MaxMemory refuses commands flagged denyoom once the heap holds more
+	// than this many bytes, zero disables the limit
+	MaxMemory int
+	// RefuseStaleData refuses client commands not flagged stale when the
+	// AOF or snapshot failed to load and the dataset may be incomplete. CONFIG calls it
+	// serve-stale-data, with the opposite value.
+	RefuseStaleData bool
+	// ShutdownTimeout is how long a graceful shutdown waits for clients to
+	// finish before closing their connections
+	ShutdownTimeout time.Duration
+
+	// Logger receives the server's messages, each with a subsystem
+	// attribute, and commands at the debug level. Nil logs to the
+	// process-wide logs that StartServer sets up, so embedders running
+	// several servers in one process should give each its own logger.
+	Logger *slog.Logger
+
+	// ConfigFile is the orion.conf the configuration was loaded from,
+	// CONFIG REWRITE updates it
+	ConfigFile string
+}
+
+// DefaultConfig returns the configuration used by the standalone server
+func DefaultConfig() Config {
+	return Config{
+		Port:           6379,
+		Bind:           []string{"*", "-::*"},
+		TLSAuthClients: "yes",
+		MetricsBind:    "127.0.0.1",
+		Dir:            ".",
+		LogDir:         "logs",
+		LogLevel:       "notice",
+		LogFormat:      "text",
+		LogRotation:    "daily",
+		LogMaxSize:     100 * 1024 * 1024,
+		LogMaxFiles:    14,
+		LogCompress:    true,
+		SyslogIdent:    "orion",
+		SyslogFacility: "local0",
+		AppendOnly:     true,
+		AppendFilename: "appendonly.orion",
+		DBFilename:     "dump.orion",
+		Hz:             1,
+		Modules:        AvailableModules(),
+		LuaTimeLimit:   5 * time.Second,
+
+		ShutdownTimeout: 10 * time.Second,
+		ACLLogMaxLen:    128,
+
+		MaxClients:         10000,
+		ClientQueryTimeout: 60 * time.Second,
+		TCPKeepAlive:       300 * time.Second,
+		RateLimitMode:      "reject",
+
+		SlowlogLogSlowerThan: 10 * time.Millisecond,
+		SlowlogMaxLen:        128,
+
+		ProtoMaxBulkLen:      protocol.DefaultMaxBulkLen,
+		ProtoMaxMultibulkLen: protocol.DefaultMaxMultibulkLen,
 	}
-	defer CloseLogFiles()
+}
 
-	// Initialize AOF
-	err = aof.InitAOF()
-	if err != nil {
-		LogError("Error initializing AOF: %v", err)
-		return
+// Server is a self-contained Orion instance. It owns its data store, AOF,
+// expiry loop and command table, so several servers can live in one process.
+type Server struct {
+	configMu     sync.RWMutex // guards config against CONFIG SET
+	config       Config
+	runID        string // random ID of this run, shown by INFO
+	stats        serverStats
+	log          *slog.Logger // the server's own messages
+	netLog       *slog.Logger // listeners and client connections
+	cmdLog       *slog.Logger // commands, nil when they go to commands.log
+	slowlog      slowLog
+	latency      latencyMonitor
+	monitors     monitorFeed
+	commandStats commandMetrics
+	instance     *commands.Instance
+	commands     *CommandTable
+	hooks        hookChain
+	acl          *aclRegistry
+	loading      atomic.Bool // true while the AOF is being replayed
+	stale        atomic.Bool // true when the AOF or snapshot failed to load
+	serveStale   atomic.Bool
+	maxMemory    atomic.Int64
+
+	modules   []loadedModule
+	keyEvents keyEventBus
+
+	execMu  sync.RWMutex // held shared by commands, exclusively by scripts
+	scripts scriptCache
+
+	nextClientID atomic.Int64
+	timeouts     atomic.Pointer[connTimeouts]
+	limiter      rateLimiter
+	clients      clientRegistry
+	pause        clientPause
+
+	tlsConfig atomic.Pointer[tls.Config] // certificates served on the TLS port
+
+	mu        sync.Mutex
+	closed    bool
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	connWG    sync.WaitGroup
+
+	stopped      atomic.Bool // set once the last command has run
+	shutdownOnce sync.Once
+	shutdownErr  error
+	done         chan struct{}
+}
+
+// New creates a Server from cfg and restores its state from the AOF
+func New(cfg Config) (*Server, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "."
+	}
+	if cfg.AppendFilename == "" {
+		cfg.AppendFilename = DefaultConfig().AppendFilename
+	}
+	if cfg.DBFilename == "" {
+		cfg.DBFilename = DefaultConfig().DBFilename
+	}
+	if cfg.Hz <= 0 {
+		cfg.Hz = DefaultConfig().Hz
+	}
+	if cfg.ProtoMaxBulkLen <= 0 {
+		cfg.ProtoMaxBulkLen = protocol.DefaultMaxBulkLen
+	}
+	if cfg.ProtoMaxMultibulkLen <= 0 {
+		cfg.ProtoMaxMultibulkLen = protocol.DefaultMaxMultibulkLen
+	}
+	if cfg.TLSAuthClients == "" {
+		cfg.TLSAuthClients = DefaultConfig().TLSAuthClients
+	}
+	if cfg.ACLLogMaxLen <= 0 {
+		cfg.ACLLogMaxLen = DefaultConfig().ACLLogMaxLen
+	}
+	if cfg.RequirePass != "" && cfg.ACLFile != "" {
+		return nil, errors.New("requirepass can't be used together with aclfile, set the default user's password in the aclfile instead")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = DefaultConfig().ShutdownTimeout
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = DefaultConfig().MaxClients
+	}
+	if cfg.SlowlogLogSlowerThan == 0 {
+		cfg.SlowlogLogSlowerThan = DefaultConfig().SlowlogLogSlowerThan
+	}
+	if cfg.SlowlogMaxLen <= 0 {
+		cfg.SlowlogMaxLen = DefaultConfig().SlowlogMaxLen
+	}
+	if cfg.RateLimitMode == "" {
+		cfg.RateLimitMode = DefaultConfig().RateLimitMode
+	}
+	if cfg.RateLimitMode != "reject" && cfg.RateLimitMode != "delay" {
+		return nil, fmt.Errorf("invalid ratelimit-mode '%s', it must be reject or delay", cfg.RateLimitMode)
 	}
 
-	// Load AOF to restore state
-	LogInfo("Loading AOF data...")
-	err = aof.LoadAOF(func(command protocol.ArrayValue) error {
-		response := HandleCommand(command)
-		if errValue, ok := response.(protocol.ErrorValue); ok {
-			LogError("Warning: Error handling command %v: %s", command, string(errValue))
-			// Continue loading instead of returning an error
-			return nil
+	var aofLog *aof.AOF
+	if cfg.AppendOnly {
+		var err error
+		aofLog, err = aof.Open(filepath.Join(cfg.Dir, cfg.AppendFilename))
+		if err != nil {
+			return nil, fmt.Errorf("error initializing AOF: %w", err)
 		}
-		return nil
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Logger()
+	}
+	aofLog.SetLogger(logger.With("subsystem", "aof"))
+
+	s := &Server{
+		config: cfg,
+		log:    logger.With("subsystem", "server"),
+		netLog: logger.With("subsystem", "net"),
+		instance: &commands.Instance{
+			Store: data.NewDataStore(),
+			AOF:   aofLog,
+			Dir:   cfg.Dir,
+
+			DBFilename: cfg.DBFilename,
+			Logger:     logger,
+		},
+		commands: NewCommandTable(DefaultCommands()...),
+		acl:      newACLRegistry(),
+		done:     make(chan struct{}),
+		runID:    newRunID(),
+	}
+	for _, cmd := range s.serverCommands() {
+		s.commands.Register(cmd)
+	}
+	s.instance.Store.SetLogger(logger.With("subsystem", "store"))
+	if cfg.Logger != nil {
+		s.cmdLog = logger.With("subsystem", "commands")
+	}
+	s.setTimeouts(&cfg)
+	s.setRateLimits(&cfg)
+	if err := s.setSlowlog(&cfg); err != nil {
+		s.instance.Store.Close()
+		aofLog.Close()
+		return nil, err
+	}
+	s.instance.OnLatency = s.latency.record
+	s.setLatencyMonitor(&cfg)
+	s.setMaxMemory(&cfg)
+	s.setServeStale(&cfg)
+
+	// ACL runs ahead of every other hook so denied commands have no effect
+	s.AddHook(s.aclHook())
+	s.AddHook(s.flagsHook())
+	s.acl.setDefaultPassword(cfg.RequirePass)
+
+	s.instance.Store.SetHz(cfg.Hz)
+	s.AddHook(s.keyEventHook())
+	s.instance.Store.OnExpire(func(key string) {
+		s.keyEvents.publish("expired", key)
 	})
 
-	if err != nil {
-		LogError("Error loading AOF: %v", err)
-		// Consider whether you want to continue starting the server or exit here
-	} else {
-		LogInfo("AOF data loaded successfully.")
+	// Modules must be loaded before the AOF so their commands can be replayed,
+	// and before the aclfile so its rules can name them
+	if err := s.loadModules(cfg.Modules); err != nil {
+		s.instance.Store.Close()
+		aofLog.Close()
+		return nil, err
+	}
+	if cfg.ACLFile != "" {
+		if err := s.acl.loadFile(cfg.ACLFile, s.commands); err != nil {
+			s.instance.Store.Close()
+			aofLog.Close()
+			return nil, fmt.Errorf("error loading aclfile: %w", err)
+		}
 	}
 
-	listener, err := net.Listen("tcp", ":"+port)
-	if err != nil {
-		LogError("Error starting server: %v", err)
+	// The AOF holds every write, so the snapshots are only read without it
+	if aofLog == nil {
+		s.loadSnapshot()
+	}
+
+	if aofLog != nil {
+		s.AddHook(s.aofHook())
+
+		// Load AOF to restore state
+		s.log.Info("Loading AOF data")
+		s.loading.Store(true)
+		defer s.loading.Store(false)
+		ctx := s.newContext()
+		err := aofLog.Load(func(command protocol.ArrayValue) error {
+			response := s.HandleCommand(ctx, command)
+			if errValue, ok := response.(protocol.ErrorValue); ok {
+				s.log.Warn("Error replaying AOF command", "command", commandToString(bulkString(command[0]), command[1:]), "err", string(errValue))
+				// Continue loading instead of returning an error
+				return nil
+			}
+			return nil
+		})
+
+		if err != nil {
+			s.log.Error("Error loading AOF, the dataset may be incomplete", "err", err)
+			s.stale.Store(true)
+		} else {
+			s.log.Info("AOF data loaded successfully")
+		}
+	}
+
+	return s, nil
+}
+
+// loadSnapshot restores the newest snapshot in the data directory. A
+// snapshot that fails to load leaves the dataset stale, as a broken AOF does.
+func (s *Server) loadSnapshot() {
+	filename, ok := s.instance.LatestSnapshot()
+	if !ok {
 		return
 	}
-	defer listener.Close()
+	s.log.Info("Loading snapshot", "file", filename)
+	s.loading.Store(true)
+	defer s.loading.Store(false)
+	start := time.Now()
+	if err := persistence.LoadFromFile(s.instance.Store, filename); err != nil {
+		s.log.Error("Error loading snapshot, the dataset may be incomplete", "file", filename, "err", err)
+		s.stale.Store(true)
+		return
+	}
+	s.log.Info("Snapshot loaded", "file", filename, "duration", time.Since(start))
+}
+
+// newRunID returns 40 random hex characters
+func newRunID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newContext returns a fresh handler context bound to this server
+func (s *Server) newContext() *commands.Context {
+	return &commands.Context{Instance: s.instance, Protocol: protocol.RESP2}
+}
+
+// Do executes a single command in-process and returns its reply. Replies use
+// the RESP3 types whatever protocol HELLO selects. Error replies are also
+// returned as the error value.
+//
+// ctx is only checked before the command starts. Embedded calls skip CLIENT
+// PAUSE, but one waiting for a running script blocks whatever ctx's
+// deadline, until the script ends or passes lua-time-limit and the call
+// gets BUSY.
+func (s *Server) Do(ctx context.Context, args ...string) (protocol.ORSPValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.isClosed() || s.stopped.Load() {
+		return nil, ErrServerClosed
+	}
+
+	command := make(protocol.ArrayValue, len(args))
+	for i, arg := range args {
+		command[i] = protocol.BulkStringValue(arg)
+	}
+
+	response := s.HandleCommand(s.newContext(), command)
+	if errValue, ok := response.(protocol.ErrorValue); ok {
+		return response, errValue
+	}
+	return response, nil
+}
 
-	LogInfo("Server is running and listening on port %s", port)
+// Commands returns the server's command table
+func (s *Server) Commands() *CommandTable {
+	return s.commands
+}
+
+// Close shuts the server down without a final snapshot. Clients get up to
+// shutdown-timeout to finish before their connections are closed.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config().ShutdownTimeout)
+	defer cancel()
+	return s.Shutdown(ctx, ShutdownNoSave)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Serve accepts connections on listener until the server is closed
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			LogError("Error accepting connection: %v", err)
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			s.netLog.Error("Error accepting connection", "err", err)
 			continue
 		}
-		go handleConnection(conn)
+		if !s.trackConn(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// StartServer initializes the TCP server and runs it until ctx is done, the
+// process receives SIGINT or SIGTERM or a client sends SHUTDOWN, then shuts it
+// down gracefully. A second signal exits immediately. SIGHUP reloads the TLS
+// certificates. The returned error is
+// nil only when the shutdown completed cleanly.
+func StartServer(ctx context.Context, cfg Config) error {
+	// Initialize logging system
+	err := logging.Setup(cfg.logConfig())
+	if err != nil {
+		return fmt.Errorf("error initializing logging system: %w", err)
+	}
+	defer logging.Close()
+
+	// The metrics port is up while the AOF loads so /readyz can report it
+	endpoint := &metricsEndpoint{}
+	if cfg.MetricsPort != 0 {
+		metrics, err := serveMetrics(cfg, endpoint)
+		if err != nil {
+			LogError("Error starting metrics server: %v", err)
+			return fmt.Errorf("error starting metrics server: %w", err)
+		}
+		defer metrics.Close()
+	}
+
+	s, err := New(cfg)
+	if err != nil {
+		LogError("%v", err)
+		return err
+	}
+	endpoint.server.Store(s)
+
+	if cfg.TLSPort != 0 {
+		if err := s.enableTLS(cfg); err != nil {
+			s.log.Error("Error starting server", "err", err)
+			s.Close()
+			return fmt.Errorf("error starting server: %w", err)
+		}
+	}
+
+	listeners, err := listen(cfg, s.listenerTLSConfig(), s.netLog)
+	if err != nil {
+		s.log.Error("Error starting server", "err", err)
+		s.Close()
+		return fmt.Errorf("error starting server: %w", err)
+	}
+
+	if cfg.ConfigFile != "" {
+		s.log.Info("Configuration loaded", "file", cfg.ConfigFile)
+	}
+	for _, listener := range listeners {
+		s.netLog.Info("Server is running and listening", "network", listener.Addr().Network(), "addr", listener.Addr().String())
+	}
+	if cfg.TLSPort != 0 {
+		s.netLog.Info("TLS enabled", "port", cfg.TLSPort)
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for _, listener := range listeners {
+		go s.Serve(listener)
+	}
+
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.Info("Shutdown requested, shutting down")
+			break wait
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := s.reloadTLS(s.Config()); err != nil {
+					s.log.Error("Error reloading TLS certificates", "err", err)
+				}
+				continue
+			}
+			s.log.Info("Received signal, shutting down", "signal", sig.String())
+			break wait
+		case <-s.Done():
+			break wait
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					continue
+				}
+				s.log.Error("Received signal during shutdown, exiting now", "signal", sig.String())
+				logging.Close()
+				os.Exit(1)
+			case <-s.Done():
+				return
+			}
+		}
+	}()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config().ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx, ShutdownDefault); err != nil {
+		s.log.Error("Shutdown failed", "err", err)
+		return err
 	}
+	s.log.Info("Orion is now ready to exit, bye bye...")
+	return nil
 }
 
-func handleConnection(conn net.Conn) {
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.untrackConn(conn)
 	defer conn.Close()
 
-	clientAddr := conn.RemoteAddr().String()
-	LogInfo("New connection from %s", clientAddr)
+	family, clientAddr := connFamily(conn)
+	cfg := s.Config()
+	if s.connCount() > cfg.MaxClients {
+		s.netLog.Warn("Connection refused, maxclients reached", "addr", clientAddr, "maxclients", cfg.MaxClients)
+		s.stats.rejectedConnections.Add(1)
+		rejectClient(conn)
+		return
+	}
+	s.netLog.Log(context.Background(), logging.LevelVerbose, "Client connected", "addr", clientAddr, "family", family)
+	setKeepAlive(conn, cfg.TCPKeepAlive)
+
+	s.stats.connectionsReceived.Add(1)
+	ctx := s.newContext()
+	ctx.ID = s.nextClientID.Add(1)
+	ctx.Addr = clientAddr
+	ctx.LocalAddr = conn.LocalAddr().String()
+	ctx.Family = family
+	ctx.User = s.acl.autoUser()
+	reader := &deadlineReader{conn: conn, timeouts: s.timeouts.Load, closing: s.isClosed}
+	decoder := protocol.NewDecoder(reader)
+	decoder.SetLimits(cfg.ProtoMaxBulkLen, cfg.ProtoMaxMultibulkLen)
+	output := newClientOutput(conn, cfg.ClientOutputBufferLimit)
 
-	reader := bufio.NewReader(conn)
+	now := time.Now()
+	c := &client{
+		id:       ctx.ID,
+		conn:     conn,
+		output:   output,
+		family:   family,
+		addr:     clientAddr,
+		laddr:    ctx.LocalAddr,
+		created:  now,
+		lastSeen: now,
+	}
+	c.endCommand(ctx)
+	s.clients.add(c)
+	defer s.clients.remove(c)
+
+	defer func() {
+		if err := output.Close(); errors.Is(err, errOutputBufferLimit) {
+			s.netLog.Warn("Client closed for overcoming of output buffer limits", "addr", clientAddr)
+		} else if err != nil {
+			s.netLog.Log(context.Background(), logging.LevelVerbose, "Error writing reply", "addr", clientAddr, "err", err)
+		}
+	}()
+	encoder := protocol.NewEncoder(output)
 	for {
-		value, err := protocol.Unmarshal(reader)
-		if err != nil {
-			LogError("Error reading input from %s: %v", clientAddr, err)
+		command, err := decoder.ReadCommand()
+		var protoErr *protocol.ProtocolError
+		if errors.As(err, &protoErr) {
+			// The stream can't be trusted any more, tell the client why and hang up
+			s.netLog.Warn("Protocol error", "addr", clientAddr, "err", err)
+			encoder.Encode(protocol.ErrorValue("ERR " + protoErr.Error()))
+			encoder.Flush()
 			return
 		}
-
-		command, args, err := parseORSPCommand(value)
 		if err != nil {
-			LogError("Invalid command from %s: %v", clientAddr, err)
-			response := protocol.ErrorValue(err.Error())
-			conn.Write([]byte(response.Marshal()))
-			continue
+			if s.isClosed() || c.killed.Load() {
+				return
+			}
+			if isTimeout(err) {
+				s.stats.timedoutConnections.Add(1)
+				if reader.idle {
+					s.netLog.Log(context.Background(), logging.LevelVerbose, "Client closed after being idle for too long", "addr", clientAddr)
+				} else {
+					s.netLog.Log(context.Background(), logging.LevelVerbose, "Client closed for taking too long to send a request", "addr", clientAddr)
+				}
+				return
+			}
+			s.netLog.Log(context.Background(), logging.LevelVerbose, "Client disconnected", "addr", clientAddr, "err", err)
+			return
 		}
+		reader.next(decoder.Buffered() > 0)
 
-		// Log the command
-		cmdStr := commandToString(command, args)
-		LogCommand(clientAddr, cmdStr)
+		name, args, err := parseORSPCommand(command)
 
-		fullArgs := append([]protocol.ORSPValue{protocol.BulkStringValue(command)}, args...)
-		response := HandleCommand(fullArgs)
-		conn.Write([]byte(response.Marshal()))
+		var response protocol.ORSPValue
+		if err != nil {
+			s.netLog.Debug("Invalid command", "addr", clientAddr, "err", err)
+			response = protocol.ErrorValue(err.Error())
+		} else {
+			// Log the command, without its secrets
+			s.logCommand(clientAddr, commandToString(name, redactArgs(name, args)))
+			// CLIENT LIST shows subcommands the way Redis does, client|list
+			cmdName := name
+			if cmd, ok := s.commands.Lookup(name); ok {
+				cmdName = cmd.resolve(command).Name
+			}
+			c.startCommand(cmdName, decoder.Buffered())
+			response = s.HandleCommand(ctx, command)
+			c.endCommand(ctx)
+		}
+		s.stats.commandsProcessed.Add(1)
+		if errValue, ok := response.(protocol.ErrorValue); ok {
+			s.stats.errorReply(errValue)
+		}
 
-		if command != "" {
-			fullCommand := protocol.ArrayValue{protocol.BulkStringValue(command)}
-			for _, arg := range args {
-				fullCommand = append(fullCommand, arg)
+		if c.takeReply() {
+			encoder.SetProtocol(ctx.Protocol)
+			if err := encoder.Encode(response); err != nil {
+				return
 			}
-			aof.AppendCommand(fullCommand) // Convert and pass as ArrayValue
+		}
+		// Replies to pipelined commands that are already buffered are sent
+		// together once the pipeline is drained
+		if decoder.Buffered() > 0 && !c.killed.Load() && !c.monitoring.Load() {
+			continue
+		}
+		if err := encoder.Flush(); err != nil {
+			return
+		}
+		if c.killed.Load() {
+			return
+		}
+		if c.monitoring.Load() {
+			s.serveMonitor(c, encoder)
+			return
 		}
 	}
 }
@@ -124,6 +772,54 @@ func commandToString(command string, args []protocol.ORSPValue) string {
 	return sb.String()
 }
 
+// redactArgs hides the passwords in AUTH, HELLO AUTH, ACL SETUSER and
+// CONFIG SET requirepass so they never reach the logs
+func redactArgs(name string, args []protocol.ORSPValue) []protocol.ORSPValue {
+	redacted := protocol.BulkStringValue("(redacted)")
+	var out []protocol.ORSPValue
+	redact := func(i int) {
+		if out == nil {
+			out = append([]protocol.ORSPValue{}, args...)
+		}
+		out[i] = redacted
+	}
+
+	switch name {
+	case "AUTH":
+		for i := range args {
+			redact(i)
+		}
+	case "HELLO":
+		for i := 1; i < len(args); i++ {
+			if strings.EqualFold(bulkString(args[i]), "AUTH") && i+2 < len(args) {
+				redact(i + 2)
+				i += 2
+			}
+		}
+	case "ACL":
+		if len(args) > 0 && strings.EqualFold(bulkString(args[0]), "SETUSER") {
+			for i := 2; i < len(args); i++ {
+				if rule := bulkString(args[i]); strings.HasPrefix(rule, ">") || strings.HasPrefix(rule, "<") {
+					redact(i)
+				}
+			}
+		}
+	case "CONFIG":
+		if len(args) > 0 && strings.EqualFold(bulkString(args[0]), "SET") {
+			for i := 1; i+1 < len(args); i += 2 {
+				if strings.EqualFold(bulkString(args[i]), "requirepass") {
+					redact(i + 1)
+				}
+			}
+		}
+	}
+
+	if out == nil {
+		return args
+	}
+	return out
+}
+
 func parseORSPCommand(value protocol.ORSPValue) (string, []protocol.ORSPValue, error) {
 	arrayValue, ok := value.(protocol.ArrayValue)
 	if !ok {
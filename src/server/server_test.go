@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"orion/src/commands"
+	"orion/src/persistence"
+	"orion/src/protocol"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer serves a fresh Server on a random local port
+func startServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	t.Cleanup(func() { s.Close() })
+	return s, listener.Addr().String()
+}
+
+// testClient speaks RESP to a server over a real connection
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	dec  *protocol.Decoder
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testClient{t: t, conn: conn, dec: protocol.NewDecoder(conn)}
+}
+
+// send writes a command without waiting for its reply
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	command := make(protocol.ArrayValue, len(args))
+	for i, arg := range args {
+		command[i] = protocol.BulkStringValue(arg)
+	}
+	enc := protocol.NewEncoder(c.conn)
+	if err := enc.Encode(command); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next reply
+func (c *testClient) read() protocol.ORSPValue {
+	c.t.Helper()
+	reply, err := c.dec.Decode()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return reply
+}
+
+// do sends a command and returns its reply
+func (c *testClient) do(args ...string) protocol.ORSPValue {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent loggers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestConfigLoggerPerServer(t *testing.T) {
+	var first, second syncBuffer
+	newLogger := func(b *syncBuffer) *slog.Logger {
+		return slog.New(slog.NewTextHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	s1, _ := startServer(t, Config{Logger: newLogger(&first)})
+	s2, _ := startServer(t, Config{Logger: newLogger(&second)})
+
+	s1.log.Info("from the first server")
+	s2.log.Info("from the second server")
+
+	if got := first.String(); !strings.Contains(got, "from the first server") || strings.Contains(got, "from the second server") {
+		t.Errorf("first server's log:\n%s", got)
+	}
+	if got := second.String(); !strings.Contains(got, "from the second server") || strings.Contains(got, "from the first server") {
+		t.Errorf("second server's log:\n%s", got)
+	}
+	if !strings.Contains(first.String(), "subsystem=server") {
+		t.Errorf("server messages lack their subsystem:\n%s", first.String())
+	}
+}
+
+func TestCloseIsBounded(t *testing.T) {
+	s, addr := startServer(t, Config{ShutdownTimeout: 100 * time.Millisecond})
+
+	// A hook that never returns keeps a command running past the timeout
+	release := make(chan struct{})
+	defer close(release)
+	entered := make(chan struct{})
+	s.AddHook(Hook{Name: "stuck", Before: func(ctx *commands.Context, call *Call) error {
+		if strings.EqualFold(string(call.Args[0].(protocol.BulkStringValue)), "SET") {
+			close(entered)
+			<-release
+		}
+		return nil
+	}})
+	c := dial(t, addr)
+	c.send("SET", "k", "v")
+	<-entered
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for a stuck command")
+	}
+	if _, err := s.Do(context.Background(), "PING"); err != ErrServerClosed {
+		t.Errorf("Do after Close returned %v, want ErrServerClosed", err)
+	}
+}
+
+func TestNewLoadsLatestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	older, err := New(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	older.Do(ctx, "SET", "k", "old")
+	if err := persistence.SaveToFile(older.instance.Store, older.instance.SnapshotPath(time.Unix(100, 0))); err != nil {
+		t.Fatal(err)
+	}
+	older.Do(ctx, "SET", "k", "new")
+	if err := persistence.SaveToFile(older.instance.Store, older.instance.SnapshotPath(time.Unix(200, 0))); err != nil {
+		t.Fatal(err)
+	}
+	older.Close()
+
+	s, _ := startServer(t, Config{Dir: dir})
+	if reply, _ := s.Do(ctx, "GET", "k"); reply != protocol.BulkStringValue("new") {
+		t.Errorf("GET k after restart got %#v, want the newest snapshot's value", reply)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"orion/src/commands"
+	"orion/src/persistence"
+	"orion/src/protocol"
+	"strings"
+	"time"
+)
+
+// ShutdownMode selects whether Shutdown writes a final snapshot
+type ShutdownMode int
+
+const (
+	// ShutdownDefault saves a snapshot only when the AOF is disabled
+	ShutdownDefault ShutdownMode = iota
+	// ShutdownSave always saves a snapshot
+	ShutdownSave
+	// ShutdownNoSave never saves a snapshot
+	ShutdownNoSave
+)
+
+// Shutdown stops the server gracefully. It stops accepting connections, lets
+// the commands in flight finish and their replies reach the clients, then
+// fsyncs and closes the AOF and, depending on mode, saves a final snapshot.
+// Connections still open when ctx is done are closed forcefully. Later calls
+// wait for the first one and return its result.
+func (s *Server) Shutdown(ctx context.Context, mode ShutdownMode) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx, mode)
+		close(s.done)
+	})
+	return s.shutdownErr
+}
+
+// Done is closed once the server has shut down, whoever started it
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Server) shutdown(ctx context.Context, mode ShutdownMode) error {
+	s.mu.Lock()
+	s.closed = true
+	listeners := s.listeners
+	s.listeners = nil
+	conns := make([]net.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	s.pause.unpause()
+	// Without a final save nothing needs the running script to finish
+	if mode == ShutdownNoSave {
+		s.scripts.abort()
+	}
+
+	// Clients waiting for their next command are woken up and hang up, the
+	// ones running a command get its reply first
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	drained := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.log.Warn("Shutdown timed out, closing the remaining connections", "connections", len(s.conns))
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	}
+
+	// Wait for the commands still running, scripts included, and refuse new
+	// ones from embedders calling Do. A command stuck past the deadline is
+	// left behind rather than holding the shutdown up.
+	idle := make(chan struct{})
+	go func() {
+		s.execMu.Lock()
+		s.stopped.Store(true)
+		s.execMu.Unlock()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		s.stopped.Store(true)
+		s.log.Warn("Shutdown timed out waiting for running commands")
+	}
+
+	var errs []error
+	save := mode == ShutdownSave || (mode == ShutdownDefault && s.instance.AOF == nil)
+	if save {
+		filename := s.instance.SnapshotPath(time.Now())
+		s.log.Info("Saving the final snapshot", "file", filename)
+		start := time.Now()
+		if err := persistence.SaveToFile(s.instance.Store, filename); err != nil {
+			errs = append(errs, fmt.Errorf("error saving snapshot: %w", err))
+		}
+		s.latency.record("snapshot", time.Since(start))
+	}
+
+	s.instance.Store.Close()
+	if s.instance.AOF != nil {
+		s.log.Info("Syncing the AOF")
+		if err := s.instance.AOF.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing AOF: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// trackConn registers a connection accepted by Serve so Shutdown can drain
+// it, it reports false once the server is closed
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.connWG.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.connWG.Done()
+}
+
+// handleShutdown replies right away and shuts the server down in the
+// background, so the reply reaches the client before its connection is drained
+func (s *Server) handleShutdown(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	if len(args) > 1 {
+		return protocol.ErrorValue("ERR syntax error")
+	}
+	mode := ShutdownDefault
+	for _, arg := range args {
+		option, ok := arg.(protocol.BulkStringValue)
+		if !ok {
+			return protocol.ErrorValue("ERR syntax error")
+		}
+		switch strings.ToUpper(string(option)) {
+		case "SAVE":
+			mode = ShutdownSave
+		case "NOSAVE":
+			mode = ShutdownNoSave
+		default:
+			return protocol.ErrorValue("ERR syntax error")
+		}
+	}
+
+	// A script past lua-time-limit may be stuck, only NOSAVE stops it since
+	// saving would write its partial effects
+	if mode != ShutdownNoSave && s.scripts.isBusy() {
+		return protocol.ErrorValue(errBusyScript)
+	}
+
+	s.log.Info("User requested shutdown")
+	go func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config().ShutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx, mode); err != nil {
+			s.log.Error("Error during shutdown", "err", err)
+		}
+	}()
+	return protocol.SimpleStringValue("OK")
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"orion/src/commands"
+	"orion/src/protocol"
+)
+
+// snapshots lists the snapshot files saved in dir
+func snapshots(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "dump*.orion"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestShutdownSaves(t *testing.T) {
+	tests := []struct {
+		name       string
+		appendOnly bool
+		mode       ShutdownMode
+		snapshot   bool
+	}{
+		{"default", false, ShutdownDefault, true},
+		{"default with AOF", true, ShutdownDefault, false},
+		{"save with AOF", true, ShutdownSave, true},
+		{"nosave", false, ShutdownNoSave, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, _ := startServer(t, Config{Dir: dir, AppendOnly: tt.appendOnly})
+			ctx := context.Background()
+			s.Do(ctx, "SET", "k", "v")
+			if err := s.Shutdown(ctx, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(snapshots(t, dir)) == 1; got != tt.snapshot {
+				t.Fatalf("snapshots after shutdown: %v", snapshots(t, dir))
+			}
+
+			restarted, _ := startServer(t, Config{Dir: dir, AppendOnly: tt.appendOnly})
+			want := protocol.ORSPValue(protocol.BulkStringValue("v"))
+			if !tt.snapshot && !tt.appendOnly {
+				want = protocol.NullValue{}
+			}
+			if reply, _ := restarted.Do(ctx, "GET", "k"); reply != want {
+				t.Errorf("GET k after restart got %#v, want %#v", reply, want)
+			}
+		})
+	}
+}
+
+func TestShutdownFinishesRunningCommands(t *testing.T) {
+	dir := t.TempDir()
+	s, addr := startServer(t, Config{Dir: dir, AppendOnly: true})
+
+	// A write held up in a hook until the shutdown has started
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	s.AddHook(Hook{Name: "hold", Before: func(ctx *commands.Context, call *Call) error {
+		if strings.EqualFold(string(call.Args[0].(protocol.BulkStringValue)), "SET") {
+			close(entered)
+			<-release
+		}
+		return nil
+	}})
+	writer := dial(t, addr)
+	writer.send("SET", "inflight", "v")
+	<-entered
+	idle := dial(t, addr)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background(), ShutdownDefault) }()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if reply := writer.read(); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("the command running during shutdown replied %#v", reply)
+	}
+	if _, err := idle.dec.Decode(); err == nil {
+		t.Error("an idle connection stayed open after shutdown")
+	}
+	if _, err := s.Do(context.Background(), "GET", "inflight"); err != ErrServerClosed {
+		t.Errorf("Do after shutdown returned %v, want ErrServerClosed", err)
+	}
+
+	// The write reached the AOF before it was closed
+	aof, err := os.ReadFile(filepath.Join(dir, DefaultConfig().AppendFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(aof), "$8\r\ninflight\r\n") {
+		t.Errorf("the AOF lacks the write made during shutdown:\n%q", aof)
+	}
+	if err := s.instance.AOF.AppendCommand(protocol.ArrayValue{protocol.BulkStringValue("PING")}); err == nil {
+		t.Error("the AOF is still open after shutdown")
+	}
+}
+
+func TestShutdownCommand(t *testing.T) {
+	dir := t.TempDir()
+	s, addr := startServer(t, Config{Dir: dir})
+	c := dial(t, addr)
+	c.do("SET", "k", "v")
+
+	if reply := c.do("SHUTDOWN", "NOW"); !isError(reply, "ERR syntax error") {
+		t.Errorf("SHUTDOWN NOW got %#v", reply)
+	}
+	if reply := c.do("SHUTDOWN"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("SHUTDOWN got %#v", reply)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SHUTDOWN didn't stop the server")
+	}
+	if len(snapshots(t, dir)) != 1 {
+		t.Errorf("SHUTDOWN without an AOF saved %v", snapshots(t, dir))
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"fmt"
+	"orion/src/commands"
+	"orion/src/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits on what a slow log entry keeps of a command line
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is a command that ran longer than slowlog-log-slower-than
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowLog keeps the latest slow commands in a ring of at most
+// slowlog-max-len entries, the newest overwriting the oldest once it is full
+type slowLog struct {
+	threshold atomic.Int64 // slowlog-log-slower-than
+
+	mu      sync.Mutex
+	entries []slowlogEntry // grows up to maxLen, then wraps around
+	head    int            // the oldest entry, the next one overwritten
+	maxLen  int
+	nextID  int64
+}
+
+func (s *Server) setSlowlog(cfg *Config) error {
+	if cfg.SlowlogMaxLen < 1 {
+		return fmt.Errorf("slowlog-max-len must be at least 1, got %d", cfg.SlowlogMaxLen)
+	}
+	s.slowlog.threshold.Store(int64(cfg.SlowlogLogSlowerThan))
+	s.slowlog.resize(cfg.SlowlogMaxLen)
+	return nil
+}
+
+// add records a slow command. Secrets are redacted, long argument lists and
+// arguments are truncated, and everything is copied so the connection can
+// reuse its buffers.
+func (l *slowLog) add(ctx *commands.Context, call *Call, start time.Time, duration time.Duration) {
+	name := call.Command.Root().Name
+	args := redactArgs(name, call.Args[1:])
+
+	line := make([]string, 0, min(len(args)+1, slowlogMaxArgs))
+	line = append(line, strings.ToLower(name))
+	for i, arg := range args {
+		if len(line) == slowlogMaxArgs-1 && i < len(args)-1 {
+			line = append(line, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		s := bulkString(arg)
+		if len(s) > slowlogMaxArgLen {
+			s = fmt.Sprintf("%s... (%d more bytes)", s[:slowlogMaxArgLen], len(s)-slowlogMaxArgLen)
+		} else {
+			s = strings.Clone(s)
+		}
+		line = append(line, s)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := slowlogEntry{
+		id:       l.nextID,
+		time:     start,
+		duration: duration,
+		args:     line,
+		addr:     ctx.Addr,
+		name:     ctx.Name,
+	}
+	l.nextID++
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.head] = entry
+	l.head = (l.head + 1) % len(l.entries)
+}
+
+// newest returns the entries newest first, at most count of them or all of
+// them when count is negative. l.mu must be held.
+func (l *slowLog) newest(count int) []slowlogEntry {
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	entries := make([]slowlogEntry, count)
+	for i := range entries {
+		entries[i] = l.entries[(l.head+len(l.entries)-1-i)%len(l.entries)]
+	}
+	return entries
+}
+
+// resize changes slowlog-max-len, keeping the newest entries that still fit
+func (l *slowLog) resize(maxLen int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maxLen == l.maxLen {
+		return
+	}
+	kept := l.newest(maxLen)
+	l.entries = l.entries[:0:0]
+	for i := len(kept) - 1; i >= 0; i-- {
+		l.entries = append(l.entries, kept[i])
+	}
+	l.head = 0
+	l.maxLen = maxLen
+}
+
+func (l *slowLog) list(count int) []slowlogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.newest(count)
+}
+
+func (l *slowLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	l.entries = nil
+	l.head = 0
+	l.mu.Unlock()
+}
+
+// recordSlow adds call to the slow log if it ran for at least
+// slowlog-log-slower-than. Commands run by scripts are accounted to the
+// script.
+func (s *Server) recordSlow(ctx *commands.Context, call *Call, start time.Time, duration time.Duration) {
+	if ctx.Script || s.loading.Load() {
+		return
+	}
+	threshold := time.Duration(s.slowlog.threshold.Load())
+	if threshold < 0 || duration < threshold {
+		return
+	}
+	s.slowlog.add(ctx, call, start, duration)
+}
+
+// handleSlowlog implements SLOWLOG GET [count], LEN and RESET
+func (s *Server) handleSlowlog(ctx *commands.Context, args []protocol.ORSPValue) protocol.ORSPValue {
+	sub, _ := args[0].(protocol.BulkStringValue)
+	name := strings.ToUpper(string(sub))
+	args = args[1:]
+	wrongArgs := protocol.ErrorValue("ERR wrong number of arguments for 'slowlog|" + strings.ToLower(name) + "' command")
+
+	switch name {
+	case "GET":
+		if len(args) > 1 {
+			return wrongArgs
+		}
+		count := 10
+		if len(args) == 1 {
+			n, err := strconv.Atoi(bulkString(args[0]))
+			if err != nil || n < -1 {
+				return protocol.ErrorValue("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := s.slowlog.list(count)
+		reply := make(protocol.ArrayValue, len(entries))
+		for i, e := range entries {
+			line := make(protocol.ArrayValue, len(e.args))
+			for j, arg := range e.args {
+				line[j] = protocol.BulkStringValue(arg)
+			}
+			reply[i] = protocol.ArrayValue{
+				protocol.IntegerValue(e.id),
+				protocol.IntegerValue(e.time.Unix()),
+				protocol.IntegerValue(e.duration.Microseconds()),
+				line,
+				protocol.BulkStringValue(e.addr),
+				protocol.BulkStringValue(e.name),
+			}
+		}
+		return reply
+
+	case "LEN":
+		if len(args) != 0 {
+			return wrongArgs
+		}
+		return protocol.IntegerValue(s.slowlog.len())
+
+	case "RESET":
+		if len(args) != 0 {
+			return wrongArgs
+		}
+		s.slowlog.reset()
+		return protocol.SimpleStringValue("OK")
+	}
+
+	return protocol.ErrorValue("ERR unknown subcommand '" + string(sub) + "'. Try SLOWLOG GET, SLOWLOG LEN or SLOWLOG RESET")
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"orion/src/commands"
+	"orion/src/protocol"
+)
+
+// slowlogIDs lists the IDs of the entries l holds, newest first
+func slowlogIDs(l *slowLog) []int64 {
+	var ids []int64
+	for _, e := range l.list(-1) {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+func TestSlowLogRing(t *testing.T) {
+	var l slowLog
+	l.resize(3)
+	call := &Call{Command: &Command{Name: "SET"}, Args: protocol.ArrayValue{protocol.BulkStringValue("SET"), protocol.BulkStringValue("k")}}
+	add := func(n int) {
+		for i := 0; i < n; i++ {
+			l.add(&commands.Context{}, call, time.Now(), time.Second)
+		}
+	}
+
+	add(2)
+	if got := slowlogIDs(&l); !slices.Equal(got, []int64{1, 0}) {
+		t.Errorf("before wrapping the log holds %v", got)
+	}
+	add(3)
+	if got := slowlogIDs(&l); !slices.Equal(got, []int64{4, 3, 2}) {
+		t.Errorf("after wrapping the log holds %v", got)
+	}
+	if got := l.list(2); len(got) != 2 || got[0].id != 4 || got[1].id != 3 {
+		t.Errorf("list(2) got %v", got)
+	}
+
+	// Shrinking keeps the newest entries, growing makes room for more
+	l.resize(2)
+	if got := slowlogIDs(&l); !slices.Equal(got, []int64{4, 3}) {
+		t.Errorf("after shrinking the log holds %v", got)
+	}
+	l.resize(4)
+	add(3)
+	if got := slowlogIDs(&l); !slices.Equal(got, []int64{7, 6, 5, 4}) {
+		t.Errorf("after growing the log holds %v", got)
+	}
+
+	l.reset()
+	add(1)
+	if got := slowlogIDs(&l); !slices.Equal(got, []int64{8}) {
+		t.Errorf("after reset the log holds %v", got)
+	}
+}
+
+func TestSlowlogThreshold(t *testing.T) {
+	s, _ := startServer(t, Config{})
+	ctx := context.Background()
+
+	// The zero Config gets the default threshold, so quick commands aren't logged
+	if got := configGet(t, s, "slowlog-log-slower-than"); got != "10000" {
+		t.Errorf("slowlog-log-slower-than defaults to %s", got)
+	}
+	s.Do(ctx, "SET", "k", "v")
+	if reply, _ := s.Do(ctx, "SLOWLOG", "LEN"); reply != protocol.IntegerValue(0) {
+		t.Errorf("SLOWLOG LEN under the default threshold got %#v", reply)
+	}
+
+	// Zero logs every command and reads back as zero
+	if reply, _ := s.Do(ctx, "CONFIG", "SET", "slowlog-log-slower-than", "0"); reply != protocol.SimpleStringValue("OK") {
+		t.Fatalf("CONFIG SET slowlog-log-slower-than 0 got %#v", reply)
+	}
+	if got := configGet(t, s, "slowlog-log-slower-than"); got != "0" {
+		t.Errorf("slowlog-log-slower-than 0 reads back as %s", got)
+	}
+	s.Do(ctx, "SLOWLOG", "RESET")
+	s.Do(ctx, "SET", "k", "v")
+	if reply, _ := s.Do(ctx, "SLOWLOG", "LEN"); reply == protocol.IntegerValue(0) {
+		t.Error("SET wasn't logged with slowlog-log-slower-than 0")
+	}
+
+	// Negative disables the log
+	s.Do(ctx, "CONFIG", "SET", "slowlog-log-slower-than", "-1")
+	s.Do(ctx, "SLOWLOG", "RESET")
+	s.Do(ctx, "SET", "k", "v")
+	if reply, _ := s.Do(ctx, "SLOWLOG", "LEN"); reply != protocol.IntegerValue(0) {
+		t.Errorf("SLOWLOG LEN with the log disabled got %#v", reply)
+	}
+
+	for _, args := range [][]string{
+		{"slowlog-log-slower-than", "-2"},
+		{"slowlog-log-slower-than", "9223372036854775807"},
+		{"slowlog-log-slower-than", "fast"},
+		{"slowlog-max-len", "0"},
+	} {
+		if reply, _ := s.Do(ctx, append([]string{"CONFIG", "SET"}, args...)...); !isError(reply, "ERR ") {
+			t.Errorf("CONFIG SET %v got %#v", args, reply)
+		}
+	}
+	if got := configGet(t, s, "slowlog-log-slower-than"); got != "-1" {
+		t.Errorf("a rejected CONFIG SET left slowlog-log-slower-than at %s", got)
+	}
+}
+
+func TestSlowlogCommand(t *testing.T) {
+	s, addr := startServer(t, Config{SlowlogLogSlowerThan: time.Nanosecond, SlowlogMaxLen: 3})
+	c := dial(t, addr)
+	c.do("CLIENT", "SETNAME", "slow")
+	c.do("SLOWLOG", "RESET")
+	c.do("CONFIG", "SET", "requirepass", "secret")
+	c.do("AUTH", "secret")
+	c.do("SET", "big", strings.Repeat("x", 200))
+
+	reply, _ := s.Do(context.Background(), "SLOWLOG", "GET", "-1")
+	entries, _ := reply.(protocol.ArrayValue)
+	if len(entries) != 3 {
+		t.Fatalf("SLOWLOG GET -1 with slowlog-max-len 3 got %#v", reply)
+	}
+	args := func(i int) []string {
+		var line []string
+		for _, arg := range entries[i].(protocol.ArrayValue)[3].(protocol.ArrayValue) {
+			line = append(line, string(arg.(protocol.BulkStringValue)))
+		}
+		return line
+	}
+
+	// Newest first, secrets redacted and long arguments cut short
+	if got := args(0); len(got) != 3 || got[0] != "set" || got[2] != strings.Repeat("x", slowlogMaxArgLen)+"... (72 more bytes)" {
+		t.Errorf("the SET entry holds %q", got)
+	}
+	if got := args(1); strings.Join(got, " ") != "auth (redacted)" {
+		t.Errorf("the AUTH entry holds %q", got)
+	}
+	if got := args(2); strings.Join(got, " ") != "config SET requirepass (redacted)" {
+		t.Errorf("the CONFIG SET entry holds %q", got)
+	}
+	entry := entries[0].(protocol.ArrayValue)
+	if entry[4] != protocol.BulkStringValue(c.conn.LocalAddr().String()) || entry[5] != protocol.BulkStringValue("slow") {
+		t.Errorf("the SET entry is from %#v named %#v", entry[4], entry[5])
+	}
+	for i := 1; i < len(entries); i++ {
+		if newer, older := entries[i-1].(protocol.ArrayValue)[0], entries[i].(protocol.ArrayValue)[0]; newer.(protocol.IntegerValue) <= older.(protocol.IntegerValue) {
+			t.Errorf("entry IDs %v and %v aren't decreasing", newer, older)
+		}
+	}
+
+	if reply, _ := s.Do(context.Background(), "SLOWLOG", "GET", "1"); len(reply.(protocol.ArrayValue)) != 1 {
+		t.Errorf("SLOWLOG GET 1 got %#v", reply)
+	}
+	if reply, _ := s.Do(context.Background(), "SLOWLOG", "GET", "-2"); !isError(reply, "ERR count should be greater than or equal to -1") {
+		t.Errorf("SLOWLOG GET -2 got %#v", reply)
+	}
+	// RESET is logged once it ran, like any other command
+	if reply, _ := s.Do(context.Background(), "SLOWLOG", "RESET"); reply != protocol.SimpleStringValue("OK") {
+		t.Errorf("SLOWLOG RESET got %#v", reply)
+	}
+	reply, _ = s.Do(context.Background(), "SLOWLOG", "GET")
+	if entries, _ := reply.(protocol.ArrayValue); len(entries) != 1 || entries[0].(protocol.ArrayValue)[3].(protocol.ArrayValue)[1] != protocol.BulkStringValue("RESET") {
+		t.Errorf("SLOWLOG GET after RESET got %#v", reply)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"orion/src/protocol"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverStats holds the counters reported in the Stats and Errorstats
+// sections of INFO and cleared by CONFIG RESETSTAT
+type serverStats struct {
+	connectionsReceived atomic.Int64
+	commandsProcessed   atomic.Int64
+	errorReplies        atomic.Int64
+	rejectedConnections atomic.Int64
+	timedoutConnections atomic.Int64
+	rateLimitDelayed    atomic.Int64
+	rateLimitRejected   atomic.Int64
+	monitorDropped      atomic.Int64 // lines not sent to a MONITOR falling behind
+
+	errorsMu sync.Mutex
+	errors   map[string]int64 // error replies by their first word
+}
+
+func (st *serverStats) reset() {
+	st.connectionsReceived.Store(0)
+	st.commandsProcessed.Store(0)
+	st.errorReplies.Store(0)
+	st.rejectedConnections.Store(0)
+	st.timedoutConnections.Store(0)
+	st.rateLimitDelayed.Store(0)
+	st.rateLimitRejected.Store(0)
+	st.monitorDropped.Store(0)
+
+	st.errorsMu.Lock()
+	st.errors = nil
+	st.errorsMu.Unlock()
+}
+
+// errorReply counts an error reply sent to a client under its prefix, the
+// code such as ERR or WRONGTYPE
+func (st *serverStats) errorReply(reply protocol.ErrorValue) {
+	st.errorReplies.Add(1)
+	prefix, _, _ := strings.Cut(string(reply), " ")
+
+	st.errorsMu.Lock()
+	defer st.errorsMu.Unlock()
+	if st.errors == nil {
+		st.errors = make(map[string]int64)
+	}
+	st.errors[prefix]++
+}
+
+// errorCounts returns the error prefixes in order with their counts
+func (st *serverStats) errorCounts() ([]string, []int64) {
+	st.errorsMu.Lock()
+	defer st.errorsMu.Unlock()
+	prefixes := make([]string, 0, len(st.errors))
+	for prefix := range st.errors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	counts := make([]int64, len(prefixes))
+	for i, prefix := range prefixes {
+		counts[i] = st.errors[prefix]
+	}
+	return prefixes, counts
+}
+
+// latencyBuckets are the upper bounds of the command latency histogram
+var latencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// commandStats counts the calls to one command. Calls ran the handler and
+// failed ones replied with an error, rejected ones were refused before it
+// by the arity check, a hook or a rate limit.
+type commandStats struct {
+	calls    atomic.Int64
+	failed   atomic.Int64
+	rejected atomic.Int64
+	total    atomic.Int64 // nanoseconds spent in the handler
+	buckets  []atomic.Int64
+}
+
+// commandMetrics holds the commandStats of every command called so far, by
+// command name
+type commandMetrics struct {
+	mu    sync.RWMutex
+	stats map[string]*commandStats
+}
+
+func (m *commandMetrics) get(name string) *commandStats {
+	m.mu.RLock()
+	st := m.stats[name]
+	m.mu.RUnlock()
+	if st != nil {
+		return st
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st = m.stats[name]; st == nil {
+		if m.stats == nil {
+			m.stats = make(map[string]*commandStats)
+		}
+		st = &commandStats{buckets: make([]atomic.Int64, len(latencyBuckets))}
+		m.stats[name] = st
+	}
+	return st
+}
+
+// record counts a call that ran the command's handler
+func (m *commandMetrics) record(name string, latency time.Duration, failed bool) {
+	st := m.get(name)
+	st.calls.Add(1)
+	if failed {
+		st.failed.Add(1)
+	}
+	st.total.Add(int64(latency))
+	// Buckets are cumulative when written out, each call lands in one
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return latency <= latencyBuckets[i] })
+	if i < len(latencyBuckets) {
+		st.buckets[i].Add(1)
+	}
+}
+
+// reject counts a call refused before the command's handler ran
+func (m *commandMetrics) reject(name string) {
+	m.get(name).rejected.Add(1)
+}
+
+// sorted returns the lowercase command names in order with their stats
+func (m *commandMetrics) sorted() ([]string, []*commandStats) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.stats))
+	for name := range m.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]*commandStats, len(names))
+	for i, name := range names {
+		stats[i] = m.stats[name]
+		names[i] = strings.ToLower(name)
+	}
+	return names, stats
+}
+
+func (m *commandMetrics) reset() {
+	m.mu.Lock()
+	m.stats = nil
+	m.mu.Unlock()
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// loadTLSConfig builds the TLS configuration served to clients from the
+// certificate files named in cfg
+func loadTLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file must be set")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.TLSCACertFile != "" {
+		caPEM, err := os.ReadFile(cfg.TLSCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS CA certificate: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.TLSCACertFile)
+		}
+	}
+
+	switch cfg.TLSAuthClients {
+	case "yes":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	}
+	if tlsConfig.ClientAuth != tls.NoClientCert && tlsConfig.ClientCAs == nil {
+		return nil, errors.New("tls-auth-clients needs tls-ca-cert-file to verify client certificates")
+	}
+	return tlsConfig, nil
+}
+
+// enableTLS loads the certificates named in cfg and starts serving them to
+// the listeners wrapped with listenerTLSConfig
+func (s *Server) enableTLS(cfg Config) error {
+	tlsConfig, err := loadTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	s.tlsConfig.Store(tlsConfig)
+	return nil
+}
+
+// reloadTLS swaps in the certificates named in cfg. Connections already
+// established keep their session, new ones get the new certificates. It does
+// nothing unless TLS is enabled, and keeps the old certificates on error.
+func (s *Server) reloadTLS(cfg Config) error {
+	if s.tlsConfig.Load() == nil {
+		return nil
+	}
+	if err := s.enableTLS(cfg); err != nil {
+		return err
+	}
+	s.netLog.Info("TLS certificates reloaded")
+	return nil
+}
+
+// listenerTLSConfig returns the configuration for tls.NewListener. It looks
+// the current certificates up on every handshake so reloads need no restart.
+func (s *Server) listenerTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.tlsConfig.Load(), nil
+		},
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate generated for a test, written to PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA
+// when parent is nil, and writes it to dir
+func newTestCert(t *testing.T, dir, name string, serial int64, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writePEM(t, tc.certFile, "CERTIFICATE", der)
+	writePEM(t, tc.keyFile, "EC PRIVATE KEY", keyDER)
+	return tc
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSServer serves a fresh Server over TLS on a random local port
+func startTLSServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	cfg.Dir = t.TempDir()
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.enableTLS(s.Config()); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(tls.NewListener(listener, s.listenerTLSConfig()))
+	t.Cleanup(func() { s.Close() })
+	return s, listener.Addr().String()
+}
+
+// ping sends PING over a new TLS connection and returns the peer's
+// certificate with the reply
+func ping(addr string, config *tls.Config) (*x509.Certificate, string, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return nil, "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return nil, "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0], reply, nil
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", 1, nil)
+	serverCert := newTestCert(t, dir, "server", 2, ca)
+	clientCert := newTestCert(t, dir, "client", 3, ca)
+	strangerCA := newTestCert(t, dir, "stranger-ca", 4, nil)
+	strangerCert := newTestCert(t, dir, "stranger", 5, strangerCA)
+
+	_, addr := startTLSServer(t, Config{
+		TLSCertFile:   serverCert.certFile,
+		TLSKeyFile:    serverCert.keyFile,
+		TLSCACertFile: ca.certFile,
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	keyPair := func(c *testCert) []tls.Certificate {
+		pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []tls.Certificate{pair}
+	}
+
+	if _, reply, err := ping(addr, &tls.Config{RootCAs: roots, Certificates: keyPair(clientCert)}); err != nil || reply != "+PONG\r\n" {
+		t.Fatalf("client with a certificate: got %q, %v", reply, err)
+	}
+	if _, _, err := ping(addr, &tls.Config{RootCAs: roots}); err == nil {
+		t.Error("client without a certificate was accepted")
+	}
+	if _, _, err := ping(addr, &tls.Config{RootCAs: roots, Certificates: keyPair(strangerCert)}); err == nil {
+		t.Error("client with a certificate from another CA was accepted")
+	}
+	if _, _, err := ping(addr, &tls.Config{Certificates: keyPair(clientCert)}); err == nil {
+		t.Error("server certificate verified without its CA")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", 1, nil)
+	first := newTestCert(t, dir, "first", 2, ca)
+	second := newTestCert(t, dir, "second", 3, ca)
+
+	s, addr := startTLSServer(t, Config{
+		TLSCertFile:    first.certFile,
+		TLSKeyFile:     first.keyFile,
+		TLSAuthClients: "no",
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	servedSerial := func() int64 {
+		t.Helper()
+		cert, _, err := ping(addr, &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.SerialNumber.Int64()
+	}
+
+	if got := servedSerial(); got != 2 {
+		t.Fatalf("served certificate %d, want 2", got)
+	}
+
+	ctx := context.Background()
+	if _, err := s.Do(ctx, "CONFIG", "SET", "tls-cert-file", second.certFile, "tls-key-file", second.keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedSerial(); got != 3 {
+		t.Fatalf("after CONFIG SET served certificate %d, want 3", got)
+	}
+
+	// A certificate that doesn't load is refused and the current one is kept
+	if _, err := s.Do(ctx, "CONFIG", "SET", "tls-cert-file", filepath.Join(dir, "missing.crt")); err == nil {
+		t.Fatal("CONFIG SET accepted a missing certificate")
+	}
+	if got := s.Config().TLSCertFile; got != second.certFile {
+		t.Errorf("tls-cert-file is %s after a failed CONFIG SET, want %s", got, second.certFile)
+	}
+	if got := servedSerial(); got != 3 {
+		t.Errorf("after a failed CONFIG SET served certificate %d, want 3", got)
+	}
+
+	// Requiring client certificates needs a CA to verify them with
+	if _, err := s.Do(ctx, "CONFIG", "SET", "tls-auth-clients", "yes"); err == nil {
+		t.Error("CONFIG SET tls-auth-clients yes accepted without tls-ca-cert-file")
+	}
+
+	// Files replaced in place are picked up by a reload, as on SIGHUP
+	if err := os.Rename(first.certFile, second.certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(first.keyFile, second.keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reloadTLS(s.Config()); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedSerial(); got != 2 {
+		t.Errorf("after reload served certificate %d, want 2", got)
+	}
+}